@@ -0,0 +1,86 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewAttachCommand will connect the terminal to a container running in background.
+func NewAttachCommand() *cobra.Command {
+	attachCommand := &cobra.Command{
+		Use:              "attach [flags] CONTAINER",
+		Short:            "Attach to a running container",
+		PreRunE:          logging.Init,
+		RunE:             attach,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	attachCommand.Flags().SetInterspersed(false)
+	attachCommand.Flags().BoolP("help", "h", false, "show help")
+	attachCommand.Flags().String("detach-keys", containerutils.DefaultDetachKeys,
+		"key sequence to detach from the container, leaving it running, empty to disable")
+
+	return attachCommand
+}
+
+func attach(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) != 1 {
+		return cmd.Help()
+	}
+
+	detachKeys, err := cmd.Flags().GetString("detach-keys")
+	if err != nil {
+		return err
+	}
+
+	keys, err := containerutils.ParseDetachKeys(detachKeys)
+	if err != nil {
+		return err
+	}
+
+	container := arguments[0]
+
+	id, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	if !containerutils.IsRunning(id) {
+		return fmt.Errorf("container %s is not running", container)
+	}
+
+	return attachAndWait(id, containerutils.AttachOptions{DetachKeys: keys, Stdin: true})
+}
+
+// attachAndWait will attach to input container until it exits, and return its
+// exit code as an ExitCodeError, or until we detach from it.
+func attachAndWait(id string, options containerutils.AttachOptions) error {
+	detached, err := containerutils.Attach(id, options)
+	if err != nil {
+		return err
+	}
+
+	if detached {
+		logging.LogDebug("detached from %s", id)
+
+		return nil
+	}
+
+	// like lilipod wait, the exit code of the container is the one of attach
+	exitCode, err := containerutils.Wait(id)
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return &ExitCodeError{Code: exitCode}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/buildutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/spf13/cobra"
+)
+
+// NewBuildCommand will build an image from a Containerfile.
+func NewBuildCommand() *cobra.Command {
+	buildCommand := &cobra.Command{
+		Use:              "build [flags] [CONTEXT]",
+		Short:            "Build an image from a Containerfile",
+		PreRunE:          logging.Init,
+		RunE:             build,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	buildCommand.Flags().SetInterspersed(false)
+	buildCommand.Flags().BoolP("help", "h", false, "show help")
+	buildCommand.Flags().BoolP("quiet", "q", false, "suppress output, except the one of RUN instructions")
+	buildCommand.Flags().StringP("file", "f", "", "path of the Containerfile, defaults to the one in CONTEXT")
+	buildCommand.Flags().StringP("tag", "t", "", "name of the resulting image")
+
+	return buildCommand
+}
+
+func build(cmd *cobra.Command, arguments []string) error {
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return err
+	}
+
+	containerfile, err := getAbsFlag(cmd, "file")
+	if err != nil {
+		return err
+	}
+
+	tag, err := cmd.Flags().GetString("tag")
+	if err != nil {
+		return err
+	}
+
+	if tag == "" {
+		return fmt.Errorf("a name for the image is required, use --tag")
+	}
+
+	context := "."
+	if len(arguments) > 0 {
+		context = arguments[0]
+	}
+
+	context, err = filepath.Abs(context)
+	if err != nil {
+		return err
+	}
+
+	if containerfile == "" {
+		containerfile, err = buildutils.GetContainerfile(context)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the image's files are owned by the fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	id, err := buildutils.Build(context, containerfile, tag, quiet)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+
+	return nil
+}
@@ -0,0 +1,82 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/spf13/cobra"
+)
+
+// NewCheckpointCommand will dump the processes of a running container with CRIU.
+func NewCheckpointCommand() *cobra.Command {
+	checkpointCommand := &cobra.Command{
+		Use:              "checkpoint [flags] CONTAINER",
+		Short:            "Checkpoint a running container with CRIU",
+		PreRunE:          logging.Init,
+		RunE:             checkpoint,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	checkpointCommand.Flags().SetInterspersed(false)
+	checkpointCommand.Flags().BoolP("help", "h", false, "show help")
+	checkpointCommand.Flags().StringP("export", "e", "", "export the container and its checkpoint to a tar.gz file")
+	checkpointCommand.Flags().BoolP("leave-running", "R", false, "leave the container running after the checkpoint")
+
+	return checkpointCommand
+}
+
+func checkpoint(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	// CRIU needs to be proper root to dump and restore processes
+	if os.Getuid() != 0 {
+		return fmt.Errorf("checkpoint requires root, run lilipod as root")
+	}
+
+	export, err := getAbsFlag(cmd, "export")
+	if err != nil {
+		return err
+	}
+
+	leaveRunning, err := cmd.Flags().GetBool("leave-running")
+	if err != nil {
+		return err
+	}
+
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	container := arguments[0]
+
+	_, err = containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	if !containerutils.IsRunning(container) {
+		return fmt.Errorf("container %s is not running", container)
+	}
+
+	err = containerutils.Checkpoint(container, export, leaveRunning)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(container)
+
+	return nil
+}
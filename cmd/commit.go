@@ -0,0 +1,80 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/buildutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/spf13/cobra"
+)
+
+// NewCommitCommand will create a new image from a container's changes.
+func NewCommitCommand() *cobra.Command {
+	commitCommand := &cobra.Command{
+		Use:              "commit [flags] CONTAINER IMAGE",
+		Short:            "Create a new image from a container's changes",
+		PreRunE:          logging.Init,
+		RunE:             commit,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	commitCommand.Flags().SetInterspersed(false)
+	commitCommand.Flags().BoolP("help", "h", false, "show help")
+	commitCommand.Flags().StringP("author", "a", "", "author of the image")
+	commitCommand.Flags().StringP("message", "m", "", "commit message, saved in the image history")
+	commitCommand.Flags().Bool("squash", false, "flatten all the layers of the image in a single one")
+
+	return commitCommand
+}
+
+func commit(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	author, err := cmd.Flags().GetString("author")
+	if err != nil {
+		return err
+	}
+
+	message, err := cmd.Flags().GetString("message")
+	if err != nil {
+		return err
+	}
+
+	squash, err := cmd.Flags().GetBool("squash")
+	if err != nil {
+		return err
+	}
+
+	container := arguments[0]
+	image := arguments[1]
+
+	err = ensureContainer(container)
+	if err != nil {
+		return err
+	}
+
+	// containers' files can be owned by the fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	id, err := buildutils.Commit(container, image, author, message, squash)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+
+	return nil
+}
@@ -0,0 +1,373 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewContainerCommand will manage the containers in local storage.
+func NewContainerCommand() *cobra.Command {
+	containerCommand := &cobra.Command{
+		Use:              "container",
+		Short:            "Manage containers",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerCommand.Flags().BoolP("help", "h", false, "show help")
+
+	containerPruneCommand := &cobra.Command{
+		Use:              "prune [flags]",
+		Short:            "Remove all stopped containers",
+		PreRunE:          logging.Init,
+		RunE:             containerPrune,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerPruneCommand.Flags().SetInterspersed(false)
+	containerPruneCommand.Flags().BoolP("help", "h", false, "show help")
+	containerPruneCommand.Flags().BoolP("force", "f", false, "do not prompt for confirmation")
+	containerPruneCommand.Flags().
+		StringArray("filter", nil, "filter containers to remove (until=DURATION or TIMESTAMP)")
+
+	containerCloneCommand := &cobra.Command{
+		Use:              "clone [flags] CONTAINER [NAME]",
+		Short:            "Create a copy of a container, with its config and changes",
+		PreRunE:          logging.Init,
+		RunE:             containerClone,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerCloneCommand.Flags().SetInterspersed(false)
+	containerCloneCommand.Flags().BoolP("help", "h", false, "show help")
+	containerCloneCommand.Flags().Bool("reset", false, "create the rootfs from the image again, without the container's changes")
+
+	containerExportStateCommand := &cobra.Command{
+		Use:              "export-state [flags] CONTAINER",
+		Short:            "Archive a container, with its config and changes, to move it to another host",
+		PreRunE:          logging.Init,
+		RunE:             containerExportState,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerExportStateCommand.Flags().SetInterspersed(false)
+	containerExportStateCommand.Flags().BoolP("help", "h", false, "show help")
+	containerExportStateCommand.Flags().StringP("output", "o", "", "write the archive to a tar.gz file")
+	containerExportStateCommand.Flags().Bool("checkpoint", false, "include the container's CRIU checkpoint")
+
+	containerImportStateCommand := &cobra.Command{
+		Use:              "import-state [flags] FILE",
+		Short:            "Create a container archived by export-state",
+		PreRunE:          logging.Init,
+		RunE:             containerImportState,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerImportStateCommand.Flags().SetInterspersed(false)
+	containerImportStateCommand.Flags().BoolP("help", "h", false, "show help")
+	containerImportStateCommand.Flags().StringP("name", "n", "", "name of the imported container")
+	containerImportStateCommand.Flags().Bool("hooks", false, "keep the container's hooks, that run commands on this host")
+
+	containerCommand.AddCommand(containerCloneCommand)
+	containerCommand.AddCommand(containerExportStateCommand)
+	containerCommand.AddCommand(containerImportStateCommand)
+	containerCommand.AddCommand(newContainerLabelCommand())
+	containerCommand.AddCommand(containerPruneCommand)
+
+	return containerCommand
+}
+
+// newContainerLabelCommand will manage the labels of existing containers.
+func newContainerLabelCommand() *cobra.Command {
+	containerLabelCommand := &cobra.Command{
+		Use:              "label",
+		Short:            "Manage the labels of a container",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerLabelCommand.Flags().BoolP("help", "h", false, "show help")
+
+	containerLabelAddCommand := &cobra.Command{
+		Use:              "add [flags] CONTAINER KEY=VALUE [KEY=VALUE...]",
+		Short:            "Set labels on a container, replacing the ones with the same key",
+		PreRunE:          logging.Init,
+		RunE:             containerLabelAdd,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerLabelAddCommand.Flags().SetInterspersed(false)
+	containerLabelAddCommand.Flags().BoolP("help", "h", false, "show help")
+
+	containerLabelRemoveCommand := &cobra.Command{
+		Use:              "remove [flags] CONTAINER KEY [KEY...]",
+		Short:            "Remove labels from a container",
+		PreRunE:          logging.Init,
+		RunE:             containerLabelRemove,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	containerLabelRemoveCommand.Flags().SetInterspersed(false)
+	containerLabelRemoveCommand.Flags().BoolP("help", "h", false, "show help")
+
+	containerLabelCommand.AddCommand(containerLabelAddCommand)
+	containerLabelCommand.AddCommand(containerLabelRemoveCommand)
+
+	return containerLabelCommand
+}
+
+func containerLabelAdd(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	id, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.UpdateLabels(id, arguments[1:], nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(arguments[0])
+
+	return nil
+}
+
+func containerLabelRemove(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	id, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.UpdateLabels(id, nil, arguments[1:])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(arguments[0])
+
+	return nil
+}
+
+func containerClone(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	reset, err := cmd.Flags().GetBool("reset")
+	if err != nil {
+		return err
+	}
+
+	// rootfs files are owned by the container's users, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	id, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(id), "config"))
+	if err != nil {
+		return err
+	}
+
+	name := config.Names + "-clone"
+	if len(arguments) > 1 {
+		name = arguments[1]
+	}
+
+	cloneID, err := containerutils.Clone(id, name, reset)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(cloneID)
+
+	return nil
+}
+
+func containerExportState(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	output, err := getAbsFlag(cmd, "output")
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := cmd.Flags().GetBool("checkpoint")
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return fmt.Errorf("--output is required")
+	}
+
+	// rootfs files are owned by the container's users, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	id, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	return containerutils.ExportState(id, output, checkpoint)
+}
+
+func containerImportState(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return err
+	}
+
+	hooks, err := cmd.Flags().GetBool("hooks")
+	if err != nil {
+		return err
+	}
+
+	input, err := filepath.Abs(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	// rootfs files are owned by the container's users, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	id, err := containerutils.ImportState(input, name, hooks)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+
+	return nil
+}
+
+func containerPrune(cmd *cobra.Command, _ []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+
+	filter, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return err
+	}
+
+	var until time.Time
+
+	for key, value := range utils.ListToMap(filter) {
+		if key != "until" {
+			return fmt.Errorf("unsupported filter %s", key)
+		}
+
+		until, err = parseUntil(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// rootfs files are owned by the container's users, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	if !force {
+		fmt.Print("WARNING! This will remove all stopped containers.\n" +
+			"Are you sure you want to continue? [y/N] ")
+
+		input, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		answer := strings.ToLower(strings.TrimSpace(input))
+		if answer != "y" && answer != "yes" {
+			return nil
+		}
+	}
+
+	pruned, err := containerutils.PruneContainers(until)
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+
+	for _, container := range pruned {
+		fmt.Printf("%s\t%s\n", container.Name, utils.HumanSize(container.Size))
+
+		total += container.Size
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", utils.HumanSize(total))
+
+	return nil
+}
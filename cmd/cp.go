@@ -3,11 +3,9 @@ package cmd
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
-	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
 	"github.com/spf13/cobra"
@@ -26,48 +24,80 @@ func NewCpCommand() *cobra.Command {
 	}
 
 	cpCommand.Flags().SetInterspersed(false)
+	cpCommand.Flags().BoolP("help", "h", false, "show help")
+	cpCommand.Flags().BoolP("archive", "a", true, "chown copied files to the primary user of the destination, "+
+		"with --archive=false they keep the owner of the source")
+	cpCommand.Flags().BoolP("follow-link", "L", false, "always follow symbolic links in SRC_PATH")
 
 	return cpCommand
 }
 
+// splitCopyPath returns the container and the path of input cp argument, in
+// the form of [container:]path. Host paths can contain colons too, when they
+// come after a slash, eg: ./file:1.
+func splitCopyPath(argument string) (string, string) {
+	container, path, found := strings.Cut(argument, ":")
+	if !found || container == "" || strings.Contains(container, "/") {
+		return "", argument
+	}
+
+	return container, path
+}
+
 func cp(cmd *cobra.Command, arguments []string) error {
 	if len(arguments) < 2 {
 		return cmd.Help()
 	}
 
-	success, err := procutils.EnsureFakeRoot(true)
+	archive, err := cmd.Flags().GetBool("archive")
 	if err != nil {
 		return err
 	}
 
-	if success {
-		return nil
+	followLink, err := cmd.Flags().GetBool("follow-link")
+	if err != nil {
+		return err
 	}
 
-	src := arguments[0]
-	dest := arguments[1]
+	srcContainer, src := splitCopyPath(arguments[0])
+	destContainer, dest := splitCopyPath(arguments[1])
 
-	if strings.Contains(src, ":") {
-		container := strings.Split(src, ":")[0]
-		file := strings.Split(src, ":")[1]
+	if srcContainer != "" && destContainer != "" {
+		return fmt.Errorf("copying between containers is not supported")
+	}
 
-		src = filepath.Join(containerutils.GetRootfsDir(container), file)
+	if srcContainer == "" && destContainer == "" {
+		return fmt.Errorf("one of the source and the destination must be a container")
+	}
 
-		if !fileutils.Exist(containerutils.GetDir(container)) {
-			return fmt.Errorf("container %s does not exist", container)
-		}
+	// files in the rootfs are owned by the container's users, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
 	}
 
-	if strings.Contains(dest, ":") {
-		container := strings.Split(dest, ":")[0]
-		file := strings.Split(dest, ":")[1]
+	if success {
+		return nil
+	}
 
-		dest = filepath.Join(containerutils.GetRootfsDir(container), file)
+	options := containerutils.CopyOptions{
+		Archive:    archive,
+		FollowLink: followLink,
+	}
 
-		if !fileutils.Exist(containerutils.GetDir(container)) {
-			return fmt.Errorf("container %s does not exist", container)
+	if srcContainer != "" {
+		id, err := containerutils.ResolveID(srcContainer)
+		if err != nil {
+			return err
 		}
+
+		return containerutils.CopyFromContainer(id, src, dest, options)
+	}
+
+	id, err := containerutils.ResolveID(destContainer)
+	if err != nil {
+		return err
 	}
 
-	return fileutils.CopyFileContainer(src, dest)
+	return containerutils.CopyToContainer(id, src, dest, options)
 }
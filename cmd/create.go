@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/89luca89/lilipod/pkg/constants"
@@ -14,7 +17,6 @@ import (
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
 	"github.com/89luca89/lilipod/pkg/utils"
-	imgName "github.com/google/go-containerregistry/pkg/name"
 	"github.com/spf13/cobra"
 )
 
@@ -33,34 +35,74 @@ func NewCreateCommand() *cobra.Command {
 	createCommand.Flags().SetInterspersed(false)
 	createCommand.Flags().Bool("help", false, "show help")
 	createCommand.Flags().Bool("privileged", false, "give extended privileges to the container")
-	createCommand.Flags().Bool("pull", false, "pull image before running")
+	createCommand.Flags().Bool("locale-gen", false, "generate the host's locale inside the container at start")
+	createCommand.Flags().Bool("init", false, "run an init inside the container that forwards signals and reaps processes")
+	createCommand.Flags().Bool("rm", false, "delete container at the end of execution")
+	createCommand.Flags().Bool("replace", false, "stop and remove the container with the same name, if it exists")
+	createCommand.Flags().String("profile", "", "apply the options, image and command of a profile in profiles.json")
+	createCommand.Flags().BoolP("tty", "t", false, "allocate a pseudo-TTY when started in background, to attach to it")
+	createCommand.Flags().String("pull", imageutils.PullMissing, "pull policy of the image: always, missing, never or newer")
+	createCommand.Flags().Lookup("pull").NoOptDefVal = imageutils.PullAlways
+	createCommand.Flags().String("platform", "", "platform of the image, eg: linux/arm64, it is pulled if missing")
+	createCommand.Flags().Bool("tls-verify", true, "verify the TLS certificate of the registry, and require HTTPS")
+	createCommand.Flags().String("cert-dir", "", "directory with the certificates to use for the registry, instead of certs.d")
+	createCommand.Flags().Int("retry", 3, "number of times to retry a failed download of the image")
+	createCommand.Flags().Duration("retry-delay", 2*time.Second, "delay before the first retry, doubled for each next one")
+	createCommand.Flags().String("cidfile", "", "write the container ID to the file")
 	createCommand.Flags().String("cgroupns", constants.Private, "cgroup namespace to use")
+	createCommand.Flags().String("domainname", "", "set container NIS domainname")
 	createCommand.Flags().String("entrypoint", "", "overwrite command to execute when starting the container")
+	createCommand.Flags().String("ip", "", "static IPv4 address of the container in a private or bridge network")
 	createCommand.Flags().String("ipc", constants.Private, "IPC namespace to use")
+	createCommand.Flags().String("mac-address", "", "static MAC address of the container in a private or bridge network")
 	createCommand.Flags().String("name", containerutils.GetRandomName(), "Assign a name to the container")
 	createCommand.Flags().String("network", constants.Private, "connect a container to a network")
+	createCommand.Flags().String("network-backend", "", "backend of the private network: pasta or slirp4netns (default pasta if installed)")
+	createCommand.Flags().StringArray("network-alias", nil, "add a name the container is resolved with in its network")
+	createCommand.Flags().String("pidfile", "", "write the container process ID to the file when started")
 	createCommand.Flags().String("pid", constants.Private, "pid namespace to use")
 	createCommand.Flags().String("time", constants.Private, "time namespace to use")
 	createCommand.Flags().String("userns", constants.KeepID, "user namespace to use")
-	createCommand.Flags().String("stop-signal", "SIGTERM", "signal to stop the container")
+	createCommand.Flags().String("stop-signal", "", "signal to stop the container (default the image's StopSignal, or SIGTERM)")
+	createCommand.Flags().Int("stop-timeout", containerutils.DefaultStopTimeout, "seconds to wait for the container to stop before killing it")
+	createCommand.Flags().String("idle-timeout", "", "stop the container in background after a duration (eg: 30m) without exec or attach sessions")
+	createCommand.Flags().String("tz", containerutils.TimezoneLocal, "set timezone in container, local mirrors the host")
+	createCommand.Flags().String("storage-driver", imageutils.StorageDriverFiles, "storage driver for the rootfs: files, or erofs (experimental)")
+	createCommand.Flags().String("runtime", containerutils.RuntimeBuiltin, "runtime to execute the container with: builtin, crun or runc")
+	createCommand.Flags().String("stats-history", "", "record resource usage every interval (eg: 10s) for stats --history")
+	createCommand.Flags().String("cpus", "", "number of CPUs the container can use, eg: 1.5")
+	createCommand.Flags().String("pids-limit", "", "maximum number of processes in the container")
+	createCommand.Flags().String("restart", containerutils.RestartNo, "restart policy when the container exits: no, on-failure[:max], always or unless-stopped")
 	//nolint:lll
 	createCommand.Flags().StringArrayP("env", "e", nil, "set environment variables in container (default [PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin,TERM=xterm])")
+	createCommand.Flags().StringArray("group-add", nil, "add additional groups, names or GIDs, to the container process")
+	createCommand.Flags().StringArray("requires", nil, "containers to start before this one, comma separated")
 	createCommand.Flags().StringArrayP("label", "", nil, "set metadata on container")
+	createCommand.Flags().StringArray("label-file", nil, "read in a line delimited file of labels")
 	createCommand.Flags().StringArrayP("volume", "v", nil, "bind mount a volume into the container")
 	createCommand.Flags().StringArrayP("mount", "", nil, "perform a mount into the container")
+	createCommand.Flags().StringArrayP("publish", "p", nil, "publish a container port on the host, [hostIP:][hostPort:]containerPort[/proto]")
 	createCommand.Flags().StringP("hostname", "h", "", "set container hostname")
+	createCommand.Flags().StringP("memory", "m", "", "memory limit of the container, eg: 512m or 1g")
 	createCommand.Flags().StringP("user", "u", "root:root", "username or UID (format: <name|uid>[:<group|gid>])")
 
 	// This does nothing, it's here for CLI compatibility with podman/docker
 	createCommand.Flags().String("security-opt", "", "")
-	createCommand.Flags().String("pids-limit", "", "")
 	_ = createCommand.Flags().MarkHidden("security-opt")
-	_ = createCommand.Flags().MarkHidden("pids-limit")
+
+	addHealthFlags(createCommand)
+	addHookFlags(createCommand)
+	addLogDriverFlags(createCommand)
 
 	return createCommand
 }
 
 func create(cmd *cobra.Command, arguments []string) error {
+	arguments, err := applyProfile(cmd, arguments)
+	if err != nil {
+		return err
+	}
+
 	if len(arguments) < 1 {
 		return cmd.Help()
 	}
@@ -74,7 +116,17 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return nil
 	}
 
-	pull, err := cmd.Flags().GetBool("pull")
+	pullPolicy, err := cmd.Flags().GetString("pull")
+	if err != nil {
+		return err
+	}
+
+	replace, err := cmd.Flags().GetBool("replace")
+	if err != nil {
+		return err
+	}
+
+	platform, err := cmd.Flags().GetString("platform")
 	if err != nil {
 		return err
 	}
@@ -89,6 +141,11 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	domainname, err := cmd.Flags().GetString("domainname")
+	if err != nil {
+		return err
+	}
+
 	ipc, err := cmd.Flags().GetString("ipc")
 	if err != nil {
 		return err
@@ -104,6 +161,70 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	ip, err := cmd.Flags().GetString("ip")
+	if err != nil {
+		return err
+	}
+
+	macAddress, err := cmd.Flags().GetString("mac-address")
+	if err != nil {
+		return err
+	}
+
+	network, err = containerutils.ResolveNetwork(network)
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateAddressing(network, ip, macAddress)
+	if err != nil {
+		return err
+	}
+
+	ports, err := cmd.Flags().GetStringArray("publish")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidatePorts(network, ports)
+	if err != nil {
+		return err
+	}
+
+	networkBackend, err := cmd.Flags().GetString("network-backend")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateNetworkBackend(network, networkBackend)
+	if err != nil {
+		return err
+	}
+
+	networkAliases, err := cmd.Flags().GetStringArray("network-alias")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateNetworkAliases(network, networkAliases)
+	if err != nil {
+		return err
+	}
+
+	// containers of bridge networks have an address of their own
+	ip, err = containerutils.AllocateAddress(network, ip)
+	if err != nil {
+		return err
+	}
+
+	// pin a MAC address too, so that the static address stays stable
+	if ip != "" && macAddress == "" {
+		macAddress, err = containerutils.GenerateMacAddress()
+		if err != nil {
+			return err
+		}
+	}
+
 	cgroup, err := cmd.Flags().GetString("cgroupns")
 	if err != nil {
 		return err
@@ -124,6 +245,26 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	groupAdd, err := cmd.Flags().GetStringArray("group-add")
+	if err != nil {
+		return err
+	}
+
+	requiresFlag, err := cmd.Flags().GetStringArray("requires")
+	if err != nil {
+		return err
+	}
+
+	requires, err := containerutils.ResolveRequires(requiresFlag)
+	if err != nil {
+		return err
+	}
+
+	// the container whose network is shared is started first
+	if id, ok := containerutils.GetNetworkContainer(network); ok && !slices.Contains(requires, id) {
+		requires = append(requires, id)
+	}
+
 	userns, err := cmd.Flags().GetString("userns")
 	if err != nil {
 		return err
@@ -134,6 +275,26 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	stopTimeout, err := cmd.Flags().GetInt("stop-timeout")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateStopConfig(stopsignal, stopTimeout)
+	if err != nil {
+		return err
+	}
+
+	idleTimeout, err := cmd.Flags().GetString("idle-timeout")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateIdleTimeout(idleTimeout)
+	if err != nil {
+		return err
+	}
+
 	entrypoint, err := cmd.Flags().GetString("entrypoint")
 	if err != nil {
 		return err
@@ -144,7 +305,7 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	label, err := cmd.Flags().GetStringArray("label")
+	label, err := getLabels(cmd)
 	if err != nil {
 		return err
 	}
@@ -159,30 +320,170 @@ func create(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	err = containerutils.ValidateVolumes(append(mount, volume...))
+	if err != nil {
+		return err
+	}
+
+	timezone, err := cmd.Flags().GetString("tz")
+	if err != nil {
+		return err
+	}
+
+	localeGen, err := cmd.Flags().GetBool("locale-gen")
+	if err != nil {
+		return err
+	}
+
+	useInit, err := cmd.Flags().GetBool("init")
+	if err != nil {
+		return err
+	}
+
+	tty, err := cmd.Flags().GetBool("tty")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateTimezone(timezone)
+	if err != nil {
+		return err
+	}
+
+	storageDriver, err := cmd.Flags().GetString("storage-driver")
+	if err != nil {
+		return err
+	}
+
+	err = imageutils.ValidateStorageDriver(storageDriver)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := cmd.Flags().GetString("runtime")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateRuntime(runtime)
+	if err != nil {
+		return err
+	}
+
+	statsInterval, err := cmd.Flags().GetString("stats-history")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateStatsInterval(statsInterval)
+	if err != nil {
+		return err
+	}
+
+	restart, err := cmd.Flags().GetString("restart")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateRestartPolicy(restart)
+	if err != nil {
+		return err
+	}
+
+	remove, err := cmd.Flags().GetBool("rm")
+	if err != nil {
+		return err
+	}
+
+	if remove && containerutils.IsRestarted(restart) {
+		return fmt.Errorf("--rm cannot be used with --restart, a removed container cannot be restarted")
+	}
+
+	memory, err := cmd.Flags().GetString("memory")
+	if err != nil {
+		return err
+	}
+
+	cpus, err := cmd.Flags().GetString("cpus")
+	if err != nil {
+		return err
+	}
+
+	pidsLimit, err := cmd.Flags().GetString("pids-limit")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateResources(memory, cpus, pidsLimit)
+	if err != nil {
+		return err
+	}
+
+	cidfile, err := getAbsFlag(cmd, "cidfile")
+	if err != nil {
+		return err
+	}
+
+	if cidfile != "" && fileutils.Exist(cidfile) {
+		return fmt.Errorf("container ID file %s already exists", cidfile)
+	}
+
+	pidfile, err := getAbsFlag(cmd, "pidfile")
+	if err != nil {
+		return err
+	}
+
+	healthcheck, err := getHealthConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	logDriver, logOpts, err := getLogDriverConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := getHooksConfig(cmd)
+	if err != nil {
+		return err
+	}
+
 	// default hostname to name if not specified.
 	if hostname == "" {
 		hostname = name
 	}
 
-	image := cmd.Flags().Args()[0]
+	image := arguments[0]
+
+	err = setImageRegistryTLS(cmd, image)
+	if err != nil {
+		return err
+	}
+
+	err = setRetryPolicy(cmd)
+	if err != nil {
+		return err
+	}
+
+	// missing images are pulled here, so that short names are resolved
+	// with the search registries, and referenced by their full name.
+	pull, err := imageutils.ShouldPull(image, pullPolicy, platform)
+	if err != nil {
+		return err
+	}
 
 	if pull {
 		logging.LogDebug("pulling image: %s", image)
 
-		_, err := imageutils.Pull(image, false)
+		_, err := imageutils.Pull(image, platform, false)
 		if err != nil {
 			return err
 		}
-	}
 
-	if !fileutils.Exist(imageutils.GetPath(image)) {
-		ref, err := imgName.ParseReference(image)
-		if err == nil {
-			image = ref.Name()
-		}
+		image = imageutils.GetName(image)
 	}
 
-	args := cmd.Flags().Args()[1:]
+	args := arguments[1:]
 	if len(args) == 0 {
 		args = nil
 	}
@@ -199,41 +500,346 @@ func create(cmd *cobra.Command, arguments []string) error {
 	uid := os.Getenv("PARENT_UID_MAP")
 	gid := os.Getenv("PARENT_GID_MAP")
 
+	id, err := containerutils.NewID()
+	if err != nil {
+		return err
+	}
+
 	createConfig := utils.Config{
-		ID:         containerutils.GetID(name),
+		ID:         id,
 		Env:        env,
 		Cgroup:     cgroup,
-		Created:    time.Now().Format("2006.01.02 15:04:05"),
+		Created:    time.Now().Format(time.RFC3339),
+		Domainname: domainname,
 		Hostname:   hostname,
 		Image:      image,
 		Ipc:        ipc,
 		Names:      name,
 		Network:    network,
+		IP:         ip,
+		MacAddress: macAddress,
+		Ports:      ports,
 		Pid:        pid,
+		PidFile:    pidfile,
 		Privileged: privileged,
 		Time:       timens,
 		User:       user,
+		GroupAdd:   groupAdd,
+		Requires:   requires,
 		Userns:     userns,
 		Workdir:    "/",
 		Stopsignal: stopsignal,
 		Mounts:     append(mount, volume...),
 		Labels:     utils.ListToMap(label),
+		Timezone:   timezone,
+		LocaleGen:  localeGen,
+		Init:       useInit,
+		Tty:        tty,
+		// logging related
+		LogDriver: logDriver,
+		LogOpts:   logOpts,
+		// storage related
+		StorageDriver: storageDriver,
+		// runtime related
+		Runtime: runtime,
+		// network related
+		NetworkBackend: networkBackend,
+		NetworkAliases: networkAliases,
+		// stats related
+		StatsInterval: statsInterval,
+		// resources related
+		Memory:    memory,
+		CPUs:      cpus,
+		PidsLimit: pidsLimit,
+		// restart related
+		Restart:    restart,
+		AutoRemove: remove,
+		// stop related
+		StopTimeout: stopTimeout,
+		// idle related
+		IdleTimeout: idleTimeout,
+		// health related
+		Healthcheck: healthcheck,
+		// hooks related
+		Hooks: hooks,
 		// entry point related
 		Entrypoint: append(configEntrypoint, args...),
 	}
 
-	if fileutils.Exist(filepath.Join(containerutils.GetDir(name), "config")) {
-		return fmt.Errorf("container %s already exists", name)
+	replaced, err := getReplacedContainer(name, replace)
+	if err != nil {
+		return err
+	}
+
+	// the new container takes the name once the old one is removed
+	if replaced != "" {
+		createConfig.Names = name + "-" + containerutils.ShortID(createConfig.ID)
 	}
 
 	logging.LogDebug("preparing rootfs for: %s", name)
 
-	err = containerutils.CreateRootfs(image, name, createConfig, uid, gid)
+	err = containerutils.CreateRootfs(image, createConfig.Names, createConfig, uid, gid)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println(containerutils.GetID(name))
+	if replaced != "" {
+		err = replaceContainer(replaced, createConfig.ID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cidfile != "" {
+		err = writeCIDFile(cidfile, createConfig.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(createConfig.ID)
+
+	return nil
+}
+
+// getReplacedContainer returns the ID of the container with input name, to
+// replace with a new one, or an empty string if there is none.
+// Existing containers can only be replaced with replace, and if no other
+// container requires them.
+func getReplacedContainer(name string, replace bool) (string, error) {
+	id, ok := containerutils.FindByName(name)
+	if !ok {
+		return "", nil
+	}
+
+	if !replace {
+		return "", fmt.Errorf("container %s already exists, use --replace to replace it", name)
+	}
+
+	requiring := containerutils.GetRequiring(id)
+	if len(requiring) > 0 {
+		return "", fmt.Errorf("cannot replace container %s, as it is required by %s",
+			name, strings.Join(requiring, ", "))
+	}
+
+	return id, nil
+}
+
+// replaceContainer will stop and remove the replaced container, and give its
+// name to the container with input ID, created under a temporary name.
+// The new container is removed if the old one cannot be, so that a failed
+// replacement leaves the old one in place.
+func replaceContainer(replaced string, id string, name string) error {
+	logging.LogDebug("replacing container %s with %s", name, id)
+
+	err := stopContainer(replaced, false, -1)
+	if err == nil {
+		err = containerutils.Remove(replaced)
+	}
+
+	if err != nil {
+		_ = containerutils.Remove(id)
+
+		return fmt.Errorf("cannot replace container %s: %w", name, err)
+	}
+
+	return containerutils.Rename(id, name)
+}
+
+// applyProfile will set the options of the --profile on input command, and
+// return the arguments to use, the profile's image and command if none are
+// specified. Options specified on the command line take precedence, the
+// values of repeatable ones are added after the profile's.
+func applyProfile(cmd *cobra.Command, arguments []string) ([]string, error) {
+	name, err := cmd.Flags().GetString("profile")
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		return arguments, nil
+	}
+
+	profile, err := utils.LoadProfile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	image := []string{}
+	command := []string{}
+
+	for key, value := range profile {
+		values, err := getProfileValues(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %s of profile %s: %w", key, name, err)
+		}
+
+		switch key {
+		case "image":
+			image = values
+		case "command":
+			command = values
+		default:
+			err = setProfileFlag(cmd, key, values)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %s of profile %s: %w", key, name, err)
+		}
+	}
+
+	if len(arguments) > 0 {
+		return arguments, nil
+	}
+
+	if len(image) != 1 {
+		return nil, fmt.Errorf("profile %s has no image, specify it", name)
+	}
+
+	return append(image, command...), nil
+}
+
+// getProfileValues returns the values of an option of a profile, a string,
+// number, boolean, or a list of them.
+func getProfileValues(value any) ([]string, error) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, nil
+	case bool:
+		return []string{strconv.FormatBool(value)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(value, 'f', -1, 64)}, nil
+	case []any:
+		result := []string{}
+
+		for _, item := range value {
+			values, err := getProfileValues(item)
+			if err != nil || len(values) != 1 {
+				return nil, fmt.Errorf("lists can only contain strings, numbers or booleans")
+			}
+
+			result = append(result, values...)
+		}
+
+		return result, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// setProfileFlag will set input values of a profile on input flag, if it was
+// not specified on the command line. For repeatable flags, the values are
+// added before the ones of the command line.
+func setProfileFlag(cmd *cobra.Command, key string, values []string) error {
+	flag := cmd.Flags().Lookup(key)
+	if flag == nil || key == "profile" || key == "help" {
+		return fmt.Errorf("unknown option")
+	}
+
+	slice, repeatable := flag.Value.(interface {
+		Replace(values []string) error
+		GetSlice() []string
+	})
+
+	switch {
+	case repeatable && flag.Changed:
+		return slice.Replace(append(values, slice.GetSlice()...))
+	case flag.Changed:
+		return nil
+	case !repeatable && len(values) != 1:
+		return fmt.Errorf("only one value can be specified")
+	}
+
+	for _, value := range values {
+		err := cmd.Flags().Set(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getLabels returns the labels of the label files, followed by the ones of the
+// --label flags, so that these take precedence.
+func getLabels(cmd *cobra.Command) ([]string, error) {
+	labelFiles, err := cmd.Flags().GetStringArray("label-file")
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, labelFile := range labelFiles {
+		labels, err := utils.ReadListFile(labelFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read label file %s: %w", labelFile, err)
+		}
+
+		result = append(result, labels...)
+	}
+
+	label, err := cmd.Flags().GetStringArray("label")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(result, label...), nil
+}
+
+// addHookFlags will add the lifecycle hooks related flags to input command.
+func addHookFlags(command *cobra.Command) {
+	command.Flags().StringArray("hook-pre-start", nil, "host command to run before the container starts")
+	command.Flags().StringArray("hook-post-start", nil, "host command to run once the container is running")
+	command.Flags().StringArray("hook-post-stop", nil, "host command to run after the container exits")
+}
+
+// getHooksConfig will return the lifecycle hooks from the command's flags.
+// If no hook is requested, nil is returned.
+func getHooksConfig(cmd *cobra.Command) (*utils.HooksConfig, error) {
+	preStart, err := cmd.Flags().GetStringArray("hook-pre-start")
+	if err != nil {
+		return nil, err
+	}
+
+	postStart, err := cmd.Flags().GetStringArray("hook-post-start")
+	if err != nil {
+		return nil, err
+	}
+
+	postStop, err := cmd.Flags().GetStringArray("hook-post-stop")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(preStart) == 0 && len(postStart) == 0 && len(postStop) == 0 {
+		return nil, nil //nolint: nilnil
+	}
+
+	return &utils.HooksConfig{
+		PreStart:  preStart,
+		PostStart: postStart,
+		PostStop:  postStop,
+	}, nil
+}
+
+// getAbsFlag returns the absolute path of input path flag, as the container
+// can be started from another directory.
+func getAbsFlag(cmd *cobra.Command, flag string) (string, error) {
+	path, err := cmd.Flags().GetString(flag)
+	if err != nil || path == "" {
+		return path, err
+	}
+
+	return filepath.Abs(path)
+}
+
+// writeCIDFile will write input container ID to the cidfile.
+func writeCIDFile(path string, id string) error {
+	err := fileutils.WriteFile(path, []byte(id), 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot write container ID file %s: %w", path, err)
+	}
 
 	return nil
 }
@@ -0,0 +1,89 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/buildutils"
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/spf13/cobra"
+)
+
+// NewDiffCommand will show the changes to a container's filesystem.
+func NewDiffCommand() *cobra.Command {
+	diffCommand := &cobra.Command{
+		Use:              "diff [flags] CONTAINER",
+		Short:            "Inspect changes to a container's filesystem",
+		PreRunE:          logging.Init,
+		RunE:             diff,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	diffCommand.Flags().SetInterspersed(false)
+	diffCommand.Flags().BoolP("help", "h", false, "show help")
+	diffCommand.Flags().String("format", "", "output format, can be json")
+
+	return diffCommand
+}
+
+func diff(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	if format != "" && format != "json" {
+		return fmt.Errorf("unsupported format %s, valid formats are: json", format)
+	}
+
+	id, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	err = ensureContainer(id)
+	if err != nil {
+		return err
+	}
+
+	// rootfs files are owned by the container's users, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	changes, err := buildutils.Diff(id)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		out, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(out))
+
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Println(change.Kind, change.Path)
+	}
+
+	return nil
+}
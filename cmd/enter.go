@@ -2,12 +2,16 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os/exec"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/utils"
 	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
 )
 
 // NewEnterCommand will enter target container environment.
@@ -48,5 +52,21 @@ func enterContainer(cmd *cobra.Command, _ []string) error {
 		return fmt.Errorf("invalid config: %+w", err)
 	}
 
-	return containerutils.RunContainer(tty, conf)
+	err = containerutils.RunContainer(tty, conf)
+	if errors.Is(err, containerutils.ErrEntrypoint) {
+		// like shells do, entrypoints that cannot be found or executed exit
+		// with 127 and 126, which is recorded as the container's exit code
+		switch {
+		case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+			logging.LogError("%v", err)
+
+			return &ExitCodeError{Code: 127}
+		case errors.Is(err, fs.ErrPermission) || errors.Is(err, unix.ENOEXEC):
+			logging.LogError("%v", err)
+
+			return &ExitCodeError{Code: 126}
+		}
+	}
+
+	return err
 }
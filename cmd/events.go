@@ -0,0 +1,108 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewEventsCommand will show the events recorded for containers.
+func NewEventsCommand() *cobra.Command {
+	eventsCommand := &cobra.Command{
+		Use:              "events [flags]",
+		Short:            "Show container events",
+		PreRunE:          logging.Init,
+		RunE:             showEvents,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	eventsCommand.Flags().SetInterspersed(false)
+	eventsCommand.Flags().BoolP("help", "h", false, "show help")
+	eventsCommand.Flags().BoolP("follow", "f", false, "keep waiting for new events")
+	eventsCommand.Flags().String("format", "", "output format, can be json")
+	eventsCommand.Flags().StringArrayP("filter", "", nil, "filter events (container=NAME, event=ACTION, status=STATUS)")
+
+	return eventsCommand
+}
+
+func showEvents(cmd *cobra.Command, _ []string) error {
+	follow, err := cmd.Flags().GetBool("follow")
+	if err != nil {
+		return err
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	filter, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return err
+	}
+
+	filters := utils.ListToMap(filter)
+
+	return events.Read(follow, func(event events.Event) error {
+		if !filterEvent(event, filters) {
+			return nil
+		}
+
+		if format == "json" {
+			out, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(string(out))
+
+			return nil
+		}
+
+		attributeList := utils.MapToList(event.Attributes)
+		sort.Strings(attributeList)
+
+		attributes := strings.Join(attributeList, ", ")
+
+		fmt.Printf("%s %s %s %s (name=%s", event.Time, event.Type, event.Action, event.ID, event.Name)
+
+		if attributes != "" {
+			fmt.Printf(", %s", attributes)
+		}
+
+		fmt.Println(")")
+
+		return nil
+	})
+}
+
+// filterEvent will return true if input event respects all input filters.
+func filterEvent(event events.Event, filters map[string]string) bool {
+	for key, value := range filters {
+		switch key {
+		case "container":
+			if event.Name != value && !strings.HasPrefix(event.ID, value) {
+				return false
+			}
+		case "event":
+			if event.Action != value {
+				return false
+			}
+		default:
+			if event.Attributes[key] != value {
+				return false
+			}
+		}
+	}
+
+	return true
+}
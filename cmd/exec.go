@@ -3,12 +3,17 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
 )
 
@@ -25,14 +30,15 @@ func NewExecCommand() *cobra.Command {
 	}
 
 	execCommand.Flags().SetInterspersed(false)
-	execCommand.Flags().BoolP("detach", "d", false, "run the exec session in detached mode (backgrounded)")
+	execCommand.Flags().BoolP("detach", "d", false, "run the exec session in background, with its output in the logs")
 	execCommand.Flags().BoolP("help", "h", false, "show help")
+	execCommand.Flags().Bool("history", false, "show the recorded exec sessions of the container")
 	execCommand.Flags().BoolP("interactive", "i", false, "keep STDIN open even if not attached")
 	execCommand.Flags().BoolP("tty", "t", false, "allocate a pseudo-TTY. The default is false")
-	//nolint:lll
-	execCommand.Flags().StringArrayP("env", "e", nil, "set environment variables in container (default [PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin,TERM=xterm])")
-	execCommand.Flags().StringP("user", "u", "root:root", "username or UID (format: <name|uid>[:<group|gid>])")
-	execCommand.Flags().StringP("workdir", "w", "/", "working directory inside the container")
+	execCommand.Flags().String("stdin-script", "", "run a script from this file, or - for stdin, with COMMAND (default /bin/sh -s)")
+	execCommand.Flags().StringArrayP("env", "e", nil, "set environment variables for the session, VAR alone takes it from the host")
+	execCommand.Flags().StringP("user", "u", "", "username or UID (format: <name|uid>[:<group|gid>]), default is the container's")
+	execCommand.Flags().StringP("workdir", "w", "", "working directory inside the container, default is the container's")
 
 	// This does nothing, it's here for CLI compatibility with podman/docker
 	execCommand.Flags().String("detach-keys", "", "")
@@ -45,6 +51,15 @@ func execute(cmd *cobra.Command, arguments []string) error {
 		return cmd.Help()
 	}
 
+	history, err := cmd.Flags().GetBool("history")
+	if err != nil {
+		return err
+	}
+
+	if history {
+		return execHistory(cmd.Flags().Args()[0])
+	}
+
 	detach, err := cmd.Flags().GetBool("detach")
 	if err != nil {
 		return err
@@ -75,13 +90,19 @@ func execute(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	env = append(
-		[]string{"TERM=xterm", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
-		env...)
+	stdinScript, err := cmd.Flags().GetString("stdin-script")
+	if err != nil {
+		return err
+	}
 
 	container := cmd.Flags().Args()[0]
 	entrypoint := cmd.Flags().Args()[1:]
 
+	// the script is fed to a shell reading commands from stdin by default
+	if stdinScript != "" && len(entrypoint) == 0 {
+		entrypoint = []string{"/bin/sh", "-s"}
+	}
+
 	if len(entrypoint) == 0 {
 		return fmt.Errorf("entrypoint command empty, please specify one")
 	}
@@ -93,10 +114,15 @@ func execute(cmd *cobra.Command, arguments []string) error {
 	if detach {
 		interactive = false
 		tty = false
+
+		if stdinScript != "" {
+			return fmt.Errorf("--stdin-script cannot be used with --detach")
+		}
 	}
 
-	if !fileutils.Exist(containerutils.GetDir(container)) {
-		return fmt.Errorf("container %s does not exist", container)
+	_, err = containerutils.ResolveID(container)
+	if err != nil {
+		return err
 	}
 
 	// ensure a container for this name is already running
@@ -105,6 +131,10 @@ func execute(cmd *cobra.Command, arguments []string) error {
 		return fmt.Errorf("container %s is not running", container)
 	}
 
+	if containerutils.IsPaused(container) {
+		return fmt.Errorf("container %s is paused, unpause it first", container)
+	}
+
 	configPath := filepath.Join(containerutils.GetDir(container), "config")
 	if fileutils.Exist(configPath) {
 		config, err := utils.LoadConfig(configPath)
@@ -114,10 +144,27 @@ func execute(cmd *cobra.Command, arguments []string) error {
 
 		logging.LogDebug("entering: %s", container)
 
-		config.User = user
+		// the container's settings apply, unless overridden for this session
+		if user != "" {
+			config.User = user
+		}
+
+		if workdir != "" {
+			config.Workdir = workdir
+		}
+
 		config.Entrypoint = entrypoint
-		config.Env = append(config.Env, env...)
-		config.Workdir = workdir
+		config.Env = utils.MergeEnv(
+			[]string{"TERM=xterm", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"},
+			append(config.Env, env...)...)
+
+		if detach {
+			return execDetached(container, config)
+		}
+
+		if stdinScript != "" {
+			return execScript(containerPid, stdinScript, config)
+		}
 
 		err = containerutils.Exec(containerPid, interactive, tty, config)
 		if err != nil {
@@ -129,3 +176,85 @@ func execute(cmd *cobra.Command, arguments []string) error {
 
 	return nil
 }
+
+// execDetached will run the exec session of input container config in a new
+// session, that keeps it running once we exit, with its output in the logs.
+func execDetached(container string, config utils.Config) error {
+	args := []string{
+		"--log-level", logging.GetLogLevel(), "exec",
+		"--user", config.User, "--workdir", config.Workdir,
+	}
+
+	for _, variable := range config.Env {
+		args = append(args, "--env", variable)
+	}
+
+	args = append(args, container)
+	args = append(args, config.Entrypoint...)
+
+	logging.LogDebug("executing detached: %v", args)
+
+	execCmd := exec.Command(os.Args[0], args...)
+	execCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err := execCmd.Start()
+	if err != nil {
+		return err
+	}
+
+	return execCmd.Process.Release()
+}
+
+// execScript will run the script from input path, or stdin for -, in the
+// container. The exit code of the script is the one of the exec session.
+func execScript(containerPid int, path string, config utils.Config) error {
+	script := os.Stdin
+
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = file.Close() }()
+
+		script = file
+	}
+
+	logging.LogDebug("executing script %s with %v", path, config.Entrypoint)
+
+	return containerutils.ExecScript(containerPid, script, config)
+}
+
+// execHistory will print a table of the exec sessions recorded for input container.
+func execHistory(container string) error {
+	_, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	records, err := containerutils.GetExecHistory(container)
+	if err != nil {
+		return err
+	}
+
+	historyTable := table.NewWriter()
+	historyTable.SetOutputMirror(os.Stdout)
+	historyTable.SetStyle(utils.GetDefaultTable())
+	historyTable.AppendHeader(table.Row{"STARTED", "HOST USER", "USER", "COMMAND", "EXIT CODE", "DURATION"})
+
+	for _, record := range records {
+		historyTable.AppendRow(table.Row{
+			record.Started,
+			record.HostUser,
+			record.User,
+			strings.Join(record.Command, " "),
+			record.ExitCode,
+			record.Duration,
+		})
+	}
+
+	historyTable.Render()
+
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// NewExportCommand will export a container's filesystem as a tar archive.
+func NewExportCommand() *cobra.Command {
+	exportCommand := &cobra.Command{
+		Use:              "export [flags] CONTAINER",
+		Short:            "Export a container's filesystem as a tar archive",
+		PreRunE:          logging.Init,
+		RunE:             export,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	exportCommand.Flags().SetInterspersed(false)
+	exportCommand.Flags().BoolP("help", "h", false, "show help")
+	exportCommand.Flags().StringP("output", "o", "", "write to a file, instead of stdout")
+
+	return exportCommand
+}
+
+// export will write the rootfs of a container as a tar to stdout or a file.
+func export(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	output, err := getAbsFlag(cmd, "output")
+	if err != nil {
+		return err
+	}
+
+	if output == "" && term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("refusing to write the archive to a terminal, use --output or redirect stdout")
+	}
+
+	container := arguments[0]
+
+	err = ensureContainer(container)
+	if err != nil {
+		return err
+	}
+
+	// containers' files can be owned by the fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	if output == "" {
+		return containerutils.Export(container, os.Stdout)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.Export(container, file)
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(output)
+
+		return err
+	}
+
+	return file.Close()
+}
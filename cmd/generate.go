@@ -0,0 +1,84 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewGenerateCommand will generate structured output from containers.
+func NewGenerateCommand() *cobra.Command {
+	generateCommand := &cobra.Command{
+		Use:              "generate",
+		Short:            "Generate structured data based on containers",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	generateCommand.Flags().BoolP("help", "h", false, "show help")
+
+	generateSpecCommand := &cobra.Command{
+		Use:              "spec [flags] CONTAINER",
+		Short:            "Generate the OCI runtime spec of a container",
+		PreRunE:          logging.Init,
+		RunE:             generateSpec,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	generateSpecCommand.Flags().SetInterspersed(false)
+	generateSpecCommand.Flags().BoolP("help", "h", false, "show help")
+	generateSpecCommand.Flags().StringP("output", "o", "", "write the config.json of an OCI bundle in this directory")
+
+	generateCommand.AddCommand(generateSpecCommand)
+
+	return generateCommand
+}
+
+func generateSpec(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	container, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	spec, err := containerutils.GenerateSpec(container)
+	if err != nil {
+		return err
+	}
+
+	result, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		fmt.Println(string(result))
+
+		return nil
+	}
+
+	err = os.MkdirAll(output, 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(output, "config.json"), append(result, '\n'), 0o644)
+}
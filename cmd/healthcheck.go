@@ -0,0 +1,122 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewHealthcheckCommand will manage the healthchecks of containers.
+func NewHealthcheckCommand() *cobra.Command {
+	healthcheckCommand := &cobra.Command{
+		Use:              "healthcheck",
+		Short:            "Manage healthchecks of containers",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	healthcheckCommand.Flags().BoolP("help", "h", false, "show help")
+
+	healthcheckRunCommand := &cobra.Command{
+		Use:              "run [flags] CONTAINER",
+		Short:            "Run the healthcheck of a container",
+		PreRunE:          logging.Init,
+		RunE:             healthcheckRun,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	healthcheckRunCommand.Flags().SetInterspersed(false)
+	healthcheckRunCommand.Flags().BoolP("help", "h", false, "show help")
+
+	healthcheckCommand.AddCommand(healthcheckRunCommand)
+
+	return healthcheckCommand
+}
+
+func healthcheckRun(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	container := arguments[0]
+
+	_, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	state, err := containerutils.RunHealthcheck(container)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(state.Status)
+
+	if state.Status == containerutils.HealthUnhealthy {
+		return fmt.Errorf("container %s is unhealthy", container)
+	}
+
+	return nil
+}
+
+// addHealthFlags will add the healthcheck related flags to input command.
+func addHealthFlags(command *cobra.Command) {
+	command.Flags().String("health-cmd", "", "command to run to check the container's health")
+	command.Flags().String("health-interval", "30s", "time between running the healthcheck")
+	command.Flags().String("health-timeout", "30s", "maximum time allowed for the healthcheck to complete")
+	command.Flags().Int("health-retries", 3, "consecutive failures needed to report unhealthy")
+	command.Flags().Bool("no-healthcheck", false, "disable any healthcheck defined in the image")
+}
+
+// getHealthConfig will return the healthcheck configuration from the command's flags.
+// If no healthcheck is requested, nil is returned so the image default is used.
+func getHealthConfig(cmd *cobra.Command) (*utils.HealthConfig, error) {
+	healthCmd, err := cmd.Flags().GetString("health-cmd")
+	if err != nil {
+		return nil, err
+	}
+
+	interval, err := cmd.Flags().GetString("health-interval")
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := cmd.Flags().GetString("health-timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	retries, err := cmd.Flags().GetInt("health-retries")
+	if err != nil {
+		return nil, err
+	}
+
+	noHealthcheck, err := cmd.Flags().GetBool("no-healthcheck")
+	if err != nil {
+		return nil, err
+	}
+
+	if noHealthcheck {
+		return &utils.HealthConfig{Test: []string{"NONE"}}, nil
+	}
+
+	if strings.TrimSpace(healthCmd) == "" {
+		return nil, nil //nolint: nilnil
+	}
+
+	return &utils.HealthConfig{
+		Test:     []string{"CMD-SHELL", healthCmd},
+		Interval: interval,
+		Timeout:  timeout,
+		Retries:  retries,
+	}, nil
+}
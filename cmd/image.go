@@ -0,0 +1,445 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewImageCommand will manage the images in local storage.
+func NewImageCommand() *cobra.Command {
+	imageCommand := &cobra.Command{
+		Use:              "image",
+		Short:            "Manage images",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	imageCommand.Flags().BoolP("help", "h", false, "show help")
+
+	imageTreeCommand := &cobra.Command{
+		Use:              "tree [flags] [IMAGE...]",
+		Short:            "Show the layers of images, and the ones shared with other images",
+		PreRunE:          logging.Init,
+		RunE:             imageTree,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	imageTreeCommand.Flags().SetInterspersed(false)
+	imageTreeCommand.Flags().BoolP("help", "h", false, "show help")
+	imageTreeCommand.Flags().BoolP("no-trunc", "", false, "do not truncate data")
+
+	imageExportSquashfsCommand := &cobra.Command{
+		Use:              "export-squashfs [flags] IMAGE",
+		Short:            "Export an image as a flattened squashfs filesystem",
+		PreRunE:          logging.Init,
+		RunE:             imageExportSquashfs,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	imageExportSquashfsCommand.Flags().SetInterspersed(false)
+	imageExportSquashfsCommand.Flags().BoolP("help", "h", false, "show help")
+	imageExportSquashfsCommand.Flags().StringP("output", "o", "", "file to write the squashfs to")
+	imageExportSquashfsCommand.Flags().String("compression", "gzip", "compression algorithm, eg: gzip, xz, zstd")
+
+	imageHistoryCommand := &cobra.Command{
+		Use:              "history [flags] IMAGE",
+		Short:            "Show the steps that created an image, and their layers",
+		PreRunE:          logging.Init,
+		RunE:             imageHistory,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	imageHistoryCommand.Flags().SetInterspersed(false)
+	imageHistoryCommand.Flags().BoolP("help", "h", false, "show help")
+	imageHistoryCommand.Flags().BoolP("no-trunc", "", false, "do not truncate data")
+	imageHistoryCommand.Flags().String("format", "table", "output format (table, json)")
+
+	imageInspectCommand := &cobra.Command{
+		Use:              "inspect [flags] IMAGE...",
+		Short:            "Show the config, layers, size and platform of images",
+		PreRunE:          logging.Init,
+		RunE:             imageInspect,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	imageInspectCommand.Flags().SetInterspersed(false)
+	imageInspectCommand.Flags().BoolP("help", "h", false, "show help")
+	imageInspectCommand.Flags().StringP("format", "f", "", "pretty-print output using a Go template")
+
+	imagePruneCommand := &cobra.Command{
+		Use:              "prune [flags]",
+		Short:            "Remove images not used by any container",
+		PreRunE:          logging.Init,
+		RunE:             imagePrune,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	imagePruneCommand.Flags().SetInterspersed(false)
+	imagePruneCommand.Flags().BoolP("help", "h", false, "show help")
+	imagePruneCommand.Flags().BoolP("all", "a", false, "remove all unused images, not only dangling ones")
+	imagePruneCommand.Flags().StringArray("filter", nil, "filter images to remove (until=DURATION or TIMESTAMP)")
+
+	imageCommand.AddCommand(imageExportSquashfsCommand)
+	imageCommand.AddCommand(imageHistoryCommand)
+	imageCommand.AddCommand(imageInspectCommand)
+	imageCommand.AddCommand(imagePruneCommand)
+	imageCommand.AddCommand(imageTreeCommand)
+
+	return imageCommand
+}
+
+func imageTree(cmd *cobra.Command, arguments []string) error {
+	notrunc, err := cmd.Flags().GetBool("no-trunc")
+	if err != nil {
+		return err
+	}
+
+	// without arguments, show all images and the total disk usage
+	summary := len(arguments) == 0
+
+	if summary {
+		images, err := os.ReadDir(imageutils.ImageDir)
+		if err != nil {
+			logging.Log("no images found")
+
+			//nolint: nilerr
+			return nil
+		}
+
+		for _, image := range images {
+			arguments = append(arguments, image.Name())
+		}
+	}
+
+	var total, apparent uint64
+
+	layersSeen := map[string]bool{}
+
+	for i, image := range arguments {
+		if !fileutils.Exist(imageutils.GetPath(image)) {
+			return fmt.Errorf("image %s not found", image)
+		}
+
+		layers, err := imageutils.GetLayers(image)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+
+		err = printImageTree(image, layers, notrunc)
+		if err != nil {
+			return err
+		}
+
+		for _, layer := range layers {
+			apparent += layer.Size
+
+			if !layersSeen[layer.Digest] {
+				total += layer.Size
+			}
+
+			layersSeen[layer.Digest] = true
+		}
+	}
+
+	if summary {
+		fmt.Printf("\nTotal: %s on disk, %s saved by shared layers\n",
+			utils.HumanSize(total), utils.HumanSize(apparent-total))
+	}
+
+	return nil
+}
+
+func imageInspect(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	if format != "" && !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+
+	output, err := imageutils.Inspect(arguments, format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(output)
+
+	return nil
+}
+
+func imageExportSquashfs(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return fmt.Errorf("an output file is required, use --output")
+	}
+
+	compression, err := cmd.Flags().GetString("compression")
+	if err != nil {
+		return err
+	}
+
+	image := arguments[0]
+
+	if !fileutils.Exist(imageutils.GetPath(image)) {
+		return fmt.Errorf("image %s not found", image)
+	}
+
+	// layers are unpacked with their ownership, so we need to be fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	err = imageutils.ExportSquashfs(image, output, compression)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(output)
+
+	return nil
+}
+
+func imageHistory(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	notrunc, err := cmd.Flags().GetBool("no-trunc")
+	if err != nil {
+		return err
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %s, valid formats are: table, json", format)
+	}
+
+	image := arguments[0]
+
+	if !fileutils.Exist(imageutils.GetPath(image)) {
+		return fmt.Errorf("image %s not found", image)
+	}
+
+	history, err := imageutils.GetHistory(image)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(history, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(out))
+
+		return nil
+	}
+
+	historyTable := table.NewWriter()
+	historyTable.SetOutputMirror(os.Stdout)
+	historyTable.SetStyle(utils.GetDefaultTable())
+	historyTable.AppendHeader(table.Row{"LAYER", "CREATED", "CREATED BY", "SIZE", "COMMENT"})
+
+	for _, entry := range history {
+		layer := "<empty>"
+		size := "0B"
+
+		if entry.Layer != "" {
+			layer = entry.Layer
+			size = utils.HumanSize(entry.Size)
+
+			if !notrunc {
+				layer = layer[:len("sha256:")+12]
+			}
+		}
+
+		created := "unknown"
+		if !entry.Created.IsZero() {
+			created = entry.Created.Format(time.RFC3339)
+		}
+
+		createdBy := entry.CreatedBy
+		if !notrunc && len(createdBy) > 45 {
+			createdBy = createdBy[:42] + "..."
+		}
+
+		historyTable.AppendRow(table.Row{layer, created, createdBy, size, entry.Comment})
+	}
+
+	historyTable.Render()
+
+	return nil
+}
+
+func imagePrune(cmd *cobra.Command, _ []string) error {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	filter, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return err
+	}
+
+	var until time.Time
+
+	for key, value := range utils.ListToMap(filter) {
+		if key != "until" {
+			return fmt.Errorf("unsupported filter %s", key)
+		}
+
+		until, err = parseUntil(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	pruned, err := containerutils.PruneImages(all, until)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range pruned {
+		fmt.Println(image)
+	}
+
+	return nil
+}
+
+// parseUntil returns the time of input until filter, either a duration
+// before now, eg: 24h, or a timestamp.
+func parseUntil(input string) (time.Time, error) {
+	duration, err := time.ParseDuration(input)
+	if err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	timestamp := convert(input)
+	if timestamp <= 0 {
+		return time.Time{}, fmt.Errorf("invalid until filter %s, expected a duration or a timestamp", input)
+	}
+
+	return time.Unix(timestamp, 0), nil
+}
+
+// printImageTree will print input image with its containers and layers.
+func printImageTree(image string, layers []imageutils.Layer, notrunc bool) error {
+	name := imageutils.GetName(image)
+
+	var size, unique uint64
+
+	for _, layer := range layers {
+		size += layer.Size
+
+		if len(layer.SharedWith) == 0 {
+			unique += layer.Size
+		}
+	}
+
+	fmt.Printf("Image: %s\n", name)
+	fmt.Printf("ID: %s\n", imageutils.GetID(image))
+	fmt.Printf("Size: %s, %s unique\n", utils.HumanSize(size), utils.HumanSize(unique))
+
+	// containers using the erofs storage driver share the image too
+	erofs, err := os.Stat(imageutils.GetErofsPath(image))
+	if err == nil {
+		fmt.Printf("Erofs image: %s\n", utils.HumanSize(uint64(erofs.Size())))
+	}
+
+	dependents, err := containerutils.GetDependentContainers(name)
+	if err != nil {
+		return err
+	}
+
+	if len(dependents) > 0 {
+		names := []string{}
+
+		for _, dependent := range dependents {
+			if dependent.StorageDriver == imageutils.StorageDriverErofs {
+				names = append(names, dependent.Names+" (erofs)")
+			} else {
+				names = append(names, dependent.Names)
+			}
+		}
+
+		fmt.Printf("Containers: %s\n", strings.Join(names, ", "))
+	}
+
+	fmt.Println("Layers:")
+
+	for i, layer := range layers {
+		branch := "├─"
+		if i == len(layers)-1 {
+			branch = "└─"
+		}
+
+		digest := layer.Digest
+		if !notrunc {
+			digest = digest[:len("sha256:")+12]
+		}
+
+		line := fmt.Sprintf("%s %s %s", branch, digest, utils.HumanSize(layer.Size))
+
+		if len(layer.SharedWith) > 0 {
+			line += ", shared with: " + strings.Join(layer.SharedWith, ", ")
+		}
+
+		fmt.Println(line)
+	}
+
+	return nil
+}
@@ -2,10 +2,10 @@
 package cmd
 
 import (
-	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/imageutils"
@@ -37,6 +37,12 @@ func NewImagesCommand() *cobra.Command {
 }
 
 func images(cmd *cobra.Command, _ []string) error {
+	// list the images added to the OCI layout by other tools too
+	err := imageutils.SyncLayout()
+	if err != nil {
+		return err
+	}
+
 	images, err := os.ReadDir(imageutils.ImageDir)
 	if err != nil {
 		logging.Log("no images found")
@@ -71,6 +77,11 @@ func images(cmd *cobra.Command, _ []string) error {
 	}
 
 	for _, img := range images {
+		// hidden dirs are temporary ones of ongoing operations, eg: load
+		if strings.HasPrefix(img.Name(), ".") {
+			continue
+		}
+
 		err = doImageRow(imageTable, img.Name(), quiet, notrunc, digest)
 		if err != nil {
 			return err
@@ -105,27 +116,31 @@ func doImageRow(imageTable table.Writer, image string, quiet, notrunc, digest bo
 		return nil
 	}
 
-	imageName := string(bytes.Split(imageFile, []byte(":"))[0])
-	imageTag := string(bytes.Split(imageFile, []byte(":"))[1])
+	imageName, imageTag, _ := imageutils.SplitName(string(imageFile))
+	if imageTag == "" {
+		imageTag = "<none>"
+	}
 
-	directorySize, err := fileutils.DiscUsageMegaBytes(filepath.Join(imageutils.ImageDir, image))
+	directorySize, err := imageutils.DiscUsageMegaBytes(image)
 	if err != nil {
 		return err
 	}
 
 	if digest {
-		checksum := fileutils.GetFileDigest(
-			filepath.Join(imageutils.GetPath(image), "manifest.json"),
-		)
+		checksum, err := imageutils.GetRepoDigest(image)
+		if err != nil {
+			return err
+		}
+
 		if !notrunc {
-			checksum = checksum[:12]
+			checksum = checksum[:len("sha256:")+12]
 		}
 
 		imageTable.AppendRow(
 			[]interface{}{
 				imageName,
 				imageTag,
-				"sha256:" + checksum,
+				checksum,
 				imageutils.GetID(image),
 				directorySize,
 			},
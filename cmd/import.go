@@ -0,0 +1,67 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/buildutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewImportCommand will create a new image from a rootfs tarball.
+func NewImportCommand() *cobra.Command {
+	importCommand := &cobra.Command{
+		Use:              "import [flags] FILE IMAGE",
+		Short:            "Create a new image from a rootfs tarball",
+		PreRunE:          logging.Init,
+		RunE:             importImage,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	importCommand.Flags().SetInterspersed(false)
+	importCommand.Flags().BoolP("help", "h", false, "show help")
+	importCommand.Flags().StringArrayP("change", "c", nil,
+		"apply a Containerfile instruction to the image, eg: ENV PATH=/bin (CMD, ENTRYPOINT, ENV, LABEL, WORKDIR)")
+	importCommand.Flags().StringP("message", "m", "", "commit message, saved in the image history")
+
+	return importCommand
+}
+
+// importImage will save a plain or gzipped tarball, eg: from debootstrap or
+// docker export, as a single layer image. Use - as FILE to read from stdin.
+func importImage(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	changes, err := cmd.Flags().GetStringArray("change")
+	if err != nil {
+		return err
+	}
+
+	message, err := cmd.Flags().GetString("message")
+	if err != nil {
+		return err
+	}
+
+	input := arguments[0]
+	if input != "-" {
+		input, err = filepath.Abs(input)
+		if err != nil {
+			return err
+		}
+	}
+
+	id, err := buildutils.Import(input, arguments[1], changes, message)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+
+	return nil
+}
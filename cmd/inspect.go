@@ -61,14 +61,14 @@ func inspect(cmd *cobra.Command, arguments []string) error {
 		format += "\n"
 	}
 
-	format = strings.ReplaceAll(format, ".State.Status", ".Status")
-	format = strings.ReplaceAll(format, ".Config.Env", ".Env")
-	format = strings.ReplaceAll(format, ".Config.Labels", ".Labels")
-
 	var output string
 
 	switch inspectType {
 	case "container":
+		format = strings.ReplaceAll(format, ".State.Status", ".Status")
+		format = strings.ReplaceAll(format, ".Config.Env", ".Env")
+		format = strings.ReplaceAll(format, ".Config.Labels", ".Labels")
+
 		output, err = containerutils.Inspect(arguments, size, format)
 	case "image":
 		output, err = imageutils.Inspect(arguments, format)
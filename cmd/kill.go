@@ -0,0 +1,66 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/spf13/cobra"
+)
+
+// NewKillCommand will send a signal to the init process of given containers.
+func NewKillCommand() *cobra.Command {
+	killCommand := &cobra.Command{
+		Use:              "kill [flags] CONTAINER [CONTAINER...]",
+		Short:            "Send a signal to one or more running containers",
+		PreRunE:          logging.Init,
+		RunE:             kill,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	killCommand.Flags().SetInterspersed(false)
+	killCommand.Flags().BoolP("help", "h", false, "show help")
+	killCommand.Flags().StringP("signal", "s", "KILL", "signal to send, by name or number, eg: SIGHUP, USR1 or 9")
+
+	return killCommand
+}
+
+func kill(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	signalName, err := cmd.Flags().GetString("signal")
+	if err != nil {
+		return err
+	}
+
+	signal, err := procutils.ParseSignal(signalName)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		if !containerutils.IsRunning(id) {
+			return fmt.Errorf("container %s is not running", container)
+		}
+
+		err = containerutils.Kill(id, signal)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(container)
+	}
+
+	return nil
+}
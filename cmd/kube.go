@@ -0,0 +1,84 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewKubeCommand will translate containers from and to kubernetes YAML.
+func NewKubeCommand() *cobra.Command {
+	kubeCommand := &cobra.Command{
+		Use:              "kube",
+		Short:            "Work with kubernetes YAML",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	kubeCommand.Flags().BoolP("help", "h", false, "show help")
+
+	kubeGenerateCommand := &cobra.Command{
+		Use:              "generate [flags] CONTAINER [CONTAINER...]",
+		Short:            "Generate a kubernetes Pod YAML from containers",
+		PreRunE:          logging.Init,
+		RunE:             kubeGenerate,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	kubeGenerateCommand.Flags().SetInterspersed(false)
+	kubeGenerateCommand.Flags().BoolP("help", "h", false, "show help")
+	kubeGenerateCommand.Flags().String("name", "", "name of the pod, defaults to the first container's name with -pod")
+	kubeGenerateCommand.Flags().StringP("filename", "f", "", "write the YAML to this file instead of stdout")
+
+	kubeCommand.AddCommand(kubeGenerateCommand)
+
+	return kubeCommand
+}
+
+func kubeGenerate(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	podName, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return err
+	}
+
+	filename, err := cmd.Flags().GetString("filename")
+	if err != nil {
+		return err
+	}
+
+	containers := []string{}
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		containers = append(containers, id)
+	}
+
+	result, err := containerutils.GenerateKube(containers, podName)
+	if err != nil {
+		return err
+	}
+
+	if filename == "" {
+		fmt.Print(result)
+
+		return nil
+	}
+
+	return os.WriteFile(filename, []byte(result), 0o644)
+}
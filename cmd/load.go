@@ -0,0 +1,63 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewLoadCommand will import images from an archive.
+func NewLoadCommand() *cobra.Command {
+	loadCommand := &cobra.Command{
+		Use:              "load [flags] [NAME]",
+		Short:            "Load images from an archive",
+		PreRunE:          logging.Init,
+		RunE:             load,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	loadCommand.Flags().SetInterspersed(false)
+	loadCommand.Flags().BoolP("help", "h", false, "show help")
+	loadCommand.Flags().BoolP("quiet", "q", false, "suppress output")
+	loadCommand.Flags().StringP("input", "i", "", "path of the docker-archive, oci-archive or oci-dir to load")
+
+	return loadCommand
+}
+
+// load will save the images of an archive in the configured DIR.
+func load(cmd *cobra.Command, arguments []string) error {
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return err
+	}
+
+	input, err := getAbsFlag(cmd, "input")
+	if err != nil {
+		return err
+	}
+
+	if input == "" {
+		return fmt.Errorf("a path for the archive is required, use --input")
+	}
+
+	imageName := ""
+	if len(arguments) > 0 {
+		imageName = arguments[0]
+	}
+
+	ids, err := imageutils.Load(input, imageName, quiet)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		fmt.Println(id)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// NewLoginCommand will save the credentials of a registry.
+func NewLoginCommand() *cobra.Command {
+	loginCommand := &cobra.Command{
+		Use:              "login [flags] REGISTRY",
+		Short:            "Log in to a registry",
+		PreRunE:          logging.Init,
+		RunE:             login,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	loginCommand.Flags().SetInterspersed(false)
+	loginCommand.Flags().BoolP("help", "h", false, "show help")
+	loginCommand.Flags().StringP("username", "u", "", "username for the registry")
+	loginCommand.Flags().StringP("password", "p", "", "password for the registry")
+	loginCommand.Flags().Bool("password-stdin", false, "read the password from stdin")
+	loginCommand.Flags().String("authfile", "", "path of the auth file, defaults to "+imageutils.GetAuthFile())
+	loginCommand.Flags().Bool("tls-verify", true, "verify the TLS certificate of the registry, and require HTTPS")
+	loginCommand.Flags().String("cert-dir", "", "directory with the certificates to use for the registry, instead of certs.d")
+
+	return loginCommand
+}
+
+// login will check the credentials for a registry, and save them in the auth file.
+func login(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	username, err := cmd.Flags().GetString("username")
+	if err != nil {
+		return err
+	}
+
+	password, err := cmd.Flags().GetString("password")
+	if err != nil {
+		return err
+	}
+
+	passwordStdin, err := cmd.Flags().GetBool("password-stdin")
+	if err != nil {
+		return err
+	}
+
+	authFile, err := getAbsFlag(cmd, "authfile")
+	if err != nil {
+		return err
+	}
+
+	if authFile == "" {
+		authFile = imageutils.GetAuthFile()
+	}
+
+	if password != "" && passwordStdin {
+		return fmt.Errorf("--password and --password-stdin cannot be used together")
+	}
+
+	if passwordStdin {
+		if username == "" {
+			return fmt.Errorf("--password-stdin requires --username")
+		}
+
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		password = strings.TrimRight(string(input), "\r\n")
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	if username == "" {
+		fmt.Print("Username: ")
+
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		username = strings.TrimSpace(input)
+	}
+
+	if password == "" {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("a password is required, use --password or --password-stdin")
+		}
+
+		fmt.Print("Password: ")
+
+		input, err := term.ReadPassword(int(os.Stdin.Fd()))
+
+		fmt.Println()
+
+		if err != nil {
+			return err
+		}
+
+		password = string(input)
+	}
+
+	if username == "" || password == "" {
+		return fmt.Errorf("both username and password are required")
+	}
+
+	err = setRegistryTLS(cmd, arguments[0])
+	if err != nil {
+		return err
+	}
+
+	err = imageutils.Login(arguments[0], username, password, authFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Login Succeeded!")
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewLogoutCommand will remove the credentials of a registry.
+func NewLogoutCommand() *cobra.Command {
+	logoutCommand := &cobra.Command{
+		Use:              "logout [flags] REGISTRY",
+		Short:            "Log out of a registry",
+		PreRunE:          logging.Init,
+		RunE:             logout,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	logoutCommand.Flags().SetInterspersed(false)
+	logoutCommand.Flags().BoolP("help", "h", false, "show help")
+	logoutCommand.Flags().BoolP("all", "a", false, "remove the credentials of all the registries")
+	logoutCommand.Flags().String("authfile", "", "path of the auth file, defaults to "+imageutils.GetAuthFile())
+
+	return logoutCommand
+}
+
+// logout will remove the credentials for a registry from the auth file.
+func logout(cmd *cobra.Command, arguments []string) error {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	if len(arguments) < 1 && !all {
+		return cmd.Help()
+	}
+
+	authFile, err := getAbsFlag(cmd, "authfile")
+	if err != nil {
+		return err
+	}
+
+	if authFile == "" {
+		authFile = imageutils.GetAuthFile()
+	}
+
+	registry := ""
+	if len(arguments) > 0 {
+		registry = arguments[0]
+	}
+
+	err = imageutils.Logout(registry, authFile, all)
+	if err != nil {
+		return err
+	}
+
+	if all {
+		fmt.Println("Removed login credentials for all registries")
+	} else {
+		fmt.Printf("Removed login credentials for %s\n", registry)
+	}
+
+	return nil
+}
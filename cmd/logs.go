@@ -4,11 +4,14 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
 	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logdriver"
 	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -29,6 +32,10 @@ func NewLogsCommand() *cobra.Command {
 	logsCommand.Flags().BoolP("timestamps", "t", false, "show timestamps")
 	logsCommand.Flags().String("since", "", "show logs since input timestamp")
 	logsCommand.Flags().String("until", "", "show logs until input timestamp")
+	logsCommand.Flags().Bool("stdout-only", false, "show only the stdout stream")
+	logsCommand.Flags().Bool("stderr-only", false, "show only the stderr stream")
+	logsCommand.Flags().Int("previous", 0, "show the logs of a previous run, 1 is the last one")
+	logsCommand.Flags().Lookup("previous").NoOptDefVal = "1"
 	logsCommand.Flags().BoolP("help", "h", false, "show help")
 
 	return logsCommand
@@ -41,8 +48,9 @@ func logs(cmd *cobra.Command, arguments []string) error {
 
 	container := arguments[0]
 
-	if !fileutils.Exist(containerutils.GetDir(container)) {
-		return fmt.Errorf("container %s does not exist", container)
+	_, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
 	}
 
 	follow, err := cmd.Flags().GetBool("follow")
@@ -65,19 +73,61 @@ func logs(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	_, err = os.Create(containerutils.GetDir(container) + "/current-logs")
+	stdoutOnly, err := cmd.Flags().GetBool("stdout-only")
 	if err != nil {
 		return err
 	}
 
-	file, err := os.Open(containerutils.GetDir(container) + "/current-logs")
+	stderrOnly, err := cmd.Flags().GetBool("stderr-only")
+	if err != nil {
+		return err
+	}
+
+	if stdoutOnly && stderrOnly {
+		return fmt.Errorf("--stdout-only and --stderr-only are mutually exclusive")
+	}
+
+	stream := ""
+
+	if stdoutOnly {
+		stream = logging.StreamStdout
+	}
+
+	if stderrOnly {
+		stream = logging.StreamStderr
+	}
+
+	previous, err := cmd.Flags().GetInt("previous")
+	if err != nil {
+		return err
+	}
+
+	if previous < 0 || previous > containerutils.MaxPreviousLogs {
+		return fmt.Errorf("--previous must be between 1 and %d", containerutils.MaxPreviousLogs)
+	}
+
+	logfile := containerutils.GetLogPath(container, previous)
+
+	if !fileutils.Exist(logfile) {
+		if previous > 0 {
+			return fmt.Errorf("no logs found for run %d of container %s", previous, container)
+		}
+
+		// container never ran detached, nothing to show yet
+		err = fileutils.WriteFile(logfile, []byte{}, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Open(logfile)
 	if err != nil {
 		return err
 	}
 
 	defer func() { _ = file.Close() }()
 
-	return logging.ReadLog(file, convert(since), convert(until), follow, timestamps)
+	return logging.ReadLog(file, convert(since), convert(until), follow, timestamps, stream)
 }
 
 // convert input string into a unix timestamp int64.
@@ -104,3 +154,35 @@ func convert(input string) int64 {
 
 	return result.Unix()
 }
+
+// addLogDriverFlags will add the log driver related flags to input command.
+func addLogDriverFlags(command *cobra.Command) {
+	command.Flags().String("log-driver", logdriver.File, "logging driver for the container (file, syslog, fluentd)")
+	command.Flags().StringArray("log-opt", nil, "log driver options (eg: syslog-address=udp://host:514, fluentd-address=host:24224, tag={{.Names}})")
+}
+
+// getLogDriverConfig will return the validated log driver and its options from the command's flags.
+func getLogDriverConfig(cmd *cobra.Command) (string, map[string]string, error) {
+	driver, err := cmd.Flags().GetString("log-driver")
+	if err != nil {
+		return "", nil, err
+	}
+
+	options, err := cmd.Flags().GetStringArray("log-opt")
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = logdriver.Validate(driver)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, option := range options {
+		if !strings.Contains(option, "=") {
+			return "", nil, fmt.Errorf("invalid log option %s, format must be key=value", option)
+		}
+	}
+
+	return driver, utils.ListToMap(options), nil
+}
@@ -0,0 +1,220 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewNetworkCommand will manage the user networks, that containers connect to.
+func NewNetworkCommand() *cobra.Command {
+	networkCommand := &cobra.Command{
+		Use:              "network",
+		Short:            "Manage networks",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	networkCommand.Flags().BoolP("help", "h", false, "show help")
+
+	defaultDriver := containerutils.DriverSlirp
+	if os.Getuid() == 0 {
+		defaultDriver = containerutils.DriverBridge
+	}
+
+	networkCreateCommand := &cobra.Command{
+		Use:              "create [flags] NAME",
+		Short:            "Create a network, that containers connect to with --network",
+		PreRunE:          logging.Init,
+		RunE:             networkCreate,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	networkCreateCommand.Flags().SetInterspersed(false)
+	networkCreateCommand.Flags().BoolP("help", "h", false, "show help")
+	networkCreateCommand.Flags().StringP("driver", "d", defaultDriver, "driver of the network: bridge (root only) or slirp")
+	networkCreateCommand.Flags().String("subnet", "", "subnet of the network (default a free /24 of 10.89.0.0/16)")
+	networkCreateCommand.Flags().StringArray("label", nil, "set metadata on network")
+
+	networkInspectCommand := &cobra.Command{
+		Use:              "inspect [flags] NAME...",
+		Short:            "Show the config of networks, and the containers connected to them",
+		PreRunE:          logging.Init,
+		RunE:             networkInspect,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	networkInspectCommand.Flags().SetInterspersed(false)
+	networkInspectCommand.Flags().BoolP("help", "h", false, "show help")
+
+	networkLsCommand := &cobra.Command{
+		Use:              "ls [flags]",
+		Short:            "List networks",
+		PreRunE:          logging.Init,
+		RunE:             networkLs,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	networkLsCommand.Flags().SetInterspersed(false)
+	networkLsCommand.Flags().BoolP("help", "h", false, "show help")
+	networkLsCommand.Flags().BoolP("no-trunc", "", false, "do not truncate data")
+	networkLsCommand.Flags().String("format", "table", "output format (table, json)")
+
+	networkRmCommand := &cobra.Command{
+		Use:              "rm [flags] NAME...",
+		Short:            "Remove networks, that no container is connected to",
+		PreRunE:          logging.Init,
+		RunE:             networkRm,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	networkRmCommand.Flags().SetInterspersed(false)
+	networkRmCommand.Flags().BoolP("help", "h", false, "show help")
+
+	networkCommand.AddCommand(networkCreateCommand)
+	networkCommand.AddCommand(networkInspectCommand)
+	networkCommand.AddCommand(networkLsCommand)
+	networkCommand.AddCommand(networkRmCommand)
+
+	return networkCommand
+}
+
+func networkCreate(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) != 1 {
+		return cmd.Help()
+	}
+
+	driver, err := cmd.Flags().GetString("driver")
+	if err != nil {
+		return err
+	}
+
+	subnet, err := cmd.Flags().GetString("subnet")
+	if err != nil {
+		return err
+	}
+
+	labels, err := cmd.Flags().GetStringArray("label")
+	if err != nil {
+		return err
+	}
+
+	network, err := containerutils.CreateNetwork(arguments[0], driver, subnet, labels)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(network.Name)
+
+	return nil
+}
+
+func networkInspect(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	networks := []containerutils.Network{}
+
+	for _, name := range arguments {
+		network, err := containerutils.InspectNetwork(name)
+		if err != nil {
+			return err
+		}
+
+		networks = append(networks, network)
+	}
+
+	out, err := json.MarshalIndent(networks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(out))
+
+	return nil
+}
+
+func networkLs(cmd *cobra.Command, _ []string) error {
+	notrunc, err := cmd.Flags().GetBool("no-trunc")
+	if err != nil {
+		return err
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %s, valid formats are: table, json", format)
+	}
+
+	networks, err := containerutils.ListNetworks()
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(networks, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(out))
+
+		return nil
+	}
+
+	networkTable := table.NewWriter()
+	networkTable.SetOutputMirror(os.Stdout)
+	networkTable.SetStyle(utils.GetDefaultTable())
+	networkTable.AppendHeader(table.Row{"NETWORK ID", "NAME", "DRIVER", "SUBNET"})
+
+	for _, network := range networks {
+		id := network.ID
+		if !notrunc {
+			id = id[:containerutils.ShortIDLength]
+		}
+
+		networkTable.AppendRow(table.Row{id, network.Name, network.Driver, network.Subnet})
+	}
+
+	networkTable.Render()
+
+	return nil
+}
+
+func networkRm(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	for _, name := range arguments {
+		err := containerutils.RemoveNetwork(name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(name)
+	}
+
+	return nil
+}
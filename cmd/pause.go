@@ -0,0 +1,87 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewPauseCommand will suspend all the processes of given containers.
+func NewPauseCommand() *cobra.Command {
+	pauseCommand := &cobra.Command{
+		Use:              "pause [flags] CONTAINER [CONTAINER...]",
+		Short:            "Pause all the processes in one or more containers",
+		PreRunE:          logging.Init,
+		RunE:             pause,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	pauseCommand.Flags().SetInterspersed(false)
+	pauseCommand.Flags().BoolP("all", "a", false, "pause all running containers")
+	pauseCommand.Flags().BoolP("help", "h", false, "show help")
+
+	return pauseCommand
+}
+
+func pause(cmd *cobra.Command, arguments []string) error {
+	return setPaused(cmd, arguments, true)
+}
+
+// setPaused will pause or unpause input containers, or all the running ones
+// with --all.
+func setPaused(cmd *cobra.Command, arguments []string, paused bool) error {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	if len(arguments) < 1 && !all {
+		return cmd.Help()
+	}
+
+	if all {
+		arguments = []string{}
+
+		containers, err := os.ReadDir(containerutils.ContainerDir)
+		if err != nil {
+			return err
+		}
+
+		for _, i := range containers {
+			if containerutils.IsRunning(i.Name()) && containerutils.IsPaused(i.Name()) != paused {
+				arguments = append(arguments, i.Name())
+			}
+		}
+	}
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		if !containerutils.IsRunning(id) {
+			return fmt.Errorf("container %s is not running", container)
+		}
+
+		if paused {
+			err = containerutils.Pause(id)
+		} else {
+			err = containerutils.Unpause(id)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(container)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/netns"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewPortCommand will list the port mappings of a container.
+func NewPortCommand() *cobra.Command {
+	portCommand := &cobra.Command{
+		Use:              "port [flags] CONTAINER [PRIVATE_PORT[/PROTO]]",
+		Short:            "List port mappings of a container",
+		PreRunE:          logging.Init,
+		RunE:             port,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	portCommand.Flags().SetInterspersed(false)
+	portCommand.Flags().BoolP("help", "h", false, "show help")
+
+	return portCommand
+}
+
+func port(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	// the slirp4netns socket lives in the runtime dir of our user namespace
+	success, err := procutils.EnsureFakeRoot(false)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	container, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	privatePort, proto := 0, ""
+
+	if len(arguments) > 1 {
+		portString, protoString, _ := strings.Cut(arguments[1], "/")
+
+		privatePort, err = strconv.Atoi(portString)
+		if err != nil {
+			return fmt.Errorf("invalid port %s", arguments[1])
+		}
+
+		proto = strings.ToLower(protoString)
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(container), "config"))
+	if err != nil {
+		return err
+	}
+
+	// containers sharing the network of another one have its mappings
+	owner := config
+
+	if id, ok := containerutils.GetNetworkContainer(config.Network); ok {
+		owner, err = utils.LoadConfig(filepath.Join(containerutils.GetDir(id), "config"))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the ones of slirp networks have the mappings of the one started first
+	if id, ok := containerutils.GetNetworkOwner(owner.Network); ok {
+		owner, err = utils.LoadConfig(filepath.Join(containerutils.GetDir(id), "config"))
+		if err != nil {
+			return err
+		}
+	}
+
+	// host and bridge networks have no mappings, ports are bound where they are
+	if !containerutils.CanPublish(owner.Network) {
+		return fmt.Errorf("container %s has no published ports, network %s cannot publish them", config.Names, owner.Network)
+	}
+
+	forwards, err := getPortForwards(owner)
+	if err != nil {
+		return err
+	}
+
+	found := false
+
+	for _, forward := range forwards {
+		if privatePort != 0 && forward.GuestPort != privatePort {
+			continue
+		}
+
+		if proto != "" && forward.Proto != proto {
+			continue
+		}
+
+		found = true
+		hostAddress := net.JoinHostPort(forward.HostAddr, strconv.Itoa(forward.HostPort))
+
+		// with a single port, only the host side is shown, useful for scripts
+		if privatePort != 0 {
+			fmt.Println(hostAddress)
+
+			continue
+		}
+
+		fmt.Printf("%d/%s -> %s\n", forward.GuestPort, forward.Proto, hostAddress)
+	}
+
+	if privatePort != 0 && !found {
+		return fmt.Errorf("no public port %s published for %s", arguments[1], config.Names)
+	}
+
+	return nil
+}
+
+// getPortForwards returns the active port forwards of input container, or the
+// ones it publishes at start if its network backend is not running.
+func getPortForwards(config utils.Config) ([]netns.PortForward, error) {
+	if containerutils.IsRunning(config.ID) {
+		return netns.ListPortForwards(config.ID)
+	}
+
+	logging.LogDebug("container %s is not running, listing its published ports", config.Names)
+
+	forwards := []netns.PortForward{}
+
+	for _, mapping := range config.Ports {
+		forward, err := netns.ParsePortMapping(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		forwards = append(forwards, forward)
+	}
+
+	return forwards, nil
+}
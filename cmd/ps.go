@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/89luca89/lilipod/pkg/constants"
 	"github.com/89luca89/lilipod/pkg/containerutils"
@@ -60,30 +61,7 @@ func ps(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
-	filters = make(map[string]string)
-
-	for _, filter := range filterInput {
-		name := strings.Split(filter, "=")[0]
-		value := strings.Join(strings.Split(filter, "=")[1:], "=")
-
-		switch name {
-		case "label":
-			if filters[name] != "" {
-				filters[name] = filters[name] + constants.FilterSeparator + value
-			} else {
-				filters[name] = value
-			}
-		case "status":
-			filters[name] = value
-		case "name":
-			filters[name] = value
-		case "id":
-			filters[name] = value
-		default:
-			logging.LogWarning("invalid filter %s, skipping", name)
-			logging.LogWarning("valid filters are: label, status, name, id")
-		}
-	}
+	filters = parseFilters(filterInput)
 
 	size, err := cmd.Flags().GetBool("size")
 	if err != nil {
@@ -119,7 +97,12 @@ func ps(cmd *cobra.Command, _ []string) error {
 
 	for _, container := range containers {
 		if quiet {
-			fmt.Println(containerutils.GetID(container.Name()))
+			id := containerutils.GetID(container.Name())
+			if !notrunc {
+				id = containerutils.ShortID(id)
+			}
+
+			fmt.Println(id)
 
 			continue
 		}
@@ -156,6 +139,90 @@ func ps(cmd *cobra.Command, _ []string) error {
 	return nil
 }
 
+// parseFilters returns the container filters of input --filter values, eg:
+// label=key=value, see containerutils.GetContainerInfo.
+func parseFilters(filterInput []string) map[string]string {
+	filters := make(map[string]string)
+
+	for _, filter := range filterInput {
+		name := strings.Split(filter, "=")[0]
+		value := strings.Join(strings.Split(filter, "=")[1:], "=")
+
+		switch name {
+		case "label":
+			if filters[name] != "" {
+				filters[name] = filters[name] + constants.FilterSeparator + value
+			} else {
+				filters[name] = value
+			}
+		case "status":
+			filters[name] = value
+		case "name":
+			filters[name] = value
+		case "id":
+			filters[name] = value
+		case "exited":
+			filters[name] = value
+		case "network":
+			filters[name] = value
+		default:
+			logging.LogWarning("invalid filter %s, skipping", name)
+			logging.LogWarning("valid filters are: label, status, name, id, exited, network")
+		}
+	}
+
+	return filters
+}
+
+// getTargets returns the containers a bulk command operates on: input ones,
+// or with --all or --filter the ones matching the filters and input include
+// function, if any.
+// Broken containers are returned by ID, as they have no name.
+func getTargets(
+	cmd *cobra.Command,
+	arguments []string,
+	include func(config utils.Config) bool,
+) ([]string, error) {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return nil, err
+	}
+
+	filterInput, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return nil, err
+	}
+
+	if !all && len(filterInput) == 0 {
+		return arguments, nil
+	}
+
+	if len(arguments) > 0 {
+		return nil, fmt.Errorf("cannot specify containers together with --all or --filter")
+	}
+
+	containers, err := containerutils.ListContainers(parseFilters(filterInput))
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []string{}
+
+	for _, container := range containers {
+		if include != nil && !include(container) {
+			continue
+		}
+
+		if container.Status == containerutils.StatusBroken {
+			targets = append(targets, container.ID)
+		} else {
+			targets = append(targets, container.Names)
+		}
+	}
+
+	return targets, nil
+}
+
 func doContainerRow(
 	psTable table.Writer,
 	container, format string,
@@ -202,15 +269,32 @@ func doContainerRow(
 		command = command[:15] + "..."
 	}
 
-	if config.Status == "running" || all {
+	id := container
+	if !notrunc {
+		id = containerutils.ShortID(id)
+	}
+
+	image := config.Image
+	if config.ImageRemoved {
+		image += " (removed)"
+	}
+
+	status := getHumanStatus(config)
+
+	// append the health status to running containers, eg: "Up 3 minutes (healthy)"
+	if config.Health != nil && config.Health.Status != "" {
+		status += " (" + config.Health.Status + ")"
+	}
+
+	if config.Status == "running" || config.Status == containerutils.StatusPaused || all {
 		if size {
 			psTable.AppendRow(
 				[]interface{}{
-					container,
-					config.Image,
+					id,
+					image,
 					command,
-					config.Created,
-					config.Status,
+					getTimeAgo(config.Created),
+					status,
 					labels,
 					config.Names,
 					config.Size,
@@ -218,11 +302,11 @@ func doContainerRow(
 			)
 		} else {
 			psTable.AppendRow([]interface{}{
-				container,
-				config.Image,
+				id,
+				image,
 				command,
-				config.Created,
-				config.Status,
+				getTimeAgo(config.Created),
+				status,
 				labels,
 				config.Names,
 			})
@@ -231,3 +315,42 @@ func doContainerRow(
 
 	return nil
 }
+
+// getTimeAgo returns a relative representation of input timestamp, eg: "3 days ago".
+func getTimeAgo(timestamp string) string {
+	parsed, err := utils.ParseTime(timestamp)
+	if err != nil {
+		return timestamp
+	}
+
+	return utils.HumanDuration(time.Since(parsed)) + " ago"
+}
+
+// getHumanStatus returns a relative representation of the container's status,
+// eg: "Up 2 hours", "Exited (0) 5 minutes ago" or "Created".
+func getHumanStatus(config *utils.Config) string {
+	if config.Status == containerutils.StatusBroken {
+		return "Broken"
+	}
+
+	if config.Status == "running" || config.Status == containerutils.StatusPaused {
+		status := "Up"
+
+		started, err := utils.ParseTime(config.Started)
+		if err == nil {
+			status += " " + utils.HumanDuration(time.Since(started))
+		}
+
+		if config.Status == containerutils.StatusPaused {
+			status += " (Paused)"
+		}
+
+		return status
+	}
+
+	if config.Finished == "" {
+		return "Created"
+	}
+
+	return fmt.Sprintf("Exited (%d) %s", config.ExitCode, getTimeAgo(config.Finished))
+}
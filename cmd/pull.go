@@ -3,7 +3,9 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/89luca89/lilipod/pkg/buildutils"
 	"github.com/89luca89/lilipod/pkg/imageutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/spf13/cobra"
@@ -24,6 +26,13 @@ func NewPullCommand() *cobra.Command {
 	pullCommand.Flags().SetInterspersed(false)
 	pullCommand.Flags().BoolP("help", "h", false, "show help")
 	pullCommand.Flags().BoolP("quiet", "q", false, "suppress output")
+	pullCommand.Flags().Bool("squash", false, "flatten all the layers of the image in a single one after pulling it")
+	pullCommand.Flags().Bool("lazy", false, "only pull the table of contents of eStargz layers, their files are fetched when creating a container")
+	pullCommand.Flags().String("platform", "", "platform of the image to pull, eg: linux/arm64, defaults to the host's")
+	pullCommand.Flags().Bool("tls-verify", true, "verify the TLS certificate of the registry, and require HTTPS")
+	pullCommand.Flags().String("cert-dir", "", "directory with the certificates to use for the registry, instead of certs.d")
+	pullCommand.Flags().Int("retry", 3, "number of times to retry a failed download of the image")
+	pullCommand.Flags().Duration("retry-delay", 2*time.Second, "delay before the first retry, doubled for each next one")
 
 	return pullCommand
 }
@@ -39,14 +48,101 @@ func pull(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	platform, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		return err
+	}
+
+	squash, err := cmd.Flags().GetBool("squash")
+	if err != nil {
+		return err
+	}
+
+	lazy, err := cmd.Flags().GetBool("lazy")
+	if err != nil {
+		return err
+	}
+
+	if lazy && squash {
+		return fmt.Errorf("--lazy cannot be used with --squash, that needs all the layers")
+	}
+
+	imageutils.SetLazyPull(lazy)
+
+	err = setRetryPolicy(cmd)
+	if err != nil {
+		return err
+	}
+
 	for _, image := range arguments {
-		id, err := imageutils.Pull(image, quiet)
+		err = setImageRegistryTLS(cmd, image)
 		if err != nil {
 			return err
 		}
 
+		id, err := imageutils.Pull(image, platform, quiet)
+		if err != nil {
+			return err
+		}
+
+		if squash {
+			id, err = buildutils.Squash(id, imageutils.GetName(id))
+			if err != nil {
+				return err
+			}
+		}
+
 		fmt.Println(id)
 	}
 
 	return nil
 }
+
+// setRegistryTLS will apply the --tls-verify and --cert-dir flags to the
+// connections to input registry.
+func setRegistryTLS(cmd *cobra.Command, registry string) error {
+	tlsVerify, err := cmd.Flags().GetBool("tls-verify")
+	if err != nil {
+		return err
+	}
+
+	certDir, err := getAbsFlag(cmd, "cert-dir")
+	if err != nil {
+		return err
+	}
+
+	if tlsVerify && certDir == "" {
+		return nil
+	}
+
+	return imageutils.SetRegistryTLS(registry, tlsVerify, certDir)
+}
+
+// setImageRegistryTLS will apply the --tls-verify and --cert-dir flags to the
+// connections to the registry of input image.
+func setImageRegistryTLS(cmd *cobra.Command, image string) error {
+	registry, err := imageutils.GetRegistry(image)
+	if err != nil {
+		// invalid references are reported when pulling, and stored images
+		// may be referenced by other means
+		return nil //nolint: nilerr
+	}
+
+	return setRegistryTLS(cmd, registry)
+}
+
+// setRetryPolicy will apply the --retry and --retry-delay flags to the
+// downloads of the images.
+func setRetryPolicy(cmd *cobra.Command) error {
+	attempts, err := cmd.Flags().GetInt("retry")
+	if err != nil {
+		return err
+	}
+
+	delay, err := cmd.Flags().GetDuration("retry-delay")
+	if err != nil {
+		return err
+	}
+
+	return imageutils.SetRetryPolicy(attempts, delay)
+}
@@ -0,0 +1,66 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewPushCommand will upload a stored image to a registry.
+func NewPushCommand() *cobra.Command {
+	pushCommand := &cobra.Command{
+		Use:              "push [flags] IMAGE [DESTINATION]",
+		Short:            "Push an image to a registry",
+		PreRunE:          logging.Init,
+		RunE:             push,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	pushCommand.Flags().SetInterspersed(false)
+	pushCommand.Flags().BoolP("help", "h", false, "show help")
+	pushCommand.Flags().BoolP("quiet", "q", false, "suppress output")
+	pushCommand.Flags().Bool("tls-verify", true, "verify the TLS certificate of the registry, and require HTTPS")
+	pushCommand.Flags().String("cert-dir", "", "directory with the certificates to use for the registry, instead of certs.d")
+
+	return pushCommand
+}
+
+// push will upload an image from the configured DIR to a registry.
+func push(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	quiet, err := cmd.Flags().GetBool("quiet")
+	if err != nil {
+		return err
+	}
+
+	image := arguments[0]
+
+	destination := ""
+	if len(arguments) > 1 {
+		destination = arguments[1]
+	}
+
+	if !fileutils.Exist(imageutils.GetPath(image)) {
+		return fmt.Errorf("image %s not found", image)
+	}
+
+	if destination == "" {
+		destination = imageutils.GetName(image)
+	}
+
+	err = setImageRegistryTLS(cmd, destination)
+	if err != nil {
+		return err
+	}
+
+	return imageutils.Push(image, destination, quiet)
+}
@@ -11,7 +11,7 @@ import (
 // NewRenameCommand will copy a file to or from a container.
 func NewRenameCommand() *cobra.Command {
 	renameCommand := &cobra.Command{
-		Use:              "rename OLD_NAME NEW_NAME",
+		Use:              "rename [flags] OLD_NAME NEW_NAME",
 		Short:            "Rename a container",
 		PreRunE:          logging.Init,
 		RunE:             rename,
@@ -21,6 +21,9 @@ func NewRenameCommand() *cobra.Command {
 	}
 
 	renameCommand.Flags().SetInterspersed(false)
+	renameCommand.Flags().BoolP("help", "h", false, "show help")
+	renameCommand.Flags().BoolP("force", "f", false, "stop the container before renaming it, if it is running")
+	renameCommand.Flags().IntP("timeout", "t", 0, "seconds to wait before forcefully stopping the container (default the container's stop timeout)")
 
 	return renameCommand
 }
@@ -30,6 +33,21 @@ func rename(cmd *cobra.Command, arguments []string) error {
 		return cmd.Help()
 	}
 
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+
+	timeout, err := cmd.Flags().GetInt("timeout")
+	if err != nil {
+		return err
+	}
+
+	// use the container's own stop timeout
+	if !cmd.Flags().Changed("timeout") {
+		timeout = -1
+	}
+
 	success, err := procutils.EnsureFakeRoot(true)
 	if err != nil {
 		return err
@@ -42,5 +60,17 @@ func rename(cmd *cobra.Command, arguments []string) error {
 	container := arguments[0]
 	newName := arguments[1]
 
+	_, err = containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	if force {
+		err = stopContainer(container, false, timeout)
+		if err != nil {
+			return err
+		}
+	}
+
 	return containerutils.Rename(container, newName)
 }
@@ -0,0 +1,94 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewRestartCommand will stop given containers, if running, and start them again.
+func NewRestartCommand() *cobra.Command {
+	restartCommand := &cobra.Command{
+		Use:              "restart [flags] CONTAINER [CONTAINER...]",
+		Short:            "Restart one or more containers",
+		PreRunE:          logging.Init,
+		RunE:             restart,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	restartCommand.Flags().SetInterspersed(false)
+	restartCommand.Flags().BoolP("all", "a", false, "restart all containers")
+	restartCommand.Flags().BoolP("help", "h", false, "show help")
+	restartCommand.Flags().IntP("timeout", "t", 0, "seconds to wait before forcefully exiting the container (default the container's stop timeout)")
+
+	return restartCommand
+}
+
+func restart(cmd *cobra.Command, arguments []string) error {
+	restartAll, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	timeout, err := cmd.Flags().GetInt("timeout")
+	if err != nil {
+		return err
+	}
+
+	// use the container's own stop timeout
+	if !cmd.Flags().Changed("timeout") {
+		timeout = -1
+	}
+
+	if len(arguments) < 1 && !restartAll {
+		return cmd.Help()
+	}
+
+	if restartAll {
+		arguments = []string{}
+
+		containers, err := os.ReadDir(containerutils.ContainerDir)
+		if err != nil {
+			return err
+		}
+
+		for _, i := range containers {
+			arguments = append(arguments, i.Name())
+		}
+	}
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		if containerutils.IsRunning(id) {
+			logging.LogDebug("stopping: %s", container)
+
+			err = containerutils.Stop(id, false, timeout)
+			if err != nil {
+				return err
+			}
+		}
+
+		logging.LogDebug("starting: %s", container)
+
+		// start in background, like lilipod start does
+		out, err := exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "start", id).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("cannot start container %s: %w: %s", container, err, string(out))
+		}
+
+		fmt.Println(container)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewRestoreCommand will resume a checkpointed container with CRIU.
+func NewRestoreCommand() *cobra.Command {
+	restoreCommand := &cobra.Command{
+		Use:              "restore [flags] CONTAINER",
+		Short:            "Restore a checkpointed container with CRIU",
+		PreRunE:          logging.Init,
+		RunE:             restore,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	restoreCommand.Flags().SetInterspersed(false)
+	restoreCommand.Flags().BoolP("help", "h", false, "show help")
+	restoreCommand.Flags().StringP("import", "i", "", "restore a container exported by checkpoint --export")
+	restoreCommand.Flags().StringP("name", "n", "", "name of the container restored with --import")
+	restoreCommand.Flags().Bool("hooks", false, "keep the hooks of the container restored with --import, that run commands on this host")
+
+	return restoreCommand
+}
+
+func restore(cmd *cobra.Command, arguments []string) error {
+	importFile, err := getAbsFlag(cmd, "import")
+	if err != nil {
+		return err
+	}
+
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return err
+	}
+
+	hooks, err := cmd.Flags().GetBool("hooks")
+	if err != nil {
+		return err
+	}
+
+	if len(arguments) < 1 && importFile == "" {
+		return cmd.Help()
+	}
+
+	if name != "" && importFile == "" {
+		return fmt.Errorf("--name can only be used with --import")
+	}
+
+	if hooks && importFile == "" {
+		return fmt.Errorf("--hooks can only be used with --import")
+	}
+
+	// CRIU needs to be proper root to dump and restore processes
+	if os.Getuid() != 0 {
+		return fmt.Errorf("restore requires root, run lilipod as root")
+	}
+
+	if importFile != "" {
+		id, err := containerutils.ImportCheckpoint(importFile, name, hooks)
+		if err != nil {
+			return err
+		}
+
+		// restore the imported container like any other one
+		out, err := exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "restore", id).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, string(out))
+		}
+
+		fmt.Print(string(out))
+
+		return nil
+	}
+
+	container := arguments[0]
+
+	id, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	if containerutils.IsRunning(id) {
+		return fmt.Errorf("container %s is already running", container)
+	}
+
+	if !containerutils.HasCheckpoint(id) {
+		return fmt.Errorf("container %s has no checkpoint", container)
+	}
+
+	// the restored container is supervised in background, like with start
+	parent, err := procutils.EnsureFakeRoot(false)
+	if err != nil {
+		return err
+	}
+
+	if parent {
+		_, err = waitForPid(id, 10*time.Second)
+		if err != nil {
+			return fmt.Errorf("container %s was not restored, see %s",
+				container, filepath.Join(containerutils.GetCheckpointDir(id), "restore.log"))
+		}
+
+		fmt.Println(container)
+
+		return nil
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(id), "config"))
+	if err != nil {
+		return err
+	}
+
+	return containerutils.Restore(config)
+}
@@ -6,19 +6,19 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
-	"github.com/89luca89/lilipod/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
 // NewRmCommand removes one or more containers from the host.
 func NewRmCommand() *cobra.Command {
 	rmCommand := &cobra.Command{
-		Use:              "rm [flags] IMAGE",
+		Use:              "rm [flags] CONTAINER...",
 		Short:            "Remove one or more containers",
 		PreRunE:          logging.Init,
 		RunE:             rm,
@@ -30,6 +30,7 @@ func NewRmCommand() *cobra.Command {
 	rmCommand.Flags().SetInterspersed(false)
 	rmCommand.Flags().BoolP("force", "f", false, "force remove container")
 	rmCommand.Flags().BoolP("all", "a", false, "remove all containers")
+	rmCommand.Flags().StringArray("filter", nil, "remove the containers matching the filters, see ps --filter")
 	rmCommand.Flags().BoolP("help", "h", false, "show help")
 
 	return rmCommand
@@ -41,83 +42,87 @@ func rm(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	if force {
-		err := exec.Command(os.Args[0], append([]string{"stop", "-f"}, arguments...)...).Run()
-		if err != nil {
-			return err
-		}
+	if len(arguments) < 1 && !cmd.Flags().Changed("all") && !cmd.Flags().Changed("filter") {
+		return cmd.Help()
 	}
 
-	success, err := procutils.EnsureFakeRoot(true)
+	targets, err := getTargets(cmd, arguments, nil)
 	if err != nil {
 		return err
 	}
 
-	if success {
-		return nil
+	if force {
+		running := []string{}
+
+		for _, container := range targets {
+			if containerutils.IsRunning(container) {
+				running = append(running, container)
+			}
+		}
+
+		if len(running) > 0 {
+			err := exec.Command(os.Args[0], append([]string{"stop", "-f"}, running...)...).Run()
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	delAll, err := cmd.Flags().GetBool("all")
+	success, err := procutils.EnsureFakeRoot(true)
 	if err != nil {
 		return err
 	}
 
-	if len(arguments) < 1 && !delAll {
-		return cmd.Help()
+	if success {
+		return nil
 	}
 
-	// if we want to delete all, just get a list of the targets and add it to
-	// the arguments.
-	if delAll {
-		arguments = []string{}
+	failed := 0
 
-		containers, err := os.ReadDir(containerutils.ContainerDir)
+	// keep going on errors, so that all the targets are removed
+	for _, container := range targets {
+		err := rmContainer(container, targets)
 		if err != nil {
-			return fmt.Errorf("no containers found")
-		}
+			logging.LogError("%v", err)
 
-		for _, i := range containers {
-			arguments = append(arguments, i.Name())
-		}
-	}
+			failed++
 
-	for _, container := range arguments {
-		if containerutils.IsRunning(container) {
-			return fmt.Errorf("cannot remove container %s, as it is running", container)
+			continue
 		}
 
-		targetDIR := filepath.Join(containerutils.ContainerDir, container)
-		if !fileutils.Exist(targetDIR) {
-			targetDIR = containerutils.GetDir(container)
-		}
+		fmt.Println(container)
+	}
 
-		// delete the targets.
-		if fileutils.Exist(targetDIR) {
-			// Simple retry logic for unmount
-			err := fileutils.Umount(filepath.Join(targetDIR, "rootfs"))
-			if err != nil {
-				return err
-			}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d of %d containers", failed, len(targets))
+	}
 
-			logging.LogDebug("deleting: %s in %s", container, targetDIR)
+	return nil
+}
 
-			err = os.RemoveAll(targetDIR)
-			if err != nil {
-				return err
-			}
+// rmContainer will remove input container, if it is not running, and not
+// required by other containers than input targets, removed too.
+func rmContainer(container string, targets []string) error {
+	if containerutils.IsRunning(container) {
+		return fmt.Errorf("cannot remove container %s, as it is running", container)
+	}
 
-			err = os.RemoveAll(
-				filepath.Join(utils.GetLilipodHome(), "volumes", containerutils.GetID(container)),
-			)
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("container %s does not exist", container)
+	for _, requiring := range containerutils.GetRequiring(container) {
+		if !slices.ContainsFunc(targets, func(target string) bool {
+			return containerutils.GetID(target) == containerutils.GetID(requiring)
+		}) {
+			return fmt.Errorf("cannot remove container %s, as it is required by %s", container, requiring)
 		}
+	}
 
-		fmt.Println(container)
+	id, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	if !fileutils.Exist(filepath.Join(containerutils.ContainerDir, id)) {
+		return fmt.Errorf("container %s does not exist", container)
+	}
+
+	return containerutils.Remove(id)
 }
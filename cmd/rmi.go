@@ -4,10 +4,14 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/89luca89/lilipod/pkg/containerutils"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/imageutils"
 	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -25,6 +29,7 @@ func NewRmiCommand() *cobra.Command {
 
 	rmiCommand.Flags().SetInterspersed(false)
 	rmiCommand.Flags().BoolP("all", "a", false, "remove all images")
+	rmiCommand.Flags().BoolP("force", "f", false, "remove images used by containers, marking the containers as orphaned")
 	rmiCommand.Flags().BoolP("help", "h", false, "show help")
 
 	return rmiCommand
@@ -40,8 +45,22 @@ func rmi(cmd *cobra.Command, arguments []string) error {
 		return cmd.Help()
 	}
 
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+
 	if delAll {
-		return os.RemoveAll(imageutils.ImageDir)
+		images, err := os.ReadDir(imageutils.ImageDir)
+		if err != nil {
+			return err
+		}
+
+		arguments = []string{}
+
+		for _, image := range images {
+			arguments = append(arguments, image.Name())
+		}
 	}
 
 	for _, img := range arguments {
@@ -50,6 +69,51 @@ func rmi(cmd *cobra.Command, arguments []string) error {
 			return fmt.Errorf("image %s not found", img)
 		}
 
+		// the image id is accepted too, but containers refer to the image name
+		imageName := img
+
+		name, err := fileutils.ReadFile(filepath.Join(targetDIR, "image_name"))
+		if err == nil {
+			imageName = string(name)
+		}
+
+		dependents, err := containerutils.GetDependentContainers(imageName)
+		if err != nil {
+			return err
+		}
+
+		if len(dependents) > 0 && !force {
+			names := []string{}
+			for _, dependent := range dependents {
+				names = append(names, dependent.Names)
+			}
+
+			return fmt.Errorf("image %s is in use by containers: %s, remove them first or use --force",
+				img, strings.Join(names, ", "))
+		}
+
+		// containers using the erofs storage driver mount the image itself,
+		// so they cannot be orphaned.
+		for _, dependent := range dependents {
+			if dependent.StorageDriver == imageutils.StorageDriverErofs {
+				return fmt.Errorf("image %s is mounted by container %s, remove it first",
+					img, dependent.Names)
+			}
+		}
+
+		// containers have their own rootfs, so they keep working, but we make
+		// it explicit that their image is gone.
+		for _, dependent := range dependents {
+			logging.LogWarning("container %s is now orphaned from image %s", dependent.Names, img)
+
+			dependent.ImageRemoved = true
+
+			err = utils.SaveConfig(dependent, filepath.Join(containerutils.GetDir(dependent.ID), "config"))
+			if err != nil {
+				return err
+			}
+		}
+
 		logging.LogDebug("deleting: %s", img)
 
 		err = os.RemoveAll(targetDIR)
@@ -60,5 +124,8 @@ func rmi(cmd *cobra.Command, arguments []string) error {
 		fmt.Println(img)
 	}
 
-	return nil
+	// layers are shared, they are removed with the last image using them
+	err = imageutils.RemoveUnusedLayers()
+
+	return err
 }
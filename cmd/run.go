@@ -4,7 +4,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
+	"syscall"
 	"time"
 
 	"github.com/89luca89/lilipod/pkg/constants"
@@ -14,15 +17,16 @@ import (
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
 	"github.com/89luca89/lilipod/pkg/utils"
-	imgName "github.com/google/go-containerregistry/pkg/name"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-// NewRunCommand will run a new container environment ready to use.
+// NewRunCommand will run a command in a new container: the image is pulled
+// if missing, the container created and started, in foreground or detached.
 func NewRunCommand() *cobra.Command {
 	runCommand := &cobra.Command{
 		Use:              "run [flags] IMAGE [COMMAND] [ARG...]",
-		Short:            "Run but do not start a container",
+		Short:            "Run a command in a new container",
 		PreRunE:          logging.Init,
 		RunE:             run,
 		SilenceUsage:     true,
@@ -33,34 +37,76 @@ func NewRunCommand() *cobra.Command {
 	runCommand.Flags().SetInterspersed(false)
 	runCommand.Flags().Bool("help", false, "show help")
 	runCommand.Flags().Bool("privileged", false, "give extended privileges to the container")
-	runCommand.Flags().Bool("pull", false, "pull image before running")
+	runCommand.Flags().Bool("locale-gen", false, "generate the host's locale inside the container at start")
+	runCommand.Flags().Bool("init", false, "run an init inside the container that forwards signals and reaps processes")
+	runCommand.Flags().String("pull", imageutils.PullMissing, "pull policy of the image: always, missing, never or newer")
+	runCommand.Flags().Lookup("pull").NoOptDefVal = imageutils.PullAlways
+	runCommand.Flags().String("platform", "", "platform of the image, eg: linux/arm64, it is pulled if missing")
+	runCommand.Flags().Bool("tls-verify", true, "verify the TLS certificate of the registry, and require HTTPS")
+	runCommand.Flags().String("cert-dir", "", "directory with the certificates to use for the registry, instead of certs.d")
+	runCommand.Flags().Int("retry", 3, "number of times to retry a failed download of the image")
+	runCommand.Flags().Duration("retry-delay", 2*time.Second, "delay before the first retry, doubled for each next one")
 	runCommand.Flags().Bool("rm", false, "delete container at the end of execution")
+	runCommand.Flags().Bool("replace", false, "stop and remove the container with the same name, if it exists")
+	runCommand.Flags().String("profile", "", "apply the options, image and command of a profile in profiles.json")
+	runCommand.Flags().BoolP("detach", "d", false, "run container in background and print container ID")
+	runCommand.Flags().String("cidfile", "", "write the container ID to the file")
 	runCommand.Flags().String("cgroupns", constants.Private, "cgroup namespace to use")
+	runCommand.Flags().String("domainname", "", "set container NIS domainname")
 	runCommand.Flags().String("entrypoint", "", "overwrite command to execute when starting the container")
+	runCommand.Flags().String("ip", "", "static IPv4 address of the container in a private or bridge network")
 	runCommand.Flags().String("ipc", constants.Private, "IPC namespace to use")
+	runCommand.Flags().String("mac-address", "", "static MAC address of the container in a private or bridge network")
 	runCommand.Flags().String("name", containerutils.GetRandomName(), "Assign a name to the container")
 	runCommand.Flags().String("network", constants.Private, "connect a container to a network")
+	runCommand.Flags().String("network-backend", "", "backend of the private network: pasta or slirp4netns (default pasta if installed)")
+	runCommand.Flags().StringArray("network-alias", nil, "add a name the container is resolved with in its network")
+	runCommand.Flags().String("pidfile", "", "write the container process ID to the file when started")
 	runCommand.Flags().String("pid", constants.Private, "pid namespace to use")
 	runCommand.Flags().String("time", constants.Private, "time namespace to use")
 	runCommand.Flags().String("userns", constants.KeepID, "user namespace to use")
-	runCommand.Flags().String("stop-signal", "SIGTERM", "signal to stop the container")
+	runCommand.Flags().String("stop-signal", "", "signal to stop the container (default the image's StopSignal, or SIGTERM)")
+	runCommand.Flags().Int("stop-timeout", containerutils.DefaultStopTimeout, "seconds to wait for the container to stop before killing it")
+	runCommand.Flags().String("idle-timeout", "", "stop the container in background after a duration (eg: 30m) without exec or attach sessions")
+	runCommand.Flags().String("tz", containerutils.TimezoneLocal, "set timezone in container, local mirrors the host")
+	runCommand.Flags().String("storage-driver", imageutils.StorageDriverFiles, "storage driver for the rootfs: files, or erofs (experimental)")
+	runCommand.Flags().String("runtime", containerutils.RuntimeBuiltin, "runtime to execute the container with: builtin, crun or runc")
+	runCommand.Flags().String("stats-history", "", "record resource usage every interval (eg: 10s) for stats --history")
+	runCommand.Flags().String("cpus", "", "number of CPUs the container can use, eg: 1.5")
+	runCommand.Flags().String("pids-limit", "", "maximum number of processes in the container")
+	runCommand.Flags().String("restart", containerutils.RestartNo, "restart policy when the container exits: no, on-failure[:max], always or unless-stopped")
 	//nolint:lll
 	runCommand.Flags().StringArrayP("env", "e", nil, "set environment variables in container (default [PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin,TERM=xterm])")
+	runCommand.Flags().StringArray("group-add", nil, "add additional groups, names or GIDs, to the container process")
+	runCommand.Flags().StringArray("requires", nil, "containers to start before this one, comma separated")
 	runCommand.Flags().StringArrayP("label", "", nil, "set metadata on container")
+	runCommand.Flags().StringArray("label-file", nil, "read in a line delimited file of labels")
 	runCommand.Flags().StringArrayP("volume", "v", nil, "bind mount a volume into the container")
 	runCommand.Flags().StringArrayP("mount", "", nil, "perform a mount into the container")
+	runCommand.Flags().StringArrayP("publish", "p", nil, "publish a container port on the host, [hostIP:][hostPort:]containerPort[/proto]")
 	runCommand.Flags().StringP("hostname", "h", "", "set container hostname")
+	runCommand.Flags().StringP("memory", "m", "", "memory limit of the container, eg: 512m or 1g")
 	runCommand.Flags().StringP("user", "u", "root:root", "username or UID (format: <name|uid>[:<group|gid>])")
 	runCommand.Flags().BoolP("interactive", "i", false, "keep process in foreground")
-	runCommand.Flags().BoolP("tty", "t", false, "allocate a pseudo-TTY. The default is false")
+	runCommand.Flags().BoolP("tty", "t", false, "allocate a pseudo-TTY, kept in background to attach to it. The default is false")
+	runCommand.Flags().StringArrayP("attach", "a", nil, "attach to STDIN, STDOUT or STDERR, all of them with --interactive")
 
 	// This does nothing, it's here for CLI compatibility with podman/docker
 	runCommand.Flags().String("security-opt", "", "")
 
+	addHealthFlags(runCommand)
+	addHookFlags(runCommand)
+	addLogDriverFlags(runCommand)
+
 	return runCommand
 }
 
 func run(cmd *cobra.Command, arguments []string) error {
+	arguments, err := applyProfile(cmd, arguments)
+	if err != nil {
+		return err
+	}
+
 	if len(arguments) < 1 {
 		return cmd.Help()
 	}
@@ -74,7 +120,17 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return nil
 	}
 
-	pull, err := cmd.Flags().GetBool("pull")
+	pullPolicy, err := cmd.Flags().GetString("pull")
+	if err != nil {
+		return err
+	}
+
+	replace, err := cmd.Flags().GetBool("replace")
+	if err != nil {
+		return err
+	}
+
+	platform, err := cmd.Flags().GetString("platform")
 	if err != nil {
 		return err
 	}
@@ -89,6 +145,11 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	domainname, err := cmd.Flags().GetString("domainname")
+	if err != nil {
+		return err
+	}
+
 	ipc, err := cmd.Flags().GetString("ipc")
 	if err != nil {
 		return err
@@ -104,6 +165,70 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	ip, err := cmd.Flags().GetString("ip")
+	if err != nil {
+		return err
+	}
+
+	macAddress, err := cmd.Flags().GetString("mac-address")
+	if err != nil {
+		return err
+	}
+
+	network, err = containerutils.ResolveNetwork(network)
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateAddressing(network, ip, macAddress)
+	if err != nil {
+		return err
+	}
+
+	ports, err := cmd.Flags().GetStringArray("publish")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidatePorts(network, ports)
+	if err != nil {
+		return err
+	}
+
+	networkBackend, err := cmd.Flags().GetString("network-backend")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateNetworkBackend(network, networkBackend)
+	if err != nil {
+		return err
+	}
+
+	networkAliases, err := cmd.Flags().GetStringArray("network-alias")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateNetworkAliases(network, networkAliases)
+	if err != nil {
+		return err
+	}
+
+	// containers of bridge networks have an address of their own
+	ip, err = containerutils.AllocateAddress(network, ip)
+	if err != nil {
+		return err
+	}
+
+	// pin a MAC address too, so that the static address stays stable
+	if ip != "" && macAddress == "" {
+		macAddress, err = containerutils.GenerateMacAddress()
+		if err != nil {
+			return err
+		}
+	}
+
 	cgroup, err := cmd.Flags().GetString("cgroupns")
 	if err != nil {
 		return err
@@ -124,6 +249,26 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	groupAdd, err := cmd.Flags().GetStringArray("group-add")
+	if err != nil {
+		return err
+	}
+
+	requiresFlag, err := cmd.Flags().GetStringArray("requires")
+	if err != nil {
+		return err
+	}
+
+	requires, err := containerutils.ResolveRequires(requiresFlag)
+	if err != nil {
+		return err
+	}
+
+	// the container whose network is shared is started first
+	if id, ok := containerutils.GetNetworkContainer(network); ok && !slices.Contains(requires, id) {
+		requires = append(requires, id)
+	}
+
 	userns, err := cmd.Flags().GetString("userns")
 	if err != nil {
 		return err
@@ -134,12 +279,32 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	stopTimeout, err := cmd.Flags().GetInt("stop-timeout")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateStopConfig(stopsignal, stopTimeout)
+	if err != nil {
+		return err
+	}
+
+	idleTimeout, err := cmd.Flags().GetString("idle-timeout")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateIdleTimeout(idleTimeout)
+	if err != nil {
+		return err
+	}
+
 	env, err := cmd.Flags().GetStringArray("env")
 	if err != nil {
 		return err
 	}
 
-	label, err := cmd.Flags().GetStringArray("label")
+	label, err := getLabels(cmd)
 	if err != nil {
 		return err
 	}
@@ -154,6 +319,120 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	err = containerutils.ValidateVolumes(append(mount, volume...))
+	if err != nil {
+		return err
+	}
+
+	timezone, err := cmd.Flags().GetString("tz")
+	if err != nil {
+		return err
+	}
+
+	localeGen, err := cmd.Flags().GetBool("locale-gen")
+	if err != nil {
+		return err
+	}
+
+	useInit, err := cmd.Flags().GetBool("init")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateTimezone(timezone)
+	if err != nil {
+		return err
+	}
+
+	storageDriver, err := cmd.Flags().GetString("storage-driver")
+	if err != nil {
+		return err
+	}
+
+	err = imageutils.ValidateStorageDriver(storageDriver)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := cmd.Flags().GetString("runtime")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateRuntime(runtime)
+	if err != nil {
+		return err
+	}
+
+	statsInterval, err := cmd.Flags().GetString("stats-history")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateStatsInterval(statsInterval)
+	if err != nil {
+		return err
+	}
+
+	restart, err := cmd.Flags().GetString("restart")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateRestartPolicy(restart)
+	if err != nil {
+		return err
+	}
+
+	memory, err := cmd.Flags().GetString("memory")
+	if err != nil {
+		return err
+	}
+
+	cpus, err := cmd.Flags().GetString("cpus")
+	if err != nil {
+		return err
+	}
+
+	pidsLimit, err := cmd.Flags().GetString("pids-limit")
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.ValidateResources(memory, cpus, pidsLimit)
+	if err != nil {
+		return err
+	}
+
+	cidfile, err := getAbsFlag(cmd, "cidfile")
+	if err != nil {
+		return err
+	}
+
+	if cidfile != "" && fileutils.Exist(cidfile) {
+		return fmt.Errorf("container ID file %s already exists", cidfile)
+	}
+
+	pidfile, err := getAbsFlag(cmd, "pidfile")
+	if err != nil {
+		return err
+	}
+
+	healthcheck, err := getHealthConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	logDriver, logOpts, err := getLogDriverConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := getHooksConfig(cmd)
+	if err != nil {
+		return err
+	}
+
 	// default hostname to name if not specified.
 	if hostname == "" {
 		hostname = name
@@ -169,19 +448,59 @@ func run(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	remove, err := cmd.Flags().GetBool("rm")
+	attach, err := cmd.Flags().GetStringArray("attach")
 	if err != nil {
 		return err
 	}
 
-	image := cmd.Flags().Args()[0]
-	entrypoint := cmd.Flags().Args()[1:]
+	streams, err := procutils.ParseStreams(attach)
+	if err != nil {
+		return err
+	}
 
-	if !fileutils.Exist(imageutils.GetPath(image)) {
-		ref, err := imgName.ParseReference(image)
-		if err == nil {
-			image = ref.Name()
+	// interactive alone attaches everything, else it adds stdin to the selection
+	if interactive {
+		if len(attach) == 0 {
+			streams = procutils.AllStreams
 		}
+
+		streams.Stdin = true
+	}
+
+	remove, err := cmd.Flags().GetBool("rm")
+	if err != nil {
+		return err
+	}
+
+	detach, err := cmd.Flags().GetBool("detach")
+	if err != nil {
+		return err
+	}
+
+	// detached containers keep their tty, to attach to them later
+	if detach && (len(attach) > 0 || (interactive && !tty)) {
+		return fmt.Errorf("cannot attach to a detached container, use --detach --tty and lilipod attach")
+	}
+
+	if tty && !detach && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("the input device is not a TTY, use --interactive without --tty to pipe data")
+	}
+
+	if remove && containerutils.IsRestarted(restart) {
+		return fmt.Errorf("--rm cannot be used with --restart, a removed container cannot be restarted")
+	}
+
+	image := arguments[0]
+	entrypoint := arguments[1:]
+
+	err = setImageRegistryTLS(cmd, image)
+	if err != nil {
+		return err
+	}
+
+	err = setRetryPolicy(cmd)
+	if err != nil {
+		return err
 	}
 
 	if os.Getenv("ROOTFUL") == constants.TrueString && userns == constants.KeepID {
@@ -191,61 +510,160 @@ func run(cmd *cobra.Command, arguments []string) error {
 	uid := os.Getenv("PARENT_UID_MAP")
 	gid := os.Getenv("PARENT_GID_MAP")
 
+	id, err := containerutils.NewID()
+	if err != nil {
+		return err
+	}
+
 	createConfig := utils.Config{
-		ID:         containerutils.GetID(name),
+		ID:         id,
 		Env:        env,
 		Cgroup:     cgroup,
-		Created:    time.Now().Format("2006.01.02 15:04:05"),
+		Created:    time.Now().Format(time.RFC3339),
+		Domainname: domainname,
 		Hostname:   hostname,
 		Image:      image,
 		Ipc:        ipc,
 		Names:      name,
 		Network:    network,
+		IP:         ip,
+		MacAddress: macAddress,
+		Ports:      ports,
 		Pid:        pid,
+		PidFile:    pidfile,
 		Privileged: privileged,
 		Time:       timens,
 		User:       user,
+		GroupAdd:   groupAdd,
+		Requires:   requires,
 		Userns:     userns,
 		Workdir:    "/",
 		Stopsignal: stopsignal,
 		Mounts:     append(mount, volume...),
 		Labels:     utils.ListToMap(label),
+		Timezone:   timezone,
+		LocaleGen:  localeGen,
+		Init:       useInit,
+		Tty:        tty,
+		// logging related
+		LogDriver: logDriver,
+		LogOpts:   logOpts,
+		// storage related
+		StorageDriver: storageDriver,
+		// runtime related
+		Runtime: runtime,
+		// network related
+		NetworkBackend: networkBackend,
+		NetworkAliases: networkAliases,
+		// stats related
+		StatsInterval: statsInterval,
+		// resources related
+		Memory:    memory,
+		CPUs:      cpus,
+		PidsLimit: pidsLimit,
+		// restart related
+		Restart:    restart,
+		AutoRemove: remove,
+		// stop related
+		StopTimeout: stopTimeout,
+		// idle related
+		IdleTimeout: idleTimeout,
+		// health related
+		Healthcheck: healthcheck,
+		// hooks related
+		Hooks: hooks,
 		// entry point related
 		Entrypoint: entrypoint,
 	}
 
-	if fileutils.Exist(filepath.Join(containerutils.GetDir(name), "config")) {
-		return fmt.Errorf("container %s already exists", name)
+	replaced, err := getReplacedContainer(name, replace)
+	if err != nil {
+		return err
+	}
+
+	// the new container takes the name once the old one is removed
+	if replaced != "" {
+		createConfig.Names = name + "-" + containerutils.ShortID(createConfig.ID)
+	}
+
+	// missing images are pulled here, so that short names are resolved
+	// with the search registries, and referenced by their full name.
+	pull, err := imageutils.ShouldPull(image, pullPolicy, platform)
+	if err != nil {
+		return err
 	}
 
 	if pull {
 		logging.LogDebug("pulling image: %s", image)
 
-		_, err := imageutils.Pull(image, false)
+		_, err := imageutils.Pull(image, platform, false)
 		if err != nil {
 			return err
 		}
+
+		image = imageutils.GetName(image)
+		createConfig.Image = image
 	}
 
 	logging.LogDebug("preparing rootfs for: %s", name)
 
-	err = containerutils.CreateRootfs(image, name, createConfig, uid, gid)
+	err = containerutils.CreateRootfs(image, createConfig.Names, createConfig, uid, gid)
 	if err != nil {
 		return err
 	}
 
-	defer func() {
-		if remove {
-			_ = os.RemoveAll(containerutils.GetDir(name))
+	if replaced != "" {
+		err = replaceContainer(replaced, createConfig.ID, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cidfile != "" {
+		err = writeCIDFile(cidfile, createConfig.ID)
+		if err != nil {
+			return err
 		}
-	}()
+	}
 
-	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(name), "config"))
+	if detach {
+		return startDetached(name, createConfig.ID)
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(createConfig.ID), "config"))
+	if err != nil {
+		return err
+	}
+
+	err = startRequires(config, nil)
 	if err != nil {
 		return err
 	}
 
 	logging.LogDebug("starting: %s", name)
 
-	return containerutils.Start(interactive, tty, config)
+	return containerutils.Start(streams, tty, config)
+}
+
+// startDetached will start input container in a new session, that keeps it
+// running once we exit, with its output in the logs, and print its ID.
+func startDetached(name string, id string) error {
+	logging.LogDebug("starting detached: %s", name)
+
+	startCmd := exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "start", name)
+	startCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err := startCmd.Start()
+	if err != nil {
+		return err
+	}
+
+	err = startCmd.Process.Release()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(id)
+
+	return nil
 }
@@ -0,0 +1,61 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewSaveCommand will write stored images to an archive.
+func NewSaveCommand() *cobra.Command {
+	saveCommand := &cobra.Command{
+		Use:              "save [flags] IMAGE...",
+		Short:            "Save images to an archive",
+		PreRunE:          logging.Init,
+		RunE:             save,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	saveCommand.Flags().SetInterspersed(false)
+	saveCommand.Flags().BoolP("help", "h", false, "show help")
+	saveCommand.Flags().StringP("output", "o", "", "path of the archive to write")
+	saveCommand.Flags().String("format", imageutils.FormatDockerArchive,
+		"format of the archive: docker-archive, oci-archive or oci-dir")
+
+	return saveCommand
+}
+
+// save will write images from the configured DIR to an archive.
+func save(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	output, err := getAbsFlag(cmd, "output")
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		return fmt.Errorf("a path for the archive is required, use --output")
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	for _, image := range arguments {
+		if !fileutils.Exist(imageutils.GetPath(image)) {
+			return fmt.Errorf("image %s not found", image)
+		}
+	}
+
+	return imageutils.Save(arguments, output, format)
+}
@@ -0,0 +1,111 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewSearchCommand will search images in the registries.
+func NewSearchCommand() *cobra.Command {
+	searchCommand := &cobra.Command{
+		Use:              "search [flags] TERM",
+		Short:            "Search images in the registries",
+		PreRunE:          logging.Init,
+		RunE:             search,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	searchCommand.Flags().SetInterspersed(false)
+	searchCommand.Flags().BoolP("help", "h", false, "show help")
+	searchCommand.Flags().Int("limit", 25, "maximum number of results")
+	searchCommand.Flags().StringArrayP("filter", "f", nil, "filter the results, eg: is-official=true, stars=N")
+	searchCommand.Flags().Bool("no-trunc", false, "do not truncate the descriptions")
+	searchCommand.Flags().String("format", "table", "output format: table or json")
+
+	return searchCommand
+}
+
+// search will print the images matching a term, with their description and stars.
+func search(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
+	}
+
+	if limit < 1 {
+		return fmt.Errorf("invalid limit %d, it must be positive", limit)
+	}
+
+	filter, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return err
+	}
+
+	notrunc, err := cmd.Flags().GetBool("no-trunc")
+	if err != nil {
+		return err
+	}
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %s, valid formats are: table, json", format)
+	}
+
+	results, err := imageutils.Search(arguments[0], limit, utils.ListToMap(filter))
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(out))
+
+		return nil
+	}
+
+	searchTable := table.NewWriter()
+	searchTable.SetOutputMirror(os.Stdout)
+	searchTable.SetStyle(utils.GetDefaultTable())
+	searchTable.AppendHeader(table.Row{"NAME", "DESCRIPTION", "STARS", "OFFICIAL"})
+
+	for _, result := range results {
+		description := result.Description
+		if !notrunc && len(description) > 45 {
+			description = description[:42] + "..."
+		}
+
+		official := ""
+		if result.Official {
+			official = "[OK]"
+		}
+
+		searchTable.AppendRow(table.Row{result.Name, description, strconv.Itoa(result.Stars), official})
+	}
+
+	searchTable.Render()
+
+	return nil
+}
@@ -0,0 +1,143 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// NewShellCommand will open an interactive login shell inside a container,
+// starting it first if needed.
+func NewShellCommand() *cobra.Command {
+	shellCommand := &cobra.Command{
+		Use:              "shell [flags] CONTAINER",
+		Short:            "Open an interactive shell inside a container",
+		PreRunE:          logging.Init,
+		RunE:             shell,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	shellCommand.Flags().SetInterspersed(false)
+	shellCommand.Flags().BoolP("help", "h", false, "show help")
+	shellCommand.Flags().String("shell", "", "shell to use instead of the user's login shell")
+	shellCommand.Flags().StringP("user", "u", "", "username or UID (default: the container's user)")
+	shellCommand.Flags().StringP("workdir", "w", "", "working directory inside the container (default: the user's home)")
+
+	return shellCommand
+}
+
+func shell(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	container := arguments[0]
+
+	user, err := cmd.Flags().GetString("user")
+	if err != nil {
+		return err
+	}
+
+	loginShell, err := cmd.Flags().GetString("shell")
+	if err != nil {
+		return err
+	}
+
+	workdir, err := cmd.Flags().GetString("workdir")
+	if err != nil {
+		return err
+	}
+
+	_, err = containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	configPath := filepath.Join(containerutils.GetDir(container), "config")
+
+	if !containerutils.IsRunning(container) {
+		logging.LogDebug("container %s is not running, starting it", container)
+
+		err = exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "start", container).Run()
+		if err != nil {
+			return err
+		}
+	}
+
+	containerPid, err := waitForPid(container, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	config, err := utils.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	if user == "" {
+		user = config.User
+	}
+
+	if loginShell == "" {
+		loginShell = containerutils.GetLoginShell(container, user)
+	}
+
+	home := "/"
+
+	entry, err := containerutils.GetPasswdEntry(container, user)
+	if err == nil && entry.Home != "" {
+		home = entry.Home
+	}
+
+	if workdir == "" {
+		workdir = home
+	}
+
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "xterm"
+	}
+
+	logging.LogDebug("entering %s with shell %s as %s", container, loginShell, user)
+
+	config.User = user
+	config.Workdir = workdir
+	config.Entrypoint = []string{loginShell, "-l"}
+	config.Env = append(config.Env,
+		"TERM="+term,
+		"HOME="+home,
+		"SHELL="+loginShell,
+		`PS1=[\u@`+config.Hostname+` \W]\$ `,
+	)
+
+	return containerutils.Exec(containerPid, true, true, config)
+}
+
+// waitForPid will wait until input container is running, or until timeout
+// is exceeded, and return its pid.
+func waitForPid(container string, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		pid, err := containerutils.GetPid(container)
+		if err == nil && pid > 0 {
+			return pid, nil
+		}
+
+		if time.Now().After(deadline) {
+			return -1, fmt.Errorf("container %s did not start in time", container)
+		}
+
+		time.Sleep(time.Millisecond * 250)
+	}
+}
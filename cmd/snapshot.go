@@ -0,0 +1,245 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewSnapshotCommand will manage the snapshots of containers' filesystems.
+func NewSnapshotCommand() *cobra.Command {
+	snapshotCommand := &cobra.Command{
+		Use:              "snapshot",
+		Short:            "Manage snapshots of containers' filesystems",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	snapshotCommand.Flags().BoolP("help", "h", false, "show help")
+
+	snapshotCreateCommand := &cobra.Command{
+		Use:              "create [flags] CONTAINER [SNAPSHOT]",
+		Short:            "Save a copy of the container's filesystem changes",
+		PreRunE:          logging.Init,
+		RunE:             snapshotCreate,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	snapshotCreateCommand.Flags().SetInterspersed(false)
+	snapshotCreateCommand.Flags().BoolP("help", "h", false, "show help")
+
+	snapshotListCommand := &cobra.Command{
+		Use:              "list [flags] CONTAINER",
+		Aliases:          []string{"ls"},
+		Short:            "List the snapshots of a container",
+		PreRunE:          logging.Init,
+		RunE:             snapshotList,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	snapshotListCommand.Flags().SetInterspersed(false)
+	snapshotListCommand.Flags().BoolP("help", "h", false, "show help")
+
+	snapshotRestoreCommand := &cobra.Command{
+		Use:              "restore [flags] CONTAINER SNAPSHOT",
+		Short:            "Roll back the container's filesystem to a snapshot",
+		PreRunE:          logging.Init,
+		RunE:             snapshotRestore,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	snapshotRestoreCommand.Flags().SetInterspersed(false)
+	snapshotRestoreCommand.Flags().BoolP("help", "h", false, "show help")
+
+	snapshotRmCommand := &cobra.Command{
+		Use:              "rm [flags] CONTAINER SNAPSHOT...",
+		Short:            "Remove snapshots of a container",
+		PreRunE:          logging.Init,
+		RunE:             snapshotRm,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	snapshotRmCommand.Flags().SetInterspersed(false)
+	snapshotRmCommand.Flags().BoolP("help", "h", false, "show help")
+
+	snapshotCommand.AddCommand(snapshotCreateCommand)
+	snapshotCommand.AddCommand(snapshotListCommand)
+	snapshotCommand.AddCommand(snapshotRestoreCommand)
+	snapshotCommand.AddCommand(snapshotRmCommand)
+
+	return snapshotCommand
+}
+
+// ensureContainer returns an error if input container does not exist, or is
+// an ambiguous ID prefix.
+func ensureContainer(container string) error {
+	id, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
+
+	if !fileutils.Exist(filepath.Join(containerutils.GetDir(id), "config")) {
+		return fmt.Errorf("container %s does not exist", container)
+	}
+
+	return nil
+}
+
+func snapshotCreate(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	container := arguments[0]
+
+	// default to a timestamp, so snapshots sort by name too
+	snapshot := time.Now().Format("20060102-150405")
+	if len(arguments) > 1 {
+		snapshot = arguments[1]
+	}
+
+	err := ensureContainer(container)
+	if err != nil {
+		return err
+	}
+
+	// containers' files can be owned by the fake root
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	err = containerutils.CreateSnapshot(container, snapshot)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(snapshot)
+
+	return nil
+}
+
+func snapshotList(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	container := arguments[0]
+
+	err := ensureContainer(container)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := containerutils.GetSnapshots(container)
+	if err != nil {
+		return err
+	}
+
+	snapshotTable := table.NewWriter()
+	snapshotTable.SetOutputMirror(os.Stdout)
+	snapshotTable.SetStyle(utils.GetDefaultTable())
+	snapshotTable.AppendHeader(table.Row{"SNAPSHOT", "CREATED", "SIZE"})
+
+	for _, snapshot := range snapshots {
+		created := "unknown"
+		if !snapshot.Created.IsZero() {
+			created = snapshot.Created.Format(time.RFC3339)
+		}
+
+		snapshotTable.AppendRow(table.Row{snapshot.Name, created, snapshot.Size})
+	}
+
+	snapshotTable.Render()
+
+	return nil
+}
+
+func snapshotRestore(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	container := arguments[0]
+	snapshot := arguments[1]
+
+	err := ensureContainer(container)
+	if err != nil {
+		return err
+	}
+
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	err = containerutils.RestoreSnapshot(container, snapshot)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(snapshot)
+
+	return nil
+}
+
+func snapshotRm(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	container := arguments[0]
+
+	err := ensureContainer(container)
+	if err != nil {
+		return err
+	}
+
+	success, err := procutils.EnsureFakeRoot(true)
+	if err != nil {
+		return err
+	}
+
+	if success {
+		return nil
+	}
+
+	for _, snapshot := range arguments[1:] {
+		err = containerutils.RemoveSnapshot(container, snapshot)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(snapshot)
+	}
+
+	return nil
+}
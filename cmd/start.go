@@ -4,8 +4,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
@@ -19,7 +22,7 @@ import (
 // NewStartCommand will start one or more containers in input with default entrypoint command.
 func NewStartCommand() *cobra.Command {
 	startCommand := &cobra.Command{
-		Use:              "start [flags] IMAGE",
+		Use:              "start [flags] CONTAINER...",
 		Short:            "Start one or more containers",
 		PreRunE:          logging.Init,
 		RunE:             start,
@@ -29,9 +32,13 @@ func NewStartCommand() *cobra.Command {
 	}
 
 	startCommand.Flags().SetInterspersed(false)
-	startCommand.Flags().BoolP("all", "a", false, "start all containers regardless of their state or configuration")
+	startCommand.Flags().BoolP("all", "a", false, "start all stopped containers")
+	startCommand.Flags().Bool("attach", false, "attach to the container's output, and exit with its exit code")
+	startCommand.Flags().String("detach-keys", containerutils.DefaultDetachKeys,
+		"key sequence to detach from the container with --attach, leaving it running, empty to disable")
+	startCommand.Flags().StringArray("filter", nil, "start the stopped containers matching the filters, see ps --filter")
 	startCommand.Flags().BoolP("help", "h", false, "show help")
-	startCommand.Flags().BoolP("interactive", "i", false, "keep process in foreground")
+	startCommand.Flags().BoolP("interactive", "i", false, "keep process in foreground, or forward the input with --attach")
 	startCommand.Flags().BoolP("tty", "t", false, "allocate a pseudo-TTY. The default is false")
 
 	return startCommand
@@ -48,6 +55,20 @@ func start(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	attach, err := cmd.Flags().GetBool("attach")
+	if err != nil {
+		return err
+	}
+
+	if attach {
+		return startAttached(cmd, arguments, interactive)
+	}
+
+	streams := procutils.Streams{}
+	if interactive {
+		streams = procutils.AllStreams
+	}
+
 	parent, err := procutils.EnsureFakeRoot(interactive)
 	if err != nil {
 		return err
@@ -57,64 +78,162 @@ func start(cmd *cobra.Command, arguments []string) error {
 		return nil
 	}
 
-	startAll, err := cmd.Flags().GetBool("all")
+	if len(arguments) < 1 && !cmd.Flags().Changed("all") && !cmd.Flags().Changed("filter") {
+		return cmd.Help()
+	}
+
+	targets, err := getTargets(cmd, arguments, func(config utils.Config) bool {
+		return config.Status != containerutils.StatusBroken && !containerutils.IsRunning(config.ID)
+	})
 	if err != nil {
 		return err
 	}
 
-	if len(arguments) < 1 && !startAll {
-		return cmd.Help()
-	}
+	// required containers are started first
+	targets = containerutils.SortByRequires(targets)
 
-	// if we want to delete all, just get a list of the targets and add it to
-	// the arguments.
-	if startAll {
-		arguments = []string{}
+	var wg sync.WaitGroup
 
-		containers, err := os.ReadDir(containerutils.ContainerDir)
-		if err != nil {
-			return err
-		}
+	failed := 0
 
-		for _, i := range containers {
-			arguments = append(arguments, i.Name())
+	// keep going on errors, so that all the targets are started
+	for _, container := range targets {
+		config, err := loadStartConfig(container)
+		if err == nil {
+			err = startRequires(config, targets)
 		}
-	}
 
-	var wg sync.WaitGroup
+		if err != nil {
+			logging.LogError("%v", err)
 
-	for _, container := range arguments {
-		// ensure a container for this name is already running
-		if containerutils.IsRunning(container) {
-			return fmt.Errorf("container %s is already running", container)
-		}
+			failed++
 
-		targetDIR := filepath.Join(containerutils.ContainerDir, container)
-		if !fileutils.Exist(targetDIR) {
-			targetDIR = containerutils.GetDir(container)
+			continue
 		}
 
-		// save the config to file
-		configPath := filepath.Join(targetDIR, "config")
-		if fileutils.Exist(configPath) {
-			config, err := utils.LoadConfig(configPath)
-			if err != nil {
-				return err
-			}
+		logging.LogDebug("starting: %s", container)
 
-			logging.LogDebug("starting: %s", container)
+		wg.Add(1)
 
-			wg.Add(1)
-			go containerutils.Start(interactive, tty, config)
+		go func() {
+			defer wg.Done()
 
-			// wait for routine to correctly statt
-			time.Sleep(time.Millisecond * 250)
-		} else {
-			return fmt.Errorf("container %s does not exist", container)
-		}
+			err := containerutils.Start(streams, tty, config)
+			if err != nil {
+				logging.LogError("container %s: %v", config.Names, err)
+			}
+		}()
+
+		// wait for routine to correctly statt
+		time.Sleep(time.Millisecond * 250)
 	}
 
 	wg.Wait()
 
+	if failed > 0 {
+		return fmt.Errorf("failed to start %d of %d containers", failed, len(targets))
+	}
+
 	return nil
 }
+
+// startAttached will start input container in background, like start without
+// flags, and attach to it until it exits, exiting with its exit code.
+// The container waits for us to attach before starting, so its whole output
+// is forwarded.
+func startAttached(cmd *cobra.Command, arguments []string, interactive bool) error {
+	if len(arguments) != 1 || cmd.Flags().Changed("all") || cmd.Flags().Changed("filter") {
+		return fmt.Errorf("--attach can only be used with a single container")
+	}
+
+	// the tty of containers started in background is set at creation
+	if cmd.Flags().Changed("tty") {
+		return fmt.Errorf("--tty cannot be used with --attach, create the container with --tty instead")
+	}
+
+	detachKeys, err := cmd.Flags().GetString("detach-keys")
+	if err != nil {
+		return err
+	}
+
+	keys, err := containerutils.ParseDetachKeys(detachKeys)
+	if err != nil {
+		return err
+	}
+
+	config, err := loadStartConfig(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	err = containerutils.SetAttachWait(config.ID, true)
+	if err != nil {
+		return err
+	}
+
+	// the request is served by the container once it is started, only
+	// failed starts leave it behind
+	defer func() { _ = containerutils.SetAttachWait(config.ID, false) }()
+
+	out, err := exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "start", config.ID).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, string(out))
+	}
+
+	return attachAndWait(config.ID, containerutils.AttachOptions{
+		DetachKeys: keys,
+		Stdin:      interactive,
+		Timeout:    10 * time.Second,
+	})
+}
+
+// loadStartConfig returns the config of input container, if it can be started.
+func loadStartConfig(container string) (utils.Config, error) {
+	// ensure a container for this name is already running
+	if containerutils.IsRunning(container) {
+		return utils.Config{}, fmt.Errorf("container %s is already running", container)
+	}
+
+	id, err := containerutils.ResolveID(container)
+	if err != nil {
+		return utils.Config{}, err
+	}
+
+	configPath := filepath.Join(containerutils.ContainerDir, id, "config")
+	if !fileutils.Exist(configPath) {
+		return utils.Config{}, fmt.Errorf("container %s does not exist", container)
+	}
+
+	return utils.LoadConfig(configPath)
+}
+
+// startRequires will start in background the containers required by input one
+// that are not running, except input targets started by us, and wait for all
+// of them to be running.
+func startRequires(config utils.Config, targets []string) error {
+	for _, required := range config.Requires {
+		if containerutils.IsRunning(required) ||
+			slices.ContainsFunc(targets, func(target string) bool {
+				return containerutils.GetID(target) == required
+			}) {
+			continue
+		}
+
+		logging.LogDebug("starting %s, required by %s", required, config.Names)
+
+		startCmd := exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "start", required)
+		startCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+		err := startCmd.Start()
+		if err != nil {
+			return err
+		}
+
+		err = startCmd.Process.Release()
+		if err != nil {
+			return err
+		}
+	}
+
+	return containerutils.WaitRequires(config)
+}
@@ -0,0 +1,338 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// statsSample is a single resource usage sample of a container.
+type statsSample struct {
+	Timestamp  string  `json:"timestamp"`
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemUsage   uint64  `json:"mem_usage"`
+	MemLimit   uint64  `json:"mem_limit"`
+	MemPercent float64 `json:"mem_percent"`
+	Pids       uint64  `json:"pids"`
+	BlockRead  uint64  `json:"block_read"`
+	BlockWrite uint64  `json:"block_write"`
+}
+
+// NewStatsCommand will show the resource usage of running containers.
+func NewStatsCommand() *cobra.Command {
+	statsCommand := &cobra.Command{
+		Use:              "stats [flags] [CONTAINER...]",
+		Short:            "Display a live stream of container resource usage statistics",
+		PreRunE:          logging.Init,
+		RunE:             stats,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	statsCommand.Flags().SetInterspersed(false)
+	statsCommand.Flags().BoolP("help", "h", false, "show help")
+	statsCommand.Flags().Bool("no-stream", false, "output a single sample instead of refreshing it every interval")
+	statsCommand.Flags().Bool("stream", true, "keep sampling and output a new sample every interval")
+	_ = statsCommand.Flags().MarkDeprecated("stream", "streaming is the default, use --no-stream to disable it")
+	statsCommand.Flags().String("format", "table", "output format (table, json)")
+	statsCommand.Flags().IntP("interval", "i", 1, "seconds between samples")
+	statsCommand.Flags().String("history", "", "show the samples recorded in this duration, eg: 1h")
+
+	return statsCommand
+}
+
+func stats(cmd *cobra.Command, arguments []string) error {
+	noStream, err := cmd.Flags().GetBool("no-stream")
+	if err != nil {
+		return err
+	}
+
+	stream, err := cmd.Flags().GetBool("stream")
+	if err != nil {
+		return err
+	}
+
+	stream = stream && !noStream
+
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	interval, err := cmd.Flags().GetInt("interval")
+	if err != nil {
+		return err
+	}
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %s, valid formats are: table, json", format)
+	}
+
+	if interval < 1 {
+		return fmt.Errorf("interval must be at least 1 second")
+	}
+
+	history, err := cmd.Flags().GetString("history")
+	if err != nil {
+		return err
+	}
+
+	if history != "" {
+		return statsHistory(arguments, history, format)
+	}
+
+	// if no container is specified, sample all running containers.
+	if len(arguments) == 0 {
+		containers, err := os.ReadDir(containerutils.ContainerDir)
+		if err != nil {
+			logging.Log("no containers found")
+
+			//nolint: nilerr
+			return nil
+		}
+
+		for _, container := range containers {
+			if containerutils.IsRunning(container.Name()) {
+				arguments = append(arguments, container.Name())
+			}
+		}
+	}
+
+	configs := []utils.Config{}
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		config, err := utils.LoadConfig(filepath.Join(containerutils.ContainerDir, id, "config"))
+		if err != nil {
+			return err
+		}
+
+		configs = append(configs, config)
+	}
+
+	// on a terminal the table is refreshed in place, instead of appending a new one
+	live := stream && format == "table" && term.IsTerminal(int(os.Stdout.Fd()))
+
+	hostMemory := cgrouputils.GetHostMemory()
+	previous := sampleStats(configs)
+	previousTime := time.Now()
+
+	for {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		current := sampleStats(configs)
+		now := time.Now()
+		elapsed := uint64(now.Sub(previousTime).Microseconds())
+
+		samples := []statsSample{}
+
+		for _, config := range configs {
+			curr, ok := current[config.ID]
+			if !ok {
+				continue
+			}
+
+			prev, ok := previous[config.ID]
+			if !ok {
+				prev = curr
+			}
+
+			samples = append(samples, newStatsSample(config, prev, curr, elapsed, hostMemory, now))
+		}
+
+		if live {
+			fmt.Print("\033[H\033[2J")
+		}
+
+		err = printStats(samples, format, false)
+		if err != nil {
+			return err
+		}
+
+		if !stream {
+			return nil
+		}
+
+		previous = current
+		previousTime = now
+	}
+}
+
+// newStatsSample returns the sample of input container, computing the cpu usage
+// between the prev and curr stats, taken elapsed microseconds apart.
+func newStatsSample(
+	config utils.Config,
+	prev, curr cgrouputils.Stats,
+	elapsed, hostMemory uint64,
+	now time.Time,
+) statsSample {
+	sample := statsSample{
+		Timestamp:  now.Format(time.RFC3339),
+		ID:         config.ID,
+		Name:       config.Names,
+		MemUsage:   curr.MemoryUsage,
+		MemLimit:   curr.MemoryLimit,
+		Pids:       curr.Pids,
+		BlockRead:  curr.BlockRead,
+		BlockWrite: curr.BlockWrite,
+	}
+
+	if elapsed > 0 && curr.CPUUsageUsec >= prev.CPUUsageUsec {
+		sample.CPUPercent = float64(curr.CPUUsageUsec-prev.CPUUsageUsec) / float64(elapsed) * 100
+	}
+
+	limit := curr.MemoryLimit
+	if limit == 0 {
+		limit = hostMemory
+	}
+
+	if limit > 0 {
+		sample.MemPercent = float64(curr.MemoryUsage) / float64(limit) * 100
+	}
+
+	return sample
+}
+
+// statsHistory will output the recorded samples of input containers, newer
+// than input duration.
+func statsHistory(arguments []string, history string, format string) error {
+	duration, err := time.ParseDuration(history)
+	if err != nil {
+		return fmt.Errorf("invalid history duration %s: %w", history, err)
+	}
+
+	if len(arguments) == 0 {
+		return fmt.Errorf("--history requires at least one container")
+	}
+
+	hostMemory := cgrouputils.GetHostMemory()
+	since := time.Now().Add(-duration)
+	samples := []statsSample{}
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		config, err := utils.LoadConfig(filepath.Join(containerutils.ContainerDir, id, "config"))
+		if err != nil {
+			return err
+		}
+
+		if config.StatsInterval == "" {
+			logging.LogWarning("container %s has no stats history, create it with --stats-history", config.Names)
+		}
+
+		records, err := containerutils.GetStatsHistory(id, since)
+		if err != nil {
+			return err
+		}
+
+		for i, record := range records {
+			prev := record.Stats
+			elapsed := uint64(0)
+
+			// the first sample, and the first after a restart, have no cpu usage
+			if i > 0 {
+				prev = records[i-1].Stats
+				elapsed = uint64(record.Timestamp.Sub(records[i-1].Timestamp).Microseconds())
+			}
+
+			samples = append(samples, newStatsSample(config, prev, record.Stats, elapsed, hostMemory, record.Timestamp))
+		}
+	}
+
+	return printStats(samples, format, true)
+}
+
+// sampleStats will return a map of container IDs with their current stats.
+// Containers that are not running are skipped.
+func sampleStats(configs []utils.Config) map[string]cgrouputils.Stats {
+	result := map[string]cgrouputils.Stats{}
+
+	for _, config := range configs {
+		sample, err := containerutils.GetStats(config.ID)
+		if err != nil {
+			logging.LogDebug("cannot sample %s: %v", config.Names, err)
+
+			continue
+		}
+
+		result[config.ID] = sample
+	}
+
+	return result
+}
+
+// printStats will output input samples either as a table, or as one JSON object per line.
+// With history, the time of each sample is shown in the table too.
+func printStats(samples []statsSample, format string, history bool) error {
+	if format == "json" {
+		for _, sample := range samples {
+			out, err := json.Marshal(sample)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(string(out))
+		}
+
+		return nil
+	}
+
+	statsTable := table.NewWriter()
+	statsTable.SetOutputMirror(os.Stdout)
+	statsTable.SetStyle(utils.GetDefaultTable())
+	header := table.Row{"CONTAINER ID", "NAME", "CPU %", "MEM USAGE / LIMIT", "MEM %", "BLOCK I/O", "PIDS"}
+	if history {
+		header = append(table.Row{"TIME"}, header...)
+	}
+
+	statsTable.AppendHeader(header)
+
+	for _, sample := range samples {
+		limit := "unlimited"
+		if sample.MemLimit > 0 {
+			limit = utils.HumanSize(sample.MemLimit)
+		}
+
+		row := table.Row{
+			containerutils.ShortID(sample.ID),
+			sample.Name,
+			fmt.Sprintf("%.2f%%", sample.CPUPercent),
+			utils.HumanSize(sample.MemUsage) + " / " + limit,
+			fmt.Sprintf("%.2f%%", sample.MemPercent),
+			utils.HumanSize(sample.BlockRead) + " / " + utils.HumanSize(sample.BlockWrite),
+			sample.Pids,
+		}
+
+		if history {
+			row = append(table.Row{sample.Timestamp}, row...)
+		}
+
+		statsTable.AppendRow(row)
+	}
+
+	statsTable.Render()
+
+	return nil
+}
@@ -3,20 +3,21 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/89luca89/lilipod/pkg/containerutils"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
 // NewStopCommand will find all the processes in given container and will stop them.
 func NewStopCommand() *cobra.Command {
 	stopCommand := &cobra.Command{
-		Use:              "stop [flags] IMAGE",
-		Short:            "Remove one or more containers",
+		Use:              "stop [flags] CONTAINER...",
+		Short:            "Stop one or more containers",
 		PreRunE:          logging.Init,
 		RunE:             stop,
 		SilenceUsage:     true,
@@ -26,19 +27,15 @@ func NewStopCommand() *cobra.Command {
 
 	stopCommand.Flags().SetInterspersed(false)
 	stopCommand.Flags().BoolP("all", "a", false, "stop all running containers")
+	stopCommand.Flags().StringArray("filter", nil, "stop the running containers matching the filters, see ps --filter")
 	stopCommand.Flags().BoolP("force", "f", false, "force stop running container (use SIGKILL instead of SIGTERM)")
 	stopCommand.Flags().BoolP("help", "h", false, "show help")
-	stopCommand.Flags().IntP("timeout", "t", 10, "seconds to wait before forcefully exiting the container")
+	stopCommand.Flags().IntP("timeout", "t", 0, "seconds to wait before forcefully exiting the container (default the container's stop timeout)")
 
 	return stopCommand
 }
 
 func stop(cmd *cobra.Command, arguments []string) error {
-	stopAll, err := cmd.Flags().GetBool("all")
-	if err != nil {
-		return err
-	}
-
 	force, err := cmd.Flags().GetBool("force")
 	if err != nil {
 		return err
@@ -49,52 +46,68 @@ func stop(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	if len(arguments) < 1 && !stopAll {
+	// use the container's own stop timeout
+	if !cmd.Flags().Changed("timeout") {
+		timeout = -1
+	}
+
+	if len(arguments) < 1 && !cmd.Flags().Changed("all") && !cmd.Flags().Changed("filter") {
 		return cmd.Help()
 	}
 
-	// if we want to delete all, just get a list of the targets and add it to
-	// the arguments.
-	if stopAll {
-		arguments = []string{}
+	targets, err := getTargets(cmd, arguments, func(config utils.Config) bool {
+		return containerutils.IsRunning(config.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// containers are stopped before the ones they require
+	targets = containerutils.SortByRequires(targets)
+	slices.Reverse(targets)
+
+	failed := 0
 
-		containers, err := os.ReadDir(containerutils.ContainerDir)
+	// keep going on errors, so that all the targets are stopped
+	for _, container := range targets {
+		err := stopContainer(container, force, timeout)
 		if err != nil {
-			return err
-		}
+			logging.LogError("%v", err)
+
+			failed++
 
-		for _, i := range containers {
-			arguments = append(arguments, i.Name())
+			continue
 		}
+
+		fmt.Println(container)
 	}
 
-	for _, container := range arguments {
-		// delete the targets.
-		targetDIR := filepath.Join(containerutils.ContainerDir, container)
-		if !fileutils.Exist(targetDIR) {
-			targetDIR = containerutils.GetDir(container)
-		}
+	if failed > 0 {
+		return fmt.Errorf("failed to stop %d of %d containers", failed, len(targets))
+	}
 
-		if fileutils.Exist(targetDIR) {
-			logging.LogDebug("stopping: %s", container)
+	return nil
+}
 
-			pid, _ := containerutils.GetPid(container)
-			if pid < 1 {
-				logging.LogDebug("container %s already stopped", container)
+// stopContainer will stop input container, if it is running.
+func stopContainer(container string, force bool, timeout int) error {
+	id, err := containerutils.ResolveID(container)
+	if err != nil {
+		return err
+	}
 
-				return nil
-			}
+	if !fileutils.Exist(filepath.Join(containerutils.ContainerDir, id)) {
+		return fmt.Errorf("container %s does not exist", container)
+	}
 
-			err = containerutils.Stop(container, force, timeout)
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("container %s does not exist", container)
-		}
+	logging.LogDebug("stopping: %s", container)
 
-		fmt.Println(container)
+	pid, _ := containerutils.GetPid(id)
+	if pid < 1 {
+		logging.LogDebug("container %s already stopped", container)
+
+		return nil
 	}
 
-	return nil
+	return containerutils.Stop(id, force, timeout)
 }
@@ -0,0 +1,227 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/sysinfo"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewSystemCommand will manage lilipod's storage and state.
+func NewSystemCommand() *cobra.Command {
+	systemCommand := &cobra.Command{
+		Use:              "system",
+		Short:            "Manage lilipod",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	systemCommand.Flags().BoolP("help", "h", false, "show help")
+
+	systemCheckCommand := &cobra.Command{
+		Use:              "check [flags]",
+		Short:            "Check containers for consistency, and optionally repair or remove broken ones",
+		PreRunE:          logging.Init,
+		RunE:             systemCheck,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	systemCheckCommand.Flags().SetInterspersed(false)
+	systemCheckCommand.Flags().BoolP("help", "h", false, "show help")
+	systemCheckCommand.Flags().Bool("repair", false, "try to repair broken containers")
+	systemCheckCommand.Flags().Bool("remove", false, "remove broken containers")
+
+	systemInfoCommand := &cobra.Command{
+		Use:              "info [flags]",
+		Short:            "Display information about the host, useful to report issues",
+		PreRunE:          logging.Init,
+		RunE:             systemInfo,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	systemInfoCommand.Flags().SetInterspersed(false)
+	systemInfoCommand.Flags().BoolP("help", "h", false, "show help")
+	systemInfoCommand.Flags().String("format", "", "output format, can be json or a Go template")
+
+	systemCommand.AddCommand(systemCheckCommand)
+	systemCommand.AddCommand(systemInfoCommand)
+
+	return systemCommand
+}
+
+func systemCheck(cmd *cobra.Command, _ []string) error {
+	repair, err := cmd.Flags().GetBool("repair")
+	if err != nil {
+		return err
+	}
+
+	remove, err := cmd.Flags().GetBool("remove")
+	if err != nil {
+		return err
+	}
+
+	if repair && remove {
+		return fmt.Errorf("--repair and --remove are mutually exclusive")
+	}
+
+	// containers' files can be owned by the fake root
+	if repair || remove {
+		success, err := procutils.EnsureFakeRoot(false)
+		if err != nil {
+			return err
+		}
+
+		if success {
+			return nil
+		}
+	}
+
+	problems, err := containerutils.CheckContainers()
+	if err != nil {
+		return err
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("no problems found")
+
+		return nil
+	}
+
+	checkTable := table.NewWriter()
+	checkTable.SetOutputMirror(os.Stdout)
+	checkTable.SetStyle(utils.GetDefaultTable())
+	checkTable.AppendHeader(table.Row{"CONTAINER ID", "NAMES", "PROBLEM", "ACTION"})
+
+	for _, problem := range problems {
+		action := "none"
+
+		switch {
+		case repair:
+			action = "repaired"
+
+			err = containerutils.RepairContainer(problem.ID)
+			if err != nil {
+				logging.LogWarning("cannot repair container %s: %v", problem.ID, err)
+
+				action = "repair failed"
+			}
+		case remove:
+			action = "removed"
+
+			err = containerutils.RemoveContainer(problem.ID)
+			if err != nil {
+				logging.LogWarning("cannot remove container %s: %v", problem.ID, err)
+
+				action = "remove failed"
+			}
+		}
+
+		checkTable.AppendRow(table.Row{
+			containerutils.ShortID(problem.ID),
+			problem.Name,
+			problem.Problem,
+			action,
+		})
+	}
+
+	checkTable.Render()
+
+	return nil
+}
+
+func systemInfo(cmd *cobra.Command, _ []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	info := sysinfo.Get()
+
+	switch format {
+	case "":
+	case "json":
+		out, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(out))
+
+		return nil
+	default:
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return err
+		}
+
+		err = tmpl.Execute(os.Stdout, info)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+
+		return nil
+	}
+
+	fmt.Printf("version: %s\n", info.Version)
+	fmt.Println("host:")
+	fmt.Printf("  os: %s\n", info.Host.OS)
+	fmt.Printf("  arch: %s\n", info.Host.Arch)
+	fmt.Printf("  kernel: %s\n", info.Host.Kernel)
+	fmt.Printf("  rootful: %t\n", info.Host.Rootful)
+	fmt.Printf("  uid: %d\n", info.Host.UID)
+	fmt.Printf("  uidmap: %s\n", info.Host.UIDMap)
+	fmt.Printf("  gidmap: %s\n", info.Host.GIDMap)
+	fmt.Println("store:")
+	fmt.Printf("  home: %s\n", info.Store.Home)
+	fmt.Printf("  images: %s (%d)\n", info.Store.ImageDir, info.Store.Images)
+	fmt.Printf("  layers: %s\n", info.Store.BlobDir)
+	fmt.Printf("  containers: %s (%d)\n", info.Store.ContainerDir, info.Store.Containers)
+	fmt.Printf("  volumes: %s\n", info.Store.VolumeDir)
+	fmt.Printf("  storage drivers: %s\n", strings.Join(info.Store.StorageDrivers, ", "))
+	fmt.Println("cgroup:")
+	fmt.Printf("  version: %s\n", info.Cgroup.Version)
+	fmt.Printf("  path: %s\n", info.Cgroup.Path)
+	fmt.Printf("  delegated: %t\n", info.Cgroup.Delegated)
+	fmt.Printf("  controllers: %s\n", strings.Join(info.Cgroup.Controllers, ", "))
+	fmt.Println("network:")
+	fmt.Printf("  backend: %s\n", info.Network.Backend)
+	fmt.Printf("  path: %s\n", info.Network.Path)
+	fmt.Println("kernel features:")
+	fmt.Printf("  overlay: %t\n", info.Kernel.Overlay)
+	fmt.Printf("  erofs: %t\n", info.Kernel.Erofs)
+	fmt.Printf("  idmapped mounts: %t\n", info.Kernel.IDMappedMounts)
+	fmt.Printf("  user namespaces: %t\n", info.Kernel.UserNamespaces)
+	fmt.Println("helpers:")
+
+	helpers := []string{}
+	for helper := range info.Helpers {
+		helpers = append(helpers, helper)
+	}
+
+	sort.Strings(helpers)
+
+	for _, helper := range helpers {
+		fmt.Printf("  %s: %s\n", helper, info.Helpers[helper])
+	}
+
+	return nil
+}
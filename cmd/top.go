@@ -0,0 +1,105 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewTopCommand will show the processes running in a container.
+func NewTopCommand() *cobra.Command {
+	topCommand := &cobra.Command{
+		Use:              "top [flags] CONTAINER [DESCRIPTORS...]",
+		Short:            "Display the running processes of a container",
+		PreRunE:          logging.Init,
+		RunE:             top,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	topCommand.Flags().SetInterspersed(false)
+	topCommand.Flags().BoolP("help", "h", false, "show help")
+	topCommand.Flags().Bool("list-descriptors", false, "list the supported descriptors")
+
+	return topCommand
+}
+
+func top(cmd *cobra.Command, arguments []string) error {
+	listDescriptors, err := cmd.Flags().GetBool("list-descriptors")
+	if err != nil {
+		return err
+	}
+
+	if listDescriptors {
+		for _, descriptor := range containerutils.GetTopDescriptors() {
+			fmt.Println(descriptor)
+		}
+
+		return nil
+	}
+
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	id, err := containerutils.ResolveID(arguments[0])
+	if err != nil {
+		return err
+	}
+
+	if !containerutils.IsRunning(id) {
+		return fmt.Errorf("container %s is not running", arguments[0])
+	}
+
+	// descriptors can be comma separated too, like in ps -o
+	descriptors := []string{}
+
+	for _, argument := range arguments[1:] {
+		for _, descriptor := range strings.Split(argument, ",") {
+			if descriptor != "" {
+				descriptors = append(descriptors, descriptor)
+			}
+		}
+	}
+
+	if len(descriptors) == 0 {
+		descriptors = containerutils.DefaultTopDescriptors
+	}
+
+	header, rows, err := containerutils.Top(id, descriptors)
+	if err != nil {
+		return err
+	}
+
+	topTable := table.NewWriter()
+	topTable.SetOutputMirror(os.Stdout)
+	topTable.SetStyle(utils.GetDefaultTable())
+
+	headerRow := table.Row{}
+	for _, column := range header {
+		headerRow = append(headerRow, column)
+	}
+
+	topTable.AppendHeader(headerRow)
+
+	for _, row := range rows {
+		tableRow := table.Row{}
+		for _, value := range row {
+			tableRow = append(tableRow, value)
+		}
+
+		topTable.AppendRow(tableRow)
+	}
+
+	topTable.Render()
+
+	return nil
+}
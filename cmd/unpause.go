@@ -0,0 +1,30 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// NewUnpauseCommand will resume all the processes of given paused containers.
+func NewUnpauseCommand() *cobra.Command {
+	unpauseCommand := &cobra.Command{
+		Use:              "unpause [flags] CONTAINER [CONTAINER...]",
+		Short:            "Unpause all the processes in one or more containers",
+		PreRunE:          logging.Init,
+		RunE:             unpause,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	unpauseCommand.Flags().SetInterspersed(false)
+	unpauseCommand.Flags().BoolP("all", "a", false, "unpause all paused containers")
+	unpauseCommand.Flags().BoolP("help", "h", false, "show help")
+
+	return unpauseCommand
+}
+
+func unpause(cmd *cobra.Command, arguments []string) error {
+	return setPaused(cmd, arguments, false)
+}
@@ -4,6 +4,7 @@ package cmd
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -14,6 +15,13 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// offlineFlags are the update flags that cannot be applied to running
+// containers, unlike the resources, the restart policy and the labels.
+var offlineFlags = []string{
+	"config-reset", "cgroup", "entrypoint", "ipc", "network", "pid", "privileged",
+	"time", "userns", "env", "volume", "hostname", "idle-timeout",
+}
+
 // NewUpdateCommand will update a new container environment ready to use.
 func NewUpdateCommand() *cobra.Command {
 	updateCommand := &cobra.Command{
@@ -31,11 +39,15 @@ func NewUpdateCommand() *cobra.Command {
 	updateCommand.Flags().Bool("help", false, "show help")
 	updateCommand.Flags().Bool("config-reset", false, "reset blank configuration for container")
 	updateCommand.Flags().String("cgroup", "", "cgroup namespace to use")
+	updateCommand.Flags().String("cpus", "", "number of CPUs the container can use, eg: 1.5, 0 for unlimited")
 	updateCommand.Flags().String("entrypoint", "", "overwrite command to execute when starting the container")
+	updateCommand.Flags().String("idle-timeout", "", "stop the container in background after a duration (eg: 30m) without exec or attach sessions, empty to disable")
 	updateCommand.Flags().String("ipc", "", "IPC namespace to use")
 	updateCommand.Flags().String("network", "", "connect a container to a network")
 	updateCommand.Flags().String("pid", "", "pid namespace to use")
+	updateCommand.Flags().String("pids-limit", "", "maximum number of processes in the container, 0 for unlimited")
 	updateCommand.Flags().String("privileged", "", "Give extended privileges to the container")
+	updateCommand.Flags().String("restart", "", "restart policy when the container exits: no, on-failure[:max], always or unless-stopped")
 	updateCommand.Flags().String("time", "", "time namespace to use")
 	updateCommand.Flags().String("userns", "", "user namespace to use")
 	//nolint:lll
@@ -43,6 +55,7 @@ func NewUpdateCommand() *cobra.Command {
 	updateCommand.Flags().StringArrayP("label", "", nil, "set metadata on container")
 	updateCommand.Flags().StringArrayP("volume", "v", nil, "bind mount a volume into the container")
 	updateCommand.Flags().StringP("hostname", "h", "", "set container hostname")
+	updateCommand.Flags().StringP("memory", "m", "", "memory limit of the container, eg: 512m or 1g, 0 for unlimited")
 
 	return updateCommand
 }
@@ -99,11 +112,36 @@ func update(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	restart, err := cmd.Flags().GetString("restart")
+	if err != nil {
+		return err
+	}
+
+	memory, err := cmd.Flags().GetString("memory")
+	if err != nil {
+		return err
+	}
+
+	cpus, err := cmd.Flags().GetString("cpus")
+	if err != nil {
+		return err
+	}
+
+	pidsLimit, err := cmd.Flags().GetString("pids-limit")
+	if err != nil {
+		return err
+	}
+
 	entrypoint, err := cmd.Flags().GetString("entrypoint")
 	if err != nil {
 		return err
 	}
 
+	idleTimeout, err := cmd.Flags().GetString("idle-timeout")
+	if err != nil {
+		return err
+	}
+
 	env, err := cmd.Flags().GetStringArray("env")
 	if err != nil {
 		return err
@@ -119,8 +157,9 @@ func update(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
-	if !fileutils.Exist(filepath.Join(containerutils.GetDir(container), "config")) {
-		return fmt.Errorf("container %s does not exist", container)
+	_, err = containerutils.ResolveID(container)
+	if err != nil {
+		return err
 	}
 
 	configfile, err := fileutils.ReadFile(filepath.Join(containerutils.GetDir(container), "config"))
@@ -143,8 +182,13 @@ func update(cmd *cobra.Command, arguments []string) error {
 		return nil
 	}
 
-	if containerutils.IsRunning(container) {
-		return fmt.Errorf("container %s is running, stop it first", container)
+	running := containerutils.IsRunning(container)
+	if running {
+		for _, flag := range offlineFlags {
+			if cmd.Flags().Lookup(flag).Changed {
+				return fmt.Errorf("container %s is running, stop it first to change --%s", container, flag)
+			}
+		}
 	}
 
 	if reset {
@@ -156,7 +200,6 @@ func update(cmd *cobra.Command, arguments []string) error {
 		defConf.Image = config.Image
 		defConf.Hostname = config.Hostname
 		defConf.Userns = config.Userns
-		defConf.ID = containerutils.GetID(container)
 
 		return utils.SaveConfig(defConf, filepath.Join(containerutils.GetDir(container), "config"))
 	}
@@ -165,6 +208,15 @@ func update(cmd *cobra.Command, arguments []string) error {
 		config.Entrypoint = strings.Split(entrypoint, " ")
 	}
 
+	if cmd.Flags().Lookup("idle-timeout").Changed {
+		err = containerutils.ValidateIdleTimeout(idleTimeout)
+		if err != nil {
+			return err
+		}
+
+		config.IdleTimeout = idleTimeout
+	}
+
 	if cmd.Flags().Lookup("privileged").Changed {
 		config.Privileged, err = strconv.ParseBool(privileged)
 		if err != nil {
@@ -172,12 +224,76 @@ func update(cmd *cobra.Command, arguments []string) error {
 		}
 	}
 
+	if cmd.Flags().Lookup("restart").Changed {
+		err = containerutils.ValidateRestartPolicy(restart)
+		if err != nil {
+			return err
+		}
+
+		if config.AutoRemove && containerutils.IsRestarted(restart) {
+			return fmt.Errorf("--restart cannot be used with an auto-removed container, it is removed once it exits")
+		}
+
+		config.Restart = restart
+	}
+
+	resourcesChanged := false
+
+	if cmd.Flags().Lookup("memory").Changed {
+		config.Memory = memory
+		resourcesChanged = true
+	}
+
+	if cmd.Flags().Lookup("cpus").Changed {
+		config.CPUs = cpus
+		resourcesChanged = true
+	}
+
+	if cmd.Flags().Lookup("pids-limit").Changed {
+		config.PidsLimit = pidsLimit
+		resourcesChanged = true
+	}
+
+	err = containerutils.ValidateResources(config.Memory, config.CPUs, config.PidsLimit)
+	if err != nil {
+		return err
+	}
+
 	if cmd.Flags().Lookup("ipc").Changed {
 		config.Ipc = ipc
 	}
 
 	if cmd.Flags().Lookup("network").Changed {
+		network, err = containerutils.ResolveNetwork(network)
+		if err != nil {
+			return err
+		}
+
+		// the address of a bridge network is only valid in its subnet
+		if network != config.Network && containerutils.IsUserNetwork(config.Network) {
+			config.IP = ""
+		}
+
+		if network != config.Network {
+			config.IP, err = containerutils.AllocateAddress(network, config.IP)
+			if err != nil {
+				return err
+			}
+		}
+
+		if config.IP != "" && config.MacAddress == "" {
+			config.MacAddress, err = containerutils.GenerateMacAddress()
+			if err != nil {
+				return err
+			}
+		}
+
 		config.Network = network
+
+		// the container whose network is shared is started first
+		if id, ok := containerutils.GetNetworkContainer(config.Network); ok && !slices.Contains(config.Requires, id) {
+			config.Requires = append(config.Requires, id)
+		}
 	}
 
 	if cmd.Flags().Lookup("cgroup").Changed {
@@ -222,8 +338,19 @@ func update(cmd *cobra.Command, arguments []string) error {
 		return err
 	}
 
+	// the restart policy is read again when the container exits
+	if running && resourcesChanged {
+		err = containerutils.SetResources(config)
+		if err != nil {
+			return err
+		}
+	}
+
 	logging.LogDebug("configured %s successfully", container)
-	logging.LogWarning("please stop %s and start again to take effect", container)
+
+	if !running {
+		logging.LogWarning("please stop %s and start again to take effect", container)
+	}
 
 	fmt.Println(container)
 
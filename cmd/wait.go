@@ -0,0 +1,69 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// ExitCodeError is returned by commands that exit with the exit code of a
+// container, it was already reported so only the code is propagated.
+type ExitCodeError struct {
+	Code int
+}
+
+// Error implements error.
+func (e *ExitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// NewWaitCommand will wait for given containers to exit, and print their exit codes.
+func NewWaitCommand() *cobra.Command {
+	waitCommand := &cobra.Command{
+		Use:   "wait [flags] CONTAINER [CONTAINER...]",
+		Short: "Block until one or more containers exit, and print their exit codes",
+		Long: "Block until one or more containers exit, and print their exit codes.\n" +
+			"lilipod exits with the exit code of the last container.",
+		PreRunE:          logging.Init,
+		RunE:             wait,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	waitCommand.Flags().SetInterspersed(false)
+	waitCommand.Flags().BoolP("help", "h", false, "show help")
+
+	return waitCommand
+}
+
+func wait(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	exitCode := 0
+
+	for _, container := range arguments {
+		id, err := containerutils.ResolveID(container)
+		if err != nil {
+			return err
+		}
+
+		exitCode, err = containerutils.Wait(id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(exitCode)
+	}
+
+	if exitCode != 0 {
+		return &ExitCodeError{Code: exitCode}
+	}
+
+	return nil
+}
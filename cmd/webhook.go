@@ -0,0 +1,279 @@
+// Package cmd contains all the cobra commands for the CLI application.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// NewWebhookCommand will manage the webhooks notified of container events.
+func NewWebhookCommand() *cobra.Command {
+	webhookCommand := &cobra.Command{
+		Use:              "webhook",
+		Short:            "Manage webhooks notified of container events",
+		PreRunE:          logging.Init,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	webhookCommand.Flags().BoolP("help", "h", false, "show help")
+
+	webhookAddCommand := &cobra.Command{
+		Use:              "add [flags] NAME URL",
+		Short:            "Add a webhook",
+		PreRunE:          logging.Init,
+		RunE:             webhookAdd,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	webhookAddCommand.Flags().SetInterspersed(false)
+	webhookAddCommand.Flags().BoolP("help", "h", false, "show help")
+	webhookAddCommand.Flags().StringArray("event", nil, "notify only input event (eg: die, health_status), all if not set")
+	webhookAddCommand.Flags().String("template", "", "go-template of the request body, the JSON event if not set")
+	webhookAddCommand.Flags().String("content-type", "", "content type of the request body")
+	webhookAddCommand.Flags().StringArray("header", nil, "set a custom header (key=value) on the request")
+	webhookAddCommand.Flags().Int("retries", 3, "number of retries in case of failed delivery")
+
+	webhookLsCommand := &cobra.Command{
+		Use:              "ls [flags]",
+		Short:            "List webhooks",
+		PreRunE:          logging.Init,
+		RunE:             webhookLs,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	webhookLsCommand.Flags().SetInterspersed(false)
+	webhookLsCommand.Flags().BoolP("help", "h", false, "show help")
+
+	webhookRmCommand := &cobra.Command{
+		Use:              "rm [flags] NAME [NAME...]",
+		Short:            "Remove one or more webhooks",
+		PreRunE:          logging.Init,
+		RunE:             webhookRm,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	webhookRmCommand.Flags().SetInterspersed(false)
+	webhookRmCommand.Flags().BoolP("help", "h", false, "show help")
+
+	webhookTestCommand := &cobra.Command{
+		Use:              "test [flags] NAME",
+		Short:            "Send a test event to a webhook",
+		PreRunE:          logging.Init,
+		RunE:             webhookTest,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	webhookTestCommand.Flags().SetInterspersed(false)
+	webhookTestCommand.Flags().BoolP("help", "h", false, "show help")
+
+	webhookDeliverCommand := &cobra.Command{
+		Use:              "deliver EVENT",
+		Short:            "Deliver an event to the subscribed webhooks",
+		Hidden:           true,
+		PreRunE:          logging.Init,
+		RunE:             webhookDeliver,
+		SilenceUsage:     true,
+		SilenceErrors:    true,
+		TraverseChildren: true,
+	}
+
+	webhookDeliverCommand.Flags().SetInterspersed(false)
+
+	webhookCommand.AddCommand(webhookAddCommand)
+	webhookCommand.AddCommand(webhookLsCommand)
+	webhookCommand.AddCommand(webhookRmCommand)
+	webhookCommand.AddCommand(webhookTestCommand)
+	webhookCommand.AddCommand(webhookDeliverCommand)
+
+	return webhookCommand
+}
+
+func webhookAdd(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 2 {
+		return cmd.Help()
+	}
+
+	eventFilter, err := cmd.Flags().GetStringArray("event")
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := cmd.Flags().GetString("template")
+	if err != nil {
+		return err
+	}
+
+	contentType, err := cmd.Flags().GetString("content-type")
+	if err != nil {
+		return err
+	}
+
+	headers, err := cmd.Flags().GetStringArray("header")
+	if err != nil {
+		return err
+	}
+
+	retries, err := cmd.Flags().GetInt("retries")
+	if err != nil {
+		return err
+	}
+
+	webhook := events.Webhook{
+		Name:        arguments[0],
+		URL:         arguments[1],
+		Events:      eventFilter,
+		Template:    tmpl,
+		ContentType: contentType,
+		Headers:     map[string]string{},
+		Retries:     retries,
+	}
+
+	for _, header := range headers {
+		key, value, found := strings.Cut(header, "=")
+		if !found {
+			return fmt.Errorf("invalid header %s, must be key=value", header)
+		}
+
+		webhook.Headers[key] = value
+	}
+
+	err = events.ValidateWebhook(webhook)
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := events.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range webhooks {
+		if existing.Name == webhook.Name {
+			return fmt.Errorf("webhook %s already exists", webhook.Name)
+		}
+	}
+
+	return events.SaveWebhooks(append(webhooks, webhook))
+}
+
+func webhookLs(_ *cobra.Command, _ []string) error {
+	webhooks, err := events.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	webhookTable := table.NewWriter()
+	webhookTable.SetOutputMirror(os.Stdout)
+	webhookTable.SetStyle(utils.GetDefaultTable())
+	webhookTable.AppendHeader(table.Row{"NAME", "URL", "EVENTS", "RETRIES"})
+
+	for _, webhook := range webhooks {
+		eventFilter := "all"
+		if len(webhook.Events) > 0 {
+			eventFilter = strings.Join(webhook.Events, ",")
+		}
+
+		webhookTable.AppendRow(table.Row{webhook.Name, webhook.URL, eventFilter, webhook.Retries})
+	}
+
+	webhookTable.Render()
+
+	return nil
+}
+
+func webhookRm(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	webhooks, err := events.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range arguments {
+		found := false
+
+		for i, webhook := range webhooks {
+			if webhook.Name == name {
+				webhooks = append(webhooks[:i], webhooks[i+1:]...)
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("webhook %s does not exist", name)
+		}
+
+		fmt.Println(name)
+	}
+
+	return events.SaveWebhooks(webhooks)
+}
+
+func webhookTest(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) < 1 {
+		return cmd.Help()
+	}
+
+	webhooks, err := events.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		if webhook.Name != arguments[0] {
+			continue
+		}
+
+		return webhook.Deliver(events.Event{
+			Time:       time.Now().Format(time.RFC3339Nano),
+			Type:       "container",
+			Action:     "test",
+			Name:       "lilipod",
+			Attributes: map[string]string{"webhook": webhook.Name},
+		})
+	}
+
+	return fmt.Errorf("webhook %s does not exist", arguments[0])
+}
+
+// webhookDeliver is run in background by the processes emitting events, to
+// deliver them without waiting for the webhooks.
+func webhookDeliver(cmd *cobra.Command, arguments []string) error {
+	if len(arguments) != 1 {
+		return cmd.Help()
+	}
+
+	var event events.Event
+
+	err := json.Unmarshal([]byte(arguments[0]), &event)
+	if err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+
+	events.DeliverWebhooks(event)
+
+	return nil
+}
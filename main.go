@@ -24,8 +24,10 @@ package main
 
 import (
 	_ "embed"
+	"errors"
 	"log"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
 
@@ -57,24 +59,57 @@ func newApp() *cobra.Command {
 	}
 
 	rootCmd.AddCommand(
+		cmd.NewAttachCommand(),
+		cmd.NewBuildCommand(),
+		cmd.NewCheckpointCommand(),
+		cmd.NewCommitCommand(),
+		cmd.NewContainerCommand(),
 		cmd.NewCpCommand(),
 		cmd.NewCreateCommand(),
+		cmd.NewDiffCommand(),
 		cmd.NewEnterCommand(),
+		cmd.NewEventsCommand(),
 		cmd.NewExecCommand(),
+		cmd.NewExportCommand(),
+		cmd.NewGenerateCommand(),
+		cmd.NewHealthcheckCommand(),
+		cmd.NewImageCommand(),
 		cmd.NewImagesCommand(),
+		cmd.NewImportCommand(),
 		cmd.NewInspectCommand(),
+		cmd.NewKillCommand(),
+		cmd.NewKubeCommand(),
+		cmd.NewLoadCommand(),
+		cmd.NewLoginCommand(),
+		cmd.NewLogoutCommand(),
 		cmd.NewLogsCommand(),
+		cmd.NewNetworkCommand(),
+		cmd.NewPauseCommand(),
+		cmd.NewPortCommand(),
 		cmd.NewPsCommand(),
 		cmd.NewPullCommand(),
+		cmd.NewPushCommand(),
 		cmd.NewRenameCommand(),
+		cmd.NewRestartCommand(),
+		cmd.NewRestoreCommand(),
 		cmd.NewRmCommand(),
 		cmd.NewRmiCommand(),
 		cmd.NewRootlessHelperCommand(),
 		cmd.NewRunCommand(),
+		cmd.NewSaveCommand(),
+		cmd.NewSearchCommand(),
+		cmd.NewShellCommand(),
+		cmd.NewSnapshotCommand(),
 		cmd.NewStartCommand(),
+		cmd.NewStatsCommand(),
 		cmd.NewStopCommand(),
+		cmd.NewSystemCommand(),
+		cmd.NewTopCommand(),
+		cmd.NewUnpauseCommand(),
 		cmd.NewUpdateCommand(),
 		cmd.NewVersionCommand(),
+		cmd.NewWaitCommand(),
+		cmd.NewWebhookCommand(),
 	)
 	rootCmd.PersistentFlags().
 		String("log-level", "", "log messages above specified level (debug, warn, warning, error)")
@@ -114,6 +149,18 @@ func main() {
 
 	err = app.Execute()
 	if err != nil {
+		// propagate the exit code of the executed commands as is, they
+		// already reported their errors
+		//nolint:errorlint
+		if _, ok := err.(*exec.ExitError); ok {
+			os.Exit(containerutils.GetExitCode(err))
+		}
+
+		var exitCodeErr *cmd.ExitCodeError
+		if errors.As(err, &exitCodeErr) {
+			os.Exit(exitCodeErr.Code)
+		}
+
 		log.Fatalf("%+v\n", err)
 	}
 }
@@ -0,0 +1,318 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// builder holds the state of a build: the container where the RUN
+// instructions are executed, and the config of the resulting image.
+type builder struct {
+	context  string
+	quiet    bool
+	rootfs   string
+	config   utils.Config
+	image    v1.ConfigFile
+	manifest v1.Manifest
+	// cmdSet is true when the Containerfile sets a CMD, else the one of
+	// the base image is reset by ENTRYPOINT.
+	cmdSet bool
+	// base is the state of the build container's filesystem before the steps.
+	base map[string]fileState
+}
+
+// GetContainerfile returns the path of the Containerfile in input build
+// context, falling back to a Dockerfile.
+func GetContainerfile(context string) (string, error) {
+	for _, file := range []string{"Containerfile", "Dockerfile"} {
+		path := filepath.Join(context, file)
+		if fileutils.Exist(path) {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no Containerfile or Dockerfile found in %s", context)
+}
+
+// Build will build the image described by input containerfile, using context
+// as the source of COPY instructions, and save it as tag in the ImageDir.
+// The base image is pulled if missing. RUN instructions are executed in a
+// temporary container, and all the changes to its filesystem are saved in a
+// single new layer on top of the base image ones.
+// If quiet is specified, only the output of the RUN instructions is shown.
+func Build(context string, containerfile string, tag string, quiet bool) (string, error) {
+	ref, err := name.ParseReference(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag %s: %w", tag, err)
+	}
+
+	tag = ref.Name()
+
+	file, err := os.Open(containerfile)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	instructions, err := Parse(file)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", containerfile, err)
+	}
+
+	build := &builder{context: context, quiet: quiet}
+
+	for i, instruction := range instructions {
+		build.log("STEP %d/%d: %s", i+1, len(instructions), instruction.Original)
+
+		if i == 0 {
+			err = build.from(instruction)
+			if err != nil {
+				return "", err
+			}
+
+			defer func() { _ = containerutils.RemoveContainer(build.config.ID) }()
+
+			continue
+		}
+
+		err = build.apply(instruction)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", instruction.Line, err)
+		}
+
+		build.image.History = append(build.image.History, v1.History{
+			Created:    v1.Time{Time: time.Now()},
+			CreatedBy:  instruction.Original,
+			EmptyLayer: true,
+		})
+	}
+
+	build.log("COMMIT %s", tag)
+
+	err = build.commit(instructions, tag)
+	if err != nil {
+		return "", err
+	}
+
+	return imageutils.GetID(tag), nil
+}
+
+// log will print the progress of the build, unless quiet.
+func (b *builder) log(format string, args ...any) {
+	if !b.quiet {
+		fmt.Printf(format+"\n", args...)
+	}
+}
+
+// from will prepare the build container from the base image of input FROM
+// instruction.
+func (b *builder) from(instruction Instruction) error {
+	words, err := splitWords(strings.Join(instruction.Args, " "))
+	if err != nil || len(words) == 0 {
+		return fmt.Errorf("line %d: invalid FROM", instruction.Line)
+	}
+
+	base := words[0]
+	if base == "scratch" {
+		return fmt.Errorf("line %d: building FROM scratch is not supported", instruction.Line)
+	}
+
+	platform := instruction.Flags["platform"]
+
+	if !fileutils.Exist(imageutils.GetPath(base)) ||
+		(platform != "" && !imageutils.HasPlatform(base, platform)) {
+		_, err = imageutils.Pull(base, platform, b.quiet)
+		if err != nil {
+			return err
+		}
+
+		base = imageutils.GetName(base)
+	}
+
+	b.manifest, b.image, err = loadImage(base)
+	if err != nil {
+		return err
+	}
+
+	id, err := containerutils.NewID()
+	if err != nil {
+		return err
+	}
+
+	containerName := "lilipod-build-" + containerutils.ShortID(id)
+
+	// the rootfs is unpacked and entered with the ownership of the image, so
+	// that the changes can be saved as they are.
+	createConfig := utils.Config{
+		ID:         id,
+		Cgroup:     constants.Private,
+		Created:    time.Now().Format(time.RFC3339),
+		Hostname:   containerName,
+		Image:      base,
+		Ipc:        constants.Private,
+		Names:      containerName,
+		Network:    constants.Host,
+		Pid:        constants.Private,
+		Time:       constants.Private,
+		Userns:     constants.Private,
+		Workdir:    "/",
+		Stopsignal: "SIGTERM",
+		Runtime:    containerutils.RuntimeBuiltin,
+		// entry point related
+		Entrypoint: []string{"/bin/sh"},
+	}
+
+	err = containerutils.CreateRootfs(base, containerName, createConfig, "", "")
+	if err != nil {
+		return err
+	}
+
+	b.config, err = utils.LoadConfig(filepath.Join(containerutils.GetDir(id), "config"))
+	if err != nil {
+		return err
+	}
+
+	b.rootfs = containerutils.GetRootfsDir(id)
+
+	// this is what the changes of the steps are compared to
+	b.base, err = getFileStates(b.rootfs)
+
+	return err
+}
+
+// apply will execute input instruction.
+func (b *builder) apply(instruction Instruction) error {
+	switch instruction.Command {
+	case "FROM":
+		return fmt.Errorf("multi-stage builds are not supported")
+	case "RUN":
+		return b.run(instruction)
+	case "COPY":
+		return b.copy(instruction)
+	case "ENV":
+		values, err := parseKeyValues(instruction)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			b.setEnv(value[0], value[1])
+		}
+	case "LABEL":
+		values, err := parseKeyValues(instruction)
+		if err != nil {
+			return err
+		}
+
+		if b.image.Config.Labels == nil {
+			b.image.Config.Labels = map[string]string{}
+		}
+
+		for _, value := range values {
+			b.image.Config.Labels[value[0]] = value[1]
+		}
+	case "WORKDIR":
+		workdir := b.resolvePath(strings.Join(instruction.Args, " "))
+
+		path, err := fileutils.SecurePath(b.rootfs, workdir)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(path, 0o755)
+		if err != nil {
+			return err
+		}
+
+		b.image.Config.WorkingDir = workdir
+	case "ENTRYPOINT":
+		b.image.Config.Entrypoint = getCommand(instruction)
+
+		if !b.cmdSet {
+			b.image.Config.Cmd = nil
+		}
+	case "CMD":
+		b.image.Config.Cmd = getCommand(instruction)
+		b.cmdSet = true
+	}
+
+	return nil
+}
+
+// run will execute input RUN instruction in the build container.
+func (b *builder) run(instruction Instruction) error {
+	config := b.config
+	config.Entrypoint = getCommand(instruction)
+	config.Env = append([]string{}, b.image.Config.Env...)
+	config.Env = append(config.Env, "HOSTNAME="+config.Hostname, "TERM=xterm")
+
+	config.Workdir = b.image.Config.WorkingDir
+	if config.Workdir == "" {
+		config.Workdir = "/"
+	}
+
+	logging.LogDebug("running %v in %s", config.Entrypoint, config.Names)
+
+	return containerutils.Start(procutils.Streams{Stdout: true, Stderr: true}, false, config)
+}
+
+// setEnv will set input variable in the image's environment.
+func (b *builder) setEnv(key string, value string) {
+	for i, env := range b.image.Config.Env {
+		if strings.HasPrefix(env, key+"=") {
+			b.image.Config.Env[i] = key + "=" + value
+
+			return
+		}
+	}
+
+	b.image.Config.Env = append(b.image.Config.Env, key+"="+value)
+}
+
+// resolvePath returns input path of the image, relative to the working directory.
+func (b *builder) resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+
+	workdir := b.image.Config.WorkingDir
+	if workdir == "" {
+		workdir = "/"
+	}
+
+	return filepath.Join(workdir, path)
+}
+
+// commit will save the changes of the build container as a new image on top
+// of the base one, with input tag.
+func (b *builder) commit(instructions []Instruction, tag string) error {
+	layerPath := filepath.Join(containerutils.GetDir(b.config.ID), "layer.tar.gz")
+
+	layer, err := writeDiff(b.rootfs, b.base, layerPath, nil)
+	if err != nil {
+		return err
+	}
+
+	history := v1.History{
+		CreatedBy: "lilipod build",
+		Comment:   fmt.Sprintf("changes of %d instructions", len(instructions)-1),
+	}
+
+	return saveImage(b.config.Image, b.manifest, b.image, layerPath, layer, history, tag)
+}
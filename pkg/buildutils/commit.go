@@ -0,0 +1,239 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sys/unix"
+)
+
+// opaqueXattrs are the xattrs used by overlay to mark a directory as
+// replacing the lower ones, for privileged and unprivileged mounts.
+var opaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// Commit will save the changes to the filesystem of input container as a new
+// image with input tag, on top of the layers of the image it was created from.
+// The command and labels of the container are kept in the image config.
+// If squash is specified, all the layers are flattened in a single one.
+func Commit(container string, tag string, author string, message string, squash bool) (string, error) {
+	ref, err := name.ParseReference(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag %s: %w", tag, err)
+	}
+
+	tag = ref.Name()
+
+	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(container), "config"))
+	if err != nil {
+		return "", err
+	}
+
+	// the changes are computed against the image, it must be the same one
+	digest, err := imageutils.GetDigest(config.Image)
+	if err != nil {
+		return "", fmt.Errorf("image %s of container %s not found: %w", config.Image, container, err)
+	}
+
+	if config.ImageDigest != "" && digest != config.ImageDigest {
+		return "", fmt.Errorf("image %s changed since container %s was created", config.Image, container)
+	}
+
+	manifest, image, err := loadImage(config.Image)
+	if err != nil {
+		return "", err
+	}
+
+	if containerutils.IsRunning(container) {
+		logging.LogWarning("container %s is running, the image could be inconsistent", container)
+	}
+
+	// files of keep-id containers are owned by the ids mapped on the host
+	var owner ownerMapper
+	if config.Userns == constants.KeepID && os.Getenv("ROOTFUL") != constants.TrueString {
+		owner = getKeepIDOwner(config.Uidmap, config.Gidmap)
+	}
+
+	layerPath := filepath.Join(containerutils.GetDir(config.ID), "commit.tar.gz")
+
+	defer func() { _ = os.Remove(layerPath) }()
+
+	var layer *layerInfo
+
+	if config.StorageDriver == imageutils.StorageDriverErofs {
+		layer, err = writeUpperDiff(filepath.Join(containerutils.GetDir(config.ID), "diff"), layerPath, owner)
+	} else {
+		layer, err = writeContainerDiff(config, layerPath, owner)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(config.Entrypoint) > 0 {
+		image.Config.Cmd = config.Entrypoint
+	}
+
+	for key, value := range config.Labels {
+		if image.Config.Labels == nil {
+			image.Config.Labels = map[string]string{}
+		}
+
+		image.Config.Labels[key] = value
+	}
+
+	if author != "" {
+		image.Author = author
+	}
+
+	history := v1.History{
+		Author:    author,
+		CreatedBy: "lilipod commit " + config.Names,
+		Comment:   message,
+	}
+
+	err = saveImage(config.Image, manifest, image, layerPath, layer, history, tag)
+	if err != nil {
+		return "", err
+	}
+
+	if squash {
+		return Squash(tag, tag)
+	}
+
+	return imageutils.GetID(tag), nil
+}
+
+// writeContainerDiff will write to path a layer with the changes of the rootfs
+// of input container, compared to its image.
+func writeContainerDiff(config utils.Config, path string, owner ownerMapper) (*layerInfo, error) {
+	base, err := getPristineStates(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return writeDiff(containerutils.GetRootfsDir(config.ID), base, path, owner)
+}
+
+// writeUpperDiff will write to path a layer with the content of input overlay
+// upper dir, converting its whiteouts to the ones of OCI layers.
+func writeUpperDiff(upper string, path string, owner ownerMapper) (*layerInfo, error) {
+	layer, err := newLayerWriter(path, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(upper, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(upper, file)
+		if err != nil || relative == "." {
+			return err
+		}
+
+		if skippedPaths[relative] {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		// deleted files are character devices with 0/0 device number
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok && info.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0 {
+			return layer.addWhiteout(relative)
+		}
+
+		err = layer.addFile(file, relative)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() && isOpaque(file) {
+			return layer.addMarker(filepath.Join(relative, ".wh..wh..opq"))
+		}
+
+		return nil
+	})
+
+	digest, diffID, closeErr := layer.close()
+	if err != nil {
+		return nil, err
+	}
+
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	if !layer.written {
+		return nil, os.Remove(path)
+	}
+
+	return &layerInfo{Digest: digest, DiffID: diffID, Size: layer.compressed}, nil
+}
+
+// isOpaque returns whether input directory of an overlay upper dir hides
+// the content of the lower ones.
+func isOpaque(path string) bool {
+	value := make([]byte, 1)
+
+	for _, xattr := range opaqueXattrs {
+		size, err := unix.Lgetxattr(path, xattr, value)
+		if err == nil && size == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getKeepIDOwner returns the ownerMapper reverting the keep-id mappings of
+// input uid and gid maps, in the form of id:start:size.
+// The user is mapped to itself, ids lower than it are shifted by one from
+// the ones on the host, and root is the user on the host.
+func getKeepIDOwner(uidMap string, gidMap string) ownerMapper {
+	uid, uidErr := strconv.Atoi(strings.Split(uidMap, ":")[0])
+	gid, gidErr := strconv.Atoi(strings.Split(gidMap, ":")[0])
+
+	if uidErr != nil || gidErr != nil {
+		logging.LogWarning("cannot parse keep-id maps %s %s, keeping ownership as is", uidMap, gidMap)
+
+		return nil
+	}
+
+	revert := func(id int, user int) int {
+		switch {
+		case id == 0:
+			return user
+		case id <= user:
+			return id - 1
+		default:
+			return id
+		}
+	}
+
+	return func(fileUID int, fileGID int) (int, int) {
+		return revert(fileUID, uid), revert(fileGID, gid)
+	}
+}
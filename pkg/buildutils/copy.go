@@ -0,0 +1,241 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+)
+
+// copy will execute input COPY instruction, copying files from the build
+// context in the build container.
+func (b *builder) copy(instruction Instruction) error {
+	if _, found := instruction.Flags["from"]; found {
+		return fmt.Errorf("COPY --from is not supported")
+	}
+
+	args := instruction.Args
+	if !instruction.JSON {
+		var err error
+
+		args, err = splitWords(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("COPY requires at least a source and a destination")
+	}
+
+	uid, gid, err := b.getOwner(instruction.Flags["chown"])
+	if err != nil {
+		return err
+	}
+
+	dest := b.resolvePath(args[len(args)-1])
+	destIsDir := strings.HasSuffix(args[len(args)-1], "/")
+
+	sources := []string{}
+
+	for _, source := range args[:len(args)-1] {
+		// sources cannot be outside of the context
+		matches, err := filepath.Glob(filepath.Join(b.context, filepath.Clean("/"+source)))
+		if err != nil {
+			return err
+		}
+
+		if len(matches) == 0 {
+			return fmt.Errorf("%s not found in build context", source)
+		}
+
+		sources = append(sources, matches...)
+	}
+
+	if len(sources) > 1 {
+		destIsDir = true
+	}
+
+	for _, source := range sources {
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+
+		// directories have their content copied, not the directory itself
+		target := dest
+		if !info.IsDir() && destIsDir {
+			target = filepath.Join(dest, filepath.Base(source))
+		}
+
+		err = b.copyTree(source, target, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyTree will copy the file or directory in source of the host to target
+// of the build container, owned by uid and gid.
+func (b *builder) copyTree(source string, target string, uid int, gid int) error {
+	parent, err := fileutils.SecurePath(b.rootfs, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(parent, 0o755)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		dest, err := fileutils.SecurePath(b.rootfs, filepath.Join(target, relative))
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			err = os.MkdirAll(dest, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+
+			err = os.Chmod(dest, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			_ = os.Remove(dest)
+
+			err = os.Symlink(link, dest)
+			if err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			err = copyFile(path, dest, info.Mode().Perm())
+		default:
+			// devices, sockets and pipes are not copied
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(dest, uid, gid)
+	})
+}
+
+// copyFile will copy the content of source in dest, with input mode.
+func copyFile(source string, dest string, mode fs.FileMode) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = input.Close() }()
+
+	output, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = output.Close() }()
+
+	_, err = io.Copy(output, input)
+	if err != nil {
+		return err
+	}
+
+	// the mode of created files is affected by the umask
+	return output.Chmod(mode)
+}
+
+// getOwner returns the uid and gid of input COPY --chown value, in the form of
+// user[:group], by name or id. Files are owned by root by default.
+func (b *builder) getOwner(chown string) (int, int, error) {
+	if chown == "" {
+		return 0, 0, nil
+	}
+
+	user, group, hasGroup := strings.Cut(chown, ":")
+
+	uid, err := strconv.Atoi(user)
+	if err != nil {
+		entry, err := containerutils.GetPasswdEntry(b.config.ID, user)
+		if err != nil {
+			return -1, -1, err
+		}
+
+		uid, err = strconv.Atoi(entry.UID)
+		if err != nil {
+			return -1, -1, err
+		}
+
+		// like docker, the group defaults to the user's primary one
+		if !hasGroup {
+			group = entry.GID
+		}
+	} else if !hasGroup {
+		group = user
+	}
+
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		gid, err = b.lookupGroup(group)
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// lookupGroup returns the gid of input group name in the build container.
+func (b *builder) lookupGroup(name string) (int, error) {
+	groupFile, err := fileutils.ReadFile(filepath.Join(b.rootfs, "etc", "group"))
+	if err != nil {
+		return -1, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.Trim(groupFile, "\x00")))
+	for scanner.Scan() {
+		// Line has a structure:
+		//    name:password:gid:members
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[0] == name {
+			return strconv.Atoi(fields[2])
+		}
+	}
+
+	return -1, fmt.Errorf("group %s not found", name)
+}
@@ -0,0 +1,247 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Kinds of changes of a file in a container, compared to its image.
+const (
+	ChangeAdded   = "A"
+	ChangeChanged = "C"
+	ChangeDeleted = "D"
+)
+
+// Change is a file added, changed or deleted in a container.
+type Change struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// getPristinePath returns the path of the manifest of the files of the image
+// of input container, as they were when it was created.
+func getPristinePath(config utils.Config) string {
+	return filepath.Join(containerutils.ContainerDir, config.ID, "pristine.json.gz")
+}
+
+// getPristineStates returns the state of the files of the image of input
+// container, to compare its rootfs against.
+// They are computed from a fresh copy of the image the first time, and saved
+// in a manifest, so the image is not needed anymore after that.
+func getPristineStates(config utils.Config) (map[string]fileState, error) {
+	states := map[string]fileState{}
+
+	file, err := os.Open(getPristinePath(config))
+	if err == nil {
+		defer func() { _ = file.Close() }()
+
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.NewDecoder(reader).Decode(&states)
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", getPristinePath(config), err)
+		}
+
+		return states, nil
+	}
+
+	if config.ImageRemoved || !fileutils.Exist(imageutils.GetPath(config.Image)) {
+		return nil, fmt.Errorf("image %s of container %s is not available anymore", config.Image, config.Names)
+	}
+
+	digest, err := imageutils.GetDigest(config.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ImageDigest != "" && digest != config.ImageDigest {
+		return nil, fmt.Errorf("image %s changed since container %s was created", config.Image, config.Names)
+	}
+
+	baseDir := filepath.Join(containerutils.ContainerDir, config.ID, "pristine-base")
+
+	// always cleanup before and after
+	_ = os.RemoveAll(baseDir)
+
+	defer func() { _ = os.RemoveAll(baseDir) }()
+
+	err = os.MkdirAll(baseDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.LogDebug("unpacking image %s in %s", config.Image, baseDir)
+
+	// unpacked like the container's rootfs, so that unchanged files are the same
+	err = imageutils.Unpack(config.Image, baseDir, config.Userns)
+	if err != nil {
+		return nil, err
+	}
+
+	states, err = getFileStates(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = savePristineStates(config, states)
+	if err != nil {
+		logging.LogWarning("cannot save manifest of container %s: %v", config.Names, err)
+	}
+
+	return states, nil
+}
+
+// savePristineStates will save the manifest of input states for input container.
+func savePristineStates(config utils.Config, states map[string]fileState) error {
+	path := getPristinePath(config)
+
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(file)
+
+	err = json.NewEncoder(writer).Encode(states)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path + ".tmp")
+
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// Diff returns the files added, changed and deleted in input container,
+// compared to its image, sorted by path.
+// The content of deleted directories is not reported, only the directories.
+func Diff(container string) ([]Change, error) {
+	config, err := utils.LoadConfig(filepath.Join(containerutils.GetDir(container), "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := getPristineStates(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.StorageDriver == imageutils.StorageDriverErofs {
+		return getUpperChanges(filepath.Join(containerutils.ContainerDir, config.ID, "diff"), base)
+	}
+
+	current, err := getFileStates(filepath.Join(containerutils.ContainerDir, config.ID, "rootfs"))
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+
+	for name, state := range current {
+		previous, found := base[name]
+
+		switch {
+		case !found:
+			changes = append(changes, Change{Kind: ChangeAdded, Path: "/" + name})
+		case previous != state:
+			changes = append(changes, Change{Kind: ChangeChanged, Path: "/" + name})
+		}
+	}
+
+	deleted := []string{}
+
+	for name := range base {
+		if _, found := current[name]; !found {
+			deleted = append(deleted, name)
+		}
+	}
+
+	sort.Strings(deleted)
+
+	for i, name := range deleted {
+		if i > 0 && isParent(deleted[:i], name) {
+			continue
+		}
+
+		changes = append(changes, Change{Kind: ChangeDeleted, Path: "/" + name})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// getUpperChanges returns the changes in input overlay upper dir, compared
+// to the base states of the lower one. Directories copied up for their
+// content are reported only if their own metadata changed.
+func getUpperChanges(upper string, base map[string]fileState) ([]Change, error) {
+	current, err := getFileStates(upper)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+
+	for name, state := range current {
+		previous, found := base[name]
+
+		switch {
+		// deleted files are character devices with 0/0 device number
+		case state.Mode&os.ModeCharDevice != 0 && isWhiteout(filepath.Join(upper, name)):
+			if found {
+				changes = append(changes, Change{Kind: ChangeDeleted, Path: "/" + name})
+			}
+		case !found:
+			changes = append(changes, Change{Kind: ChangeAdded, Path: "/" + name})
+		case previous != state:
+			changes = append(changes, Change{Kind: ChangeChanged, Path: "/" + name})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+// isWhiteout returns whether input file of an overlay upper dir marks a
+// deleted file.
+func isWhiteout(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && info.Mode()&fs.ModeCharDevice != 0 && stat.Rdev == 0
+}
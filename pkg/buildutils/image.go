@@ -0,0 +1,172 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// loadImage returns the manifest and config of input stored image.
+func loadImage(image string) (v1.Manifest, v1.ConfigFile, error) {
+	var manifest v1.Manifest
+
+	var config v1.ConfigFile
+
+	manifestFile, err := fileutils.ReadFile(filepath.Join(imageutils.GetPath(image), "manifest.json"))
+	if err != nil {
+		return manifest, config, err
+	}
+
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return manifest, config, err
+	}
+
+	configFile, err := fileutils.ReadFile(filepath.Join(imageutils.GetPath(image), "config.json"))
+	if err != nil {
+		return manifest, config, err
+	}
+
+	err = json.Unmarshal(configFile, &config)
+
+	return manifest, config, err
+}
+
+// saveImage will save a new image with input tag in the ImageDir, made of
+// input manifest and config of the base image, with the layer in layerPath
+// on top if not nil. The history entry describes the new layer.
+// Base is empty for images without a base, eg: imported ones.
+func saveImage(
+	base string,
+	manifest v1.Manifest,
+	image v1.ConfigFile,
+	layerPath string,
+	layer *layerInfo,
+	history v1.History,
+	tag string,
+) error {
+	targetDIR := imageutils.GetPath(tag)
+
+	err := os.MkdirAll(targetDIR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// base layers are shared in the BlobDir, like pulled ones
+	if base != "" {
+		err = imageutils.MigrateLayers(base)
+		if err != nil {
+			return err
+		}
+	}
+
+	layerType := types.OCILayer
+	configType := types.OCIConfigJSON
+
+	// old manifests have no media type, the layers tell their format
+	if manifest.MediaType == "" {
+		manifest.MediaType = types.OCIManifestSchema1
+
+		if len(manifest.Layers) > 0 && manifest.Layers[0].MediaType == types.DockerLayer {
+			manifest.MediaType = types.DockerManifestSchema2
+		}
+	}
+
+	if manifest.MediaType == types.DockerManifestSchema2 {
+		layerType = types.DockerLayer
+		configType = types.DockerConfigJSON
+	}
+
+	if layer != nil {
+		digest, err := v1.NewHash(layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		diffID, err := v1.NewHash(layer.DiffID)
+		if err != nil {
+			return err
+		}
+
+		err = imageutils.StoreLayer(layerPath, digest)
+		if err != nil {
+			return err
+		}
+
+		manifest.Layers = append(manifest.Layers, v1.Descriptor{
+			MediaType: layerType,
+			Size:      layer.Size,
+			Digest:    digest,
+		})
+
+		image.RootFS.DiffIDs = append(image.RootFS.DiffIDs, diffID)
+		history.Created = v1.Time{Time: time.Now()}
+		image.History = append(image.History, history)
+	}
+
+	image.Created = v1.Time{Time: time.Now()}
+
+	rawConfig, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+
+	configDigest, configSize, err := v1.SHA256(strings.NewReader(string(rawConfig)))
+	if err != nil {
+		return err
+	}
+
+	manifest.SchemaVersion = 2
+	manifest.Config = v1.Descriptor{
+		MediaType: configType,
+		Size:      configSize,
+		Digest:    configDigest,
+	}
+
+	rawManifest, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	// remove the files of previous builds with the same tag
+	fileList, err := os.ReadDir(targetDIR)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range fileList {
+		logging.LogDebug("found unwanted file %s, removing", file.Name())
+
+		err = os.RemoveAll(filepath.Join(targetDIR, file.Name()))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = fileutils.WriteFile(filepath.Join(targetDIR, "manifest.json"), rawManifest, 0o644)
+	if err != nil {
+		return err
+	}
+
+	err = fileutils.WriteFile(filepath.Join(targetDIR, "config.json"), rawConfig, 0o644)
+	if err != nil {
+		return err
+	}
+
+	err = fileutils.WriteFile(filepath.Join(targetDIR, "image_name"), []byte(tag), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return imageutils.SyncLayout()
+}
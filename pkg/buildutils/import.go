@@ -0,0 +1,189 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// importChanges are the instructions that can be applied to imported images.
+var importChanges = map[string]bool{
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"ENV":        true,
+	"LABEL":      true,
+	"WORKDIR":    true,
+}
+
+// Import will save the rootfs tarball in input, plain or gzipped, as a new
+// single layer image with input tag. The changes are Containerfile instructions
+// applied to its config, eg: ENV PATH=/bin.
+// Input can be "-" to read the tarball from stdin.
+func Import(input string, tag string, changes []string, message string) (string, error) {
+	ref, err := name.ParseReference(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag %s: %w", tag, err)
+	}
+
+	tag = ref.Name()
+
+	platform, err := v1.ParsePlatform(imageutils.GetHostPlatform())
+	if err != nil {
+		return "", err
+	}
+
+	build := &builder{}
+	build.image.OS = platform.OS
+	build.image.Architecture = platform.Architecture
+	build.image.RootFS.Type = "layers"
+
+	for i, change := range changes {
+		instruction, err := parseInstruction(change, i+1)
+		if err != nil || !importChanges[instruction.Command] {
+			return "", fmt.Errorf("invalid change %q, valid instructions are: CMD, ENTRYPOINT, ENV, LABEL, WORKDIR", change)
+		}
+
+		// there is no rootfs to create it in
+		if instruction.Command == "WORKDIR" {
+			build.image.Config.WorkingDir = build.resolvePath(instruction.Args[0])
+
+			continue
+		}
+
+		err = build.apply(instruction)
+		if err != nil {
+			return "", fmt.Errorf("invalid change %q: %w", change, err)
+		}
+	}
+
+	file := os.Stdin
+
+	if input != "-" {
+		file, err = os.Open(input)
+		if err != nil {
+			return "", err
+		}
+
+		defer func() { _ = file.Close() }()
+	}
+
+	// hidden files are skipped when listing the images
+	layerPath := filepath.Join(imageutils.ImageDir, fmt.Sprintf(".import-%d.tar.gz", os.Getpid()))
+
+	defer func() { _ = os.Remove(layerPath) }()
+
+	layer, err := writeTarLayer(file, layerPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot import %s: %w", input, err)
+	}
+
+	history := v1.History{
+		CreatedBy: "lilipod import " + input,
+		Comment:   message,
+	}
+
+	manifest := v1.Manifest{MediaType: types.OCIManifestSchema1}
+
+	err = saveImage("", manifest, build.image, layerPath, layer, history, tag)
+	if err != nil {
+		return "", err
+	}
+
+	return imageutils.GetID(tag), nil
+}
+
+// writeTarLayer will write to path a gzipped layer with the content of the
+// tarball in input, that can be gzipped too. The tarball is checked to be
+// valid while it is copied.
+func writeTarLayer(input io.Reader, path string) (*layerInfo, error) {
+	reader := bufio.NewReader(input)
+
+	var source io.Reader = reader
+
+	magic, err := reader.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = gzipReader.Close() }()
+
+		source = gzipReader
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	digest := sha256.New()
+	diffID := sha256.New()
+
+	var compressed int64
+
+	gzipWriter := gzip.NewWriter(countingWriter{
+		writer: io.MultiWriter(file, digest),
+		count:  &compressed,
+	})
+
+	tee := io.TeeReader(source, io.MultiWriter(gzipWriter, diffID))
+
+	tarReader := tar.NewReader(tee)
+
+	entries := 0
+
+	for {
+		_, err = tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.Copy(io.Discard, tarReader)
+		if err != nil {
+			return nil, err
+		}
+
+		entries++
+	}
+
+	if entries == 0 {
+		return nil, fmt.Errorf("empty tarball")
+	}
+
+	// keep the padding after the end of the archive, it is part of the diffID
+	_, err = io.Copy(io.Discard, tee)
+	if err != nil {
+		return nil, err
+	}
+
+	err = gzipWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return &layerInfo{
+		Digest: fmt.Sprintf("sha256:%x", digest.Sum(nil)),
+		DiffID: fmt.Sprintf("sha256:%x", diffID.Sum(nil)),
+		Size:   compressed,
+	}, nil
+}
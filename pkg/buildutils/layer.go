@@ -0,0 +1,358 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+)
+
+// fileState is the metadata we use to detect changes of a file in the rootfs.
+type fileState struct {
+	Mode  fs.FileMode
+	UID   uint32
+	GID   uint32
+	Size  int64
+	Mtime int64
+	Link  string
+}
+
+// skippedPaths are the paths of the rootfs managed by lilipod while running
+// the build steps, their changes are not part of the image.
+var skippedPaths = map[string]bool{
+	"dev":                      true,
+	"etc/localtime":            true,
+	"proc":                     true,
+	"run/.containerenv":        true,
+	"sys":                      true,
+	constants.PtyAgentPath[1:]: true,
+}
+
+// getFileStates returns the state of all the files in input rootfs, by
+// their path relative to it.
+func getFileStates(rootfs string) (map[string]fileState, error) {
+	result := map[string]fileState{}
+
+	err := filepath.WalkDir(rootfs, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(rootfs, path)
+		if err != nil || relative == "." {
+			return err
+		}
+
+		if skippedPaths[relative] {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		state := fileState{
+			Mode:  info.Mode(),
+			Size:  info.Size(),
+			Mtime: info.ModTime().UnixNano(),
+		}
+
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok {
+			state.UID = stat.Uid
+			state.GID = stat.Gid
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			state.Link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		// directories change size and mtime when their content does, only
+		// their own metadata matters.
+		if info.IsDir() {
+			state.Size = 0
+			state.Mtime = 0
+		}
+
+		result[relative] = state
+
+		return nil
+	})
+
+	return result, err
+}
+
+// ownerMapper translates the ownership of files on disk to the one inside
+// the image.
+type ownerMapper func(uid int, gid int) (int, int)
+
+// layerWriter writes a gzipped tar layer, and computes both the digest of
+// the compressed file and the diffID of the uncompressed tar.
+type layerWriter struct {
+	owner      ownerMapper
+	file       *os.File
+	gzip       *gzip.Writer
+	tar        *tar.Writer
+	digest     hash.Hash
+	diffID     hash.Hash
+	hardlinks  map[uint64]string
+	written    bool
+	compressed int64
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	writer io.Writer
+	count  *int64
+}
+
+func (c countingWriter) Write(data []byte) (int, error) {
+	n, err := c.writer.Write(data)
+	*c.count += int64(n)
+
+	return n, err
+}
+
+func newLayerWriter(path string, owner ownerMapper) (*layerWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &layerWriter{
+		owner:     owner,
+		file:      file,
+		digest:    sha256.New(),
+		diffID:    sha256.New(),
+		hardlinks: map[uint64]string{},
+	}
+
+	layer.gzip = gzip.NewWriter(countingWriter{
+		writer: io.MultiWriter(file, layer.digest),
+		count:  &layer.compressed,
+	})
+	layer.tar = tar.NewWriter(io.MultiWriter(layer.gzip, layer.diffID))
+
+	return layer, nil
+}
+
+// addFile will add the file in path of the rootfs to the layer, with input name.
+func (l *layerWriter) addFile(path string, name string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		// sockets and the like cannot be part of a layer
+		return nil //nolint: nilerr
+	}
+
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	header.Format = tar.FormatPAX
+	header.Uname = ""
+	header.Gname = ""
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok {
+		header.Uid = int(stat.Uid)
+		header.Gid = int(stat.Gid)
+
+		if l.owner != nil {
+			header.Uid, header.Gid = l.owner(header.Uid, header.Gid)
+		}
+
+		// keep hardlinked files as such, and store their content only once
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			target, found := l.hardlinks[stat.Ino]
+			if found {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = target
+				header.Size = 0
+			} else {
+				l.hardlinks[stat.Ino] = name
+			}
+		}
+	}
+
+	l.written = true
+
+	err = l.tar.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	_, err = io.Copy(l.tar, file)
+
+	return err
+}
+
+// addWhiteout will mark the file with input name as deleted.
+func (l *layerWriter) addWhiteout(name string) error {
+	return l.addMarker(filepath.Join(filepath.Dir(name), ".wh."+filepath.Base(name)))
+}
+
+// addMarker will add an empty file with input name, as used by whiteouts.
+func (l *layerWriter) addMarker(name string) error {
+	l.written = true
+
+	return l.tar.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Format:   tar.FormatPAX,
+	})
+}
+
+// close will flush the layer, and return its digest and diffID.
+func (l *layerWriter) close() (string, string, error) {
+	err := l.tar.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = l.gzip.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = l.file.Close()
+	if err != nil {
+		return "", "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", l.digest.Sum(nil)), fmt.Sprintf("sha256:%x", l.diffID.Sum(nil)), nil
+}
+
+// layerInfo describes a written layer.
+type layerInfo struct {
+	Digest string
+	DiffID string
+	Size   int64
+}
+
+// writeDiff will write to path a layer with the changes of rootfs since
+// input states were taken. A nil layerInfo is returned if nothing changed.
+// If owner is not nil, it is used to translate the ownership of the files.
+func writeDiff(rootfs string, before map[string]fileState, path string, owner ownerMapper) (*layerInfo, error) {
+	after, err := getFileStates(rootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := newLayerWriter(path, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		previous, found := before[name]
+		if found && previous == after[name] {
+			continue
+		}
+
+		err = layer.addFile(filepath.Join(rootfs, name), name)
+		if err != nil {
+			_, _, _ = layer.close()
+
+			return nil, err
+		}
+	}
+
+	deleted := []string{}
+
+	for name := range before {
+		if _, found := after[name]; !found {
+			deleted = append(deleted, name)
+		}
+	}
+
+	sort.Strings(deleted)
+
+	for i, name := range deleted {
+		// removing a directory is enough for its content too
+		if i > 0 && isParent(deleted[:i], name) {
+			continue
+		}
+
+		err = layer.addWhiteout(name)
+		if err != nil {
+			_, _, _ = layer.close()
+
+			return nil, err
+		}
+	}
+
+	digest, diffID, err := layer.close()
+	if err != nil {
+		return nil, err
+	}
+
+	if !layer.written {
+		return nil, os.Remove(path)
+	}
+
+	return &layerInfo{Digest: digest, DiffID: diffID, Size: layer.compressed}, nil
+}
+
+// isParent returns whether any of the sorted paths is a parent of name.
+func isParent(paths []string, name string) bool {
+	for i := len(paths) - 1; i >= 0; i-- {
+		if strings.HasPrefix(name, paths[i]+"/") {
+			return true
+		}
+	}
+
+	return false
+}
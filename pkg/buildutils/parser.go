@@ -0,0 +1,255 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instruction is a parsed line of a Containerfile.
+type Instruction struct {
+	// Command is the uppercase instruction, eg: RUN.
+	Command string
+	// Args are the arguments of the instruction. For the exec form, eg:
+	// RUN ["a", "b"], these are the JSON array elements, else the whole
+	// string after the command.
+	Args []string
+	// JSON is true when the instruction uses the exec form.
+	JSON bool
+	// Flags are the --key=value options preceding the arguments, eg: COPY --chown.
+	Flags map[string]string
+	// Original is the instruction as written, used for the history.
+	Original string
+	Line     int
+}
+
+// supportedInstructions are the Containerfile instructions we can build.
+var supportedInstructions = map[string]bool{
+	"CMD":        true,
+	"COPY":       true,
+	"ENTRYPOINT": true,
+	"ENV":        true,
+	"FROM":       true,
+	"LABEL":      true,
+	"RUN":        true,
+	"WORKDIR":    true,
+}
+
+// Parse will read the instructions of the Containerfile in input.
+// Comments and empty lines are skipped, lines ending with a backslash are
+// joined with the next ones.
+func Parse(input io.Reader) ([]Instruction, error) {
+	result := []Instruction{}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	current := ""
+	start := 0
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+
+		// comments and empty lines are allowed between continued lines too
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if current == "" {
+			start = lineNumber
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimRight(strings.TrimSuffix(line, "\\"), " \t") + " "
+
+			continue
+		}
+
+		current += line
+
+		instruction, err := parseInstruction(current, start)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, instruction)
+		current = ""
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSpace(current) != "" {
+		instruction, err := parseInstruction(current, start)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, instruction)
+	}
+
+	if len(result) == 0 || result[0].Command != "FROM" {
+		return nil, fmt.Errorf("the first instruction must be FROM")
+	}
+
+	return result, nil
+}
+
+// parseInstruction will parse a single, already joined, instruction.
+func parseInstruction(line string, lineNumber int) (Instruction, error) {
+	command, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+
+	instruction := Instruction{
+		Command:  strings.ToUpper(command),
+		Flags:    map[string]string{},
+		Original: strings.TrimSpace(line),
+		Line:     lineNumber,
+	}
+
+	if !supportedInstructions[instruction.Command] {
+		return instruction, fmt.Errorf("line %d: unsupported instruction %s", lineNumber, command)
+	}
+
+	rest = strings.TrimSpace(rest)
+
+	// options like --chown=1000:1000 come before the arguments
+	for strings.HasPrefix(rest, "--") {
+		var option string
+
+		option, rest, _ = strings.Cut(rest, " ")
+		rest = strings.TrimSpace(rest)
+
+		key, value, _ := strings.Cut(strings.TrimPrefix(option, "--"), "=")
+		instruction.Flags[key] = value
+	}
+
+	if rest == "" {
+		return instruction, fmt.Errorf("line %d: %s requires at least one argument", lineNumber, instruction.Command)
+	}
+
+	if strings.HasPrefix(rest, "[") {
+		var args []string
+
+		err := json.Unmarshal([]byte(rest), &args)
+		if err == nil {
+			instruction.Args = args
+			instruction.JSON = true
+
+			return instruction, nil
+		}
+	}
+
+	instruction.Args = []string{rest}
+
+	return instruction, nil
+}
+
+// splitWords will split input on whitespace, honoring quotes and backslash
+// escapes, like a shell would do.
+func splitWords(input string) ([]string, error) {
+	result := []string{}
+
+	var word strings.Builder
+
+	quote := rune(0)
+	escaped := false
+	inWord := false
+
+	for _, char := range input {
+		switch {
+		case escaped:
+			word.WriteRune(char)
+
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				word.WriteRune(char)
+			}
+		case char == '"' || char == '\'':
+			quote = char
+			inWord = true
+		case char == ' ' || char == '\t':
+			if inWord {
+				result = append(result, word.String())
+				word.Reset()
+
+				inWord = false
+			}
+		default:
+			word.WriteRune(char)
+
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", input)
+	}
+
+	if inWord {
+		result = append(result, word.String())
+	}
+
+	return result, nil
+}
+
+// parseKeyValues will parse the arguments of ENV and LABEL, in the form of
+// key=value pairs, or the legacy single "key value" form.
+func parseKeyValues(instruction Instruction) ([][2]string, error) {
+	result := [][2]string{}
+
+	words, err := splitWords(strings.Join(instruction.Args, " "))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", instruction.Line, err)
+	}
+
+	if len(words) == 0 {
+		return nil, fmt.Errorf("line %d: %s requires at least one argument", instruction.Line, instruction.Command)
+	}
+
+	if !strings.Contains(words[0], "=") {
+		key, value, _ := strings.Cut(strings.Join(instruction.Args, " "), " ")
+
+		values, err := splitWords(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", instruction.Line, err)
+		}
+
+		return append(result, [2]string{key, strings.Join(values, " ")}), nil
+	}
+
+	for _, word := range words {
+		key, value, found := strings.Cut(word, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: invalid %s %s, expected key=value", instruction.Line, instruction.Command, word)
+		}
+
+		result = append(result, [2]string{key, value})
+	}
+
+	return result, nil
+}
+
+// getCommand returns the command to execute for RUN, CMD and ENTRYPOINT:
+// the exec form is used as is, the shell form is executed by /bin/sh -c.
+func getCommand(instruction Instruction) []string {
+	if instruction.JSON {
+		return instruction.Args
+	}
+
+	return []string{"/bin/sh", "-c", strings.Join(instruction.Args, " ")}
+}
@@ -0,0 +1,72 @@
+// Package buildutils contains helpers and utilities to build images from a
+// Containerfile.
+package buildutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Squash will save input stored image as a new image with input tag, with
+// all its layers flattened in a single one. The history is kept, marking the
+// previous steps as empty. Tag can be the image itself, to replace it.
+func Squash(image string, tag string) (string, error) {
+	ref, err := name.ParseReference(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid tag %s: %w", tag, err)
+	}
+
+	tag = ref.Name()
+
+	manifest, config, err := loadImage(image)
+	if err != nil {
+		return "", err
+	}
+
+	filesystem, err := imageutils.Extract(image)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = filesystem.Close() }()
+
+	// hidden files are skipped when listing the images
+	layerPath := filepath.Join(imageutils.ImageDir, fmt.Sprintf(".squash-%d.tar.gz", os.Getpid()))
+
+	defer func() { _ = os.Remove(layerPath) }()
+
+	layer, err := writeTarLayer(filesystem, layerPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot squash %s: %w", image, err)
+	}
+
+	history := v1.History{
+		CreatedBy: "lilipod squash",
+		Comment:   fmt.Sprintf("%d layers of %s", len(manifest.Layers), imageutils.GetName(image)),
+	}
+
+	manifest.Layers = nil
+	config.RootFS.DiffIDs = nil
+
+	for i := range config.History {
+		config.History[i].EmptyLayer = true
+	}
+
+	err = saveImage("", manifest, config, layerPath, layer, history, tag)
+	if err != nil {
+		return "", err
+	}
+
+	// the layers of a replaced image are not used anymore
+	err = imageutils.RemoveUnusedLayers()
+	if err != nil {
+		return "", err
+	}
+
+	return imageutils.GetID(tag), nil
+}
@@ -0,0 +1,582 @@
+// Package cgrouputils contains helpers and utilities to read and manage the
+// cgroups of running containers.
+package cgrouputils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"golang.org/x/sys/unix"
+)
+
+// CgroupRoot is the default mountpoint of the cgroup filesystem.
+const CgroupRoot = "/sys/fs/cgroup"
+
+// Stats is a point in time sample of the resources used by a cgroup.
+type Stats struct {
+	// CPUUsageUsec is the total CPU time consumed, in microseconds.
+	CPUUsageUsec uint64 `json:"cpu_usage_usec"`
+	// MemoryUsage is the current memory usage, in bytes.
+	MemoryUsage uint64 `json:"memory_usage"`
+	// MemoryLimit is the memory limit in bytes, 0 means unlimited.
+	MemoryLimit uint64 `json:"memory_limit"`
+	// Pids is the number of processes in the cgroup.
+	Pids uint64 `json:"pids"`
+	// BlockRead is the total data read from block devices, in bytes.
+	BlockRead uint64 `json:"block_read"`
+	// BlockWrite is the total data written to block devices, in bytes.
+	BlockWrite uint64 `json:"block_write"`
+}
+
+// GetCgroupPath returns the host path of the unified cgroup of input pid.
+func GetCgroupPath(pid int) (string, error) {
+	cgroupFile, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	// Line has a structure:
+	//    hierarchy-ID:controller-list:cgroup-path
+	// the unified hierarchy has ID 0 and an empty controller list.
+	for _, line := range strings.Split(string(cgroupFile), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			root := CgroupRoot
+			if GetVersion() == VersionHybrid {
+				root = filepath.Join(CgroupRoot, "unified")
+			}
+
+			return filepath.Join(root, strings.TrimPrefix(line, "0::")), nil
+		}
+	}
+
+	return "", fmt.Errorf("cannot find unified cgroup for pid %d", pid)
+}
+
+// ReadStats will read the resource usage of the cgroup in input path.
+// Controllers that are not enabled for the cgroup are reported as zero.
+func ReadStats(path string) (Stats, error) {
+	stats := Stats{}
+
+	if !fileutils.Exist(path) {
+		return stats, fmt.Errorf("cgroup %s does not exist", path)
+	}
+
+	cpuStat, err := readKeyValueFile(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		logging.LogDebug("cannot read cpu stats: %v", err)
+	}
+
+	stats.CPUUsageUsec = cpuStat["usage_usec"]
+	stats.MemoryUsage = readUintFile(filepath.Join(path, "memory.current"))
+	stats.MemoryLimit = readUintFile(filepath.Join(path, "memory.max"))
+	stats.Pids = readUintFile(filepath.Join(path, "pids.current"))
+	stats.BlockRead, stats.BlockWrite = readIOStat(filepath.Join(path, "io.stat"))
+
+	return stats, nil
+}
+
+// readIOStat will read the bytes read and written by a cgroup, summing the
+// ones of all the devices in input io.stat.
+func readIOStat(path string) (uint64, uint64) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logging.LogDebug("cannot read %s: %v", path, err)
+
+		return 0, 0
+	}
+
+	var read, write uint64
+
+	// Line has a structure:
+	//    MAJ:MIN rbytes=N wbytes=N rios=N wios=N dbytes=N dios=N
+	for _, line := range strings.Split(string(content), "\n") {
+		for _, field := range strings.Fields(line) {
+			key, value, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+
+			number, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			switch key {
+			case "rbytes":
+				read += number
+			case "wbytes":
+				write += number
+			}
+		}
+	}
+
+	return read, write
+}
+
+// readBlkioServiceBytes will read the bytes read and written by a cgroup,
+// summing the ones of all the devices in input blkio service bytes file.
+func readBlkioServiceBytes(path string) (uint64, uint64) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logging.LogDebug("cannot read %s: %v", path, err)
+
+		return 0, 0
+	}
+
+	var read, write uint64
+
+	// Line has a structure:
+	//    MAJ:MIN Operation N
+	// with a last "Total N" line.
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		number, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[1] {
+		case "Read":
+			read += number
+		case "Write":
+			write += number
+		}
+	}
+
+	return read, write
+}
+
+// readUintFile will read a cgroup file containing a single number.
+// Missing files and "max" values are returned as 0.
+func readUintFile(path string) uint64 {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		logging.LogDebug("cannot read %s: %v", path, err)
+
+		return 0
+	}
+
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// readKeyValueFile will read a cgroup file in the form of "key value" lines,
+// like cpu.stat or memory.stat.
+func readKeyValueFile(path string) (map[string]uint64, error) {
+	result := map[string]uint64{}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return result, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		result[fields[0]] = value
+	}
+
+	return result, nil
+}
+
+// GetHostMemory returns the total memory of the host in bytes, this is useful
+// as a reference for cgroups without a memory limit.
+func GetHostMemory() uint64 {
+	meminfo, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+
+	// Line has a structure:
+	//    MemTotal:       16281284 kB
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+
+			return value * 1024
+		}
+	}
+
+	return 0
+}
+
+// Versions of the cgroup hierarchy of the host.
+const (
+	// Version1 is the legacy hierarchy, with a mount for each controller.
+	Version1 = "v1"
+	// Version2 is the unified hierarchy.
+	Version2 = "v2"
+	// VersionHybrid is the legacy hierarchy, with the unified one mounted in
+	// CgroupRoot/unified without controllers.
+	VersionHybrid = "hybrid"
+)
+
+// GetVersion returns the version of the cgroup hierarchy mounted in CgroupRoot.
+func GetVersion() string {
+	var stat unix.Statfs_t
+
+	err := unix.Statfs(CgroupRoot, &stat)
+	if err == nil && stat.Type == unix.CGROUP2_SUPER_MAGIC {
+		return Version2
+	}
+
+	err = unix.Statfs(filepath.Join(CgroupRoot, "unified"), &stat)
+	if err == nil && stat.Type == unix.CGROUP2_SUPER_MAGIC {
+		return VersionHybrid
+	}
+
+	return Version1
+}
+
+// Hierarchy is a legacy cgroup hierarchy a process belongs to.
+type Hierarchy struct {
+	// Controllers are the controllers bound to the hierarchy, or the name of
+	// named hierarchies like name=systemd.
+	Controllers []string
+	// Path is the cgroup of the process in the hierarchy.
+	Path string
+}
+
+// GetMountpoint returns the directory of the hierarchy in CgroupRoot, named
+// after its controllers like cpu,cpuacct or systemd.
+func (h Hierarchy) GetMountpoint() string {
+	return filepath.Join(CgroupRoot, strings.TrimPrefix(strings.Join(h.Controllers, ","), "name="))
+}
+
+// GetHierarchies returns the legacy cgroup hierarchies of input pid.
+func GetHierarchies(pid int) ([]Hierarchy, error) {
+	cgroupFile, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Hierarchy{}
+
+	// Line has a structure:
+	//    hierarchy-ID:controller-list:cgroup-path
+	for _, line := range strings.Split(string(cgroupFile), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || fields[0] == "0" || fields[1] == "" {
+			continue
+		}
+
+		result = append(result, Hierarchy{
+			Controllers: strings.Split(fields[1], ","),
+			Path:        fields[2],
+		})
+	}
+
+	return result, nil
+}
+
+// getControllerPath returns the host path of the legacy cgroup of input pid,
+// for input controller.
+func getControllerPath(pid int, controller string) (string, error) {
+	hierarchies, err := GetHierarchies(pid)
+	if err != nil {
+		return "", err
+	}
+
+	for _, hierarchy := range hierarchies {
+		for _, name := range hierarchy.Controllers {
+			if name == controller {
+				return filepath.Join(hierarchy.GetMountpoint(), hierarchy.Path), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("cannot find %s cgroup for pid %d", controller, pid)
+}
+
+// GetStats returns a sample of the resources used by the cgroup of input pid,
+// using the legacy controllers if the unified hierarchy has none.
+func GetStats(pid int) (Stats, error) {
+	if GetVersion() == Version2 {
+		path, err := GetCgroupPath(pid)
+		if err != nil {
+			return Stats{}, err
+		}
+
+		logging.LogDebug("reading stats of %d from %s", pid, path)
+
+		return ReadStats(path)
+	}
+
+	return readStatsV1(pid)
+}
+
+// getFreezerPath returns the host path of the cgroup that freezes input pid,
+// the legacy freezer one if the unified hierarchy has none.
+func getFreezerPath(pid int) (string, error) {
+	if GetVersion() == Version2 {
+		return GetCgroupPath(pid)
+	}
+
+	return getControllerPath(pid, "freezer")
+}
+
+// GetFreezerProcesses returns the pids in the cgroup that SetFrozen would
+// freeze for input pid.
+func GetFreezerProcesses(pid int) ([]int, error) {
+	path, err := getFreezerPath(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return getProcesses(path)
+}
+
+// SetFrozen will freeze or thaw all the processes in the cgroup of input pid,
+// using the legacy freezer controller if the unified hierarchy has none.
+func SetFrozen(pid int, frozen bool) error {
+	path, err := getFreezerPath(pid)
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("setting frozen=%t for %d in %s", frozen, pid, path)
+
+	if GetVersion() == Version2 {
+		value := "0"
+		if frozen {
+			value = "1"
+		}
+
+		return os.WriteFile(filepath.Join(path, "cgroup.freeze"), []byte(value), 0o644)
+	}
+
+	value := "THAWED"
+	if frozen {
+		value = "FROZEN"
+	}
+
+	return os.WriteFile(filepath.Join(path, "freezer.state"), []byte(value), 0o644)
+}
+
+// readStatsV1 will read the resource usage of input pid from the legacy
+// controllers. Controllers that are not available are reported as zero.
+func readStatsV1(pid int) (Stats, error) {
+	stats := Stats{}
+
+	hierarchies, err := GetHierarchies(pid)
+	if err != nil {
+		return stats, err
+	}
+
+	if len(hierarchies) == 0 {
+		return stats, fmt.Errorf("cannot find cgroups for pid %d", pid)
+	}
+
+	path, err := getControllerPath(pid, "cpuacct")
+	if err == nil {
+		// cpuacct reports nanoseconds
+		stats.CPUUsageUsec = readUintFile(filepath.Join(path, "cpuacct.usage")) / 1000
+	}
+
+	path, err = getControllerPath(pid, "memory")
+	if err == nil {
+		stats.MemoryUsage = readUintFile(filepath.Join(path, "memory.usage_in_bytes"))
+		stats.MemoryLimit = readUintFile(filepath.Join(path, "memory.limit_in_bytes"))
+
+		// no limit is reported as the max value, rounded to the page size
+		if stats.MemoryLimit >= 1<<62 {
+			stats.MemoryLimit = 0
+		}
+	}
+
+	path, err = getControllerPath(pid, "pids")
+	if err == nil {
+		stats.Pids = readUintFile(filepath.Join(path, "pids.current"))
+	}
+
+	path, err = getControllerPath(pid, "blkio")
+	if err == nil {
+		stats.BlockRead, stats.BlockWrite = readBlkioServiceBytes(
+			filepath.Join(path, "blkio.throttle.io_service_bytes_recursive"))
+	}
+
+	return stats, nil
+}
+
+// GetControllers returns the controllers available in the cgroup in input path.
+func GetControllers(path string) []string {
+	content, err := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		logging.LogDebug("cannot read controllers of %s: %v", path, err)
+
+		return []string{}
+	}
+
+	return strings.Fields(string(content))
+}
+
+// IsDelegated returns if the cgroup in input path is delegated to the current
+// user, so that it can create sub-cgroups and move processes in them.
+func IsDelegated(path string) bool {
+	return unix.Access(path, unix.W_OK) == nil &&
+		unix.Access(filepath.Join(path, "cgroup.procs"), unix.W_OK) == nil
+}
+
+// cpuPeriod is the period of the CPU bandwidth limits, in microseconds.
+const cpuPeriod = 100000
+
+// Resources are the limits of a cgroup, zero values mean unlimited.
+type Resources struct {
+	// Memory is the memory limit, in bytes.
+	Memory int64
+	// CPUs is the number of CPUs the cgroup can use, eg: 1.5.
+	CPUs float64
+	// Pids is the maximum number of processes in the cgroup.
+	Pids int64
+}
+
+// getProcesses returns the pids in the cgroup in input path.
+func getProcesses(path string) ([]int, error) {
+	content, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []int{}
+
+	for _, field := range strings.Fields(string(content)) {
+		process, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, process)
+	}
+
+	return result, nil
+}
+
+// resourceFile is a cgroup file setting a limit, with its value.
+type resourceFile struct {
+	controller string
+	name       string
+	value      string
+	// unlimited is whether the value removes the limit.
+	unlimited bool
+}
+
+// getResourceFiles returns the files to write to set input resources, with
+// the legacy controllers names and values if the unified hierarchy has none.
+func getResourceFiles(version string, resources Resources) []resourceFile {
+	memory := strconv.FormatInt(resources.Memory, 10)
+	quota := strconv.FormatInt(int64(resources.CPUs*cpuPeriod), 10)
+	pids := strconv.FormatInt(resources.Pids, 10)
+
+	if version == Version2 {
+		if resources.Memory <= 0 {
+			memory = "max"
+		}
+
+		if resources.CPUs <= 0 {
+			quota = "max"
+		}
+
+		if resources.Pids <= 0 {
+			pids = "max"
+		}
+
+		return []resourceFile{
+			{"memory", "memory.max", memory, resources.Memory <= 0},
+			{"cpu", "cpu.max", fmt.Sprintf("%s %d", quota, cpuPeriod), resources.CPUs <= 0},
+			{"pids", "pids.max", pids, resources.Pids <= 0},
+		}
+	}
+
+	if resources.Memory <= 0 {
+		memory = "-1"
+	}
+
+	if resources.CPUs <= 0 {
+		quota = "-1"
+	}
+
+	if resources.Pids <= 0 {
+		pids = "max"
+	}
+
+	return []resourceFile{
+		{"memory", "memory.limit_in_bytes", memory, resources.Memory <= 0},
+		{"cpu", "cpu.cfs_period_us", strconv.Itoa(cpuPeriod), resources.CPUs <= 0},
+		{"cpu", "cpu.cfs_quota_us", quota, resources.CPUs <= 0},
+		{"pids", "pids.max", pids, resources.Pids <= 0},
+	}
+}
+
+// SetResources will set input limits on the cgroup of input pid, using the
+// legacy controllers if the unified hierarchy has none.
+// The cgroup must contain only the processes in allowed, so that we never
+// limit the ones of the host, eg: in the user's session cgroup.
+func SetResources(pid int, allowed []int, resources Resources) error {
+	version := GetVersion()
+
+	for _, file := range getResourceFiles(version, resources) {
+		path, err := GetCgroupPath(pid)
+		if version != Version2 {
+			path, err = getControllerPath(pid, file.controller)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		members, err := getProcesses(path)
+		if err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			if !slices.Contains(allowed, member) {
+				return fmt.Errorf("cgroup %s is shared with process %d, cannot limit it", path, member)
+			}
+		}
+
+		if !fileutils.Exist(filepath.Join(path, file.name)) {
+			// nothing to remove if the controller is not there at all
+			if file.unlimited {
+				continue
+			}
+
+			return fmt.Errorf("the %s controller is not available in cgroup %s", file.controller, path)
+		}
+
+		logging.LogDebug("setting %s=%s for %d in %s", file.name, file.value, pid, path)
+
+		err = os.WriteFile(filepath.Join(path, file.name), []byte(file.value), 0o644)
+		if err != nil {
+			return fmt.Errorf("cannot set %s of cgroup %s: %w", file.name, path, err)
+		}
+	}
+
+	return nil
+}
@@ -15,6 +15,10 @@ const BusyboxURL = "https://busybox.net/downloads/binaries/1.35.0-x86_64-linux-m
 // PtyAgentPath is the path inside the container where we put the pty agent.
 const PtyAgentPath = "/sbin/pty"
 
+// InitAgentFlag makes the pty agent run the entrypoint as the container's init,
+// forwarding it signals and reaping zombie processes.
+const InitAgentFlag = "--init"
+
 // TrueString is useful for easy string comparisons with bools.
 const TrueString = "true"
 
@@ -0,0 +1,422 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/pkg/term/termios"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultDetachKeys is the key sequence to detach from a container, leaving it running.
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// attachWriteTimeout is how long an attached client can block the output of
+// the container, before being disconnected.
+const attachWriteTimeout = time.Second
+
+// attachWaitTimeout is how long a container waits for the client that started
+// it to attach, before starting anyway.
+const attachWaitTimeout = 10 * time.Second
+
+// AttachOptions are the options of a client attaching to a container.
+type AttachOptions struct {
+	// DetachKeys is the key sequence to detach from the container, empty
+	// to disable detaching.
+	DetachKeys []byte
+	// Stdin forwards the input of the terminal to the container, if it
+	// has a tty.
+	Stdin bool
+	// Timeout is how long to wait for the container to accept clients, when
+	// it is just being started.
+	Timeout time.Duration
+}
+
+// GetAttachSocket returns the path of the socket to attach to input container.
+func GetAttachSocket(name string) string {
+	return filepath.Join(GetDir(name), "attach.sock")
+}
+
+// getAttachWaitPath returns the path of the file asking the next run of input
+// container to wait for a client to attach, before starting its process.
+func getAttachWaitPath(name string) string {
+	return filepath.Join(GetDir(name), "attach.wait")
+}
+
+// SetAttachWait will make the next run in background of input container wait
+// for a client to attach, so that none of its output is missed, or withdraw
+// the request if wait is false.
+func SetAttachWait(name string, wait bool) error {
+	if !wait {
+		err := os.Remove(getAttachWaitPath(name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	return fileutils.WriteFile(getAttachWaitPath(name), []byte{}, 0o644)
+}
+
+// ParseDetachKeys returns the bytes of input detach keys, a comma separated
+// list of characters or ctrl-<value>, eg: ctrl-p,ctrl-q.
+// An empty string disables detaching.
+func ParseDetachKeys(keys string) ([]byte, error) {
+	result := []byte{}
+
+	if keys == "" {
+		return result, nil
+	}
+
+	for _, key := range strings.Split(keys, ",") {
+		value, isCtrl := strings.CutPrefix(strings.ToLower(key), "ctrl-")
+
+		switch {
+		case len(key) == 1:
+			result = append(result, key[0])
+		case isCtrl && len(value) == 1 && value[0] >= 'a' && value[0] <= 'z':
+			result = append(result, value[0]-'a'+1)
+		case isCtrl && len(value) == 1 && strings.Contains("@[\\]^_", value):
+			result = append(result, value[0]-'@')
+		default:
+			return nil, fmt.Errorf("invalid detach key %s, use a character or ctrl-<value>", key)
+		}
+	}
+
+	return result, nil
+}
+
+// attachServer relays the output of a detached container to the clients
+// attached to its socket, and their input to the container if it has a tty.
+type attachServer struct {
+	path     string
+	listener net.Listener
+	lock     sync.Mutex
+	clients  map[net.Conn]struct{}
+	// attached is closed once the first client is attached.
+	attached     chan struct{}
+	attachedOnce sync.Once
+	// stdin is the container's stdin, only set up for containers with a tty,
+	// input is its other end, where the clients' input is written.
+	stdin *os.File
+	input *os.File
+}
+
+// newAttachServer will listen on the attach socket of input container.
+// If tty is specified, the returned server's input is meant to be the
+// container's stdin.
+func newAttachServer(config utils.Config, tty bool) (*attachServer, error) {
+	path := GetAttachSocket(config.ID)
+
+	// a stale socket of a previous run
+	_ = os.Remove(path)
+
+	address, closeDir, err := getSocketAddress(path)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", address)
+
+	closeDir()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the address is not valid anymore, the socket is removed by path on close
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	server := &attachServer{
+		path:     path,
+		listener: listener,
+		clients:  map[net.Conn]struct{}{},
+		attached: make(chan struct{}),
+	}
+
+	if tty {
+		server.stdin, server.input, err = os.Pipe()
+		if err != nil {
+			_ = listener.Close()
+			_ = os.Remove(path)
+
+			return nil, err
+		}
+	}
+
+	go server.serve()
+
+	return server, nil
+}
+
+// serve will accept attach clients until the server is closed.
+func (s *attachServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		logging.LogDebug("client attached")
+
+		s.lock.Lock()
+		s.clients[conn] = struct{}{}
+		s.lock.Unlock()
+
+		s.attachedOnce.Do(func() { close(s.attached) })
+
+		go func() {
+			// without a tty the input is discarded, we still read it to
+			// know when the client detaches
+			var input io.Writer = io.Discard
+			if s.input != nil {
+				input = s.input
+			}
+
+			_, _ = io.Copy(input, conn)
+
+			logging.LogDebug("client detached")
+
+			s.lock.Lock()
+			delete(s.clients, conn)
+			s.lock.Unlock()
+
+			_ = conn.Close()
+		}()
+	}
+}
+
+// waitClient will wait for the first client to attach, up to input timeout.
+// Returns whether a client attached.
+func (s *attachServer) waitClient(timeout time.Duration) bool {
+	select {
+	case <-s.attached:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// countClients returns the number of clients attached.
+func (s *attachServer) countClients() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.clients)
+}
+
+// Write will send input output of the container to all the attached clients.
+// It never fails, so that no client can stop the container's output: the
+// clients that cannot keep up are disconnected.
+func (s *attachServer) Write(data []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for conn := range s.clients {
+		_ = conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+
+		_, err := conn.Write(data)
+		if err != nil {
+			logging.LogDebug("disconnecting attached client: %v", err)
+
+			delete(s.clients, conn)
+
+			_ = conn.Close()
+		}
+	}
+
+	return len(data), nil
+}
+
+// Close will disconnect all the clients and remove the attach socket.
+func (s *attachServer) Close() {
+	_ = s.listener.Close()
+	_ = os.Remove(s.path)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for conn := range s.clients {
+		_ = conn.Close()
+	}
+
+	if s.stdin != nil {
+		_ = s.stdin.Close()
+		_ = s.input.Close()
+	}
+}
+
+// detachReader forwards the input read from the terminal, until the detach
+// keys are typed.
+type detachReader struct {
+	reader io.Reader
+	keys   []byte
+	// matched is how many of the keys were typed so far, held back.
+	matched int
+	// pending is the input not returned yet.
+	pending []byte
+	// detached is set once the keys are typed, after the pending input.
+	detached bool
+}
+
+// errDetached is returned by detachReader once the detach keys are typed.
+var errDetached = errors.New("detached")
+
+// Read will read from the terminal, holding back the input matching the
+// detach keys until the whole sequence is typed, or another key is.
+func (r *detachReader) Read(data []byte) (int, error) {
+	if len(r.pending) > 0 {
+		count := copy(data, r.pending)
+		r.pending = r.pending[count:]
+
+		return count, nil
+	}
+
+	if r.detached {
+		return 0, errDetached
+	}
+
+	buffer := make([]byte, len(data))
+
+	count, err := r.reader.Read(buffer)
+	if len(r.keys) == 0 {
+		return copy(data, buffer[:count]), err
+	}
+
+	for _, char := range buffer[:count] {
+		if char == r.keys[r.matched] {
+			r.matched++
+
+			if r.matched == len(r.keys) {
+				r.detached = true
+
+				break
+			}
+
+			continue
+		}
+
+		// not the sequence, the held back keys are input too
+		r.pending = append(r.pending, r.keys[:r.matched]...)
+		r.matched = 0
+
+		if char == r.keys[0] {
+			r.matched = 1
+
+			continue
+		}
+
+		r.pending = append(r.pending, char)
+	}
+
+	result := copy(data, r.pending)
+	r.pending = r.pending[result:]
+
+	return result, err
+}
+
+// Attach will connect the terminal to input container, started in background,
+// until it exits, or the detach keys are typed, leaving it running.
+// The input is only forwarded with options.Stdin, to containers with a tty.
+// Returns whether we detached from the container.
+func Attach(name string, options AttachOptions) (bool, error) {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := dialAttach(GetAttachSocket(config.ID), options.Timeout)
+	if err != nil {
+		return false, fmt.Errorf("cannot attach to container %s, it is not running in background: %w", name, err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	detached := make(chan bool, 2)
+
+	go func() {
+		_, _ = io.Copy(os.Stdout, conn)
+
+		detached <- false
+	}()
+
+	if !options.Stdin {
+		return <-detached, nil
+	}
+
+	// the container's tty handles the keys, eg: ctrl-c
+	var previous unix.Termios
+
+	err = termios.Tcgetattr(os.Stdin.Fd(), &previous)
+	if err == nil && config.Tty {
+		raw := previous
+		termios.Cfmakeraw(&raw)
+
+		err = termios.Tcsetattr(os.Stdin.Fd(), termios.TCSANOW, &raw)
+		if err != nil {
+			return false, err
+		}
+
+		defer func() { _ = termios.Tcsetattr(os.Stdin.Fd(), termios.TCSANOW, &previous) }()
+	}
+
+	go func() {
+		_, err := io.Copy(conn, &detachReader{reader: os.Stdin, keys: options.DetachKeys})
+		if errors.Is(err, errDetached) {
+			detached <- true
+		}
+	}()
+
+	return <-detached, nil
+}
+
+// dialAttach will connect to input attach socket, retrying until input
+// timeout while the container is starting.
+func dialAttach(path string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		address, closeDir, err := getSocketAddress(path)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := net.Dial("unix", address)
+
+		closeDir()
+
+		if err == nil || time.Now().After(deadline) {
+			return conn, err
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// getSocketAddress returns the address of input unix socket through an open
+// descriptor of its directory, as the paths of the containers' directories
+// are usually longer than the 108 bytes of a socket address, and a function
+// to close the descriptor once the socket is bound or connected.
+func getSocketAddress(path string) (string, func(), error) {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return "", nil, err
+	}
+
+	address := fmt.Sprintf("/proc/self/fd/%d/%s", dir.Fd(), filepath.Base(path))
+
+	return address, func() { _ = dir.Close() }, nil
+}
@@ -0,0 +1,198 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// StatusBroken is the status of containers whose config cannot be loaded.
+const StatusBroken = "broken"
+
+// Problem describes an issue found in a container by CheckContainers.
+type Problem struct {
+	ID      string
+	Name    string
+	Problem string
+}
+
+// getContainerEnv returns the key/values of the /run/.containerenv file
+// found in the rootfs of input container id.
+func getContainerEnv(id string) map[string]string {
+	result := map[string]string{}
+
+	file, err := fileutils.ReadFile(filepath.Join(ContainerDir, id, "rootfs", "run", ".containerenv"))
+	if err != nil {
+		// containers using the erofs storage driver keep it in their changes
+		file, err = fileutils.ReadFile(filepath.Join(ContainerDir, id, "diff", "run", ".containerenv"))
+		if err != nil {
+			return result
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.Trim(file, "\x00")))
+	for scanner.Scan() {
+		// Line has a structure:
+		//    key="value"
+		key, value, found := strings.Cut(scanner.Text(), "=")
+		if found {
+			result[key] = strings.Trim(value, `"`)
+		}
+	}
+
+	return result
+}
+
+// GetBrokenName returns the best guess of the name of a container whose
+// config cannot be loaded, using the info saved in its rootfs.
+func GetBrokenName(id string) string {
+	name := getContainerEnv(id)["name"]
+	if name == "" {
+		return "<unknown>"
+	}
+
+	return name
+}
+
+// CheckContainers will look for containers with invalid configs or
+// missing rootfs, and return the problems found.
+func CheckContainers() ([]Problem, error) {
+	result := []Problem{}
+
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, container := range containers {
+		id := container.Name()
+
+		logging.LogDebug("checking container %s", id)
+
+		config, err := utils.LoadConfig(filepath.Join(ContainerDir, id, "config"))
+		if err != nil {
+			result = append(result, Problem{
+				ID:      id,
+				Name:    GetBrokenName(id),
+				Problem: fmt.Sprintf("invalid config: %v", err),
+			})
+
+			continue
+		}
+
+		if !fileutils.Exist(filepath.Join(ContainerDir, id, "rootfs")) {
+			result = append(result, Problem{
+				ID:      id,
+				Name:    config.Names,
+				Problem: "missing rootfs",
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// RepairContainer will try to repair the config of input broken container id.
+// The last valid config is restored if available, else a default config is
+// regenerated from the info saved in the container's rootfs.
+func RepairContainer(id string) error {
+	configPath := filepath.Join(ContainerDir, id, "config")
+
+	if !fileutils.Exist(filepath.Join(ContainerDir, id, "rootfs")) {
+		return fmt.Errorf("container %s has no rootfs and cannot be repaired, remove it", id)
+	}
+
+	backup, err := utils.LoadConfig(configPath + ".bak")
+	if err == nil {
+		logging.LogDebug("restoring last valid config for %s", id)
+
+		backup.ID = id
+
+		return utils.SaveConfig(backup, configPath)
+	}
+
+	logging.LogDebug("no valid backup for %s, regenerating config", id)
+
+	info := getContainerEnv(id)
+
+	name := info["name"]
+	if name == "" || GetID(name) != getLegacyID(name) {
+		// unknown name, or already taken by another container
+		name = "recovered_" + ShortID(id)
+	}
+
+	config := utils.GetDefaultConfig()
+	config.ID = id
+	config.Names = name
+	config.Hostname = name
+	config.Image = info["image"]
+	config.Created = time.Now().Format(time.RFC3339)
+	config.Entrypoint = []string{"/bin/sh"}
+
+	// if the image is still around, use its defaults
+	imageConfigFile, err := fileutils.ReadFile(filepath.Join(imageutils.GetPath(config.Image), "config.json"))
+	if err == nil {
+		var imageConfig v1.ConfigFile
+
+		err = json.Unmarshal(imageConfigFile, &imageConfig)
+		if err == nil && len(imageConfig.Config.Cmd) > 0 {
+			config.Entrypoint = imageConfig.Config.Cmd
+			config.Env = append(config.Env, imageConfig.Config.Env...)
+		}
+	}
+
+	logging.LogWarning("regenerated default config for %s as %s, review it with update", id, name)
+
+	// the broken config would be backed up otherwise
+	_ = os.Remove(configPath)
+
+	return utils.SaveConfig(config, configPath)
+}
+
+// RemoveContainer will delete input container id with its volumes.
+// This does not need a valid config, so it can be used on broken containers.
+func RemoveContainer(id string) error {
+	targetDIR := filepath.Join(ContainerDir, id)
+
+	// the config may be broken, the event will carry the ID only then
+	config, err := utils.LoadConfig(filepath.Join(targetDIR, "config"))
+	if err != nil {
+		config = utils.Config{ID: id}
+	}
+
+	err = fileutils.Umount(filepath.Join(targetDIR, "rootfs"))
+	if err != nil {
+		logging.LogDebug("cannot umount %s: %v", targetDIR, err)
+	}
+
+	err = os.RemoveAll(targetDIR)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(filepath.Join(utils.GetLilipodHome(), "volumes", id))
+	if err != nil {
+		return err
+	}
+
+	events.Emit("destroy", config, nil)
+
+	return nil
+}
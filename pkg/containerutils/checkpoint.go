@@ -0,0 +1,357 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// criuOptions are the options used both to dump and restore containers:
+// detached containers are in their own session, and their connections,
+// file locks and bind mounts are kept.
+var criuOptions = []string{
+	"--shell-job",
+	"--tcp-established",
+	"--file-locks",
+	"--ext-mount-map", "auto",
+}
+
+// checkpointStreams are the pipes the container's output was forwarded to
+// when dumped, eg: pipe:[1234], to be replaced with the new ones on restore.
+type checkpointStreams struct {
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// GetCheckpointDir returns where the CRIU images of input container are saved.
+func GetCheckpointDir(name string) string {
+	return filepath.Join(GetDir(name), "checkpoint")
+}
+
+// HasCheckpoint returns whether input container has a checkpoint to restore.
+func HasCheckpoint(name string) bool {
+	return fileutils.Exist(filepath.Join(GetCheckpointDir(name), "inventory.img"))
+}
+
+// getCriu returns the path of the criu binary.
+func getCriu() (string, error) {
+	criu, err := exec.LookPath("criu")
+	if err != nil {
+		return "", errors.New("criu not found, install it to checkpoint and restore containers")
+	}
+
+	return criu, nil
+}
+
+// validateCheckpoint will check that input container can be checkpointed:
+// CRIU cannot restore the slirp4netns networking, nor the containers of
+// external runtimes, that have their own checkpoint support.
+func validateCheckpoint(config utils.Config) error {
+	if IsExternalRuntime(config.Runtime) {
+		return fmt.Errorf("checkpointing containers of runtime %s is not supported, use %s checkpoint",
+			config.Runtime, config.Runtime)
+	}
+
+	if config.Network != constants.Host {
+		return fmt.Errorf("checkpointing containers with a %s network is not supported, only with --network %s",
+			config.Network, constants.Host)
+	}
+
+	return nil
+}
+
+// getRootPid returns the process of input pids that is the parent of all the
+// others: the one CRIU dumps the process tree of.
+func getRootPid(pids []int) (int, error) {
+	for _, pid := range pids {
+		stat, err := fileutils.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil {
+			continue
+		}
+
+		// the command in the second field can contain spaces, the parent
+		// pid is the second field after it.
+		fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+		if len(fields) < 2 {
+			continue
+		}
+
+		ppid, err := strconv.Atoi(fields[1])
+		if err == nil && !slices.Contains(pids, ppid) {
+			return pid, nil
+		}
+	}
+
+	return -1, errors.New("cannot find the main process of the container")
+}
+
+// getStreams returns the pipes of the standard output and error of input pid.
+func getStreams(pid int) checkpointStreams {
+	result := checkpointStreams{}
+
+	for fd, stream := range map[int]*string{1: &result.Stdout, 2: &result.Stderr} {
+		target, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "fd", strconv.Itoa(fd)))
+		if err == nil && strings.HasPrefix(target, "pipe:") {
+			*stream = target
+		}
+	}
+
+	return result
+}
+
+// Checkpoint will dump the processes of input running container with CRIU,
+// in its checkpoint dir, stopping it unless leaveRunning is specified.
+// If export is not empty, the container and its checkpoint are archived
+// there as a tar.gz, to be restored on another host with ImportCheckpoint.
+func Checkpoint(name string, export string, leaveRunning bool) error {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return err
+	}
+
+	err = validateCheckpoint(config)
+	if err != nil {
+		return err
+	}
+
+	if export != "" && config.StorageDriver == imageutils.StorageDriverErofs {
+		return fmt.Errorf("exporting containers using the %s storage driver is not supported",
+			imageutils.StorageDriverErofs)
+	}
+
+	criu, err := getCriu()
+	if err != nil {
+		return err
+	}
+
+	if IsPaused(name) {
+		return fmt.Errorf("container %s is paused, unpause it first", name)
+	}
+
+	pids, err := getPids(name)
+	if err != nil {
+		return err
+	}
+
+	pid, err := getRootPid(pids)
+	if err != nil {
+		return err
+	}
+
+	dir := GetCheckpointDir(name)
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	streams, err := json.Marshal(getStreams(pid))
+	if err != nil {
+		return err
+	}
+
+	err = fileutils.WriteFile(filepath.Join(dir, "streams.json"), streams, 0o644)
+	if err != nil {
+		return err
+	}
+
+	args := append([]string{
+		"dump",
+		"--tree", strconv.Itoa(pid),
+		"--images-dir", dir,
+		"--log-file", "dump.log",
+	}, criuOptions...)
+
+	// the dumped processes are killed, this must not restart them
+	if leaveRunning {
+		args = append(args, "--leave-running")
+	} else {
+		markStopped(config.ID)
+	}
+
+	logging.LogDebug("checkpointing %s, executing %s %v", name, criu, args)
+
+	out, err := exec.Command(criu, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint %s: %w: %s, see %s",
+			name, err, string(out), filepath.Join(dir, "dump.log"))
+	}
+
+	events.Emit("checkpoint", config, nil)
+
+	if export == "" {
+		return nil
+	}
+
+	logging.LogDebug("exporting checkpoint of %s to %s", name, export)
+
+	out, err = exec.Command("tar",
+		"--numeric-owner", "--xattrs", "--xattrs-include=*",
+		"--exclude=./rootfs/dev/*", "--exclude=./rootfs/proc/*", "--exclude=./rootfs/sys/*",
+		"-czf", export, "-C", GetDir(name), "./config", "./rootfs", "./checkpoint").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to export checkpoint of %s: %w: %s", name, err, string(out))
+	}
+
+	return nil
+}
+
+// ImportCheckpoint will create the container archived in input file by
+// Checkpoint, and return its ID. If name is not empty, the container is
+// renamed to it. As for ImportState, the hooks of the container are dropped
+// unless hooks is set.
+func ImportCheckpoint(input string, name string, hooks bool) (string, error) {
+	err := os.MkdirAll(ContainerDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	tmpdir, err := os.MkdirTemp(ContainerDir, ".import-")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = os.RemoveAll(tmpdir) }()
+
+	out, err := exec.Command("tar", "--numeric-owner", "--xattrs", "--xattrs-include=*",
+		"-xzf", input, "-C", tmpdir).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w: %s", input, err, string(out))
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(tmpdir, "config"))
+	if err != nil {
+		return "", fmt.Errorf("%s is not a container checkpoint: %w", input, err)
+	}
+
+	// the ID is used in paths, it must not point outside of our dirs
+	if !IsValidID(config.ID) {
+		return "", fmt.Errorf("%s is not a container checkpoint, invalid container ID %q", input, config.ID)
+	}
+
+	if !fileutils.Exist(filepath.Join(tmpdir, "checkpoint", "inventory.img")) {
+		return "", fmt.Errorf("%s is not a container checkpoint, no CRIU images found", input)
+	}
+
+	if name != "" {
+		config.Names = name
+	}
+
+	// the checkpointed processes know the container by its ID
+	if fileutils.Exist(filepath.Join(ContainerDir, config.ID)) {
+		return "", fmt.Errorf("container %s already exists", config.ID)
+	}
+
+	if _, ok := FindByName(config.Names); ok {
+		return "", fmt.Errorf("container %s already exists, specify another name", config.Names)
+	}
+
+	getImportConfig(&config, hooks)
+
+	err = utils.SaveConfig(config, filepath.Join(tmpdir, "config"))
+	if err != nil {
+		return "", err
+	}
+
+	err = os.Rename(tmpdir, filepath.Join(ContainerDir, config.ID))
+	if err != nil {
+		return "", err
+	}
+
+	events.Emit("create", config, nil)
+
+	return config.ID, nil
+}
+
+// Restore will resume the processes of input container from its checkpoint
+// with CRIU, in background with all output saved in the logs, like Start.
+// Once it exits, the container is started again according to its restart policy.
+func Restore(config utils.Config) error {
+	err := validateCheckpoint(config)
+	if err != nil {
+		return err
+	}
+
+	criu, err := getCriu()
+	if err != nil {
+		return err
+	}
+
+	err = MountRootfs(config)
+	if err != nil {
+		return err
+	}
+
+	dir := GetCheckpointDir(config.ID)
+
+	args := append([]string{
+		"restore",
+		"--images-dir", dir,
+		"--root", GetRootfsDir(config.ID),
+		"--log-file", "restore.log",
+	}, criuOptions...)
+
+	// the restored processes write to criu's output, saved in the logs
+	var streams checkpointStreams
+
+	file, err := fileutils.ReadFile(filepath.Join(dir, "streams.json"))
+	if err == nil {
+		err = json.Unmarshal(file, &streams)
+		if err != nil {
+			return err
+		}
+	}
+
+	if streams.Stdout != "" {
+		args = append(args, "--inherit-fd", "fd[1]:"+streams.Stdout)
+	}
+
+	if streams.Stderr != "" && streams.Stderr != streams.Stdout {
+		args = append(args, "--inherit-fd", "fd[2]:"+streams.Stderr)
+	}
+
+	cmd := exec.Command(criu, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+
+	logging.LogDebug("restoring the container, executing %v", cmd.Args)
+
+	markStarted(config.ID, 0)
+	events.Emit("restore", config, nil)
+
+	forward, closeForwarder := getLogForwarder(config)
+	runErr := procutils.RunDetached(cmd, GetLogPath(config.ID, 0), forward)
+
+	closeForwarder()
+
+	markFinished(config.ID, runErr)
+	events.Emit("die", config, map[string]string{"exitCode": strconv.Itoa(GetExitCode(runErr))})
+
+	refreshConfig(&config)
+
+	if shouldRestart(config, runErr, 0) {
+		return Start(procutils.Streams{}, false, config)
+	}
+
+	return runErr
+}
@@ -0,0 +1,168 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Clone will create a new container with input name, with the config of the
+// source container and a copy of its rootfs and anonymous volumes. With reset,
+// the rootfs is created from the image again instead, without the changes
+// and volumes of the source container.
+// Returns the ID of the new container.
+func Clone(source string, name string, reset bool) (string, error) {
+	if !fileutils.Exist(GetDir(source)) {
+		return "", fmt.Errorf("container %s does not exist", source)
+	}
+
+	if _, ok := FindByName(name); ok {
+		return "", fmt.Errorf("container %s already exists", name)
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(GetDir(source), "config"))
+	if err != nil {
+		return "", err
+	}
+
+	if reset && (config.ImageRemoved || !fileutils.Exist(imageutils.GetPath(config.Image))) {
+		return "", fmt.Errorf("image %s of container %s is not available anymore", config.Image, source)
+	}
+
+	if !reset && IsRunning(source) {
+		logging.LogWarning("container %s is running, the clone could be inconsistent", source)
+	}
+
+	clone, err := getCloneConfig(config, name)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(ContainerDir, clone.ID)
+
+	logging.LogDebug("cloning %s to %s in %s", source, name, dir)
+
+	err = cloneRootfs(config, clone, reset)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		_ = os.RemoveAll(filepath.Join(utils.GetLilipodHome(), "volumes", clone.ID))
+
+		return "", err
+	}
+
+	err = utils.SaveConfig(clone, filepath.Join(dir, "config"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		_ = os.RemoveAll(filepath.Join(utils.GetLilipodHome(), "volumes", clone.ID))
+
+		return "", err
+	}
+
+	events.Emit("create", clone, map[string]string{"image": clone.Image, "clonedFrom": config.Names})
+
+	return clone.ID, nil
+}
+
+// getCloneConfig returns the config of a clone of input container, with input
+// name, without the state of the source container and the settings that
+// cannot be shared by two containers.
+func getCloneConfig(config utils.Config, name string) (utils.Config, error) {
+	clone := config
+
+	id, err := NewID()
+	if err != nil {
+		return utils.Config{}, err
+	}
+
+	clone.ID = id
+	clone.Names = name
+	clone.Created = time.Now().Format(time.RFC3339)
+
+	// hostnames defaulting to the name follow it
+	if config.Hostname == config.Names {
+		clone.Hostname = name
+
+		clone.Env = []string{}
+
+		for _, env := range config.Env {
+			if strings.HasPrefix(env, "HOSTNAME=") {
+				env = "HOSTNAME=" + name
+			}
+
+			clone.Env = append(clone.Env, env)
+		}
+	}
+
+	// static addresses and pidfiles belong to the source container
+	clone.IP = ""
+	clone.MacAddress = ""
+	clone.PidFile = ""
+
+	clone.Status = ""
+	clone.Size = ""
+	clone.Started = ""
+	clone.Finished = ""
+	clone.ExitCode = 0
+	clone.RestartCount = 0
+	clone.Health = nil
+
+	return clone, nil
+}
+
+// cloneRootfs will create the rootfs of the clone container, copying the one
+// of the source container, or from the image with reset.
+func cloneRootfs(source utils.Config, clone utils.Config, reset bool) error {
+	// the clone does not exist yet, so it cannot be looked up by name
+	rootfs := filepath.Join(ContainerDir, clone.ID, "rootfs")
+	layer := rootfs
+
+	if source.StorageDriver == imageutils.StorageDriverErofs {
+		err := os.MkdirAll(rootfs, 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = prepareErofsRootfs(source.Image, clone.ID)
+		if err != nil {
+			return err
+		}
+
+		layer = filepath.Join(ContainerDir, clone.ID, "diff")
+	} else if reset {
+		err := os.MkdirAll(rootfs, 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = imageutils.Unpack(source.Image, rootfs, source.Userns)
+		if err != nil {
+			return err
+		}
+	}
+
+	if reset {
+		return nil
+	}
+
+	// only the changes are copied, the erofs image is shared
+	err := copyLayer(getWritableLayer(source), layer)
+	if err != nil {
+		return err
+	}
+
+	volumes := filepath.Join(utils.GetLilipodHome(), "volumes", source.ID)
+	if !fileutils.Exist(volumes) {
+		return nil
+	}
+
+	return copyLayer(volumes, filepath.Join(utils.GetLilipodHome(), "volumes", clone.ID))
+}
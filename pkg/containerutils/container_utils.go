@@ -6,26 +6,29 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/big"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
 	"time"
 
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
 	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/events"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/imageutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
 	"github.com/89luca89/lilipod/pkg/utils"
 	"github.com/google/go-containerregistry/pkg/legacy"
-	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"golang.org/x/sys/unix"
 )
 
@@ -52,13 +55,131 @@ func GetRandomName() string {
 	return string(string1) + "_" + string(string2)
 }
 
-// GetID returns the md5sum based ID for given container.
+// NewID returns a new random 256-bit container ID, hex encoded.
+func NewID() (string, error) {
+	id := make([]byte, 32)
+
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate container id: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// validID matches the container IDs returned by NewID, and the legacy md5sum
+// based ones.
+var validID = regexp.MustCompile(`^[0-9a-f]{32}([0-9a-f]{32})?$`)
+
+// IsValidID returns whether input string is a container ID like the ones
+// returned by NewID or getLegacyID, so that it is safe to use as a path.
+func IsValidID(id string) bool {
+	return validID.MatchString(id)
+}
+
+// ShortIDLength is the length of the IDs shown to the user.
+const ShortIDLength = 12
+
+// ShortID returns the truncated form of input container ID, used for display.
+func ShortID(id string) string {
+	if len(id) > ShortIDLength {
+		return id[:ShortIDLength]
+	}
+
+	return id
+}
+
+// ResolveID returns the ID of input container name, full ID or ID prefix.
+// Names take precedence over ID prefixes, an error listing the candidates is
+// returned if a prefix matches more than one container.
+func ResolveID(name string) (string, error) {
+	if name != "" && fileutils.Exist(filepath.Join(ContainerDir, name)) {
+		return name, nil
+	}
+
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil {
+		return "", fmt.Errorf("container %s does not exist", name)
+	}
+
+	candidates := []string{}
+
+	for _, container := range containers {
+		config, err := utils.LoadConfig(filepath.Join(ContainerDir, container.Name(), "config"))
+		if err != nil {
+			continue
+		}
+
+		if config.Names == name {
+			return container.Name(), nil
+		}
+
+		if name != "" && strings.HasPrefix(container.Name(), name) {
+			candidates = append(candidates, container.Name())
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("container %s does not exist", name)
+	case 1:
+		return candidates[0], nil
+	default:
+		for i, candidate := range candidates {
+			candidates[i] = ShortID(candidate)
+		}
+
+		return "", fmt.Errorf("container ID prefix %s is ambiguous, it matches: %s",
+			name, strings.Join(candidates, ", "))
+	}
+}
+
+// FindByName returns the ID of the container with exactly input name, and
+// whether there is one. Unlike ResolveID, IDs and ID prefixes do not match, so
+// it is used to check if a name is taken.
+func FindByName(name string) (string, bool) {
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil || name == "" {
+		return "", false
+	}
+
+	for _, container := range containers {
+		config, err := utils.LoadConfig(filepath.Join(ContainerDir, container.Name(), "config"))
+		if err != nil {
+			continue
+		}
+
+		if config.Names == name {
+			return container.Name(), true
+		}
+	}
+
+	return "", false
+}
+
+// GetID returns the ID for given container name, ID or ID prefix.
 // If a recognized ID is passed, it is returned.
+// IDs are stored in the containers' config, so we look them up by name,
+// falling back to the legacy md5sum based ID for unknown names.
+// Ambiguous ID prefixes fall back too, so user input should be checked with
+// ResolveID first, to report the containers it matches.
 func GetID(name string) string {
 	if fileutils.Exist(filepath.Join(ContainerDir, name)) {
 		return name
 	}
 
+	id, err := ResolveID(name)
+	if err == nil {
+		return id
+	}
+
+	logging.LogDebug("%v", err)
+
+	return getLegacyID(name)
+}
+
+// getLegacyID returns the md5sum based ID used by older lilipod versions.
+func getLegacyID(name string) string {
 	hasher := md5.New()
 
 	_, err := io.WriteString(hasher, name)
@@ -132,20 +253,42 @@ func GetContainerInfo(
 
 	config, err := utils.LoadConfig(configPath)
 	if err != nil {
-		// in case of invalid container, let's cleanup the mess.
-		logging.LogWarning("found invalid container %s, cleaning up", container)
-		return nil, exec.Command(os.Args[0], "rm", container).Run()
-	}
+		// in case of invalid container, do not touch it, show it as broken
+		// so that it can be checked with "system check".
+		logging.LogWarning("found invalid container %s: %v", container, err)
+
+		config = utils.Config{
+			ID:     container,
+			Names:  GetBrokenName(container),
+			Status: StatusBroken,
+		}
 
-	if !filterContainer(config, filters) {
-		// this container does not match any filter, return nil, and no errors.
-		//nolint: nilnil
-		return nil, nil
+		if !filterContainer(config, filters) {
+			//nolint: nilnil
+			return nil, nil
+		}
+
+		return &config, nil
 	}
 
 	isRunning := IsRunning(config.Names)
 	if isRunning {
 		state = "running"
+
+		if IsPaused(config.Names) {
+			state = StatusPaused
+		}
+	}
+
+	// the status and exited filters need the current state
+	config.Status = state
+
+	populateState(&config)
+
+	if !filterContainer(config, filters) {
+		// this container does not match any filter, return nil, and no errors.
+		//nolint: nilnil
+		return nil, nil
 	}
 
 	if size {
@@ -155,9 +298,12 @@ func GetContainerInfo(
 		}
 	}
 
-	config.Status = state
 	config.Size = directorySize
 
+	if isRunning && HasHealthcheck(config) {
+		config.Health, _ = GetHealth(config.Names)
+	}
+
 	return &config, nil
 }
 
@@ -170,7 +316,8 @@ func GetContainerInfo(
 func CreateRootfs(image string, name string, createConfig utils.Config, uid, gid string) error {
 	logging.LogDebug("preparing rootfs for new container %s", name)
 
-	containerDIR := GetRootfsDir(name)
+	// the container does not exist yet, so it cannot be looked up by name.
+	containerDIR := filepath.Join(ContainerDir, createConfig.ID, "rootfs")
 
 	logging.LogDebug("creating %s", containerDIR)
 
@@ -183,7 +330,7 @@ func CreateRootfs(image string, name string, createConfig utils.Config, uid, gid
 
 	imageDir := imageutils.GetPath(image)
 	if !fileutils.Exist(imageDir) {
-		_, err := imageutils.Pull(image, false)
+		_, err := imageutils.Pull(image, "", false)
 		if err != nil {
 			return err
 		}
@@ -197,28 +344,18 @@ func CreateRootfs(image string, name string, createConfig utils.Config, uid, gid
 		return err
 	}
 
-	var manifest v1.Manifest
+	// keep track of the exact image the container comes from
+	createConfig.ImageDigest = fmt.Sprintf("sha256:%x", sha256.Sum256(manifestFile))
 
-	err = json.Unmarshal(manifestFile, &manifest)
-	if err != nil {
-		return err
+	// with the erofs driver the image is shared, and mounted at start
+	if createConfig.StorageDriver == imageutils.StorageDriverErofs {
+		err = prepareErofsRootfs(image, createConfig.ID)
+	} else {
+		err = imageutils.Unpack(image, containerDIR, createConfig.Userns)
 	}
 
-	logging.LogDebug("extracting image's layers")
-
-	for _, layer := range manifest.Layers {
-		layerDigest := strings.Split(layer.Digest.String(), ":")[1] + ".tar.gz"
-
-		logging.LogDebug("extracting layer %s in %s", layerDigest, containerDIR)
-
-		err = fileutils.UntarFile(
-			filepath.Join(imageDir, layerDigest),
-			containerDIR,
-			createConfig.Userns,
-		)
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
 	}
 
 	logging.LogDebug("populating default config.json")
@@ -245,6 +382,27 @@ func CreateRootfs(image string, name string, createConfig utils.Config, uid, gid
 	createConfig.Env = append(createConfig.Env, "HOSTNAME="+createConfig.Hostname)
 	createConfig.Env = append(createConfig.Env, "TERM=xterm")
 
+	// if no stop signal is specified, default to image default stop signal
+	if createConfig.Stopsignal == "" {
+		createConfig.Stopsignal = config.Config.StopSignal
+	}
+
+	if createConfig.Stopsignal == "" {
+		createConfig.Stopsignal = DefaultStopSignal
+	}
+
+	// if no healthcheck is specified, default to image default healthcheck
+	if createConfig.Healthcheck == nil && config.Config.Healthcheck != nil {
+		logging.LogDebug("healthcheck not specified, fallbacking to default one in image manifest")
+
+		createConfig.Healthcheck = &utils.HealthConfig{
+			Test:     config.Config.Healthcheck.Test,
+			Interval: config.Config.Healthcheck.Interval.String(),
+			Timeout:  config.Config.Healthcheck.Timeout.String(),
+			Retries:  config.Config.Healthcheck.Retries,
+		}
+	}
+
 	// if empty entrypoint, default to image default entrypoint
 	if len(createConfig.Entrypoint) == 0 || createConfig.Entrypoint == nil {
 		logging.LogDebug("entrypoint not specified, fallbacking to default one in image manifest")
@@ -255,7 +413,7 @@ func CreateRootfs(image string, name string, createConfig utils.Config, uid, gid
 	createConfig.Gidmap = gid
 
 	// save the config to file
-	configPath := filepath.Join(GetDir(name), "config")
+	configPath := filepath.Join(ContainerDir, createConfig.ID, "config")
 
 	logging.LogDebug("saving config")
 
@@ -264,25 +422,47 @@ func CreateRootfs(image string, name string, createConfig utils.Config, uid, gid
 		return err
 	}
 
+	events.Emit("create", createConfig, map[string]string{"image": createConfig.Image})
+
 	logging.LogDebug("done")
 
 	return nil
 }
 
+// updateContainerEnv will rewrite the /run/.containerenv of input running
+// container, as seen by its processes. The file is replaced atomically, as
+// running containers are found by it.
+func updateContainerEnv(config utils.Config) error {
+	pid, err := GetPid(config.ID)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join("/proc", strconv.Itoa(pid), "root", "run", ".containerenv")
+
+	err = os.WriteFile(path+".tmp", []byte(formatContainerEnv(config)), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
 // Rename will change the name of oldContainer to newContainer.
 func Rename(oldContainer string, newContainer string) error {
 	logging.LogDebug("extracting IDs")
 
 	logging.LogDebug("checking if old container %s exists", oldContainer)
 
-	if !fileutils.Exist(GetDir(oldContainer)) {
-		logging.LogError("container %s does not exist", oldContainer)
-		return fmt.Errorf("container %s does not exist", oldContainer)
+	id, err := ResolveID(oldContainer)
+	if err != nil {
+		logging.LogError("%v", err)
+		return err
 	}
 
 	logging.LogDebug("checking if new container %s does not already exist", newContainer)
 
-	if fileutils.Exist(GetDir(newContainer)) {
+	if _, ok := FindByName(newContainer); ok {
 		logging.LogError(
 			"destination name %s for container %s already exists",
 			newContainer,
@@ -296,30 +476,46 @@ func Rename(oldContainer string, newContainer string) error {
 		)
 	}
 
-	logging.LogDebug("renaming %s to %s, moving %s to %s",
-		oldContainer, newContainer, GetDir(oldContainer), GetDir(newContainer))
-
-	err := os.Rename(GetDir(oldContainer), GetDir(newContainer))
-	if err != nil {
-		logging.LogError("cannot rename %s to %s, error: %v", oldContainer, newContainer, err)
-		return fmt.Errorf("cannot rename %s to %s, error: %w", oldContainer, newContainer, err)
-	}
-
 	logging.LogDebug("adjusting config to reflect new name %s", newContainer)
 
-	config, err := utils.LoadConfig(filepath.Join(GetDir(newContainer), "config"))
+	configPath := filepath.Join(ContainerDir, id, "config")
+
+	config, err := utils.LoadConfig(configPath)
 	if err != nil {
 		logging.LogError("%+v", err)
 		return err
 	}
 
+	oldName := config.Names
+
+	// the ID does not depend on the name, so the container's dir stays the same
 	config.Names = newContainer
-	config.ID = GetID(newContainer)
-	config.Created = time.Now().Format("2006.01.02 15:04:05")
+
+	// running containers show their name in /run/.containerenv too
+	running := IsRunning(config.ID)
+	if running {
+		err = updateContainerEnv(config)
+		if err != nil {
+			return fmt.Errorf("cannot rename running container %s: %w, use --force to stop it first",
+				oldContainer, err)
+		}
+	}
 
 	logging.LogDebug("saving config for %s", newContainer)
 
-	return utils.SaveConfig(config, filepath.Join(GetDir(newContainer), "config"))
+	err = utils.SaveConfig(config, configPath)
+	if err != nil {
+		if running {
+			config.Names = oldName
+			_ = updateContainerEnv(config)
+		}
+
+		return err
+	}
+
+	events.Emit("rename", config, map[string]string{"oldName": oldContainer})
+
+	return nil
 }
 
 // Exec will enter the namespace of target container and execute the command needed.
@@ -330,25 +526,64 @@ func Exec(pid int, interactive bool, tty bool, config utils.Config) error {
 	logging.LogDebug("entering namespace of pid: %s", containerPid)
 	logging.LogDebug("setting up nsenter flags")
 
+	started := time.Now()
+
+	// let idle containers know they are in use
+	endSession := startSession(config)
+	defer endSession()
+
 	cmd := generateExecCommand(containerPid, tty, config)
-	if tty {
-		return procutils.RunWithTTY(cmd)
-	}
 
-	logging.LogDebug("tty not requested, setting up command pipes")
+	var err error
 
-	// in case we want interactive mode, but no tty
-	// just run the command and exchange outputs
-	if interactive && !tty {
-		return procutils.RunInteractive(cmd)
+	switch {
+	case tty:
+		err = procutils.RunWithTTY(cmd)
+	case interactive:
+		logging.LogDebug("tty not requested, setting up command pipes")
+
+		// in case we want interactive mode, but no tty
+		// just run the command and exchange outputs
+		err = procutils.RunInteractive(cmd)
+	default:
+		logfile := GetLogPath(config.Names, 0)
+
+		forward, closeForwarder := getLogForwarder(config)
+		err = procutils.RunDetached(cmd, logfile, forward)
+
+		closeForwarder()
 	}
 
-	logfile := filepath.Join(GetDir(config.Names), "current-logs")
+	recordExec(config, started, err)
 
-	return procutils.RunDetached(cmd, logfile)
+	return err
+}
+
+// ExecScript will execute the command needed inside target container, like Exec,
+// feeding it input script as stdin. The output is attached to the main process.
+func ExecScript(pid int, script io.Reader, config utils.Config) error {
+	containerPid := strconv.Itoa(pid)
+
+	logging.LogDebug("entering namespace of pid: %s", containerPid)
+
+	started := time.Now()
+
+	endSession := startSession(config)
+	defer endSession()
+
+	cmd := generateExecCommand(containerPid, false, config)
+	cmd.Stdin = script
+
+	err := procutils.RunAttached(cmd, procutils.Streams{Stdout: true, Stderr: true})
+
+	recordExec(config, started, err)
+
+	return err
 }
 
 // Stop will find all the processes in given container and will stop them.
+// The container's stop signal is sent first, then they are killed after
+// timeout seconds, or after the container's stop timeout if it is negative.
 func Stop(name string, force bool, timeout int) error {
 	logging.LogDebug("stopping container %s", name)
 
@@ -360,14 +595,47 @@ func Stop(name string, force bool, timeout int) error {
 	logging.LogDebug("container pid is %d", containerPid)
 	logging.LogDebug("terminating pid: %d", containerPid)
 
+	signal := unix.SIGTERM
+
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err == nil {
+		signal = getStopSignal(config)
+
+		if timeout < 0 {
+			timeout = getStopTimeout(config)
+		}
+
+		action := "stop"
+		if force {
+			action = "kill"
+		}
+
+		events.Emit(action, config, nil)
+	}
+
+	// the restart policy does not apply to stopped containers
+	markStopped(GetID(name))
+
+	// paused processes cannot handle the stop signal
+	if IsPaused(name) {
+		err = Unpause(name)
+		if err != nil {
+			logging.LogWarning("cannot unpause container %s: %v", name, err)
+		}
+	}
+
 	if force {
 		logging.LogDebug("killing process with pid: %d", containerPid)
 		return unix.Kill(containerPid, unix.SIGKILL)
 	}
 
-	logging.LogDebug("sending SIGTERM to pid: %d", containerPid)
+	if timeout < 0 {
+		timeout = DefaultStopTimeout
+	}
+
+	logging.LogDebug("sending %s to pid: %d", unix.SignalName(signal), containerPid)
 
-	err = unix.Kill(containerPid, unix.SIGTERM)
+	err = unix.Kill(containerPid, signal)
 	if err != nil {
 		return err
 	}
@@ -391,12 +659,81 @@ func Stop(name string, force bool, timeout int) error {
 	return nil
 }
 
+// Kill will send input signal to the init process of input running container,
+// eg: SIGHUP to reload the configuration of a daemon.
+// Containers killed with a terminating signal are not restarted.
+func Kill(name string, signal unix.Signal) error {
+	pids, err := getPids(name)
+	if err != nil {
+		return err
+	}
+
+	pid, err := getRootPid(pids)
+	if err != nil {
+		return err
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err == nil {
+		events.Emit("kill", config, map[string]string{"signal": unix.SignalName(signal)})
+	}
+
+	switch signal {
+	case unix.SIGKILL, unix.SIGTERM, unix.SIGINT, unix.SIGQUIT:
+		markStopped(GetID(name))
+	}
+
+	logging.LogDebug("sending %s to pid: %d", unix.SignalName(signal), pid)
+
+	return unix.Kill(pid, signal)
+}
+
+// Remove will delete input stopped container, with its rootfs and anonymous volumes.
+func Remove(name string) error {
+	targetDIR := GetDir(name)
+
+	// the container dir is named after its ID, used for the volumes too
+	containerID := filepath.Base(targetDIR)
+
+	config, err := utils.LoadConfig(filepath.Join(targetDIR, "config"))
+	if err != nil {
+		config = utils.Config{ID: containerID}
+	}
+
+	// Simple retry logic for unmount
+	err = fileutils.Umount(filepath.Join(targetDIR, "rootfs"))
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("deleting: %s in %s", name, targetDIR)
+
+	err = os.RemoveAll(targetDIR)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(
+		filepath.Join(utils.GetLilipodHome(), "volumes", containerID),
+	)
+	if err != nil {
+		return err
+	}
+
+	events.Emit("destroy", config, nil)
+
+	return nil
+}
+
 // Inspect will return a JSON or a formatted string describing the input containers.
 func Inspect(containers []string, size bool, format string) (string, error) {
 	result := ""
 
 	for _, container := range containers {
-		container = GetID(container)
+		container, err := ResolveID(container)
+		if err != nil {
+			return "", err
+		}
 
 		configPath := filepath.Join(ContainerDir, container, "config")
 
@@ -407,8 +744,18 @@ func Inspect(containers []string, size bool, format string) (string, error) {
 
 		config.Status = "stopped"
 
+		populateState(&config)
+
 		if IsRunning(config.Names) {
 			config.Status = "running"
+
+			if IsPaused(config.Names) {
+				config.Status = StatusPaused
+			}
+
+			if HasHealthcheck(config) {
+				config.Health, _ = GetHealth(config.Names)
+			}
 		}
 
 		if size {
@@ -468,13 +815,9 @@ func filterContainer(config utils.Config, filters map[string]string) bool {
 		switch name {
 		case "label":
 			labels := strings.Split(filter, constants.FilterSeparator)
-			for _, containerLabel := range config.Labels {
-				for _, filterLabel := range labels {
-					logging.LogDebug("filtering label: %s, %s", containerLabel, filterLabel)
-					if containerLabel == filterLabel {
-						matched++
-					}
-				}
+			logging.LogDebug("filtering labels: %v, %v", config.Labels, labels)
+			if matchLabels(config.Labels, labels) {
+				matched++
 			}
 		case "status":
 			logging.LogDebug("filtering status: %s, %s", config.Status, filter)
@@ -486,16 +829,116 @@ func filterContainer(config utils.Config, filters map[string]string) bool {
 			if config.Names == filter {
 				matched++
 			}
+		case "ancestor":
+			logging.LogDebug("filtering ancestor: %s, %s", config.Image, filter)
+			if IsAncestor(config, filter) {
+				matched++
+			}
 		case "id":
 			logging.LogDebug("filtering IDs: %s, %s", config.ID, filter)
-			if config.ID == filter {
+			if strings.HasPrefix(config.ID, filter) {
+				matched++
+			}
+		case "exited":
+			logging.LogDebug("filtering exit codes: %d, %s", config.ExitCode, filter)
+			// only containers that ran and are not running anymore have one
+			if config.Finished != "" && config.Status == "stopped" &&
+				strconv.Itoa(config.ExitCode) == filter {
+				matched++
+			}
+		case "network":
+			logging.LogDebug("filtering networks: %s, %s", config.Network, filter)
+			if config.Network == filter {
 				matched++
 			}
 		default:
 			logging.LogWarning("invalid filter %s, skipping", name)
-			logging.LogWarning("valid filters are: label, status, name, id")
+			logging.LogWarning("valid filters are: label, status, name, id, ancestor, exited, network")
 		}
 	}
 
 	return matched >= filterLen
 }
+
+// IsAncestor returns true if input container was created from input image.
+// The image can be specified by name, image id or manifest digest.
+func IsAncestor(config utils.Config, image string) bool {
+	if config.ImageDigest != "" && strings.HasPrefix(image, "sha256:") {
+		return strings.HasPrefix(config.ImageDigest, image)
+	}
+
+	return config.Image == image || imageutils.GetID(config.Image) == imageutils.GetID(image)
+}
+
+// GetDependentContainers returns the configs of the containers created from input image.
+func GetDependentContainers(image string) ([]utils.Config, error) {
+	result := []utils.Config{}
+
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, container := range containers {
+		config, err := utils.LoadConfig(filepath.Join(ContainerDir, container.Name(), "config"))
+		if err != nil {
+			continue
+		}
+
+		// already orphaned containers do not depend on the image anymore
+		if !config.ImageRemoved && IsAncestor(config, image) {
+			result = append(result, config)
+		}
+	}
+
+	return result, nil
+}
+
+// ListContainers returns the containers matching input filters, with their
+// current status, see GetContainerInfo.
+func ListContainers(filters map[string]string) ([]utils.Config, error) {
+	result := []utils.Config{}
+
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, container := range containers {
+		// hidden dirs are temporary ones of ongoing operations, eg: import
+		if !container.IsDir() || strings.HasPrefix(container.Name(), ".") {
+			continue
+		}
+
+		config, err := GetContainerInfo(container.Name(), false, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		if config != nil {
+			result = append(result, *config)
+		}
+	}
+
+	return result, nil
+}
+
+// GetStats returns a sample of the resources used by input running container.
+func GetStats(name string) (cgrouputils.Stats, error) {
+	pid, err := GetPid(name)
+	if err != nil {
+		return cgrouputils.Stats{}, err
+	}
+
+	logging.LogDebug("reading stats of %s", name)
+
+	return cgrouputils.GetStats(pid)
+}
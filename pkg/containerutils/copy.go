@@ -0,0 +1,432 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// CopyOptions are the options of a copy between the host and a container.
+type CopyOptions struct {
+	// Archive makes the copied files owned by the primary user of the
+	// destination, instead of keeping the ids of the source.
+	Archive bool
+	// FollowLink copies the target of the source, if it is a symlink.
+	FollowLink bool
+}
+
+// copyEndpoint is the source or destination of a copy: a path, and the root
+// it is resolved in, empty for the host.
+type copyEndpoint struct {
+	root string
+	path string
+}
+
+// resolve returns the path on the host of input path of the endpoint.
+// Paths in a container cannot point outside of its root, even through symlinks.
+func (e copyEndpoint) resolve(path string) (string, error) {
+	if e.root == "" {
+		return path, nil
+	}
+
+	return fileutils.SecurePath(e.root, path)
+}
+
+// resolveEntry returns the path on the host of input path of the endpoint,
+// without resolving its last component, so that symlinks are copied and
+// replaced instead of followed.
+func (e copyEndpoint) resolveEntry(path string) (string, error) {
+	parent, err := e.resolve(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+// getCopyRoot returns the path on the host of the root of input container.
+// For running containers we go through the container's own root, so that
+// its mounts and volumes are visible too, when we can access it.
+func getCopyRoot(name string) (string, error) {
+	pid, err := GetPid(name)
+	if err == nil {
+		root := filepath.Join("/proc", strconv.Itoa(pid), "root")
+
+		_, err = os.ReadDir(root)
+		if err == nil {
+			return root, nil
+		}
+
+		logging.LogDebug("cannot access root of %s, using its rootfs: %v", name, err)
+	}
+
+	return GetRootfsPath(name)
+}
+
+// getContainerOwner returns the ids on the host owning the files of the primary
+// user of input container.
+func getContainerOwner(config utils.Config, root string) (int, int, error) {
+	uid, gid := 0, 0
+
+	if config.User != "" {
+		user, group, hasGroup := strings.Cut(config.User, ":")
+
+		entry, err := GetPasswdEntry(config.ID, user)
+		if err == nil {
+			uid, _ = strconv.Atoi(entry.UID)
+			gid, _ = strconv.Atoi(entry.GID)
+		} else {
+			uid, err = strconv.Atoi(user)
+			if err != nil {
+				return -1, -1, err
+			}
+
+			gid = uid
+		}
+
+		if hasGroup {
+			gids, err := getGroupIDs(root, []string{group})
+			if err != nil {
+				return -1, -1, err
+			}
+
+			gid = gids[0]
+		}
+	}
+
+	// keep-id containers run in their own user namespace, the ids of their
+	// files on the host are shifted
+	if config.Userns == constants.KeepID && os.Getenv("ROOTFUL") != constants.TrueString {
+		uid = mapKeepID(uid, config.Uidmap)
+		gid = mapKeepID(gid, config.Gidmap)
+	}
+
+	return uid, gid, nil
+}
+
+// mapKeepID returns the id on the host of input id of a keep-id container,
+// with input map in the form of id:start:size.
+// The user is root on the host, ids lower than it are shifted by one.
+func mapKeepID(id int, idMap string) int {
+	user, err := strconv.Atoi(strings.Split(idMap, ":")[0])
+	if err != nil {
+		return id
+	}
+
+	switch {
+	case id == user:
+		return 0
+	case id < user:
+		return id + 1
+	default:
+		return id
+	}
+}
+
+// CopyToContainer will copy src of the host to dest of input container.
+func CopyToContainer(name string, src string, dest string, options CopyOptions) error {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return err
+	}
+
+	root, err := getCopyRoot(name)
+	if err != nil {
+		return err
+	}
+
+	owner := func(fs.FileInfo) (int, int) { return -1, -1 }
+
+	if options.Archive {
+		uid, gid, err := getContainerOwner(config, root)
+		if err != nil {
+			return fmt.Errorf("cannot find the user of container %s: %w", name, err)
+		}
+
+		owner = func(fs.FileInfo) (int, int) { return uid, gid }
+	}
+
+	return copyPath(copyEndpoint{path: src}, copyEndpoint{root: root, path: dest}, options, owner)
+}
+
+// CopyFromContainer will copy src of input container to dest of the host.
+func CopyFromContainer(name string, src string, dest string, options CopyOptions) error {
+	root, err := getCopyRoot(name)
+	if err != nil {
+		return err
+	}
+
+	owner := func(fs.FileInfo) (int, int) { return -1, -1 }
+
+	if options.Archive {
+		uid, gid := os.Getuid(), os.Getgid()
+
+		owner = func(fs.FileInfo) (int, int) { return uid, gid }
+	}
+
+	return copyPath(copyEndpoint{root: root, path: src}, copyEndpoint{path: dest}, options, owner)
+}
+
+// copyPath will copy the file or directory in src to dest, like cp -a:
+// a directory ending in /. has its content copied instead of itself, and
+// data copied to an existing directory is copied inside it.
+// Files are owned by the ids returned by owner, or keep the ones of the
+// source if they are negative.
+func copyPath(
+	src copyEndpoint,
+	dest copyEndpoint,
+	options CopyOptions,
+	owner func(fs.FileInfo) (int, int),
+) error {
+	source, err := src.resolveEntry(src.path)
+	if err != nil {
+		return err
+	}
+
+	if options.FollowLink {
+		source, err = src.resolve(src.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+
+	targetPath := dest.path
+
+	target, err := dest.resolve(targetPath)
+	if err != nil {
+		return err
+	}
+
+	targetInfo, err := os.Stat(target)
+
+	switch {
+	case err == nil && targetInfo.IsDir():
+		if !info.IsDir() || !strings.HasSuffix(src.path, "/.") {
+			targetPath = filepath.Join(targetPath, filepath.Base(filepath.Clean(src.path)))
+		}
+	case err == nil && info.IsDir():
+		return fmt.Errorf("cannot copy directory %s to file %s", src.path, dest.path)
+	case errors.Is(err, fs.ErrNotExist):
+		if strings.HasSuffix(dest.path, "/") && !info.IsDir() {
+			return fmt.Errorf("directory %s does not exist", dest.path)
+		}
+
+		parent, err := os.Stat(filepath.Dir(target))
+		if err != nil || !parent.IsDir() {
+			return fmt.Errorf("directory %s does not exist", filepath.Dir(dest.path))
+		}
+	case err != nil:
+		return err
+	}
+
+	dirs := []string{}
+	dirInfos := []fs.FileInfo{}
+
+	err = filepath.WalkDir(source, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		// the destination itself can be a symlink to follow, its content cannot
+		resolve := dest.resolveEntry
+		if relative == "." {
+			resolve = dest.resolve
+		}
+
+		destPath, err := resolve(filepath.Join(targetPath, relative))
+		if err != nil {
+			return err
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		uid, gid := owner(info)
+
+		err = copyEntry(path, destPath, info, uid, gid)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, destPath)
+			dirInfos = append(dirInfos, info)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the times of directories change while their content is copied
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err = setTimes(dirs[i], dirInfos[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyEntry will copy the single file, directory or symlink in path to dest,
+// with its permissions, times and extended attributes, owned by uid and gid,
+// or by the ids of path if they are negative.
+func copyEntry(path string, dest string, info fs.FileInfo, uid int, gid int) error {
+	var err error
+
+	switch {
+	case info.IsDir():
+		err = os.Mkdir(dest, info.Mode().Perm())
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+
+		_ = os.Remove(dest)
+
+		err = os.Symlink(link, dest)
+		if err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		err = copyContent(path, dest, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	default:
+		// devices, sockets and pipes are not copied
+		logging.LogWarning("skipping special file %s", path)
+
+		return nil
+	}
+
+	if uid < 0 || gid < 0 {
+		stat, ok := info.Sys().(*unix.Stat_t)
+		if ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+	}
+
+	// ids that are not mapped in our user namespace cannot be set
+	err = os.Lchown(dest, uid, gid)
+	if err != nil {
+		logging.LogDebug("cannot change owner of %s: %v", dest, err)
+	}
+
+	copyXattrs(path, dest)
+
+	// the mode is set after the owner, as chown clears the setuid bits
+	if info.Mode()&os.ModeSymlink == 0 {
+		err = os.Chmod(dest, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+		if err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	return setTimes(dest, info)
+}
+
+// setTimes will set the access and modification times of path to the
+// modification time in info, without following symlinks.
+func setTimes(path string, info fs.FileInfo) error {
+	times := []unix.Timespec{
+		unix.NsecToTimespec(info.ModTime().UnixNano()),
+		unix.NsecToTimespec(info.ModTime().UnixNano()),
+	}
+
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// copyContent will copy the content of source in dest, with input mode.
+func copyContent(source string, dest string, mode fs.FileMode) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = input.Close() }()
+
+	output, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = output.Close() }()
+
+	_, err = io.Copy(output, input)
+
+	return err
+}
+
+// copyXattrs will copy the extended attributes of path to dest. Attributes
+// that cannot be set, eg: trusted ones without privileges, are skipped.
+func copyXattrs(path string, dest string) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size <= 0 {
+		return
+	}
+
+	list := make([]byte, size)
+
+	size, err = unix.Llistxattr(path, list)
+	if err != nil {
+		return
+	}
+
+	for _, name := range strings.Split(strings.TrimRight(string(list[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+
+		valueSize, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+
+		value := make([]byte, valueSize)
+
+		valueSize, err = unix.Lgetxattr(path, name, value)
+		if err != nil {
+			continue
+		}
+
+		err = unix.Lsetxattr(dest, name, value[:valueSize], 0)
+		if err != nil {
+			logging.LogDebug("cannot set xattr %s of %s: %v", name, dest, err)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// dnsAddress is the address of the resolver of the containers of user
+// networks, in their network namespace, like the embedded one of docker.
+const dnsAddress = "127.0.0.11"
+
+const (
+	// dnsTimeout is how long an upstream server is waited for.
+	dnsTimeout = 2 * time.Second
+	// dnsTTL is the TTL of the answers for containers, in seconds, short as
+	// containers come and go.
+	dnsTTL = 10
+	// dnsRetryInterval is how often the resolver tries to listen, until the
+	// container is running, or the one serving its namespace stops.
+	dnsRetryInterval = time.Second
+)
+
+// DNS record types and response codes used by the resolver.
+const (
+	dnsTypeA        = 1
+	dnsTypeANY      = 255
+	dnsCodeServFail = 2
+)
+
+// getResolvConfPath returns the path of the resolv.conf of input container,
+// that points to the resolver of its network.
+func getResolvConfPath(name string) string {
+	return filepath.Join(GetDir(name), "resolv.conf")
+}
+
+// writeResolvConf will write the resolv.conf of input container, pointing to
+// the resolver of its network, with the search domains and options of the host.
+func writeResolvConf(config utils.Config) error {
+	content := "nameserver " + dnsAddress + "\n"
+
+	for _, line := range readResolvConf() {
+		if strings.HasPrefix(line, "search ") || strings.HasPrefix(line, "options ") {
+			content += line + "\n"
+		}
+	}
+
+	return os.WriteFile(getResolvConfPath(config.ID), []byte(content), 0o644)
+}
+
+// readResolvConf returns the lines of the host's resolv.conf.
+func readResolvConf() []string {
+	file, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		logging.LogDebug("cannot read host's resolv.conf: %v", err)
+
+		return nil
+	}
+
+	defer func() { _ = file.Close() }()
+
+	lines := []string{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+
+	return lines
+}
+
+// getUpstreamConfig returns the addresses of the nameservers of the host,
+// that the queries for other names are forwarded to, and its search domains.
+func getUpstreamConfig() ([]string, []string) {
+	servers := []string{}
+	domains := []string{}
+
+	for _, line := range readResolvConf() {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		case "search", "domain":
+			for _, domain := range fields[1:] {
+				domains = append(domains, strings.ToLower(strings.TrimSuffix(domain, ".")))
+			}
+		}
+	}
+
+	return servers, domains
+}
+
+// dnsResolver answers the queries for the names of the containers of a user
+// network, and their aliases, and forwards the other ones to the host's
+// nameservers.
+type dnsResolver struct {
+	config  utils.Config
+	servers []string
+	// domains are the search domains of the host, that clients may append
+	// to the names of the containers
+	domains []string
+	// upstream is a socket in the host's network, where the host's
+	// nameservers are reachable, even the ones on its localhost
+	upstream net.PacketConn
+	lock     sync.Mutex
+}
+
+// newDNSResolver returns the resolver of input container. It must be called
+// before its network is set up, to forward queries from the host's network.
+func newDNSResolver(config utils.Config) (*dnsResolver, error) {
+	upstream, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up dns forwarding: %w", err)
+	}
+
+	servers, domains := getUpstreamConfig()
+
+	return &dnsResolver{config: config, servers: servers, domains: domains, upstream: upstream}, nil
+}
+
+// Close will release the host's socket of the resolver.
+func (r *dnsResolver) Close() {
+	_ = r.upstream.Close()
+}
+
+// listenInNetwork returns a socket listening on input address, in the network
+// namespace of input process.
+func listenInNetwork(pid int, address string) (net.PacketConn, error) {
+	type result struct {
+		conn net.PacketConn
+		err  error
+	}
+
+	listening := make(chan result)
+
+	go func() {
+		// the thread is left in the namespace, so it is never unlocked, and is
+		// terminated with this goroutine
+		runtime.LockOSThread()
+
+		fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/net", pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			listening <- result{nil, err}
+
+			return
+		}
+
+		defer func() { _ = unix.Close(fd) }()
+
+		err = unix.Setns(fd, unix.CLONE_NEWNET)
+		if err != nil {
+			listening <- result{nil, err}
+
+			return
+		}
+
+		conn, err := net.ListenPacket("udp4", net.JoinHostPort(address, "53"))
+		listening <- result{conn, err}
+	}()
+
+	listen := <-listening
+
+	return listen.conn, listen.err
+}
+
+// serve will answer the queries in the network of the container, until done
+// is closed. The containers joining the namespace of a slirp network keep
+// trying, to take over once the one serving it stops.
+func (r *dnsResolver) serve(done chan struct{}) {
+	ticker := time.NewTicker(dnsRetryInterval)
+	defer ticker.Stop()
+
+	var conn net.PacketConn
+
+	stopped := make(chan struct{}, 1)
+
+	for {
+		select {
+		case <-done:
+			if conn != nil {
+				_ = conn.Close()
+			}
+
+			return
+		case <-stopped:
+			conn = nil
+		case <-ticker.C:
+			if conn != nil {
+				continue
+			}
+
+			pid, err := GetPid(r.config.ID)
+			if err != nil || pid <= 0 {
+				continue
+			}
+
+			conn, err = listenInNetwork(pid, dnsAddress)
+			if err != nil {
+				logging.LogDebug("cannot listen for dns queries: %v", err)
+
+				conn = nil
+
+				continue
+			}
+
+			logging.LogDebug("resolving names of network %s on %s", r.config.Network, dnsAddress)
+
+			go func(conn net.PacketConn) {
+				r.handle(conn)
+
+				stopped <- struct{}{}
+			}(conn)
+		}
+	}
+}
+
+// handle will answer the queries received on input socket, until it is closed.
+func (r *dnsResolver) handle(conn net.PacketConn) {
+	buffer := make([]byte, 4096)
+
+	for {
+		n, address, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		query := make([]byte, n)
+		copy(query, buffer[:n])
+
+		go func() {
+			response := r.resolve(query)
+			if response != nil {
+				_, _ = conn.WriteTo(response, address)
+			}
+		}()
+	}
+}
+
+// resolve returns the response to input query: the address of the container
+// with the name asked, or the one of the host's nameservers.
+func (r *dnsResolver) resolve(query []byte) []byte {
+	name, qtype, end, ok := parseDNSQuestion(query)
+	if !ok {
+		return nil
+	}
+
+	ip, found := r.lookup(name)
+	if found {
+		return buildDNSAnswer(query[:end], qtype, ip)
+	}
+
+	response, err := r.forward(query)
+	if err != nil {
+		logging.LogDebug("cannot resolve %s: %v", name, err)
+
+		return buildDNSError(query[:end], dnsCodeServFail)
+	}
+
+	return response
+}
+
+// lookup returns the address of the running container of the network with
+// input name or alias. The containers of slirp networks share the namespace,
+// so they are on localhost.
+func (r *dnsResolver) lookup(name string) (net.IP, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	candidates := []string{name}
+
+	for _, domain := range r.domains {
+		if base, ok := strings.CutSuffix(name, "."+domain); ok {
+			candidates = append(candidates, base)
+		}
+	}
+
+	containers, err := ListContainers(map[string]string{"network": r.config.Network})
+	if err != nil {
+		return nil, false
+	}
+
+	for _, container := range containers {
+		names := append([]string{container.Names}, container.NetworkAliases...)
+		if !slices.ContainsFunc(names, func(current string) bool {
+			return slices.Contains(candidates, strings.ToLower(current))
+		}) || !IsRunning(container.ID) {
+			continue
+		}
+
+		if getNetworkDriver(r.config.Network) == DriverSlirp {
+			return net.IPv4(127, 0, 0, 1), true
+		}
+
+		ip := net.ParseIP(container.IP)
+
+		return ip, ip != nil
+	}
+
+	return nil, false
+}
+
+// forward returns the response of the host's nameservers to input query, the
+// first one answering.
+func (r *dnsResolver) forward(query []byte) ([]byte, error) {
+	if len(r.servers) == 0 {
+		return nil, fmt.Errorf("no nameservers found in the host's resolv.conf")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	buffer := make([]byte, 65535)
+
+	var err error
+
+	for _, server := range r.servers {
+		var address *net.UDPAddr
+
+		address, err = net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			continue
+		}
+
+		_ = r.upstream.SetDeadline(time.Now().Add(dnsTimeout))
+
+		_, err = r.upstream.WriteTo(query, address)
+		if err != nil {
+			continue
+		}
+
+		for {
+			var n int
+
+			n, _, err = r.upstream.ReadFrom(buffer)
+			if err != nil {
+				break
+			}
+
+			// skip late responses to the previous queries
+			if n >= 2 && buffer[0] == query[0] && buffer[1] == query[1] {
+				response := make([]byte, n)
+				copy(response, buffer[:n])
+
+				return response, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// parseDNSQuestion returns the name and type asked by input query, and where
+// its question ends, if it is a standard query with a single question.
+func parseDNSQuestion(query []byte) (string, uint16, int, bool) {
+	// header: id, flags, questions, answers, authorities, additionals
+	if len(query) < 12 || query[2]&0x80 != 0 || binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return "", 0, 0, false
+	}
+
+	labels := []string{}
+	offset := 12
+
+	for {
+		if offset >= len(query) {
+			return "", 0, 0, false
+		}
+
+		length := int(query[offset])
+		offset++
+
+		if length == 0 {
+			break
+		}
+
+		// queries have no compressed names
+		if length&0xC0 != 0 || offset+length > len(query) {
+			return "", 0, 0, false
+		}
+
+		labels = append(labels, string(query[offset:offset+length]))
+		offset += length
+	}
+
+	// type and class
+	if offset+4 > len(query) {
+		return "", 0, 0, false
+	}
+
+	return strings.Join(labels, "."), binary.BigEndian.Uint16(query[offset : offset+2]), offset + 4, true
+}
+
+// buildDNSResponse returns the response header and question of input query
+// header and question, with input code and number of answers.
+func buildDNSResponse(question []byte, code byte, answers uint16) []byte {
+	response := make([]byte, len(question))
+	copy(response, question)
+
+	// response, authoritative, recursion desired as asked, and available
+	response[2] = 0x80 | 0x04 | question[2]&0x01
+	response[3] = 0x80 | code
+
+	binary.BigEndian.PutUint16(response[6:8], answers)
+	binary.BigEndian.PutUint16(response[8:10], 0)
+	binary.BigEndian.PutUint16(response[10:12], 0)
+
+	return response
+}
+
+// buildDNSError returns the response of input query header and question,
+// with input error code.
+func buildDNSError(question []byte, code byte) []byte {
+	return buildDNSResponse(question, code, 0)
+}
+
+// buildDNSAnswer returns the response of input query header and question
+// with input address, for A queries. The other types have no records, so that
+// clients do not ask the host's nameservers for containers.
+func buildDNSAnswer(question []byte, qtype uint16, ip net.IP) []byte {
+	if (qtype != dnsTypeA && qtype != dnsTypeANY) || ip.To4() == nil {
+		return buildDNSResponse(question, 0, 0)
+	}
+
+	response := buildDNSResponse(question, 0, 1)
+
+	// the name is the one of the question, right after the header
+	record := []byte{0xC0, 12, 0, dnsTypeA, 0, 1}
+	record = binary.BigEndian.AppendUint32(record, dnsTTL)
+	record = binary.BigEndian.AppendUint16(record, 4)
+	record = append(record, ip.To4()...)
+
+	return append(response, record...)
+}
@@ -14,22 +14,7 @@ import (
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
 	"github.com/89luca89/lilipod/pkg/utils"
-)
-
-// Linux syscall numbers from /usr/include/asm/unistd_64.h
-const (
-	SYS_UNSHARE = 272
-)
-
-// Clone flags from /usr/include/linux/sched.h
-const (
-	CLONE_NEWNS     = 0x00020000 // New mount namespace
-	CLONE_NEWUTS    = 0x04000000 // New utsname namespace
-	CLONE_NEWIPC    = 0x08000000 // New ipc namespace
-	CLONE_NEWUSER   = 0x10000000 // New user namespace
-	CLONE_NEWPID    = 0x20000000 // New pid namespace
-	CLONE_NEWNET    = 0x40000000 // New network namespace
-	CLONE_NEWCGROUP = 0x02000000 // New cgroup namespace
+	"golang.org/x/sys/unix"
 )
 
 // formatID formats a numeric ID as a string
@@ -52,18 +37,18 @@ func generateEnterCommand(config utils.Config) (*exec.Cmd, error) {
 		"enter",
 		"--config", string(configArg))
 
-	var cloneFlags uintptr
+	var cloneFlags int
 
 	// Always create new mount and UTS namespaces
-	cloneFlags |= CLONE_NEWNS | CLONE_NEWUTS
+	cloneFlags |= unix.CLONE_NEWNS | unix.CLONE_NEWUTS
 
 	if config.Userns == constants.KeepID &&
 		os.Getenv("ROOTFUL") != constants.TrueString {
-		cloneFlags |= CLONE_NEWUSER
+		cloneFlags |= unix.CLONE_NEWUSER
 	}
 
 	if config.Ipc == constants.Private {
-		cloneFlags |= CLONE_NEWIPC
+		cloneFlags |= unix.CLONE_NEWIPC
 	}
 
 	// Set up process attributes for namespace isolation
@@ -77,27 +62,31 @@ func generateEnterCommand(config utils.Config) (*exec.Cmd, error) {
 		},
 	}
 
-	// Use raw syscall for namespace setup
 	if cloneFlags != 0 {
-		_, _, errno := syscall.Syscall(SYS_UNSHARE, cloneFlags, 0, 0)
-		if errno != 0 {
-			return nil, fmt.Errorf("failed to unshare namespaces: %w", errno)
+		err := unix.Unshare(cloneFlags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unshare namespaces: %w", err)
 		}
 	}
 
 	// Handle network namespace setup
-	if config.Network == constants.Private {
+	if config.Network == constants.Private || IsUserNetwork(config.Network) {
 		// Set up network namespace using the existing helper
 		ns, err := setupNetworking(config)
 		if err != nil {
 			return nil, err
 		}
 
-		// Start slirp4netns for network connectivity
-		if err := ns.StartSlirp(os.Getpid()); err != nil {
+		// Start the network backend for network connectivity, the
+		// containers joining the namespace of a slirp network have none
+		if ns != nil {
+			err = ns.Start(os.Getpid())
+		}
+
+		if err != nil {
 			// Clean up on failure
 			_ = cleanupNetworking(ns)
-			return nil, fmt.Errorf("failed to start slirp4netns: %w", err)
+			return nil, fmt.Errorf("failed to start network backend: %w", err)
 		}
 
 		// The network namespace will be cleaned up when the container exits
@@ -105,11 +94,11 @@ func generateEnterCommand(config utils.Config) (*exec.Cmd, error) {
 	}
 
 	if config.Pid == constants.Private {
-		cloneFlags |= CLONE_NEWPID
+		cloneFlags |= unix.CLONE_NEWPID
 	}
 
 	if config.Cgroup == constants.Private {
-		cloneFlags |= CLONE_NEWCGROUP
+		cloneFlags |= unix.CLONE_NEWCGROUP
 	}
 
 	// Set up user/group credentials
@@ -146,13 +135,17 @@ func generateEnterCommand(config utils.Config) (*exec.Cmd, error) {
 // this command will respect the container's namespace configuration and will
 // let you execute an entrypoint in target namespace.
 func generateExecCommand(containerPid string, tty bool, config utils.Config) *exec.Cmd {
+	if IsExternalRuntime(config.Runtime) {
+		return generateRuntimeExecCommand(tty, config)
+	}
+
 	args := []string{"-m", "-u", "-U", "--preserve-credentials"}
 
 	if config.Ipc == constants.Private {
 		args = append(args, "-i")
 	}
 
-	if config.Network == constants.Private {
+	if config.Network != constants.Host {
 		args = append(args, "-n")
 	}
 
@@ -185,7 +178,7 @@ func generateExecCommand(containerPid string, tty bool, config utils.Config) *ex
 	logging.LogDebug("executing nsenter: %s %v", "nsenter", args)
 
 	cmd := exec.Command("nsenter", args...)
-	cmd.Env = config.Env
+	cmd.Env = appendLocaleEnv(config.Env)
 
 	return cmd
 }
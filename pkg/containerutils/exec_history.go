@@ -0,0 +1,125 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// ExecRecord describes a completed exec session inside a container.
+type ExecRecord struct {
+	HostUser string   `json:"hostuser"`
+	User     string   `json:"user"`
+	Started  string   `json:"started"`
+	Command  []string `json:"command"`
+	ExitCode int      `json:"exitcode"`
+	Duration string   `json:"duration"`
+}
+
+// getExecHistoryPath returns the path of the exec history file for input container.
+func getExecHistoryPath(name string) string {
+	return filepath.Join(GetDir(name), "exec-history")
+}
+
+// GetExitCode returns the exit code corresponding to input error returned
+// by a process execution. A nil error is a 0 exit code, errors not coming
+// from the process itself are reported as -1.
+func GetExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// processes killed by a signal are reported as 128+signal, like shells do
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+// AppendExecHistory will record a completed exec session in the container's history.
+func AppendExecHistory(name string, record ExecRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return logging.AppendStringToFile(getExecHistoryPath(name), string(line))
+}
+
+// GetExecHistory returns all the recorded exec sessions for input container,
+// oldest first.
+func GetExecHistory(name string) ([]ExecRecord, error) {
+	result := []ExecRecord{}
+
+	file, err := os.Open(getExecHistoryPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record ExecRecord
+
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			logging.LogWarning("skipping invalid exec history entry: %v", err)
+
+			continue
+		}
+
+		result = append(result, record)
+	}
+
+	return result, scanner.Err()
+}
+
+// getHostUser returns the name of the user executing lilipod on the host.
+func getHostUser() string {
+	current, err := user.Current()
+	if err != nil {
+		return os.Getenv("USER")
+	}
+
+	return current.Username
+}
+
+// recordExec will save the exec session of input config, that started at
+// input time, in the container's history. Failures are only logged.
+func recordExec(config utils.Config, started time.Time, execErr error) {
+	record := ExecRecord{
+		HostUser: getHostUser(),
+		User:     config.User,
+		Started:  started.Format(time.RFC3339),
+		Command:  config.Entrypoint,
+		ExitCode: GetExitCode(execErr),
+		Duration: time.Since(started).Round(time.Millisecond).String(),
+	}
+
+	err := AppendExecHistory(config.Names, record)
+	if err != nil {
+		logging.LogWarning("cannot record exec session: %v", err)
+	}
+}
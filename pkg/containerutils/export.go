@@ -0,0 +1,43 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+)
+
+// Export will write the filesystem of input container as a tar archive to
+// output, keeping numeric ownership and xattrs.
+// The content of the pseudo filesystems, eg: /proc, is left out.
+func Export(name string, output io.Writer) error {
+	rootfs, err := GetRootfsPath(name)
+	if err != nil {
+		return err
+	}
+
+	if IsRunning(name) {
+		logging.LogWarning("container %s is running, the archive could be inconsistent", name)
+	}
+
+	logging.LogDebug("exporting %s from %s", name, rootfs)
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("tar",
+		"--numeric-owner", "--xattrs", "--xattrs-include=*",
+		"--exclude=./dev/*", "--exclude=./proc/*", "--exclude=./sys/*",
+		"-cf", "-", "-C", rootfs, ".")
+	cmd.Stdout = output
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w: %s", name, err, stderr.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Health statuses of a container.
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// healthLogSize is the number of probe results kept in the health state.
+const healthLogSize = 5
+
+// healthOutputSize is the maximum size of a probe output that is kept.
+const healthOutputSize = 4096
+
+// getHealthPath returns the path of the health state file for input container.
+func getHealthPath(name string) string {
+	return filepath.Join(GetDir(name), "health")
+}
+
+// HasHealthcheck returns true if input config has an enabled healthcheck.
+func HasHealthcheck(config utils.Config) bool {
+	return config.Healthcheck != nil &&
+		len(config.Healthcheck.Test) > 0 &&
+		config.Healthcheck.Test[0] != "NONE"
+}
+
+// GetHealth returns the current health state of input container.
+func GetHealth(name string) (*utils.HealthState, error) {
+	file, err := fileutils.ReadFile(getHealthPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var state utils.HealthState
+
+	err = json.Unmarshal(file, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// saveHealth will write input health state for input container.
+func saveHealth(name string, state utils.HealthState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(getHealthPath(name), data, 0o644)
+}
+
+// getHealthDuration will parse input duration string, falling back to
+// input default if it's empty or invalid.
+func getHealthDuration(duration string, fallback time.Duration) time.Duration {
+	if duration == "" {
+		return fallback
+	}
+
+	result, err := time.ParseDuration(duration)
+	if err != nil || result < 0 {
+		logging.LogWarning("invalid healthcheck duration %s, using %s", duration, fallback)
+
+		return fallback
+	}
+
+	// zero means to inherit the default, as in the image config
+	if result == 0 {
+		return fallback
+	}
+
+	return result
+}
+
+// getHealthEntrypoint will convert the healthcheck test in a command to execute.
+// CMD-SHELL tests are executed with /bin/sh, CMD tests are executed as is.
+func getHealthEntrypoint(test []string) ([]string, error) {
+	if len(test) == 0 {
+		return nil, fmt.Errorf("empty healthcheck test")
+	}
+
+	switch test[0] {
+	case "CMD-SHELL":
+		return []string{"/bin/sh", "-c", strings.Join(test[1:], " ")}, nil
+	case "CMD":
+		if len(test) < 2 {
+			return nil, fmt.Errorf("empty healthcheck command")
+		}
+
+		return test[1:], nil
+	case "NONE":
+		return nil, fmt.Errorf("healthcheck is disabled")
+	default:
+		// plain commands, eg: from --health-cmd
+		return []string{"/bin/sh", "-c", strings.Join(test, " ")}, nil
+	}
+}
+
+// RunHealthcheck will execute the healthcheck probe of input container, update its
+// health state and emit a health_status event if the status changed.
+func RunHealthcheck(name string) (*utils.HealthState, error) {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !HasHealthcheck(config) {
+		return nil, fmt.Errorf("container %s has no healthcheck", name)
+	}
+
+	pid, err := GetPid(name)
+	if err != nil {
+		return nil, fmt.Errorf("container %s is not running", name)
+	}
+
+	entrypoint, err := getHealthEntrypoint(config.Healthcheck.Test)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := GetHealth(name)
+	if err != nil {
+		state = &utils.HealthState{Status: HealthStarting}
+	}
+
+	previous := state.Status
+	timeout := getHealthDuration(config.Healthcheck.Timeout, 30*time.Second)
+
+	logging.LogDebug("running healthcheck %v for %s", entrypoint, name)
+
+	config.Entrypoint = entrypoint
+
+	var output bytes.Buffer
+
+	cmd := generateExecCommand(strconv.Itoa(pid), false, config)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+
+	err = cmd.Start()
+	if err == nil {
+		timer := time.AfterFunc(timeout, func() {
+			logging.LogWarning("healthcheck for %s timed out after %s", name, timeout)
+
+			_ = cmd.Process.Kill()
+		})
+
+		err = cmd.Wait()
+
+		timer.Stop()
+	}
+
+	exitCode := GetExitCode(err)
+
+	probeOutput := output.String()
+	if len(probeOutput) > healthOutputSize {
+		probeOutput = probeOutput[:healthOutputSize]
+	}
+
+	state.Log = append(state.Log, utils.HealthLogEntry{
+		Start:    start.Format(time.RFC3339Nano),
+		End:      time.Now().Format(time.RFC3339Nano),
+		ExitCode: exitCode,
+		Output:   probeOutput,
+	})
+	if len(state.Log) > healthLogSize {
+		state.Log = state.Log[len(state.Log)-healthLogSize:]
+	}
+
+	if exitCode == 0 {
+		state.FailingStreak = 0
+		state.Status = HealthHealthy
+	} else {
+		state.FailingStreak++
+
+		retries := config.Healthcheck.Retries
+		if retries <= 0 {
+			retries = 3
+		}
+
+		if state.FailingStreak >= retries {
+			state.Status = HealthUnhealthy
+		}
+	}
+
+	err = saveHealth(name, *state)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Status != previous {
+		logging.LogDebug("container %s is now %s", name, state.Status)
+
+		events.Emit("health_status", config, map[string]string{"status": state.Status})
+	}
+
+	return state, nil
+}
+
+// monitorHealth will periodically run the healthcheck of input container until
+// the done channel is closed.
+func monitorHealth(config utils.Config, done chan struct{}) {
+	interval := getHealthDuration(config.Healthcheck.Interval, 30*time.Second)
+
+	err := saveHealth(config.Names, utils.HealthState{Status: HealthStarting, Log: []utils.HealthLogEntry{}})
+	if err != nil {
+		logging.LogWarning("cannot initialize health state: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			_, err := RunHealthcheck(config.Names)
+			if err != nil {
+				logging.LogDebug("healthcheck failed to run: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// The stages of a container's lifecycle hooks can run at.
+const (
+	HookPreStart  = "pre-start"
+	HookPostStart = "post-start"
+	HookPostStop  = "post-stop"
+)
+
+// hookTimeout is how long a hook can run for, before being killed.
+const hookTimeout = time.Minute
+
+// HooksDir holds the hooks run for all the containers, the executables in
+// a directory per stage, eg: hooks/pre-start.d.
+var HooksDir = filepath.Join(utils.GetLilipodHome(), "hooks")
+
+// getHooks returns the commands to run at input stage for input container:
+// the executables in the stage's directory of HooksDir in lexical order, then
+// the container's own hooks.
+func getHooks(config utils.Config, stage string) [][]string {
+	result := [][]string{}
+
+	dir := filepath.Join(HooksDir, stage+".d")
+
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		names := []string{}
+
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || strings.HasPrefix(entry.Name(), ".") ||
+				!info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+				continue
+			}
+
+			names = append(names, entry.Name())
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			result = append(result, []string{filepath.Join(dir, name)})
+		}
+	}
+
+	if config.Hooks == nil {
+		return result
+	}
+
+	var hooks []string
+
+	switch stage {
+	case HookPreStart:
+		hooks = config.Hooks.PreStart
+	case HookPostStart:
+		hooks = config.Hooks.PostStart
+	case HookPostStop:
+		hooks = config.Hooks.PostStop
+	}
+
+	for _, hook := range hooks {
+		result = append(result, []string{"sh", "-c", hook})
+	}
+
+	return result
+}
+
+// runHooks will run the hooks of input stage for input container one after
+// the other, stopping at the first failing one. The hooks find the container
+// in their environment, in LILIPOD_CONTAINER_ID, LILIPOD_CONTAINER_NAME and
+// LILIPOD_CONTAINER_ROOTFS, and the stage in LILIPOD_HOOK, with input env too.
+func runHooks(config utils.Config, stage string, env ...string) error {
+	for _, hook := range getHooks(config, stage) {
+		logging.LogDebug("running %s hook of %s: %v", stage, config.Names, hook)
+
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+
+		cmd := exec.CommandContext(ctx, hook[0], hook[1:]...)
+		cmd.Env = append(os.Environ(),
+			"LILIPOD_HOOK="+stage,
+			"LILIPOD_CONTAINER_ID="+config.ID,
+			"LILIPOD_CONTAINER_NAME="+config.Names,
+			"LILIPOD_CONTAINER_ROOTFS="+GetRootfsDir(config.ID),
+		)
+		cmd.Env = append(cmd.Env, env...)
+
+		output, err := cmd.CombinedOutput()
+
+		cancel()
+
+		logging.LogDebug("%s hook output: %s", stage, output)
+
+		if err != nil {
+			return fmt.Errorf("%s hook %s of container %s failed: %w: %s",
+				stage, hook[len(hook)-1], config.Names, err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	return nil
+}
+
+// runPostStartHooks will run the post-start hooks of input container as soon
+// as its process is running, with its pid in LILIPOD_CONTAINER_PID, unless the
+// done channel is closed first.
+func runPostStartHooks(config utils.Config, done chan struct{}) {
+	if len(getHooks(config, HookPostStart)) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			pid, err := GetPid(config.ID)
+			if err != nil || pid < 1 {
+				continue
+			}
+
+			err = runHooks(config, HookPostStart, "LILIPOD_CONTAINER_PID="+strconv.Itoa(pid))
+			if err != nil {
+				logging.LogWarning("%v", err)
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// idleCheckInterval is how often the sessions of a container with an idle
+// timeout are checked.
+const idleCheckInterval = 10 * time.Second
+
+// ValidateIdleTimeout will check that input idle timeout is valid.
+func ValidateIdleTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return fmt.Errorf("invalid idle timeout %s: %w", timeout, err)
+	}
+
+	if duration < time.Second {
+		return fmt.Errorf("idle timeout must be at least 1s")
+	}
+
+	return nil
+}
+
+// getSessionsDir returns the path of the directory where the exec sessions
+// of input container are registered, one file per lilipod process.
+func getSessionsDir(name string) string {
+	return filepath.Join(GetDir(name), "sessions")
+}
+
+// startSession will register an exec session of the current process in input
+// container, and return the function to unregister it once it ends.
+func startSession(config utils.Config) func() {
+	path := filepath.Join(getSessionsDir(config.ID), strconv.Itoa(os.Getpid()))
+
+	err := os.MkdirAll(getSessionsDir(config.ID), 0o755)
+	if err == nil {
+		err = os.WriteFile(path, []byte{}, 0o644)
+	}
+
+	if err != nil {
+		logging.LogDebug("cannot register exec session: %v", err)
+	}
+
+	return func() { _ = os.Remove(path) }
+}
+
+// countSessions returns the number of exec sessions of input container, whose
+// process is still running. The ones of processes that did not unregister
+// them, eg: killed, are removed.
+func countSessions(name string) int {
+	entries, err := os.ReadDir(getSessionsDir(name))
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		// processes of other users still exist, we just cannot signal them
+		err = unix.Kill(pid, 0)
+		if errors.Is(err, unix.ESRCH) {
+			_ = os.Remove(filepath.Join(getSessionsDir(name), entry.Name()))
+
+			continue
+		}
+
+		count++
+	}
+
+	return count
+}
+
+// monitorIdle will stop input container once it had no exec sessions, nor
+// clients attached to input server, for its idle timeout, until done is closed.
+func monitorIdle(config utils.Config, attach *attachServer, done chan struct{}) {
+	timeout, err := time.ParseDuration(config.IdleTimeout)
+	if err != nil {
+		logging.LogWarning("invalid idle timeout %s: %v", config.IdleTimeout, err)
+
+		return
+	}
+
+	ticker := time.NewTicker(min(idleCheckInterval, timeout))
+	defer ticker.Stop()
+
+	lastActive := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if countSessions(config.ID) > 0 || (attach != nil && attach.countClients() > 0) {
+				lastActive = now
+
+				continue
+			}
+
+			if now.Sub(lastActive) < timeout {
+				continue
+			}
+
+			logging.LogWarning("container %s was idle for %s, stopping it", config.Names, timeout)
+
+			err := Stop(config.ID, false, -1)
+			if err != nil {
+				logging.LogWarning("cannot stop idle container %s: %v", config.Names, err)
+			}
+
+			return
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// invalidKubeName matches the chars not allowed in kubernetes resource names.
+var invalidKubeName = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// kubeWriter is a minimal YAML emitter, all strings are double quoted so that
+// no value can be misinterpreted.
+type kubeWriter struct {
+	builder strings.Builder
+}
+
+func (k *kubeWriter) line(indent int, format string, args ...any) {
+	k.builder.WriteString(strings.Repeat("  ", indent))
+	k.builder.WriteString(fmt.Sprintf(format, args...))
+	k.builder.WriteString("\n")
+}
+
+func (k *kubeWriter) value(indent int, key string, value string) {
+	k.line(indent, "%s: %s", key, strconv.Quote(value))
+}
+
+func (k *kubeWriter) list(indent int, key string, values []string) {
+	k.line(indent, "%s:", key)
+
+	for _, value := range values {
+		k.line(indent, "- %s", strconv.Quote(value))
+	}
+}
+
+func (k *kubeWriter) dict(indent int, key string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+
+	k.line(indent, "%s:", key)
+
+	keys := []string{}
+	for name := range values {
+		keys = append(keys, name)
+	}
+
+	sort.Strings(keys)
+
+	for _, name := range keys {
+		k.value(indent+1, strconv.Quote(name), values[name])
+	}
+}
+
+// getKubeName converts input name to a valid kubernetes resource name.
+func getKubeName(name string) string {
+	return strings.Trim(invalidKubeName.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// GenerateKube will describe input containers as a kubernetes Pod YAML,
+// translating their image, command, env, volumes and healthchecks.
+// The pod shares the host namespaces of the first container.
+func GenerateKube(containers []string, podName string) (string, error) {
+	configs := []utils.Config{}
+
+	for _, container := range containers {
+		config, err := utils.LoadConfig(filepath.Join(GetDir(container), "config"))
+		if err != nil {
+			return "", err
+		}
+
+		configs = append(configs, config)
+	}
+
+	if len(configs) == 0 {
+		return "", fmt.Errorf("no containers specified")
+	}
+
+	if podName == "" {
+		podName = configs[0].Names + "-pod"
+	}
+
+	pod := configs[0]
+	volumes := &kubeWriter{}
+	result := &kubeWriter{}
+
+	result.line(0, "# Generated by lilipod %s on %s", constants.Version, time.Now().Format(time.RFC3339))
+	result.value(0, "apiVersion", "v1")
+	result.value(0, "kind", "Pod")
+	result.line(0, "metadata:")
+	result.value(1, "name", getKubeName(podName))
+	result.dict(1, "labels", map[string]string{"app": getKubeName(podName)})
+	// labels have a stricter syntax in kubernetes, so we keep them as annotations
+	result.dict(1, "annotations", pod.Labels)
+	result.line(0, "spec:")
+	result.value(1, "hostname", pod.Hostname)
+
+	if pod.Network == constants.Host {
+		result.line(1, "hostNetwork: true")
+	}
+
+	if pod.Pid == constants.Host {
+		result.line(1, "hostPID: true")
+	}
+
+	if pod.Ipc == constants.Host {
+		result.line(1, "hostIPC: true")
+	}
+
+	result.line(1, "containers:")
+
+	for _, config := range configs {
+		if config.Network != pod.Network || config.Pid != pod.Pid || config.Ipc != pod.Ipc {
+			logging.LogWarning("container %s namespaces differ from the pod, using the ones of %s",
+				config.Names, pod.Names)
+		}
+
+		err := writeKubeContainer(result, volumes, config)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if volumes.builder.Len() > 0 {
+		result.line(1, "volumes:")
+		result.builder.WriteString(volumes.builder.String())
+	}
+
+	return result.builder.String(), nil
+}
+
+// writeKubeContainer will add input container to the pod's containers, and its
+// volumes to the pod's volumes.
+func writeKubeContainer(result *kubeWriter, volumes *kubeWriter, config utils.Config) error {
+	name := getKubeName(config.Names)
+
+	result.value(1, "- name", name)
+	result.value(2, "image", config.Image)
+
+	if len(config.Entrypoint) > 0 {
+		result.list(2, "command", config.Entrypoint)
+	}
+
+	env := getKubeEnv(config)
+	if len(env) > 0 {
+		result.line(2, "env:")
+
+		for _, variable := range env {
+			key, value, _ := strings.Cut(variable, "=")
+
+			result.value(2, "- name", key)
+			result.value(3, "value", value)
+		}
+	}
+
+	if config.Workdir != "" && config.Workdir != "/" {
+		result.value(2, "workingDir", config.Workdir)
+	}
+
+	writeKubeSecurityContext(result, config)
+
+	mounts, err := getSpecVolumes(config)
+	if err != nil {
+		return err
+	}
+
+	if len(mounts) > 0 {
+		result.line(2, "volumeMounts:")
+	}
+
+	anonymousDir := filepath.Join(utils.GetLilipodHome(), "volumes")
+
+	for i, mount := range mounts {
+		volumeName := fmt.Sprintf("%s-volume-%d", name, i)
+
+		result.value(2, "- name", volumeName)
+		result.value(3, "mountPath", mount.Destination)
+
+		for _, option := range mount.Options {
+			if option == "ro" {
+				result.line(3, "readOnly: true")
+			}
+		}
+
+		volumes.value(1, "- name", volumeName)
+
+		switch {
+		case mount.Type == "tmpfs":
+			volumes.line(2, "emptyDir:")
+			volumes.value(3, "medium", "Memory")
+		case strings.HasPrefix(mount.Source, NamedVolumeDir+"/"):
+			volumes.line(2, "persistentVolumeClaim:")
+			volumes.value(3, "claimName", getKubeName(filepath.Base(mount.Source)))
+		case strings.HasPrefix(mount.Source, anonymousDir):
+			volumes.line(2, "emptyDir: {}")
+		default:
+			volumeType := "DirectoryOrCreate"
+
+			info, err := os.Stat(mount.Source)
+			if err == nil && !info.IsDir() {
+				volumeType = "File"
+			}
+
+			volumes.line(2, "hostPath:")
+			volumes.value(3, "path", mount.Source)
+			volumes.value(3, "type", volumeType)
+		}
+	}
+
+	if HasHealthcheck(config) {
+		command, err := getHealthEntrypoint(config.Healthcheck.Test)
+		if err != nil {
+			return err
+		}
+
+		retries := config.Healthcheck.Retries
+		if retries <= 0 {
+			retries = 3
+		}
+
+		result.line(2, "livenessProbe:")
+		result.line(3, "exec:")
+		result.list(4, "command", command)
+		result.line(3, "periodSeconds: %d",
+			int(getHealthDuration(config.Healthcheck.Interval, 30*time.Second).Seconds()))
+		result.line(3, "timeoutSeconds: %d",
+			int(getHealthDuration(config.Healthcheck.Timeout, 30*time.Second).Seconds()))
+		result.line(3, "failureThreshold: %d", retries)
+	}
+
+	return nil
+}
+
+// writeKubeSecurityContext will add the privileges and user of input container.
+func writeKubeSecurityContext(result *kubeWriter, config utils.Config) {
+	context := []string{}
+
+	if config.Privileged {
+		context = append(context, "privileged: true")
+	}
+
+	username, groupname, _ := strings.Cut(config.User, ":")
+
+	uid, err := strconv.Atoi(username)
+	if err != nil && username != "" {
+		entry, err := GetPasswdEntry(config.ID, config.User)
+		if err == nil {
+			uid, _ = strconv.Atoi(entry.UID)
+		}
+	}
+
+	if uid > 0 {
+		context = append(context, fmt.Sprintf("runAsUser: %d", uid))
+	}
+
+	gid, err := strconv.Atoi(groupname)
+	if err == nil && gid > 0 {
+		context = append(context, fmt.Sprintf("runAsGroup: %d", gid))
+	}
+
+	if len(context) == 0 {
+		return
+	}
+
+	result.line(2, "securityContext:")
+
+	for _, line := range context {
+		result.line(3, "%s", line)
+	}
+}
+
+// getKubeEnv returns the env of input container, without the variables
+// inherited from the image or set by lilipod itself.
+func getKubeEnv(config utils.Config) []string {
+	imageEnv := map[string]bool{
+		"HOSTNAME=" + config.Hostname: true,
+		"TERM=xterm":                  true,
+	}
+
+	imageConfigFile, err := fileutils.ReadFile(filepath.Join(imageutils.GetPath(config.Image), "config.json"))
+	if err == nil {
+		var imageConfig v1.ConfigFile
+
+		err = json.Unmarshal(imageConfigFile, &imageConfig)
+		if err == nil {
+			for _, variable := range imageConfig.Config.Env {
+				imageEnv[variable] = true
+			}
+		}
+	}
+
+	result := []string{}
+
+	for _, variable := range config.Env {
+		if !imageEnv[variable] {
+			result = append(result, variable)
+		}
+	}
+
+	return result
+}
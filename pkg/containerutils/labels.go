@@ -0,0 +1,65 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// UpdateLabels will set input labels, in the key=value form, on input
+// container, and remove the ones with input keys.
+// Labels are only metadata, so running containers can be updated too.
+// Removing a label the container does not have is not an error, so that
+// scripts can run it again.
+func UpdateLabels(name string, add []string, remove []string) error {
+	configPath := filepath.Join(GetDir(name), "config")
+
+	config, err := utils.LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+
+	for _, label := range add {
+		key, value, _ := strings.Cut(label, "=")
+		if key == "" {
+			return fmt.Errorf("invalid label %s, use key=value", label)
+		}
+
+		config.Labels[key] = value
+	}
+
+	for _, key := range remove {
+		if _, ok := config.Labels[key]; !ok {
+			logging.LogDebug("container %s has no label %s, skipping", config.Names, key)
+
+			continue
+		}
+
+		delete(config.Labels, key)
+	}
+
+	return utils.SaveConfig(config, configPath)
+}
+
+// matchLabels returns whether input labels match all the filters, in the
+// key or key=value form.
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		key, value, hasValue := strings.Cut(filter, "=")
+
+		current, ok := labels[key]
+		if !ok || (hasValue && current != value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,131 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+)
+
+// isLocaleVariable returns true if input variable name is locale related.
+func isLocaleVariable(name string) bool {
+	return name == "LANG" || name == "LANGUAGE" || strings.HasPrefix(name, "LC_")
+}
+
+// appendLocaleEnv will append the host's LANG, LANGUAGE and LC_* variables to
+// input env, unless they're already set by the user or the image.
+func appendLocaleEnv(env []string) []string {
+	for _, variable := range os.Environ() {
+		name, _, found := strings.Cut(variable, "=")
+		if !found || !isLocaleVariable(name) || hasEnv(env, name) {
+			continue
+		}
+
+		env = append(env, variable)
+	}
+
+	return env
+}
+
+// getRequiredLocales returns the locales set in input env that are not
+// built in the C library, eg: en_US.UTF-8.
+func getRequiredLocales(env []string) []string {
+	result := []string{}
+
+	for _, variable := range env {
+		name, value, found := strings.Cut(variable, "=")
+		// LANGUAGE is a priority list, not a locale
+		if !found || !isLocaleVariable(name) || name == "LANGUAGE" {
+			continue
+		}
+
+		switch value {
+		case "", "C", "POSIX", "C.UTF-8", "C.utf8":
+			continue
+		}
+
+		found = false
+
+		for _, locale := range result {
+			if locale == value {
+				found = true
+			}
+		}
+
+		if !found {
+			result = append(result, value)
+		}
+	}
+
+	return result
+}
+
+// normalizeLocale will convert input locale name in the form used by
+// localedef --list-archive, eg: en_US.UTF-8 -> en_US.utf8.
+func normalizeLocale(locale string) string {
+	name, codeset, found := strings.Cut(locale, ".")
+	if !found {
+		return locale
+	}
+
+	codeset, modifier, hasModifier := strings.Cut(codeset, "@")
+	codeset = strings.ToLower(strings.ReplaceAll(codeset, "-", ""))
+
+	if hasModifier {
+		return name + "." + codeset + "@" + modifier
+	}
+
+	return name + "." + codeset
+}
+
+// generateLocales will generate the locales required by input env with localedef,
+// if they're not already available in the container.
+// This is only supported inside glibc based images, and must be called after
+// the pivot root and before dropping privileges.
+func generateLocales(env []string) {
+	localedef, err := exec.LookPath("localedef")
+	if err != nil {
+		logging.LogDebug("localedef not found, skipping locale generation")
+
+		return
+	}
+
+	available, err := exec.Command(localedef, "--list-archive").Output()
+	if err != nil {
+		logging.LogDebug("cannot list available locales: %v", err)
+	}
+
+	for _, locale := range getRequiredLocales(env) {
+		normalized := normalizeLocale(locale)
+		if strings.Contains("\n"+string(available)+"\n", "\n"+normalized+"\n") {
+			logging.LogDebug("locale %s already available", locale)
+
+			continue
+		}
+
+		// Locale has a structure:
+		//    language_territory.codeset@modifier
+		input, codeset, _ := strings.Cut(locale, ".")
+		codeset, modifier, hasModifier := strings.Cut(codeset, "@")
+
+		if hasModifier {
+			input += "@" + modifier
+		}
+
+		args := []string{"-i", input}
+		if codeset != "" {
+			args = append(args, "-f", codeset)
+		}
+
+		args = append(args, locale)
+
+		logging.LogDebug("generating locale %s: localedef %v", locale, args)
+
+		out, err := exec.Command(localedef, args...).CombinedOutput()
+		if err != nil {
+			logging.LogWarning("cannot generate locale %s: %v: %s", locale, err, string(out))
+		}
+	}
+}
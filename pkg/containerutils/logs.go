@@ -0,0 +1,95 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logdriver"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// getLogForwarder returns a function forwarding output lines to the log driver
+// of input container, and a function to close the driver once finished.
+// Output is always saved to the local log file too, so if the driver cannot be
+// set up we only warn and return a nil forwarder.
+func getLogForwarder(config utils.Config) (func(string, string), func()) {
+	driver, err := logdriver.New(config)
+	if err != nil {
+		logging.LogWarning("cannot set up log driver %s: %v", config.LogDriver, err)
+
+		return nil, func() {}
+	}
+
+	if driver == nil {
+		return nil, func() {}
+	}
+
+	var lock sync.Mutex
+
+	forward := func(stream string, line string) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		err := driver.Log(stream, time.Now(), line)
+		if err != nil {
+			logging.LogDebug("cannot forward log line to %s: %v", config.LogDriver, err)
+		}
+	}
+
+	closer := func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		_ = driver.Close()
+	}
+
+	return forward, closer
+}
+
+// MaxPreviousLogs is the number of logs from previous runs kept for each container.
+const MaxPreviousLogs = 5
+
+// GetLogPath returns the path of the log file of input container.
+// Previous is the number of runs to go back, 0 is the current run,
+// 1 is the previous one and so on.
+func GetLogPath(name string, previous int) string {
+	if previous <= 0 {
+		return filepath.Join(GetDir(name), "current-logs")
+	}
+
+	return filepath.Join(GetDir(name), fmt.Sprintf("previous-logs.%d", previous))
+}
+
+// rotateLogs will move the current logs of input container to previous-logs.1,
+// shifting older logs and keeping at most MaxPreviousLogs of them.
+func rotateLogs(name string) error {
+	if !fileutils.Exist(GetLogPath(name, 0)) {
+		return nil
+	}
+
+	logging.LogDebug("rotating logs for %s", name)
+
+	err := os.Remove(GetLogPath(name, MaxPreviousLogs))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := MaxPreviousLogs - 1; i >= 0; i-- {
+		if !fileutils.Exist(GetLogPath(name, i)) {
+			continue
+		}
+
+		err = os.Rename(GetLogPath(name, i), GetLogPath(name, i+1))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// stateTarOptions are the options used both to archive and extract the state
+// of containers, to keep the ownership and attributes of their files.
+var stateTarOptions = []string{"--numeric-owner", "--xattrs", "--xattrs-include=*"}
+
+// ExportState will archive input container in output as a tar.gz, with its
+// config, rootfs and anonymous volumes, to be moved to another host with
+// ImportState. With checkpoint, its CRIU checkpoint is archived too.
+// Containers using the erofs storage driver are archived with their merged
+// rootfs, and imported with the files storage driver.
+func ExportState(name string, output string, checkpoint bool) error {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return err
+	}
+
+	if checkpoint && !HasCheckpoint(config.ID) {
+		return fmt.Errorf("container %s has no checkpoint, create it with lilipod checkpoint", name)
+	}
+
+	if !checkpoint && IsRunning(config.ID) {
+		logging.LogWarning("container %s is running, the archive could be inconsistent", name)
+	}
+
+	rootfs, err := GetRootfsPath(config.ID)
+	if err != nil {
+		return err
+	}
+
+	archive := output + ".tar"
+
+	defer func() { _ = os.Remove(archive) }()
+
+	files := []string{"./config"}
+
+	// the manifest of the image files, so that diff works without the image
+	if fileutils.Exist(filepath.Join(GetDir(config.ID), "pristine.json.gz")) {
+		files = append(files, "./pristine.json.gz")
+	}
+
+	if checkpoint {
+		files = append(files, "./checkpoint")
+	}
+
+	logging.LogDebug("exporting state of %s to %s", name, output)
+
+	args := append([]string{}, stateTarOptions...)
+	args = append(args, "-cf", archive, "-C", GetDir(config.ID))
+	args = append(args, files...)
+
+	out, err := exec.Command("tar", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to export state of %s: %w: %s", name, err, string(out))
+	}
+
+	// the rootfs and volumes are appended from where they are, the erofs
+	// rootfs is only available mounted
+	err = appendStateDir(archive, rootfs, "rootfs", "./dev/*", "./proc/*", "./sys/*")
+	if err != nil {
+		return fmt.Errorf("failed to export state of %s: %w", name, err)
+	}
+
+	volumes := filepath.Join(utils.GetLilipodHome(), "volumes", config.ID)
+	if fileutils.Exist(volumes) {
+		err = appendStateDir(archive, volumes, "volumes")
+		if err != nil {
+			return fmt.Errorf("failed to export state of %s: %w", name, err)
+		}
+	}
+
+	return compressFile(archive, output)
+}
+
+// appendStateDir will append the content of input dir to the tar archive,
+// under input prefix, excluding input patterns.
+func appendStateDir(archive string, dir string, prefix string, exclude ...string) error {
+	args := append([]string{}, stateTarOptions...)
+
+	for _, pattern := range exclude {
+		args = append(args, "--exclude="+pattern)
+	}
+
+	// the targets of symlinks are paths in the container, they are kept as is
+	args = append(args, "--transform=s,^\\.,./"+prefix+",S", "-rf", archive, "-C", dir, ".")
+
+	out, err := exec.Command("tar", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, string(out))
+	}
+
+	return nil
+}
+
+// compressFile will write the gzip of input file to output.
+func compressFile(input string, output string) error {
+	source, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = source.Close() }()
+
+	dest, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(dest)
+
+	_, err = io.Copy(writer, source)
+	if err == nil {
+		err = writer.Close()
+	}
+
+	closeErr := dest.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(output)
+	}
+
+	return err
+}
+
+// ImportState will create the container archived in input file by ExportState,
+// and return its ID. If name is not empty, the container is renamed to it.
+// The container keeps its ID, unless it is already used on this host and it
+// has no checkpoint, whose processes know the container by its ID.
+// The hooks of the container run commands on this host, so they are dropped
+// unless hooks is set.
+func ImportState(input string, name string, hooks bool) (string, error) {
+	err := os.MkdirAll(ContainerDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	tmpdir, err := os.MkdirTemp(ContainerDir, ".import-")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = os.RemoveAll(tmpdir) }()
+
+	args := append([]string{}, stateTarOptions...)
+	args = append(args, "-xzf", input, "-C", tmpdir)
+
+	out, err := exec.Command("tar", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w: %s", input, err, string(out))
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(tmpdir, "config"))
+	if err != nil || !fileutils.Exist(filepath.Join(tmpdir, "rootfs")) {
+		return "", fmt.Errorf("%s is not a container state archive", input)
+	}
+
+	// the ID is used in paths, it must not point outside of our dirs
+	if !IsValidID(config.ID) {
+		return "", fmt.Errorf("%s is not a container state archive, invalid container ID %q", input, config.ID)
+	}
+
+	if name != "" {
+		config.Names = name
+	}
+
+	if _, ok := FindByName(config.Names); ok {
+		return "", fmt.Errorf("container %s already exists, specify another name", config.Names)
+	}
+
+	if fileutils.Exist(filepath.Join(ContainerDir, config.ID)) {
+		if fileutils.Exist(filepath.Join(tmpdir, "checkpoint")) {
+			return "", fmt.Errorf("container %s already exists", config.ID)
+		}
+
+		config.ID, err = NewID()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	getImportConfig(&config, hooks)
+
+	err = utils.SaveConfig(config, filepath.Join(tmpdir, "config"))
+	if err != nil {
+		return "", err
+	}
+
+	volumes := filepath.Join(tmpdir, "volumes")
+	if fileutils.Exist(volumes) {
+		err = os.MkdirAll(filepath.Join(utils.GetLilipodHome(), "volumes"), 0o755)
+		if err != nil {
+			return "", err
+		}
+
+		err = os.Rename(volumes, filepath.Join(utils.GetLilipodHome(), "volumes", config.ID))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = os.Rename(tmpdir, filepath.Join(ContainerDir, config.ID))
+	if err != nil {
+		_ = os.RemoveAll(filepath.Join(utils.GetLilipodHome(), "volumes", config.ID))
+
+		return "", err
+	}
+
+	events.Emit("create", config, nil)
+
+	return config.ID, nil
+}
+
+// getImportConfig will adapt the config of a container imported from another
+// host to this one. The settings that run or write on this host are dropped,
+// unless hooks is set for the hooks, and a warning is logged for the paths of
+// this host the container bind mounts.
+func getImportConfig(config *utils.Config, hooks bool) {
+	if config.Hooks != nil && !hooks {
+		logging.LogWarning("dropping the hooks of container %s, they run commands on this host, use --hooks to keep them",
+			config.Names)
+
+		config.Hooks = nil
+	}
+
+	for _, mount := range config.Mounts {
+		volume, err := parseVolume(mount, *config)
+		if err == nil && volume.Type == "bind" && !volume.Managed {
+			logging.LogWarning("container %s bind mounts %s of this host, check it before starting the container",
+				config.Names, volume.Source)
+		}
+	}
+
+	if config.Runtime != "" && config.Runtime != RuntimeBuiltin && !IsExternalRuntime(config.Runtime) {
+		logging.LogWarning("dropping unknown runtime %s of container %s", config.Runtime, config.Names)
+
+		config.Runtime = ""
+	}
+
+	// the required containers and the pidfile are the ones of the other host
+	if len(config.Requires) > 0 {
+		logging.LogWarning("dropping the containers required by %s, they are not imported", config.Names)
+
+		config.Requires = nil
+	}
+
+	config.PidFile = ""
+
+	// the rootfs is archived merged
+	config.StorageDriver = imageutils.StorageDriverFiles
+
+	// the image is not needed to run the container, only to diff and commit it
+	config.ImageRemoved = config.ImageRemoved || !fileutils.Exist(imageutils.GetPath(config.Image))
+
+	// keep-id containers follow the user and subordinate ids of this host
+	if config.Userns == constants.KeepID && os.Getenv("PARENT_UID_MAP") != "" {
+		config.Uidmap = os.Getenv("PARENT_UID_MAP")
+		config.Gidmap = os.Getenv("PARENT_GID_MAP")
+	}
+
+	config.Status = ""
+	config.Size = ""
+	config.Started = ""
+	config.Finished = ""
+	config.ExitCode = 0
+	config.RestartCount = 0
+	config.Health = nil
+}
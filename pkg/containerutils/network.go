@@ -2,25 +2,89 @@
 package containerutils
 
 import (
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"net"
+	"os/exec"
+	"strings"
 
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/netns"
 	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
 )
 
 // setupNetworking configures network namespace for the container if network isolation is requested
 func setupNetworking(config utils.Config) (*netns.NetworkNamespace, error) {
+	if container, ok := GetNetworkContainer(config.Network); ok {
+		return nil, joinNetwork(container)
+	}
+
+	var network *Network
+
+	if IsUserNetwork(config.Network) {
+		userNetwork, err := LoadNetwork(config.Network)
+		if err != nil {
+			return nil, err
+		}
+
+		network = &userNetwork
+	}
+
 	// Only set up network namespace if network isolation is requested
-	if config.Network != "private" {
+	if config.Network != constants.Private && network == nil {
 		return nil, nil
 	}
 
+	ports := []netns.PortForward{}
+
+	for _, mapping := range config.Ports {
+		forward, err := netns.ParsePortMapping(mapping)
+		if err != nil {
+			return nil, err
+		}
+
+		ports = append(ports, forward)
+	}
+
+	// the containers of a slirp network join the one started first
+	if network != nil && network.Driver == DriverSlirp {
+		if owner, ok := getNetworkOwner(network.Name, config.ID); ok {
+			err := joinNetwork(owner)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, netns.AddPortForwards(owner, network.getSlirpAddress(), ports)
+		}
+	}
+
 	// Create new network namespace instance
 	ns, err := netns.New(config.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create network namespace: %w", err)
 	}
 
+	ns.IP = config.IP
+	ns.MacAddress = config.MacAddress
+	ns.Ports = ports
+	ns.Backend = config.NetworkBackend
+
+	if network != nil && network.Driver == DriverSlirp {
+		ns.IP = network.getSlirpAddress()
+	}
+
+	if network != nil && network.Driver == DriverBridge {
+		ns.Backend = netns.BackendBridge
+		ns.Bridge = &netns.Bridge{
+			Name:    network.GetBridgeName(),
+			Gateway: network.Gateway,
+			Subnet:  network.Subnet,
+		}
+	}
+
 	// Set up the network namespace
 	if err := ns.Setup(); err != nil {
 		// Clean up on failure
@@ -43,3 +107,226 @@ func cleanupNetworking(ns *netns.NetworkNamespace) error {
 
 	return nil
 }
+
+// networkContainerPrefix is the prefix of the network mode of containers
+// sharing the network namespace of another one, followed by its ID.
+const networkContainerPrefix = "container:"
+
+// ResolveNetwork returns input network mode, if supported: private, in a
+// network namespace of its own, host, sharing the host's network stack and
+// its DNS configuration, container:<name>, sharing the network namespace
+// of another container, referenced by its ID, or the name of a user network.
+func ResolveNetwork(network string) (string, error) {
+	if name, ok := strings.CutPrefix(network, networkContainerPrefix); ok {
+		id, err := ResolveID(name)
+		if err != nil {
+			return "", fmt.Errorf("container %s, whose network is shared, does not exist", name)
+		}
+
+		return networkContainerPrefix + id, nil
+	}
+
+	if network != constants.Private && network != constants.Host && !IsUserNetwork(network) {
+		return "", fmt.Errorf("invalid network %s, use %s, %s, %s<name> or the name of a network",
+			network, constants.Private, constants.Host, networkContainerPrefix)
+	}
+
+	return network, nil
+}
+
+// GetNetworkContainer returns the ID of the container whose network namespace
+// is shared by input network mode, and whether it is one.
+func GetNetworkContainer(network string) (string, bool) {
+	return strings.CutPrefix(network, networkContainerPrefix)
+}
+
+// joinNetwork will move the current thread in the network namespace of
+// input container, that must be running. The thread must be locked until the
+// container is forked from it, see Start.
+func joinNetwork(container string) error {
+	pid, err := GetPid(container)
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("container %s, whose network is shared, is not running", container)
+	}
+
+	fd, err := unix.Open(fmt.Sprintf("/proc/%d/ns/net", pid), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace of container %s: %w", container, err)
+	}
+
+	defer func() { _ = unix.Close(fd) }()
+
+	err = unix.Setns(fd, unix.CLONE_NEWNET)
+
+	// rootless, namespaces belong to the session of lilipod that created them
+	if errors.Is(err, unix.EPERM) {
+		return fmt.Errorf("cannot join network of container %s, started by another session: "+
+			"stop it, to start it with this container", container)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to join network namespace of container %s: %w", container, err)
+	}
+
+	return nil
+}
+
+// getJoinedNetworkPath returns the path of the network namespace of the
+// container whose network input one joins, with container:<name> or as a
+// member of a slirp network.
+func getJoinedNetworkPath(config utils.Config) (string, error) {
+	owner, ok := GetNetworkContainer(config.Network)
+	if !ok && getNetworkDriver(config.Network) == DriverSlirp {
+		owner, ok = getNetworkOwner(config.Network, config.ID)
+	}
+
+	if !ok {
+		return "", fmt.Errorf("no running container provides network %s", config.Network)
+	}
+
+	pid, err := GetPid(owner)
+	if err != nil || pid <= 0 {
+		return "", fmt.Errorf("container %s, whose network is shared, is not running", owner)
+	}
+
+	return fmt.Sprintf("/proc/%d/ns/net", pid), nil
+}
+
+// ValidateAddressing returns an error if input static ip and mac address are
+// invalid, or cannot be used with input network mode.
+func ValidateAddressing(network, ip, mac string) error {
+	if ip == "" && mac == "" {
+		return nil
+	}
+
+	bridge := getNetworkDriver(network) == DriverBridge
+
+	if network != constants.Private && !bridge {
+		return fmt.Errorf("--ip and --mac-address can only be used with a private or %s network", DriverBridge)
+	}
+
+	// the address of bridge networks is checked against the subnet, once allocated
+	if ip != "" && !bridge {
+		_, err := netns.GetStaticNetwork(ip)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mac != "" {
+		_, err := net.ParseMAC(mac)
+		if err != nil {
+			return fmt.Errorf("invalid mac address %s: %w", mac, err)
+		}
+	}
+
+	return nil
+}
+
+// CanPublish returns whether input network mode has a backend of its own,
+// that can forward ports from the host: private and slirp networks.
+func CanPublish(network string) bool {
+	return network == constants.Private || getNetworkDriver(network) == DriverSlirp
+}
+
+// getNetworkDriver returns the driver of input network mode, if it is a user
+// network.
+func getNetworkDriver(network string) string {
+	if !IsUserNetwork(network) {
+		return ""
+	}
+
+	userNetwork, err := LoadNetwork(network)
+	if err != nil {
+		logging.LogWarning("%v", err)
+
+		return ""
+	}
+
+	return userNetwork.Driver
+}
+
+// ValidatePorts returns an error if input port mappings are invalid, or
+// cannot be used with input network mode.
+func ValidatePorts(network string, ports []string) error {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	// shared networks use the host's ports as they are
+	if !CanPublish(network) {
+		return fmt.Errorf("--publish can only be used with a private or %s network", DriverSlirp)
+	}
+
+	for _, mapping := range ports {
+		_, err := netns.ParsePortMapping(mapping)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateNetworkBackend returns an error if input backend is unknown, not
+// installed, or cannot be used with input network mode.
+func ValidateNetworkBackend(network, backend string) error {
+	if backend == "" {
+		return nil
+	}
+
+	if !CanPublish(network) {
+		return fmt.Errorf("--network-backend can only be used with a private or %s network", DriverSlirp)
+	}
+
+	switch backend {
+	case netns.BackendSlirp4netns:
+		return nil
+	case netns.BackendPasta:
+		_, err := exec.LookPath(netns.BackendPasta)
+		if err != nil {
+			return fmt.Errorf("network backend pasta is not installed")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("invalid network backend %s, use %s or %s",
+			backend, netns.BackendPasta, netns.BackendSlirp4netns)
+	}
+}
+
+// ValidateNetworkAliases returns an error if input aliases are invalid, or
+// cannot be used with input network mode, as only user networks resolve them.
+func ValidateNetworkAliases(network string, aliases []string) error {
+	if len(aliases) == 0 {
+		return nil
+	}
+
+	if !IsUserNetwork(network) {
+		return fmt.Errorf("--network-alias can only be used with a network created by lilipod network create")
+	}
+
+	for _, alias := range aliases {
+		if !validNetworkName.MatchString(alias) {
+			return fmt.Errorf("invalid network alias %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", alias)
+		}
+	}
+
+	return nil
+}
+
+// GenerateMacAddress returns a random locally administered unicast MAC address.
+// This is saved in the container's config so the address is stable across restarts.
+func GenerateMacAddress() (string, error) {
+	mac := make([]byte, 6)
+
+	_, err := rand.Read(mac)
+	if err != nil {
+		return "", err
+	}
+
+	// set the locally administered bit, clear the multicast bit
+	mac[0] = (mac[0] | 0x02) &^ 0x01
+
+	return net.HardwareAddr(mac).String(), nil
+}
@@ -0,0 +1,146 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+)
+
+// PasswdEntry is a parsed line of a container's /etc/passwd.
+type PasswdEntry struct {
+	Username string
+	UID      string
+	GID      string
+	Home     string
+	Shell    string
+}
+
+// GetPasswdEntry will search the container's /etc/passwd for input user.
+// Input user can be in the form of username:group, or uid:gid or a mix of that,
+// only the user part is taken into account.
+func GetPasswdEntry(name string, user string) (PasswdEntry, error) {
+	user = strings.Split(user, ":")[0]
+
+	rootfs, err := GetRootfsPath(name)
+	if err != nil {
+		return PasswdEntry{}, err
+	}
+
+	passwdPath := filepath.Join(rootfs, "etc", "passwd")
+
+	logging.LogDebug("looking up user %s in %s", user, passwdPath)
+
+	passwd, err := fileutils.ReadFile(passwdPath)
+	if err != nil {
+		return PasswdEntry{}, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.Trim(passwd, "\x00")))
+	for scanner.Scan() {
+		// Line has a structure:
+		//    name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 {
+			continue
+		}
+
+		if fields[0] == user || fields[2] == user {
+			return PasswdEntry{
+				Username: fields[0],
+				UID:      fields[2],
+				GID:      fields[3],
+				Home:     fields[5],
+				Shell:    fields[6],
+			}, nil
+		}
+	}
+
+	return PasswdEntry{}, fmt.Errorf("user %s not found in container %s", user, name)
+}
+
+// GetLoginShell returns the login shell of input user inside the container.
+// If the user or its shell cannot be found, it falls back to /bin/sh.
+func GetLoginShell(name string, user string) string {
+	entry, err := GetPasswdEntry(name, user)
+	if err != nil || entry.Shell == "" {
+		logging.LogDebug("cannot find login shell for %s, falling back to /bin/sh", user)
+
+		return "/bin/sh"
+	}
+
+	// use lstat, the shell can be an absolute symlink that only makes sense
+	// inside the container
+	rootfs, err := GetRootfsPath(name)
+	if err != nil {
+		return "/bin/sh"
+	}
+
+	_, err = os.Lstat(filepath.Join(rootfs, entry.Shell))
+	if err != nil {
+		logging.LogDebug("login shell %s does not exist, falling back to /bin/sh", entry.Shell)
+
+		return "/bin/sh"
+	}
+
+	return entry.Shell
+}
+
+// getGroupIDs will resolve input supplementary groups, either names or GIDs,
+// using the /etc/group of the rootfs in path.
+func getGroupIDs(rootfs string, groups []string) ([]int, error) {
+	result := []int{}
+
+	var groupFile []byte
+
+	for _, group := range groups {
+		gid, err := strconv.Atoi(group)
+		if err == nil {
+			result = append(result, gid)
+
+			continue
+		}
+
+		if groupFile == nil {
+			groupFile, err = fileutils.ReadFile(filepath.Join(rootfs, "etc", "group"))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		found := false
+
+		scanner := bufio.NewScanner(bytes.NewReader(bytes.Trim(groupFile, "\x00")))
+		for scanner.Scan() {
+			// Line has a structure:
+			//    name:password:gid:members
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 3 || fields[0] != group {
+				continue
+			}
+
+			gid, err = strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid gid %s for group %s", fields[2], group)
+			}
+
+			result = append(result, gid)
+			found = true
+
+			break
+		}
+
+		if !found {
+			return nil, fmt.Errorf("group %s not found in container", group)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,193 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// StatusPaused is the status of a running container whose processes are frozen.
+const StatusPaused = "paused"
+
+// Ways a container can be paused, as recorded in its state.
+const (
+	// PauseCgroup is a container frozen with the cgroup freezer.
+	PauseCgroup = "cgroup"
+	// PauseSignal is a container whose processes got SIGSTOP, when its
+	// cgroup cannot be frozen.
+	PauseSignal = "signal"
+)
+
+// getPids returns the pids of all the processes running in the container
+// with input id, found like GetPid.
+func getPids(id string) ([]int, error) {
+	id = GetID(id)
+	idb := []byte(id)
+
+	processes, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	result := []int{}
+
+	for _, proc := range processes {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+
+		filedata, err := fileutils.ReadFile(filepath.Join("/proc", proc.Name(), "/root/run/.containerenv"))
+		if err != nil {
+			continue
+		}
+
+		if bytes.Contains(filedata, idb) {
+			result = append(result, pid)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("container %s is not running", id)
+	}
+
+	return result, nil
+}
+
+// canFreeze returns whether the cgroup of input container pid can be frozen:
+// it must contain only the processes of the container, else, eg: with the
+// host's cgroup namespace, we would freeze the user's session too.
+func canFreeze(pid int, pids []int) bool {
+	members, err := cgrouputils.GetFreezerProcesses(pid)
+	if err != nil {
+		logging.LogDebug("cannot read the cgroup of %d: %v", pid, err)
+
+		return false
+	}
+
+	for _, member := range members {
+		if !slices.Contains(pids, member) {
+			logging.LogDebug("cgroup of %d is shared with process %d", pid, member)
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// signalAll will send input signal to all input processes.
+func signalAll(pids []int, signal unix.Signal) error {
+	for _, pid := range pids {
+		err := unix.Kill(pid, signal)
+		if err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsPaused returns whether the container name or id is running and paused.
+func IsPaused(name string) bool {
+	state, err := GetState(name)
+
+	return err == nil && state.Paused != "" && IsRunning(name)
+}
+
+// Pause will suspend all the processes of input running container, freezing
+// its cgroup, or sending them SIGSTOP if it cannot be frozen.
+func Pause(name string) error {
+	pids, err := getPids(name)
+	if err != nil {
+		return err
+	}
+
+	state, err := GetState(name)
+	if err != nil {
+		return err
+	}
+
+	if state.Paused != "" {
+		return fmt.Errorf("container %s is already paused", name)
+	}
+
+	state.Paused = PauseSignal
+
+	if canFreeze(pids[0], pids) {
+		err = cgrouputils.SetFrozen(pids[0], true)
+		if err == nil {
+			state.Paused = PauseCgroup
+		} else {
+			logging.LogDebug("cannot freeze cgroup, using SIGSTOP: %v", err)
+		}
+	}
+
+	if state.Paused == PauseSignal {
+		err = signalAll(pids, unix.SIGSTOP)
+		if err != nil {
+			return err
+		}
+	}
+
+	logging.LogDebug("container %s paused with %s", name, state.Paused)
+
+	saveState(GetID(name), state)
+
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err == nil {
+		events.Emit("pause", config, nil)
+	}
+
+	return nil
+}
+
+// Unpause will resume all the processes of input paused container, the same
+// way they were suspended.
+func Unpause(name string) error {
+	pids, err := getPids(name)
+	if err != nil {
+		return err
+	}
+
+	state, err := GetState(name)
+	if err != nil {
+		return err
+	}
+
+	switch state.Paused {
+	case PauseCgroup:
+		err = cgrouputils.SetFrozen(pids[0], false)
+	case PauseSignal:
+		err = signalAll(pids, unix.SIGCONT)
+	default:
+		return fmt.Errorf("container %s is not paused", name)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("container %s unpaused", name)
+
+	state.Paused = ""
+	saveState(GetID(name), state)
+
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err == nil {
+		events.Emit("unpause", config, nil)
+	}
+
+	return nil
+}
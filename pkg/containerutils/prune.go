@@ -0,0 +1,220 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// GetImageReferences returns how many containers use each image, by image
+// ID. Orphaned containers do not count, as they do not need the image anymore.
+func GetImageReferences() (map[string]int, error) {
+	result := map[string]int{}
+
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, container := range containers {
+		config, err := utils.LoadConfig(filepath.Join(ContainerDir, container.Name(), "config"))
+		if err != nil || config.ImageRemoved {
+			continue
+		}
+
+		result[imageutils.GetID(config.Image)]++
+	}
+
+	return result, nil
+}
+
+// isDanglingImage returns whether input image directory is incomplete, eg:
+// left behind by an interrupted pull, so it cannot be used by containers.
+func isDanglingImage(dir string) bool {
+	for _, file := range []string{"image_name", "manifest.json", "config.json"} {
+		if !fileutils.Exist(filepath.Join(dir, file)) {
+			return true
+		}
+	}
+
+	manifestFile, err := fileutils.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return true
+	}
+
+	var manifest v1.Manifest
+
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return true
+	}
+
+	for _, layer := range manifest.Layers {
+		if !imageutils.HasLayer(filepath.Base(dir), layer.Digest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getImageCreated returns when input image directory was created, as saved in
+// its config, else when it was pulled.
+func getImageCreated(dir string) time.Time {
+	configFile, err := fileutils.ReadFile(filepath.Join(dir, "config.json"))
+	if err == nil {
+		var config v1.ConfigFile
+
+		err = json.Unmarshal(configFile, &config)
+		if err == nil && !config.Created.IsZero() {
+			return config.Created.Time
+		}
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// PruneImages will remove the images not used by any container, and return
+// their IDs. Without all, only the dangling ones are removed, eg: the ones
+// left incomplete by an interrupted pull.
+// If until is not zero, only images created before it are removed.
+func PruneImages(all bool, until time.Time) ([]string, error) {
+	result := []string{}
+
+	images, err := os.ReadDir(imageutils.ImageDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	references, err := GetImageReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range images {
+		dir := filepath.Join(imageutils.ImageDir, image.Name())
+
+		// hidden dirs are temporary ones of ongoing operations, eg: load
+		if !image.IsDir() || strings.HasPrefix(image.Name(), ".") ||
+			references[image.Name()] > 0 {
+			continue
+		}
+
+		if !all && !isDanglingImage(dir) {
+			continue
+		}
+
+		if !until.IsZero() && !getImageCreated(dir).Before(until) {
+			continue
+		}
+
+		logging.LogDebug("pruning image %s", image.Name())
+
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, image.Name())
+	}
+
+	// layers are shared, they are removed with the last image using them
+	err = imageutils.RemoveUnusedLayers()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PrunedContainer is a container removed by PruneContainers.
+type PrunedContainer struct {
+	Name string
+	// Size is the disk space reclaimed by removing the container, in bytes.
+	Size uint64
+}
+
+// PruneContainers will remove the containers that are not running, and
+// return them with the disk space reclaimed.
+// If until is not zero, only containers created before it are removed.
+func PruneContainers(until time.Time) ([]PrunedContainer, error) {
+	result := []PrunedContainer{}
+
+	containers, err := os.ReadDir(ContainerDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, container := range containers {
+		// hidden dirs are temporary ones of ongoing operations, eg: import
+		if !container.IsDir() || strings.HasPrefix(container.Name(), ".") ||
+			IsRunning(container.Name()) {
+			continue
+		}
+
+		dir := filepath.Join(ContainerDir, container.Name())
+
+		config, err := utils.LoadConfig(filepath.Join(dir, "config"))
+		if err != nil {
+			logging.LogWarning("skipping container %s: %v", container.Name(), err)
+
+			continue
+		}
+
+		created, err := time.Parse(time.RFC3339, config.Created)
+		if !until.IsZero() && (err != nil || !created.Before(until)) {
+			continue
+		}
+
+		size, err := fileutils.DiscUsage(dir)
+		if err != nil {
+			logging.LogWarning("cannot compute size of container %s: %v", config.Names, err)
+		}
+
+		// anonymous volumes are removed with the container
+		volumesDir := filepath.Join(utils.GetLilipodHome(), "volumes", container.Name())
+		if fileutils.Exist(volumesDir) {
+			volumes, err := fileutils.DiscUsage(volumesDir)
+			if err == nil {
+				size += volumes
+			}
+		}
+
+		logging.LogDebug("pruning container %s", config.Names)
+
+		err = Remove(container.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, PrunedContainer{Name: config.Names, Size: uint64(size)})
+	}
+
+	return result, nil
+}
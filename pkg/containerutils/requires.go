@@ -0,0 +1,144 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// requiresTimeout is how long we wait for the required containers of a
+// starting container to be running.
+const requiresTimeout = 10 * time.Second
+
+// ResolveRequires returns the IDs of input required containers, a list of
+// names or IDs, that can be comma separated too. They must exist.
+func ResolveRequires(requires []string) ([]string, error) {
+	result := []string{}
+
+	for _, names := range requires {
+		for _, name := range strings.Split(names, ",") {
+			if name == "" {
+				continue
+			}
+
+			id, err := ResolveID(name)
+			if err != nil {
+				return nil, fmt.Errorf("required container %s does not exist", name)
+			}
+
+			result = append(result, id)
+		}
+	}
+
+	return result, nil
+}
+
+// getRequires returns the IDs of the containers required by input one.
+func getRequires(id string) []string {
+	config, err := utils.LoadConfig(filepath.Join(ContainerDir, id, "config"))
+	if err != nil {
+		return nil
+	}
+
+	return config.Requires
+}
+
+// SortByRequires returns input containers ordered so that each one comes after
+// the ones it requires.
+// Containers that cannot be found are left as is, to be reported by the caller.
+func SortByRequires(containers []string) []string {
+	names := map[string]string{}
+	ids := []string{}
+	result := []string{}
+
+	for _, container := range containers {
+		id, err := ResolveID(container)
+		if err != nil {
+			result = append(result, container)
+
+			continue
+		}
+
+		names[id] = container
+		ids = append(ids, id)
+	}
+
+	visited := map[string]bool{}
+
+	var visit func(id string)
+
+	visit = func(id string) {
+		// containers being visited are skipped too, to break cycles
+		if visited[id] {
+			return
+		}
+
+		visited[id] = true
+
+		// the requirements of the containers not in input count too
+		for _, required := range getRequires(id) {
+			visit(required)
+		}
+
+		if name, ok := names[id]; ok {
+			result = append(result, name)
+		}
+	}
+
+	for _, id := range ids {
+		visit(id)
+	}
+
+	return result
+}
+
+// GetRequiring returns the names of the containers requiring input one.
+func GetRequiring(name string) []string {
+	result := []string{}
+
+	configs, err := ListContainers(nil)
+	if err != nil {
+		return result
+	}
+
+	id := GetID(name)
+
+	for _, config := range configs {
+		for _, required := range config.Requires {
+			if required == id {
+				result = append(result, config.Names)
+			}
+		}
+	}
+
+	return result
+}
+
+// WaitRequires will wait for the containers required by input one to be
+// running, up to requiresTimeout.
+func WaitRequires(config utils.Config) error {
+	deadline := time.Now().Add(requiresTimeout)
+
+	for _, required := range config.Requires {
+		if !fileutils.Exist(filepath.Join(ContainerDir, required)) {
+			return fmt.Errorf("container %s requires container %s, which does not exist",
+				config.Names, ShortID(required))
+		}
+
+		for !IsRunning(required) {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("container %s requires container %s, which is not running",
+					config.Names, ShortID(required))
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// resourcesTimeout is how long we try to limit a starting container for.
+const resourcesTimeout = 5 * time.Second
+
+// memoryUnits are the multipliers of the memory limit suffixes, eg: 512m.
+var memoryUnits = map[string]int64{
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// parseMemory returns the bytes of input memory limit, a number with an
+// optional b, k, m, g or t suffix. 0 means unlimited.
+func parseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+
+	value := strings.ToLower(memory)
+	multiplier := int64(1)
+
+	// both 512m and 512mb are accepted
+	if len(value) > 1 && strings.HasSuffix(value, "b") && memoryUnits[value[len(value)-2:len(value)-1]] > 1 {
+		value = strings.TrimSuffix(value, "b")
+	}
+
+	if unit, ok := memoryUnits[value[len(value)-1:]]; ok {
+		multiplier = unit
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid memory limit %s, use a number with an optional b, k, m or g suffix", memory)
+	}
+
+	return int64(number * float64(multiplier)), nil
+}
+
+// getResources returns the cgroup limits described by input memory, cpus and
+// pids limits.
+func getResources(memory, cpus, pidsLimit string) (cgrouputils.Resources, error) {
+	resources := cgrouputils.Resources{}
+
+	bytes, err := parseMemory(memory)
+	if err != nil {
+		return resources, err
+	}
+
+	resources.Memory = bytes
+
+	if cpus != "" {
+		resources.CPUs, err = strconv.ParseFloat(cpus, 64)
+		if err != nil || resources.CPUs < 0 {
+			return resources, fmt.Errorf("invalid cpus %s, use a number of CPUs, eg: 1.5", cpus)
+		}
+	}
+
+	if pidsLimit != "" {
+		resources.Pids, err = strconv.ParseInt(pidsLimit, 10, 64)
+		if err != nil {
+			return resources, fmt.Errorf("invalid pids limit %s, use a number of processes", pidsLimit)
+		}
+	}
+
+	return resources, nil
+}
+
+// ValidateResources will check that input memory, cpus and pids limits are
+// valid, empty or 0 ones mean unlimited.
+func ValidateResources(memory, cpus, pidsLimit string) error {
+	_, err := getResources(memory, cpus, pidsLimit)
+
+	return err
+}
+
+// HasResources returns whether input container has any cgroup limit.
+func HasResources(config utils.Config) bool {
+	resources, err := getResources(config.Memory, config.CPUs, config.PidsLimit)
+
+	return err == nil && resources != cgrouputils.Resources{}
+}
+
+// SetResources will apply the cgroup limits of input container config to its
+// running processes. Limits are only applied to containers in a cgroup of
+// their own, that we can write to.
+func SetResources(config utils.Config) error {
+	resources, err := getResources(config.Memory, config.CPUs, config.PidsLimit)
+	if err != nil {
+		return err
+	}
+
+	pids, err := getPids(config.ID)
+	if err != nil {
+		return err
+	}
+
+	pid, err := getRootPid(pids)
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("setting resources of %s to %+v", config.Names, resources)
+
+	err = cgrouputils.SetResources(pid, pids, resources)
+	if err != nil {
+		return fmt.Errorf("cannot set resources of container %s: %w", config.Names, err)
+	}
+
+	return nil
+}
+
+// refreshConfig will reload the settings of input container that can be
+// updated while it is running: its name, resources and restart policy.
+func refreshConfig(config *utils.Config) {
+	current, err := utils.LoadConfig(filepath.Join(GetDir(config.ID), "config"))
+	if err != nil {
+		logging.LogDebug("cannot reload config of %s: %v", config.Names, err)
+
+		return
+	}
+
+	config.Names = current.Names
+	config.Memory = current.Memory
+	config.CPUs = current.CPUs
+	config.PidsLimit = current.PidsLimit
+	config.Restart = current.Restart
+}
+
+// applyResources will set the cgroup limits of input container as soon as it
+// is running in its own cgroup, giving up after resourcesTimeout, or when the
+// done channel is closed.
+func applyResources(config utils.Config, done chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	timeout := time.After(resourcesTimeout)
+
+	var err error
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			if err != nil {
+				logging.LogWarning("%v", err)
+			}
+
+			return
+		case <-ticker.C:
+			if !IsRunning(config.ID) {
+				continue
+			}
+
+			// the container moves to its own cgroup right after starting
+			err = SetResources(config)
+			if err == nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Restart policies of a container, applied when its main process exits.
+const (
+	// RestartNo never restarts the container.
+	RestartNo = "no"
+	// RestartOnFailure restarts the container when it exits with a non-zero
+	// code, optionally at most N times, eg: on-failure:3.
+	RestartOnFailure = "on-failure"
+	// RestartAlways restarts the container whatever its exit code is.
+	RestartAlways = "always"
+	// RestartUnlessStopped is like always: without a daemon, containers are
+	// never restarted once stopped by lilipod stop anyway.
+	RestartUnlessStopped = "unless-stopped"
+)
+
+const (
+	// restartDelay is the delay before the first restart, doubled for each next one.
+	restartDelay = 100 * time.Millisecond
+	// maxRestartDelay is the longest we wait between two restarts.
+	maxRestartDelay = time.Minute
+	// restartResetTime is how long a container has to run for the delay to be reset.
+	restartResetTime = 10 * time.Second
+)
+
+// parseRestartPolicy returns the name of input restart policy, and the
+// maximum number of restarts, 0 if unlimited.
+func parseRestartPolicy(policy string) (string, int, error) {
+	name, count, found := strings.Cut(policy, ":")
+
+	switch name {
+	case "", RestartNo, RestartAlways, RestartUnlessStopped:
+		if found {
+			return "", 0, fmt.Errorf("invalid restart policy %s, only %s accepts a maximum", policy, RestartOnFailure)
+		}
+
+		return name, 0, nil
+	case RestartOnFailure:
+		if !found {
+			return name, 0, nil
+		}
+
+		maxRestarts, err := strconv.Atoi(count)
+		if err != nil || maxRestarts < 1 {
+			return "", 0, fmt.Errorf("invalid restart policy %s, the maximum must be a positive number", policy)
+		}
+
+		return name, maxRestarts, nil
+	default:
+		return "", 0, fmt.Errorf("unsupported restart policy %s, valid policies are: %s, %s[:max], %s, %s",
+			policy, RestartNo, RestartOnFailure, RestartAlways, RestartUnlessStopped)
+	}
+}
+
+// ValidateRestartPolicy will check that input restart policy is valid.
+func ValidateRestartPolicy(policy string) error {
+	_, _, err := parseRestartPolicy(policy)
+
+	return err
+}
+
+// IsRestarted returns whether input restart policy restarts the container,
+// that is it is not empty nor no. Invalid policies never restart it.
+func IsRestarted(policy string) bool {
+	name, _, err := parseRestartPolicy(policy)
+
+	return err == nil && name != "" && name != RestartNo
+}
+
+// shouldRestart returns whether input container has to be restarted, after
+// its main process exited with input error, according to its restart policy.
+// Containers stopped by lilipod stop are never restarted.
+func shouldRestart(config utils.Config, runErr error, restarts int) bool {
+	policy, maxRestarts, err := parseRestartPolicy(config.Restart)
+	if err != nil {
+		logging.LogWarning("container %s: %v", config.Names, err)
+
+		return false
+	}
+
+	if policy == "" || policy == RestartNo {
+		return false
+	}
+
+	state, err := GetState(config.ID)
+	if err == nil && state.Stopped {
+		logging.LogDebug("container %s was stopped, not restarting it", config.Names)
+
+		return false
+	}
+
+	if policy != RestartOnFailure {
+		return true
+	}
+
+	if GetExitCode(runErr) == 0 {
+		return false
+	}
+
+	if maxRestarts > 0 && restarts >= maxRestarts {
+		logging.LogWarning("container %s failed %d times, not restarting it", config.Names, restarts+1)
+
+		return false
+	}
+
+	return true
+}
@@ -3,6 +3,7 @@
 package containerutils
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,6 +11,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
 	"github.com/89luca89/lilipod/pkg/constants"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/imageutils"
@@ -61,7 +63,11 @@ var linuxReadWritePaths = []string{
 }
 
 // we need to setup the /sys/fs/cgroup mountpoint, by mounting a new cgroup2 filesystem.
+// On cgroup v1 and hybrid hosts, the legacy hierarchies are mounted instead.
 func setupCgroupfs(conf utils.Config) error {
+	// detect it before hiding the host's hierarchy
+	version := cgrouputils.GetVersion()
+
 	logging.LogDebug("mounting new tmpfs fs on %s", "/sys/fs/cgroup")
 
 	// blank out the mount using a tmpfs
@@ -72,6 +78,10 @@ func setupCgroupfs(conf utils.Config) error {
 		return fmt.Errorf("error setting cgroups %w", err)
 	}
 
+	if version != cgrouputils.Version2 {
+		return setupCgroupfsV1(version)
+	}
+
 	logging.LogDebug("mounting new cgroup fs on %s", "/sys/fs/cgroup")
 
 	// mount a new cgroup v2 fs on it
@@ -99,6 +109,62 @@ func setupCgroupfs(conf utils.Config) error {
 	return err
 }
 
+// setupCgroupfsV1 will mount the legacy hierarchies we belong to in /sys/fs/cgroup,
+// with the usual symlinks for co-mounted controllers, eg: cpu -> cpu,cpuacct.
+// On hybrid hosts the unified hierarchy is mounted in /sys/fs/cgroup/unified too.
+// Hierarchies that cannot be mounted, eg. without privileges, are skipped.
+func setupCgroupfsV1(version string) error {
+	hierarchies, err := cgrouputils.GetHierarchies(os.Getpid())
+	if err != nil {
+		return fmt.Errorf("error setting cgroups %w", err)
+	}
+
+	for _, hierarchy := range hierarchies {
+		dest := hierarchy.GetMountpoint()
+		options := strings.Join(hierarchy.Controllers, ",")
+
+		// named hierarchies have no controllers
+		if strings.HasPrefix(options, "name=") {
+			options = "none," + options
+		}
+
+		logging.LogDebug("mounting cgroup hierarchy %s on %s", options, dest)
+
+		err = os.MkdirAll(dest, 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = syscall.Mount("cgroup", dest, "cgroup",
+			syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, options)
+		if err != nil {
+			logging.LogWarning("cannot mount cgroup hierarchy %s: %v", options, err)
+
+			continue
+		}
+
+		if len(hierarchy.Controllers) > 1 {
+			for _, controller := range hierarchy.Controllers {
+				_ = os.Symlink(filepath.Base(dest), filepath.Join(cgrouputils.CgroupRoot, controller))
+			}
+		}
+	}
+
+	if version == cgrouputils.VersionHybrid {
+		err = os.MkdirAll("/sys/fs/cgroup/unified", 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = fileutils.MountCgroup("/sys/fs/cgroup/unified")
+		if err != nil {
+			logging.LogWarning("cannot mount unified cgroup hierarchy: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // we need to setup the /dev/pts mountpoint, by mounting a new devpts filesystem
 // and linking up /dev/ptmx to /dev/pts/ptmx.
 func setupPTY(path string) error {
@@ -273,10 +339,30 @@ func setupMounts(path string, conf utils.Config) error {
 		}
 	}
 
-	if conf.Network == constants.Host {
-		logging.LogDebug("coping host's /dev/resolv.conf on %s", filepath.Join(path, "/etc/"))
+	// the host's DNS configuration is only reachable from the host's network,
+	// user networks have a resolver of their own, private networks keep the
+	// image's one
+	resolvSource := ""
+
+	switch {
+	case conf.Network == constants.Host:
+		resolvSource = "/etc/resolv.conf"
+	case IsUserNetwork(conf.Network):
+		resolvSource = getResolvConfPath(conf.ID)
+	}
+
+	if resolvSource != "" {
+		logging.LogDebug("coping %s on %s", resolvSource, filepath.Join(path, "/etc/"))
 
-		err = fileutils.MountBind("/etc/resolv.conf", filepath.Join(path, "/etc/resolv.conf"))
+		// images often link it to the resolver's runtime file, as the host does
+		resolvConf, err := fileutils.SecurePath(path, "/etc/resolv.conf")
+		if err != nil {
+			return fmt.Errorf("error setting DNS: %w", err)
+		}
+
+		_ = os.MkdirAll(filepath.Dir(resolvConf), 0o755)
+
+		err = fileutils.MountBind(resolvSource, resolvConf)
 		if err != nil {
 			logging.LogDebug("error: %+v", err)
 
@@ -314,157 +400,35 @@ func setupMounts(path string, conf utils.Config) error {
 
 // here we setup the custom mounts/volumes specified during creation. Reference
 // config is utils.Config.Mounts.
-// Specified mounts are in the form of src:dest:mode
-// For anonymous mountpoints, we create an empty dir in LILIPOD_HOME/volumes/ID/path.
+// Specified mounts are in the form of src:dest:options, see volumeMount.
+// For anonymous mountpoints, we create an empty dir in LILIPOD_HOME/volumes/ID/path,
+// named volumes are in LILIPOD_HOME/volumes/named/NAME, both are seeded with
+// the content of the rootfs when created.
 func setupVolumes(path string, conf utils.Config) error {
 	for _, volume := range conf.Mounts {
 		if strings.Compare(volume, "") == 0 {
 			continue
 		}
 
-		mounts := strings.Split(volume, ",")
-		// case of --mount type=xxx,source=xxx,destination=xxx,readonly=xxxx,bind-propagation=xxxx
-		if len(mounts) > 1 {
-			logging.LogDebug("setting up mount %s", volume)
-
-			readonly := false
-			destination := ""
-			mountType := ""
-			propagation := ""
-			source := ""
-
-			for _, mount := range mounts {
-				if strings.HasPrefix(mount, "type") {
-					mountType = strings.Split(mount, "=")[1]
-				}
-
-				if strings.HasPrefix(mount, "source") {
-					source = strings.Split(mount, "=")[1]
-				}
-
-				if strings.HasPrefix(mount, "destination") {
-					destination = filepath.Join(path, strings.Split(mount, "=")[1])
-				}
-
-				if strings.HasPrefix(mount, "readonly") {
-					readonly = true
-				}
-
-				if strings.HasPrefix(mount, "bind-propagation") {
-					propagation = strings.Split(mount, "=")[1]
-				}
-			}
-
-			mountprop := 0
-
-			if readonly {
-				mountprop |= syscall.MS_RDONLY
-			}
-
-			switch propagation {
-			case "private":
-				mountprop |= syscall.MS_PRIVATE
-			case "rprivate":
-				mountprop |= syscall.MS_REC | syscall.MS_PRIVATE
-			case "rshared":
-				mountprop |= syscall.MS_REC | syscall.MS_SHARED
-			case "rslave":
-				mountprop |= syscall.MS_REC | syscall.MS_SLAVE
-			case "shared":
-				mountprop |= syscall.MS_SHARED
-			case "slave":
-				mountprop |= syscall.MS_SLAVE
-			}
-
-			switch mountType {
-			case "tmpfs":
-				err := fileutils.MountTmpfs(destination)
-				if err != nil {
-					logging.LogDebug("error: %+v", err)
-
-					return fmt.Errorf("error creating tmpfs mount %s: %w", volume, err)
-				}
-			case "bind":
-				mountprop |= syscall.MS_BIND | syscall.MS_REC
-
-				err := fileutils.Mount(source, destination, uintptr(mountprop))
-				if err != nil {
-					logging.LogDebug("error: %+v", err)
-
-					return fmt.Errorf("error creating bind mount %s: %w", volume, err)
-				}
-			}
-
-			// mount finished, skip to next entry
-			continue
-		}
-
-		// here it means it's not a mount but a volume
-		logging.LogDebug("setting up volume mount %s", volume)
-
-		mode := "rw"
-		mountings := strings.Split(volume, ":")
-
-		// case of --volume a, anonymous mount
-		if len(mountings) <= 1 {
-			logging.LogDebug("setting up anonymous mount: %s. mounting empty tmps", volume)
-
-			src := filepath.Join(utils.GetLilipodHome(), "volumes", conf.ID, volume)
-			dest := filepath.Join(path, volume)
-
-			// we now create the volume in LILIPOD_HOME
-			err := os.MkdirAll(src, os.ModePerm)
-			if err != nil {
-				logging.LogDebug("error: %+v", err)
-
-				return fmt.Errorf("error creating anonyous mount %s: %w", volume, err)
-			}
-
-			err = fileutils.MountBind(src, dest)
-			if err != nil {
-				logging.LogDebug("error: %+v", err)
+		logging.LogDebug("setting up mount %s", volume)
 
-				return fmt.Errorf("error creating anonyous mount %s: %w", volume, err)
-			}
-
-			continue
-		}
-
-		// case of --volume a:b:mode
-		if len(mountings) > 2 {
-			mode = mountings[2]
-		}
-
-		modeUint := syscall.MS_BIND | syscall.MS_REC
-
-		if strings.Contains(mode, "rslave") {
-			modeUint |= syscall.MS_SLAVE | syscall.MS_REC
-		}
-
-		if strings.Contains(mode, "rshared") {
-			modeUint |= syscall.MS_SHARED | syscall.MS_REC
-		}
-
-		if strings.Contains(mode, "rprivate") {
-			modeUint |= syscall.MS_PRIVATE | syscall.MS_REC
+		mount, err := parseVolume(volume, conf)
+		if err != nil {
+			return err
 		}
 
-		source := mountings[0]
-		dest := filepath.Join(path, mountings[1])
-
-		logging.LogDebug("setting up mount: %s on %s as %s", source, dest, mode)
-
-		if !fileutils.Exist(source) {
-			logging.LogDebug("path %s does not exist on host", source)
+		err = mount.prepare(path)
+		if err != nil {
+			logging.LogDebug("error: %+v", err)
 
-			return fmt.Errorf("path %s does not exist on host", source)
+			return err
 		}
 
-		err := fileutils.Mount(source, dest, uintptr(modeUint))
+		err = mount.mount(path)
 		if err != nil {
 			logging.LogDebug("error: %+v", err)
 
-			return fmt.Errorf("failed to mount %s on %s: %w", source, dest, err)
+			return fmt.Errorf("failed to mount %s on %s: %w", mount.Source, mount.Destination, err)
 		}
 	}
 
@@ -532,6 +496,22 @@ func SetupRootfs(conf utils.Config) error {
 		return fmt.Errorf("setup pty: %w", err)
 	}
 
+	return writeContainerEnv(path, conf)
+}
+
+// formatContainerEnv returns the content of the /run/.containerenv of input container.
+func formatContainerEnv(conf utils.Config) string {
+	return fmt.Sprintf(`engine="%s"
+name="%s"
+id="%s"
+image="%s"
+imageid="%s"
+`, "lilipod-"+constants.Version, conf.Names, conf.ID, conf.Image, imageutils.GetID(conf.Image))
+}
+
+// writeContainerEnv will populate the /run/.containerenv of the rootfs in path.
+// Running containers are found by the ID in this file.
+func writeContainerEnv(path string, conf utils.Config) error {
 	logging.LogDebug("populating /run/.containerenv")
 
 	// setting this file ensures compatibility and gives back some info
@@ -544,14 +524,7 @@ func SetupRootfs(conf utils.Config) error {
 
 	defer func() { _ = infoFile.Close() }()
 
-	info := fmt.Sprintf(`engine="%s"
-name="%s"
-id="%s"
-image="%s"
-imageid="%s"
-`, "lilipod-"+constants.Version, conf.Names, GetID(conf.Names), conf.Image, imageutils.GetID(conf.Image))
-
-	_, err = infoFile.WriteString(info)
+	_, err = infoFile.WriteString(formatContainerEnv(conf))
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
@@ -629,6 +602,10 @@ func PivotRoot(path string) error {
 	return nil
 }
 
+// ErrEntrypoint is wrapped by the errors of RunContainer about the entrypoint
+// that cannot be found or executed.
+var ErrEntrypoint = errors.New("cannot run entrypoint")
+
 // RunContainer will start specified container in path, with tty if enabled.
 // This will:
 //   - SetupRootfs
@@ -647,6 +624,18 @@ func RunContainer(tty bool, conf utils.Config) error {
 		return fmt.Errorf("setup rootfs: %w", err)
 	}
 
+	timezone, err := setupTimezone(GetRootfsDir(conf.ID), conf)
+	if err != nil {
+		logging.LogWarning("failed to set up timezone: %v", err)
+	}
+
+	// image or user defined TZ takes precedence
+	if timezone != "" && !hasEnv(conf.Env, "TZ") {
+		conf.Env = append(conf.Env, "TZ="+timezone)
+	}
+
+	conf.Env = appendLocaleEnv(conf.Env)
+
 	err = PivotRoot(GetRootfsDir(conf.ID))
 	if err != nil {
 		logging.LogError("error: %+v", err)
@@ -683,11 +672,48 @@ func RunContainer(tty bool, conf utils.Config) error {
 		return fmt.Errorf("error setting hostname for namespace: %w", err)
 	}
 
+	// and the NIS domainname, in the same UTS namespace.
+	if conf.Domainname != "" {
+		logging.LogDebug("setting container domainname to %s", conf.Domainname)
+
+		err = syscall.Setdomainname([]byte(conf.Domainname))
+		if err != nil {
+			logging.LogDebug("error: %+v", err)
+
+			return fmt.Errorf("error setting domainname for namespace: %w", err)
+		}
+	}
+
+	// generate missing locales while we're still root in the container
+	if conf.LocaleGen {
+		logging.LogDebug("generating required locales")
+
+		generateLocales(conf.Env)
+	}
+
 	logging.LogDebug("become user: %s", conf.User)
 
 	// become the user that we're reuired to be
 	uid, gid := procutils.GetUIDGID(conf.User)
 
+	if len(conf.GroupAdd) > 0 {
+		groups, err := getGroupIDs("/", conf.GroupAdd)
+		if err != nil {
+			logging.LogDebug("error: %+v", err)
+
+			return err
+		}
+
+		logging.LogDebug("setting supplementary groups: %v", groups)
+
+		err = syscall.Setgroups(groups)
+		if err != nil {
+			logging.LogDebug("error: %+v", err)
+
+			return fmt.Errorf("error setting supplementary groups: %w", err)
+		}
+	}
+
 	err = syscall.Setgid(gid)
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
@@ -721,7 +747,7 @@ func RunContainer(tty bool, conf utils.Config) error {
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
-		return err
+		return fmt.Errorf("%w %s: %w", ErrEntrypoint, command, err)
 	}
 
 	if err := setCapabilities(keepCaps...); err != nil {
@@ -729,17 +755,30 @@ func RunContainer(tty bool, conf utils.Config) error {
 		os.Exit(1)
 	}
 
-	if tty {
-		args := append([]string{constants.PtyAgentPath}, conf.Entrypoint...)
+	if tty || conf.Init {
+		args := conf.Entrypoint
+
+		if tty {
+			args = append([]string{constants.PtyAgentPath}, args...)
+		}
+
+		// the init is the parent of the pty agent too, if any
+		if conf.Init {
+			args = append([]string{constants.PtyAgentPath, constants.InitAgentFlag}, args...)
+		}
+
+		logging.LogDebug("tty or init requested, execute entrypoint with agent: %s", args)
 
-		logging.LogDebug("tty requested, execute entrypoint with agent: %s", args)
+		err = syscall.Exec(constants.PtyAgentPath, args, conf.Env)
 
-		return syscall.Exec(constants.PtyAgentPath, args, conf.Env)
+		return fmt.Errorf("%w %s: %w", ErrEntrypoint, constants.PtyAgentPath, err)
 	}
 
 	logging.LogDebug("execute entrypoint: %s", conf.Entrypoint)
 
-	return syscall.Exec(commandPath, conf.Entrypoint, conf.Env)
+	err = syscall.Exec(commandPath, conf.Entrypoint, conf.Env)
+
+	return fmt.Errorf("%w %s: %w", ErrEntrypoint, command, err)
 }
 
 var keepCaps = []string{
@@ -795,3 +834,14 @@ func setCapabilities(keepCaps ...string) error {
 
 	return nil
 }
+
+// hasEnv returns true if input env list contains input variable.
+func hasEnv(env []string, variable string) bool {
+	for _, v := range env {
+		if strings.HasPrefix(v, variable+"=") {
+			return true
+		}
+	}
+
+	return false
+}
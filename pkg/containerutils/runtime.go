@@ -0,0 +1,266 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/netns"
+	"github.com/89luca89/lilipod/pkg/ocispec"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Runtimes that can execute the containers.
+// The builtin runtime is lilipod itself, the others are external OCI runtimes
+// that are delegated an OCI bundle generated from the container's config.
+const (
+	RuntimeBuiltin = "builtin"
+	RuntimeCrun    = "crun"
+	RuntimeRunc    = "runc"
+)
+
+// ValidateRuntime returns an error if input runtime is not supported or not
+// available on the host.
+func ValidateRuntime(runtime string) error {
+	switch runtime {
+	case "", RuntimeBuiltin:
+		return nil
+	case RuntimeCrun, RuntimeRunc:
+		_, err := exec.LookPath(runtime)
+		if err != nil {
+			return fmt.Errorf("runtime %s not found in PATH", runtime)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported runtime %s, use crun, runc or builtin", runtime)
+	}
+}
+
+// IsExternalRuntime returns true if input runtime is an external OCI runtime.
+func IsExternalRuntime(runtime string) bool {
+	return runtime == RuntimeCrun || runtime == RuntimeRunc
+}
+
+// getRuntimeArgs returns the base arguments for the runtime of input container.
+// The state is kept in lilipod's home, as the default one may not be writable
+// from inside our user namespace.
+func getRuntimeArgs(config utils.Config) []string {
+	return []string{"--root", filepath.Join(utils.GetLilipodHome(), "runtime", config.Runtime)}
+}
+
+// generateRuntimeCommand will write the OCI bundle of input container and return
+// the command to run it with its external runtime.
+// If the container has a private network, the namespace is set up here, and
+// returned so that it can be cleaned up once the container exits.
+func generateRuntimeCommand(
+	tty bool,
+	config utils.Config,
+) (*exec.Cmd, *netns.NetworkNamespace, error) {
+	rootfs := GetRootfsDir(config.ID)
+
+	// the runtime does not know about it, but we need it to find the container
+	err := writeContainerEnv(rootfs, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the runtime only bind mounts the volumes, we create and seed them
+	err = prepareVolumes(rootfs, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec, err := generateSpec(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spec.Process.Terminal = tty
+
+	// the runtime runs our agent as the container's init, like the builtin one
+	if config.Init {
+		err = injectAgent(config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		spec.Process.Args = append([]string{constants.PtyAgentPath, constants.InitAgentFlag}, spec.Process.Args...)
+	}
+
+	timezone, err := setupTimezone(rootfs, config)
+	if err != nil {
+		logging.LogWarning("failed to set up timezone: %v", err)
+	}
+
+	if timezone != "" && !hasEnv(spec.Process.Env, "TZ") {
+		spec.Process.Env = append(spec.Process.Env, "TZ="+timezone)
+	}
+
+	spec.Process.Env = appendLocaleEnv(spec.Process.Env)
+
+	err = setRuntimeIDMappings(spec.Linux, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ns, err := setupNetworking(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	netnsPath := ""
+
+	if ns != nil {
+		// we're now in the new network namespace, so the network backend can attach to us,
+		// and the container will join it by path.
+		err = ns.Start(os.Getpid())
+		if err != nil {
+			_ = cleanupNetworking(ns)
+
+			return nil, nil, fmt.Errorf("failed to start network backend: %w", err)
+		}
+
+		netnsPath = ns.NetNSMountPath
+	} else if config.Network != constants.Host {
+		// the namespace of the container we joined, with container:<name> or
+		// in a slirp network
+		netnsPath, err = getJoinedNetworkPath(config)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for i, namespace := range spec.Linux.Namespaces {
+		if namespace.Type == ocispec.NetworkNamespace {
+			spec.Linux.Namespaces[i].Path = netnsPath
+		}
+	}
+
+	bundle := filepath.Join(GetDir(config.ID), "bundle")
+
+	err = os.MkdirAll(bundle, 0o755)
+	if err != nil {
+		_ = cleanupNetworking(ns)
+
+		return nil, nil, err
+	}
+
+	data, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		_ = cleanupNetworking(ns)
+
+		return nil, nil, err
+	}
+
+	err = os.WriteFile(filepath.Join(bundle, "config.json"), data, 0o644)
+	if err != nil {
+		_ = cleanupNetworking(ns)
+
+		return nil, nil, err
+	}
+
+	args := append(getRuntimeArgs(config), "run", "--bundle", bundle, config.ID)
+
+	return exec.Command(config.Runtime, args...), ns, nil
+}
+
+// setRuntimeIDMappings will replace the host id mappings of the spec, with the
+// ones relative to our user namespace, where the runtime is executed.
+// Without keep-id, we're already the container's root, so no user
+// namespace is needed.
+func setRuntimeIDMappings(linux *ocispec.Linux, config utils.Config) error {
+	namespaces := []ocispec.LinuxNamespace{}
+
+	for _, namespace := range linux.Namespaces {
+		if namespace.Type != ocispec.UserNamespace {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	linux.Namespaces = namespaces
+	linux.UIDMappings = nil
+	linux.GIDMappings = nil
+
+	if config.Userns != constants.KeepID || os.Getenv("ROOTFUL") == constants.TrueString {
+		return nil
+	}
+
+	var err error
+
+	linux.UIDMappings, err = getRuntimeKeepIDMappings(config.Uidmap)
+	if err != nil {
+		return err
+	}
+
+	linux.GIDMappings, err = getRuntimeKeepIDMappings(config.Gidmap)
+	if err != nil {
+		return err
+	}
+
+	linux.Namespaces = append(linux.Namespaces, ocispec.LinuxNamespace{Type: ocispec.UserNamespace})
+
+	return nil
+}
+
+// getRuntimeKeepIDMappings returns the keep-id mappings, as set by
+// procutils.SetProcessKeepIDMaps.
+func getRuntimeKeepIDMappings(idMap string) ([]ocispec.LinuxIDMapping, error) {
+	fields := strings.Split(idMap, ":")
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid id map %s", idMap)
+	}
+
+	id, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ocispec.LinuxIDMapping{
+		{ContainerID: 0, HostID: 1, Size: uint32(id)},
+		{ContainerID: uint32(id), HostID: 0, Size: 1},
+		{ContainerID: uint32(id) + 1, HostID: uint32(id) + 1, Size: uint32(size - id)},
+	}, nil
+}
+
+// generateRuntimeExecCommand returns the command to execute the entrypoint of
+// input config in a running container, through its external runtime.
+func generateRuntimeExecCommand(tty bool, config utils.Config) *exec.Cmd {
+	args := append(getRuntimeArgs(config), "exec")
+
+	if tty {
+		args = append(args, "--tty")
+	}
+
+	process, err := getSpecProcess(config)
+	if err == nil {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", process.User.UID, process.User.GID))
+	} else {
+		logging.LogWarning("cannot resolve user %s, executing as the container's user: %v", config.User, err)
+	}
+
+	if config.Workdir != "" {
+		args = append(args, "--cwd", config.Workdir)
+	}
+
+	for _, env := range appendLocaleEnv(config.Env) {
+		args = append(args, "--env", env)
+	}
+
+	args = append(args, config.ID)
+	args = append(args, config.Entrypoint...)
+
+	return exec.Command(config.Runtime, args...)
+}
@@ -0,0 +1,221 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Snapshot is a point-in-time copy of the writable layer of a container.
+type Snapshot struct {
+	Name    string
+	Created time.Time
+	Size    string
+}
+
+// getSnapshotsDir returns the path where the snapshots of input container are stored.
+func getSnapshotsDir(name string) string {
+	return filepath.Join(GetDir(name), "snapshots")
+}
+
+// getWritableLayer returns the path of the writable layer of input container:
+// the rootfs itself, or the dir holding the changes for the erofs storage driver.
+func getWritableLayer(config utils.Config) string {
+	if config.StorageDriver == imageutils.StorageDriverErofs {
+		return filepath.Join(GetDir(config.ID), "diff")
+	}
+
+	return GetRootfsDir(config.ID)
+}
+
+// copyLayer will copy the layer in src to dest, preserving ownership and
+// attributes. Where the filesystem supports it, files are reflinked instead
+// of copied, so snapshots are cheap until the files are changed.
+func copyLayer(src string, dest string) error {
+	err := os.MkdirAll(dest, 0o755)
+	if err != nil {
+		return err
+	}
+
+	out, err := exec.Command("cp", "-a", "--reflink=auto", src+"/.", dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error copying %s: %w: %s", src, err, string(out))
+	}
+
+	return nil
+}
+
+// CreateSnapshot will save a copy of the writable layer of input container,
+// with input snapshot name.
+func CreateSnapshot(name string, snapshot string) error {
+	if !validVolumeName.MatchString(snapshot) {
+		return fmt.Errorf("invalid snapshot name %s", snapshot)
+	}
+
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(getSnapshotsDir(name), snapshot)
+
+	if fileutils.Exist(dir) {
+		return fmt.Errorf("snapshot %s already exists", snapshot)
+	}
+
+	if IsRunning(name) {
+		logging.LogWarning("container %s is running, the snapshot could be inconsistent", name)
+	}
+
+	tmpDir := dir + ".tmp"
+
+	// always cleanup leftovers of failed snapshots
+	_ = os.RemoveAll(tmpDir)
+
+	logging.LogDebug("creating snapshot %s of %s in %s", snapshot, name, dir)
+
+	err = copyLayer(getWritableLayer(config), filepath.Join(tmpDir, "layer"))
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(tmpDir, "created"), []byte(time.Now().Format(time.RFC3339)), 0o644)
+	if err != nil {
+		_ = os.RemoveAll(tmpDir)
+
+		return err
+	}
+
+	return os.Rename(tmpDir, dir)
+}
+
+// GetSnapshots returns the snapshots of input container, oldest first.
+func GetSnapshots(name string) ([]Snapshot, error) {
+	result := []Snapshot{}
+
+	dirs, err := os.ReadDir(getSnapshotsDir(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasSuffix(dir.Name(), ".tmp") {
+			continue
+		}
+
+		path := filepath.Join(getSnapshotsDir(name), dir.Name())
+		snapshot := Snapshot{Name: dir.Name()}
+
+		created, err := fileutils.ReadFile(filepath.Join(path, "created"))
+		if err == nil {
+			snapshot.Created, _ = time.Parse(time.RFC3339, strings.TrimSpace(string(created)))
+		}
+
+		snapshot.Size, err = fileutils.DiscUsageMegaBytes(filepath.Join(path, "layer"))
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, snapshot)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	return result, nil
+}
+
+// RestoreSnapshot will replace the writable layer of input container with
+// the content of input snapshot. The container must not be running.
+func RestoreSnapshot(name string, snapshot string) error {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return err
+	}
+
+	source := filepath.Join(getSnapshotsDir(name), snapshot, "layer")
+
+	if !validVolumeName.MatchString(snapshot) || !fileutils.Exist(source) {
+		return fmt.Errorf("snapshot %s does not exist", snapshot)
+	}
+
+	if IsRunning(name) {
+		return fmt.Errorf("container %s is running, stop it before restoring a snapshot", name)
+	}
+
+	layer := getWritableLayer(config)
+	restored := layer + ".restore"
+	previous := layer + ".old"
+
+	_ = os.RemoveAll(restored)
+	_ = os.RemoveAll(previous)
+
+	logging.LogDebug("restoring snapshot %s of %s in %s", snapshot, name, layer)
+
+	// copy the snapshot first, so that a failure leaves the container untouched
+	err = copyLayer(source, restored)
+	if err != nil {
+		_ = os.RemoveAll(restored)
+
+		return err
+	}
+
+	err = os.Rename(layer, previous)
+	if err != nil {
+		_ = os.RemoveAll(restored)
+
+		return err
+	}
+
+	err = os.Rename(restored, layer)
+	if err != nil {
+		_ = os.Rename(previous, layer)
+
+		return err
+	}
+
+	// overlay's work dir must be empty when the upper dir changes
+	if config.StorageDriver == imageutils.StorageDriverErofs {
+		work := filepath.Join(GetDir(config.ID), "work")
+
+		err = os.RemoveAll(work)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(work, 0o755)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(previous)
+}
+
+// RemoveSnapshot will delete input snapshot of input container.
+func RemoveSnapshot(name string, snapshot string) error {
+	dir := filepath.Join(getSnapshotsDir(name), snapshot)
+
+	if !validVolumeName.MatchString(snapshot) || !fileutils.Exist(dir) {
+		return fmt.Errorf("snapshot %s does not exist", snapshot)
+	}
+
+	return os.RemoveAll(dir)
+}
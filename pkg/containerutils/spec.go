@@ -0,0 +1,348 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/ocispec"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// GenerateSpec will describe input container as an OCI runtime-spec config,
+// with the same mounts, namespaces, id mappings and process we set up when
+// entering it.
+func GenerateSpec(name string) (ocispec.Spec, error) {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return ocispec.Spec{}, err
+	}
+
+	return generateSpec(config)
+}
+
+// generateSpec will describe input config as an OCI runtime-spec config.
+func generateSpec(config utils.Config) (ocispec.Spec, error) {
+	if config.StorageDriver == imageutils.StorageDriverErofs &&
+		!fileutils.IsMountpoint(GetRootfsDir(config.ID)) {
+		logging.LogWarning("container %s uses the erofs storage driver, its rootfs must be mounted first",
+			config.Names)
+	}
+
+	process, err := getSpecProcess(config)
+	if err != nil {
+		return ocispec.Spec{}, err
+	}
+
+	mounts, err := getSpecMounts(config)
+	if err != nil {
+		return ocispec.Spec{}, err
+	}
+
+	linux, err := getSpecLinux(config)
+	if err != nil {
+		return ocispec.Spec{}, err
+	}
+
+	return ocispec.Spec{
+		Version:     ocispec.Version,
+		Process:     process,
+		Root:        &ocispec.Root{Path: GetRootfsDir(config.ID)},
+		Hostname:    config.Hostname,
+		Domainname:  config.Domainname,
+		Mounts:      mounts,
+		Annotations: config.Labels,
+		Linux:       linux,
+	}, nil
+}
+
+// getSpecProcess returns the process of input container, with its user
+// resolved from the container's /etc/passwd when it's not numeric.
+func getSpecProcess(config utils.Config) (*ocispec.Process, error) {
+	user := ocispec.User{}
+
+	username, groupname, _ := strings.Cut(config.User, ":")
+	if username == "" {
+		username = "0"
+	}
+
+	uid, err := strconv.ParseUint(username, 10, 32)
+	if err != nil {
+		entry, err := GetPasswdEntry(config.ID, config.User)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, _ = strconv.ParseUint(entry.UID, 10, 32)
+
+		if groupname == "" {
+			groupname = entry.GID
+		}
+	}
+
+	user.UID = uint32(uid)
+
+	if groupname == "" {
+		groupname = username
+	}
+
+	gid, err := strconv.ParseUint(groupname, 10, 32)
+	if err == nil {
+		user.GID = uint32(gid)
+	}
+
+	if len(config.GroupAdd) > 0 {
+		rootfs, err := GetRootfsPath(config.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		groups, err := getGroupIDs(rootfs, config.GroupAdd)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups {
+			user.AdditionalGids = append(user.AdditionalGids, uint32(group))
+		}
+	}
+
+	capabilities := []string{}
+	for _, capability := range keepCaps {
+		capabilities = append(capabilities, "CAP_"+strings.ToUpper(capability))
+	}
+
+	return &ocispec.Process{
+		User: user,
+		Args: config.Entrypoint,
+		Env:  config.Env,
+		Cwd:  config.Workdir,
+		Capabilities: &ocispec.Capabilities{
+			Bounding:  capabilities,
+			Effective: capabilities,
+			Permitted: capabilities,
+		},
+	}, nil
+}
+
+// getSpecMounts returns the basic mountpoints of input container, as set up
+// by setupMounts, followed by its volumes.
+func getSpecMounts(config utils.Config) ([]ocispec.Mount, error) {
+	mounts := []ocispec.Mount{}
+
+	if config.Pid == constants.Private {
+		mounts = append(mounts, ocispec.Mount{Destination: "/proc", Type: "proc", Source: "proc"})
+	} else {
+		mounts = append(mounts, getSpecBindMount("/proc", "/proc"))
+	}
+
+	mounts = append(mounts,
+		getSpecBindMount("/dev", "/dev"),
+		ocispec.Mount{
+			Destination: "/tmp",
+			Type:        "tmpfs",
+			Source:      "tmpfs",
+			Options:     []string{"nosuid", "nodev"},
+		},
+	)
+
+	if config.Ipc == constants.Private {
+		mounts = append(mounts,
+			ocispec.Mount{
+				Destination: "/dev/shm",
+				Type:        "tmpfs",
+				Source:      "shm",
+				Options:     []string{"nosuid", "noexec", "nodev", "mode=1777"},
+			},
+			ocispec.Mount{
+				Destination: "/dev/mqueue",
+				Type:        "mqueue",
+				Source:      "mqueue",
+				Options:     []string{"nosuid", "noexec", "nodev"},
+			},
+		)
+	} else {
+		mounts = append(mounts,
+			getSpecBindMount("/dev/shm", "/dev/shm"),
+			getSpecBindMount("/dev/mqueue", "/dev/mqueue"),
+		)
+	}
+
+	if config.Network == constants.Host {
+		mounts = append(mounts, getSpecBindMount("/etc/resolv.conf", "/etc/resolv.conf"))
+	}
+
+	if IsUserNetwork(config.Network) {
+		mounts = append(mounts, getSpecBindMount(getResolvConfPath(config.ID), "/etc/resolv.conf"))
+	}
+
+	for _, path := range linuxReadWritePaths {
+		mounts = append(mounts, getSpecBindMount(path, path))
+	}
+
+	if config.Cgroup != constants.Host {
+		mounts = append(mounts, ocispec.Mount{
+			Destination: "/sys/fs/cgroup",
+			Type:        "cgroup2",
+			Source:      "cgroup2",
+		})
+	}
+
+	volumes, err := getSpecVolumes(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(mounts, volumes...), nil
+}
+
+// getSpecVolumes converts the custom mounts and volumes of input container,
+// in the same formats accepted by setupVolumes.
+func getSpecVolumes(config utils.Config) ([]ocispec.Mount, error) {
+	mounts := []ocispec.Mount{}
+
+	for _, volume := range config.Mounts {
+		if volume == "" {
+			continue
+		}
+
+		parsed, err := parseVolume(volume, config)
+		if err != nil {
+			return nil, err
+		}
+
+		mount := getSpecBindMount(parsed.Source, parsed.Destination)
+
+		if parsed.Type == "tmpfs" {
+			mount = ocispec.Mount{
+				Destination: parsed.Destination,
+				Type:        "tmpfs",
+				Source:      "tmpfs",
+			}
+		}
+
+		if parsed.Readonly {
+			mount.Options = append(mount.Options, "ro")
+		}
+
+		if parsed.Propagation != "" {
+			mount.Options = append(mount.Options, parsed.Propagation)
+		}
+
+		mounts = append(mounts, mount)
+	}
+
+	return mounts, nil
+}
+
+// getSpecBindMount returns a recursive bind mount of source on destination.
+func getSpecBindMount(source string, destination string) ocispec.Mount {
+	return ocispec.Mount{
+		Destination: destination,
+		Type:        "bind",
+		Source:      source,
+		Options:     []string{"rbind"},
+	}
+}
+
+// getSpecLinux returns the namespaces, id mappings and masked paths of input container.
+func getSpecLinux(config utils.Config) (*ocispec.Linux, error) {
+	linux := &ocispec.Linux{
+		Namespaces: []ocispec.LinuxNamespace{
+			{Type: ocispec.MountNamespace},
+			{Type: ocispec.UTSNamespace},
+		},
+	}
+
+	namespaces := map[string]string{
+		ocispec.IPCNamespace:     config.Ipc,
+		ocispec.NetworkNamespace: config.Network,
+		ocispec.PIDNamespace:     config.Pid,
+		ocispec.CgroupNamespace:  config.Cgroup,
+	}
+
+	for _, namespace := range []string{
+		ocispec.IPCNamespace,
+		ocispec.NetworkNamespace,
+		ocispec.PIDNamespace,
+		ocispec.CgroupNamespace,
+	} {
+		if namespaces[namespace] == constants.Private {
+			linux.Namespaces = append(linux.Namespaces, ocispec.LinuxNamespace{Type: namespace})
+		}
+	}
+
+	// user networks and the ones of other containers are namespaces too, the
+	// runtime joins them by path
+	if config.Network != constants.Host && config.Network != constants.Private {
+		linux.Namespaces = append(linux.Namespaces, ocispec.LinuxNamespace{Type: ocispec.NetworkNamespace})
+	}
+
+	// rootful containers have no id mappings
+	if config.Uidmap != "" && config.Gidmap != "" {
+		linux.Namespaces = append(linux.Namespaces, ocispec.LinuxNamespace{Type: ocispec.UserNamespace})
+
+		var err error
+
+		linux.UIDMappings, err = getSpecIDMappings(config.Uidmap, config.Userns == constants.KeepID)
+		if err != nil {
+			return nil, err
+		}
+
+		linux.GIDMappings, err = getSpecIDMappings(config.Gidmap, config.Userns == constants.KeepID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !config.Privileged {
+		linux.MaskedPaths = append(linux.MaskedPaths, linuxMaskedFiles...)
+		linux.MaskedPaths = append(linux.MaskedPaths, linuxMaskedDirs...)
+		linux.ReadonlyPaths = linuxReadOnlyPaths
+	}
+
+	return linux, nil
+}
+
+// getSpecIDMappings converts input id map, in the form of id:subid:size, to
+// the mappings from the container to the host.
+// The rootless-helper maps 0 to the user's id and 1.. to the subids, with keep-id
+// the container then maps the user's id back on itself.
+func getSpecIDMappings(idMap string, keepID bool) ([]ocispec.LinuxIDMapping, error) {
+	fields := strings.Split(idMap, ":")
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid id map %s", idMap)
+	}
+
+	ids := make([]uint32, 3)
+
+	for i, field := range fields[:3] {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id map %s: %w", idMap, err)
+		}
+
+		ids[i] = uint32(id)
+	}
+
+	hostID, subID, size := ids[0], ids[1], ids[2]
+
+	if !keepID {
+		return []ocispec.LinuxIDMapping{
+			{ContainerID: 0, HostID: hostID, Size: 1},
+			{ContainerID: 1, HostID: subID, Size: size},
+		}, nil
+	}
+
+	return []ocispec.LinuxIDMapping{
+		{ContainerID: 0, HostID: subID, Size: hostID},
+		{ContainerID: hostID, HostID: hostID, Size: 1},
+		{ContainerID: hostID + 1, HostID: subID + hostID, Size: size - hostID},
+	}, nil
+}
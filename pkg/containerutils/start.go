@@ -4,9 +4,14 @@ package containerutils
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
 
 	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/events"
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/netns"
@@ -16,11 +21,288 @@ import (
 
 // Start will enter the target container.
 // If tty is specified, the container will be started in interactive mode with full shell.
-// If interactive only is specified, container will be started in interactive mode, but only stdin will be forwarded.
+// If streams are attached, container will be started in interactive mode, with only the selected
+// streams forwarded, stdin can be a file or a pipe.
 // Else the container will be started in background and all output will be saved in the logs.
-func Start(interactive, tty bool, config utils.Config) error {
+// Containers with an external runtime are delegated to it, instead of being entered by lilipod.
+// Once the container exits, it is started again according to its restart policy.
+func Start(streams procutils.Streams, tty bool, config utils.Config) error {
+	delay := restartDelay
+
+	// the network namespace of the container is created, or joined, by this
+	// thread, that has to fork the container in it: it is never unlocked, so
+	// that it is terminated with this goroutine
+	runtime.LockOSThread()
+
+	// the resolver of user networks forwards from the host's network, so
+	// it is set up before the container's one
+	var resolver *dnsResolver
+
+	if IsUserNetwork(config.Network) {
+		var err error
+
+		resolver, err = newDNSResolver(config)
+		if err != nil {
+			return err
+		}
+
+		defer resolver.Close()
+	}
+
+	for restarts := 0; ; restarts++ {
+		started := time.Now()
+
+		ran, err := startOnce(streams, tty, config, restarts, resolver)
+
+		refreshConfig(&config)
+
+		if !ran || !shouldRestart(config, err, restarts) {
+			removeAuto(config)
+
+			return err
+		}
+
+		// containers that ran for a while get restarted right away again
+		if time.Since(started) > restartResetTime {
+			delay = restartDelay
+		}
+
+		logging.LogWarning("container %s exited with code %d, restarting in %s",
+			config.Names, GetExitCode(err), delay)
+
+		time.Sleep(delay)
+
+		delay = min(delay*2, maxRestartDelay)
+
+		events.Emit("restart", config, map[string]string{"restartCount": strconv.Itoa(restarts + 1)})
+	}
+}
+
+// removeAuto will remove input container, if it is auto-removed, once it
+// exited and it is not restarted. It is done by the process supervising the
+// container, so that the ones started in background are removed too.
+func removeAuto(config utils.Config) {
+	if !config.AutoRemove {
+		return
+	}
+
+	logging.LogDebug("removing container %s", config.Names)
+
+	err := Remove(config.ID)
+	if err != nil {
+		logging.LogWarning("cannot remove container %s: %v", config.Names, err)
+	}
+}
+
+// startOnce will enter the target container, as described by Start, once,
+// with input resolver for the names of its network, if any.
+// Returns whether the container process was started, and its error.
+func startOnce(
+	streams procutils.Streams,
+	tty bool,
+	config utils.Config,
+	restarts int,
+	resolver *dnsResolver,
+) (bool, error) {
 	logging.LogDebug("entering container")
 
+	err := MountRootfs(config)
+	if err != nil {
+		logging.LogError("failed to mount rootfs: %v", err)
+		return false, err
+	}
+
+	// a failing pre-start hook prevents the container from starting
+	err = runHooks(config, HookPreStart)
+	if err != nil {
+		return false, err
+	}
+
+	if resolver != nil {
+		err = writeResolvConf(config)
+		if err != nil {
+			return false, fmt.Errorf("error setting DNS: %w", err)
+		}
+	}
+
+	var cmd *exec.Cmd
+
+	var ns *netns.NetworkNamespace
+
+	// containers with a tty started in background keep it, to attach to them
+	attachTTY := config.Tty && !tty && !streams.Attached() && !IsExternalRuntime(config.Runtime)
+
+	if IsExternalRuntime(config.Runtime) {
+		logging.LogDebug("delegating container to runtime %s", config.Runtime)
+
+		var runtimeNS *netns.NetworkNamespace
+
+		cmd, runtimeNS, err = generateRuntimeCommand(tty, config)
+		if err != nil {
+			logging.LogError("failed to generate runtime cmd: %v", err)
+			return false, err
+		}
+
+		// the network is already up, and is not needed once the container exits
+		defer func() { _ = cleanupNetworking(runtimeNS) }()
+	} else {
+		cmd, ns, err = generateBuiltinCommand(config)
+		if err != nil {
+			return false, err
+		}
+
+		if tty || attachTTY {
+			cmd.Args = append(cmd.Args, "--tty")
+		}
+	}
+
+	logging.LogDebug("container is starting with %+v", cmd.SysProcAttr)
+	logging.LogDebug("starting the container, executing %v", cmd.Args)
+
+	// Probe the container's health for as long as it's running
+	if HasHealthcheck(config) {
+		logging.LogDebug("starting healthcheck monitor")
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go monitorHealth(config, done)
+	}
+
+	// Record the container's resource usage, to inspect it after it exits
+	if config.StatsInterval != "" {
+		logging.LogDebug("starting stats history monitor")
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go monitorStats(config, done)
+	}
+
+	// Limit the container's resources once it's in its own cgroup
+	if HasResources(config) {
+		logging.LogDebug("starting resources monitor")
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go applyResources(config, done)
+	}
+
+	// Resolve the names of the containers of its network
+	if resolver != nil {
+		logging.LogDebug("starting dns resolver")
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go resolver.serve(done)
+	}
+
+	// Let the hooks find the container's process
+	if config.Hooks != nil || fileutils.Exist(HooksDir) {
+		done := make(chan struct{})
+		defer close(done)
+
+		go runPostStartHooks(config, done)
+	}
+
+	// Let supervisors find the container's process
+	if config.PidFile != "" {
+		done := make(chan struct{})
+		finished := make(chan struct{})
+
+		defer func() {
+			close(done)
+			<-finished
+		}()
+
+		go writePidFile(config, done, finished)
+	}
+
+	// Start the container process
+	markStarted(config.ID, restarts)
+	events.Emit("start", config, nil)
+
+	var startErr error
+	if tty {
+		startErr = procutils.RunWithTTY(cmd)
+	} else if streams.Attached() {
+		startErr = procutils.RunAttached(cmd, streams)
+	} else {
+		// keep the output of the previous runs around
+		rotateErr := rotateLogs(config.ID)
+		if rotateErr != nil {
+			logging.LogWarning("failed to rotate logs: %v", rotateErr)
+		}
+
+		// a client starting the container waits to attach to it first
+		waitAttach := os.Remove(getAttachWaitPath(config.ID)) == nil
+
+		// let lilipod attach reconnect to the container's output, and input
+		attach, err := newAttachServer(config, attachTTY)
+		if err != nil {
+			logging.LogWarning("cannot set up attach socket: %v", err)
+		} else {
+			cmd.Stdout = attach
+			cmd.Stderr = attach
+
+			if attach.stdin != nil {
+				cmd.Stdin = attach.stdin
+			}
+
+			if waitAttach && !attach.waitClient(attachWaitTimeout) {
+				logging.LogWarning("no client attached to container %s, starting it anyway", config.Names)
+			}
+		}
+
+		// Stop the container once nobody uses it, containers in foreground
+		// are always attached
+		if config.IdleTimeout != "" {
+			logging.LogDebug("starting idle monitor")
+
+			done := make(chan struct{})
+			defer close(done)
+
+			go monitorIdle(config, attach, done)
+		}
+
+		logfile := GetLogPath(config.ID, 0)
+		forward, closeForwarder := getLogForwarder(config)
+		startErr = procutils.RunDetached(cmd, logfile, forward)
+		closeForwarder()
+
+		if attach != nil {
+			attach.Close()
+		}
+	}
+
+	// the container could have been renamed while running
+	refreshConfig(&config)
+
+	markFinished(config.ID, startErr)
+	events.Emit("die", config, map[string]string{"exitCode": strconv.Itoa(GetExitCode(startErr))})
+
+	err = runHooks(config, HookPostStop, "LILIPOD_CONTAINER_EXIT_CODE="+strconv.Itoa(GetExitCode(startErr)))
+	if err != nil {
+		logging.LogWarning("%v", err)
+	}
+
+	// the network backend was started with the container, and is not needed
+	// once it exits
+	err = cleanupNetworking(ns)
+	if err != nil {
+		logging.LogWarning("%v", err)
+	}
+
+	// Return any error from starting the container
+	return true, startErr
+}
+
+// injectAgent will copy the pty agent in input container, if not already there.
+// The agents of images committed with older versions may not support the
+// init mode, so it's always refreshed for containers using it.
+func injectAgent(config utils.Config) error {
 	path := GetRootfsDir(config.ID)
 
 	logging.LogDebug("searching pty agent")
@@ -31,7 +313,7 @@ func Start(interactive, tty bool, config utils.Config) error {
 		return err
 	}
 
-	if !fileutils.Exist(filepath.Join(path, constants.PtyAgentPath)) {
+	if config.Init || !fileutils.Exist(filepath.Join(path, constants.PtyAgentPath)) {
 		logging.LogDebug("injecting pty agent")
 
 		err = os.MkdirAll(filepath.Join(path, filepath.Base(constants.PtyAgentPath)), 0o755)
@@ -61,60 +343,40 @@ func Start(interactive, tty bool, config utils.Config) error {
 		)
 	}
 
+	return nil
+}
+
+// generateBuiltinCommand will inject the pty agent in input container, and
+// return the command to enter it with lilipod itself.
+func generateBuiltinCommand(config utils.Config) (*exec.Cmd, *netns.NetworkNamespace, error) {
+	err := injectAgent(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	logging.LogDebug("ready to start the container")
 
-	// Set up network namespace if network isolation is requested
+	// Set up network namespace if network isolation is requested, or join
+	// the one of another container
 	var ns *netns.NetworkNamespace
-	if config.Network == "private" {
+	if config.Network != constants.Host {
 		logging.LogDebug("setting up network namespace")
 		ns, err = setupNetworking(config)
 		if err != nil {
 			logging.LogError("failed to set up network namespace: %v", err)
-			return err
+			return nil, nil, err
 		}
-		// Ensure cleanup on any error
-		defer func() {
-			if err != nil {
-				_ = cleanupNetworking(ns)
-			}
-		}()
 	}
 
 	cmd, err := generateEnterCommand(config)
 	if err != nil {
 		logging.LogError("failed to generate enter cmd: %v", err)
-		return err
-	}
-
-	logging.LogDebug("container is starting with %+v", cmd.SysProcAttr)
-	logging.LogDebug("starting the container, executing %v", cmd.Args)
-
-	// Start the container process
-	var startErr error
-	if tty {
-		cmd.Args = append(cmd.Args, "--tty")
-		startErr = procutils.RunWithTTY(cmd)
-	} else if interactive {
-		startErr = procutils.RunInteractive(cmd)
-	} else {
-		logfile := filepath.Join(path, "../current-logs")
-		startErr = procutils.RunDetached(cmd, logfile)
-	}
 
-	// If network namespace was created, start slirp4netns after the container process
-	if ns != nil {
-		pid, err := GetPid(config.ID)
-		if err != nil {
-			logging.LogError("failed to get container PID: %v", err)
-			return fmt.Errorf("failed to get container PID: %w", err)
-		}
+		// Ensure cleanup on any error
+		_ = cleanupNetworking(ns)
 
-		if err := ns.StartSlirp(pid); err != nil {
-			logging.LogError("failed to start slirp4netns: %v", err)
-			return fmt.Errorf("failed to start slirp4netns: %w", err)
-		}
+		return nil, nil, err
 	}
 
-	// Return any error from starting the container
-	return startErr
+	return cmd, ns, nil
 }
@@ -0,0 +1,215 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/events"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// State holds the runtime information of the last run of a container.
+// This is kept apart from the config, so that running containers never
+// need to rewrite it.
+type State struct {
+	Started  string `json:"started"`
+	Finished string `json:"finished,omitempty"`
+	ExitCode int    `json:"exitcode"`
+	// Paused is how the running container is paused, empty if it is not.
+	Paused string `json:"paused,omitempty"`
+	// RestartCount is how many times the restart policy restarted the container.
+	RestartCount int `json:"restartcount,omitempty"`
+	// Stopped is set when the container is stopped by lilipod stop, so that
+	// it is not restarted.
+	Stopped bool `json:"stopped,omitempty"`
+}
+
+// getStatePath returns the path of the state file for input container.
+func getStatePath(id string) string {
+	return filepath.Join(ContainerDir, id, "state")
+}
+
+// GetState returns the state of the last run of input container.
+func GetState(name string) (State, error) {
+	var state State
+
+	file, err := fileutils.ReadFile(getStatePath(GetID(name)))
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(file, &state)
+
+	return state, err
+}
+
+// saveState will write input state for input container id, failures are only logged.
+func saveState(id string, state State) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		logging.LogWarning("cannot encode container state: %v", err)
+
+		return
+	}
+
+	err = os.WriteFile(getStatePath(id), data, 0o644)
+	if err != nil {
+		logging.LogWarning("cannot save container state: %v", err)
+	}
+}
+
+// markStarted will record the start time of a new run of input container,
+// after the given number of restarts.
+func markStarted(id string, restarts int) {
+	saveState(id, State{Started: time.Now().Format(time.RFC3339Nano), RestartCount: restarts})
+}
+
+// markStopped will record that input container is being stopped on purpose.
+func markStopped(id string) {
+	state, err := GetState(id)
+	if err != nil {
+		return
+	}
+
+	state.Stopped = true
+
+	saveState(id, state)
+}
+
+// markFinished will record the end time and exit code of the current run of input container.
+func markFinished(id string, runErr error) {
+	state, err := GetState(id)
+	if err != nil {
+		state = State{}
+	}
+
+	state.Finished = time.Now().Format(time.RFC3339Nano)
+	state.ExitCode = GetExitCode(runErr)
+
+	saveState(id, state)
+}
+
+// writePidFile will write the pid of input container to its pidfile as soon as
+// it is running. The pidfile is removed once the done channel is closed, then
+// the finished channel is closed.
+func writePidFile(config utils.Config, done chan struct{}, finished chan struct{}) {
+	defer close(finished)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	written := false
+
+	for {
+		select {
+		case <-done:
+			if written {
+				_ = os.Remove(config.PidFile)
+			}
+
+			return
+		case <-ticker.C:
+			if written {
+				continue
+			}
+
+			pid, err := GetPid(config.ID)
+			if err != nil {
+				continue
+			}
+
+			err = fileutils.WriteFile(config.PidFile, []byte(strconv.Itoa(pid)), 0o644)
+			if err != nil {
+				logging.LogWarning("cannot write pidfile %s: %v", config.PidFile, err)
+			}
+
+			written = true
+		}
+	}
+}
+
+// populateState will fill in the runtime information of input config,
+// from its state file.
+func populateState(config *utils.Config) {
+	state, err := GetState(config.ID)
+	if err != nil {
+		return
+	}
+
+	config.Started = state.Started
+	config.Finished = state.Finished
+	config.ExitCode = state.ExitCode
+	config.RestartCount = state.RestartCount
+}
+
+// errWaitDone stops reading the events once the awaited container exited.
+var errWaitDone = errors.New("container exited")
+
+// Wait will block until input container exits, and return its exit code.
+// Containers that already exited return the code of their last run at once.
+// The exit code is taken from the die event, so that it is not lost when the
+// container is removed as it exits, eg: with run --rm.
+func Wait(name string) (int, error) {
+	id := GetID(name)
+
+	if !fileutils.Exist(filepath.Join(ContainerDir, id)) {
+		return -1, fmt.Errorf("container %s does not exist", name)
+	}
+
+	since := time.Now()
+
+	state, err := GetState(id)
+	if err == nil && state.Finished != "" && !IsRunning(id) {
+		return state.ExitCode, nil
+	}
+
+	logging.LogDebug("waiting for container %s to exit", name)
+
+	exitCode := -1
+	removed := false
+
+	err = events.Read(true, func(event events.Event) error {
+		if event.ID != id {
+			return nil
+		}
+
+		// skip the events of the previous runs
+		eventTime, err := time.Parse(time.RFC3339Nano, event.Time)
+		if err != nil || eventTime.Before(since) {
+			return nil
+		}
+
+		switch event.Action {
+		case "die":
+			exitCode, err = strconv.Atoi(event.Attributes["exitCode"])
+			if err != nil {
+				exitCode = -1
+			}
+
+			return errWaitDone
+		case "destroy":
+			removed = true
+
+			return errWaitDone
+		}
+
+		return nil
+	})
+	if !errors.Is(err, errWaitDone) {
+		return -1, err
+	}
+
+	if removed {
+		return -1, fmt.Errorf("container %s was removed before exiting", name)
+	}
+
+	return exitCode, nil
+}
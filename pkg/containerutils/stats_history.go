@@ -0,0 +1,170 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// StatsHistorySize is the number of samples kept in the stats history of a
+// container, older samples are overwritten.
+const StatsHistorySize = 4096
+
+// statsRecordSize is the size on disk of a sample: the unix timestamp followed
+// by the cpu usage, memory usage, memory limit and pids.
+const statsRecordSize = 5 * 8
+
+// StatsRecord is a resource usage sample of the stats history.
+type StatsRecord struct {
+	Timestamp time.Time
+	cgrouputils.Stats
+}
+
+// getStatsHistoryPath returns the path of the stats history file for input container.
+func getStatsHistoryPath(name string) string {
+	return filepath.Join(GetDir(name), "stats-history")
+}
+
+// ValidateStatsInterval will check that input stats history interval is valid.
+func ValidateStatsInterval(interval string) error {
+	if interval == "" {
+		return nil
+	}
+
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("invalid stats history interval %s: %w", interval, err)
+	}
+
+	if duration < time.Second {
+		return fmt.Errorf("stats history interval must be at least 1s")
+	}
+
+	return nil
+}
+
+// AppendStatsHistory will record a sample in the stats history of input container.
+// The file is a ring buffer: a header with the number of written samples,
+// followed by StatsHistorySize fixed size records.
+func AppendStatsHistory(name string, record StatsRecord) error {
+	file, err := os.OpenFile(getStatsHistoryPath(name), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	header := make([]byte, 8)
+
+	_, err = file.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	count := binary.LittleEndian.Uint64(header)
+
+	data := make([]byte, statsRecordSize)
+	binary.LittleEndian.PutUint64(data[0:], uint64(record.Timestamp.Unix()))
+	binary.LittleEndian.PutUint64(data[8:], record.CPUUsageUsec)
+	binary.LittleEndian.PutUint64(data[16:], record.MemoryUsage)
+	binary.LittleEndian.PutUint64(data[24:], record.MemoryLimit)
+	binary.LittleEndian.PutUint64(data[32:], record.Pids)
+
+	_, err = file.WriteAt(data, int64(8+(count%StatsHistorySize)*statsRecordSize))
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint64(header, count+1)
+
+	_, err = file.WriteAt(header, 0)
+
+	return err
+}
+
+// GetStatsHistory returns the recorded samples of input container newer than
+// since, oldest first.
+func GetStatsHistory(name string, since time.Time) ([]StatsRecord, error) {
+	result := []StatsRecord{}
+
+	content, err := os.ReadFile(getStatsHistoryPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	if len(content) < 8 {
+		return result, nil
+	}
+
+	for offset := 8; offset+statsRecordSize <= len(content); offset += statsRecordSize {
+		data := content[offset : offset+statsRecordSize]
+
+		timestamp := int64(binary.LittleEndian.Uint64(data[0:]))
+		if timestamp == 0 || time.Unix(timestamp, 0).Before(since) {
+			continue
+		}
+
+		result = append(result, StatsRecord{
+			Timestamp: time.Unix(timestamp, 0),
+			Stats: cgrouputils.Stats{
+				CPUUsageUsec: binary.LittleEndian.Uint64(data[8:]),
+				MemoryUsage:  binary.LittleEndian.Uint64(data[16:]),
+				MemoryLimit:  binary.LittleEndian.Uint64(data[24:]),
+				Pids:         binary.LittleEndian.Uint64(data[32:]),
+			},
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+
+	return result, nil
+}
+
+// monitorStats will periodically record the resource usage of input container
+// in its stats history until the done channel is closed.
+func monitorStats(config utils.Config, done chan struct{}) {
+	interval, err := time.ParseDuration(config.StatsInterval)
+	if err != nil {
+		logging.LogWarning("invalid stats history interval %s: %v", config.StatsInterval, err)
+
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			sample, err := GetStats(config.ID)
+			if err != nil {
+				logging.LogDebug("cannot sample %s: %v", config.Names, err)
+
+				continue
+			}
+
+			err = AppendStatsHistory(config.ID, StatsRecord{Timestamp: now, Stats: sample})
+			if err != nil {
+				logging.LogDebug("cannot record stats of %s: %v", config.Names, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultStopSignal is the signal sent to stop containers, when neither the
+// container nor its image specify one.
+const DefaultStopSignal = "SIGTERM"
+
+// DefaultStopTimeout is how many seconds we wait for containers to stop, before
+// killing them, when the container does not specify it.
+const DefaultStopTimeout = 10
+
+// ValidateStopConfig will check that input stop signal and stop timeout are valid.
+// An empty signal is valid, the one of the image is used.
+func ValidateStopConfig(signal string, timeout int) error {
+	if signal != "" {
+		_, err := procutils.ParseSignal(signal)
+		if err != nil {
+			return err
+		}
+	}
+
+	if timeout < 1 {
+		return fmt.Errorf("invalid stop timeout %d, must be at least 1 second", timeout)
+	}
+
+	return nil
+}
+
+// getStopSignal returns the signal to stop input container with.
+func getStopSignal(config utils.Config) unix.Signal {
+	if config.Stopsignal == "" {
+		return unix.SIGTERM
+	}
+
+	signal, err := procutils.ParseSignal(config.Stopsignal)
+	if err != nil {
+		logging.LogWarning("container %s: %v, using %s", config.Names, err, DefaultStopSignal)
+
+		return unix.SIGTERM
+	}
+
+	return signal
+}
+
+// getStopTimeout returns how many seconds to wait for input container to stop.
+// Containers created before it was configurable use DefaultStopTimeout.
+func getStopTimeout(config utils.Config) int {
+	if config.StopTimeout < 1 {
+		return DefaultStopTimeout
+	}
+
+	return config.StopTimeout
+}
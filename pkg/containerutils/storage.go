@@ -0,0 +1,129 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// prepareErofsRootfs will ensure input image is available as an EROFS filesystem,
+// and create the dirs holding the changes of container id on top of it.
+func prepareErofsRootfs(image string, id string) error {
+	_, err := imageutils.BuildErofs(image)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{"lower", "diff", "work"} {
+		err = os.MkdirAll(filepath.Join(ContainerDir, id, dir), 0o755)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MountRootfs will make the rootfs of input container available in its rootfs dir.
+// For containers using the erofs storage driver, this mounts the image's EROFS
+// filesystem read-only, with an overlay of the container's changes on top.
+// Mounts only live in the current mount namespace, and are gone with it.
+func MountRootfs(config utils.Config) error {
+	if config.StorageDriver != imageutils.StorageDriverErofs {
+		return nil
+	}
+
+	dir := filepath.Join(ContainerDir, config.ID)
+	rootfs := filepath.Join(dir, "rootfs")
+
+	if fileutils.IsMountpoint(rootfs) {
+		return nil
+	}
+
+	err := imageutils.VerifyErofs(config.Image)
+	if err != nil {
+		return err
+	}
+
+	// ensure the mounts do not leak in the host's namespace
+	err = syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("error setting private mount: %w", err)
+	}
+
+	lower := filepath.Join(dir, "lower")
+
+	if !fileutils.IsMountpoint(lower) {
+		var cmd *exec.Cmd
+
+		// loop devices are only available to proper root, else we use the
+		// FUSE implementation, which works in user namespaces.
+		if os.Getenv("ROOTFUL") == constants.TrueString {
+			cmd = exec.Command("mount", "-t", "erofs", "-o", "ro,loop",
+				imageutils.GetErofsPath(config.Image), lower)
+		} else {
+			cmd = exec.Command("erofsfuse", imageutils.GetErofsPath(config.Image), lower)
+		}
+
+		logging.LogDebug("mounting erofs image, executing %v", cmd.Args)
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to mount erofs image: %w: %s", err, string(out))
+		}
+	}
+
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		lower, filepath.Join(dir, "diff"), filepath.Join(dir, "work"))
+
+	// unprivileged overlays keep their metadata in user xattrs
+	if os.Getenv("ROOTFUL") != constants.TrueString {
+		options += ",userxattr"
+	}
+
+	logging.LogDebug("mounting overlay on %s with %s", rootfs, options)
+
+	err = syscall.Mount("overlay", rootfs, "overlay", 0, options)
+	if err != nil {
+		return fmt.Errorf("failed to mount overlay on %s: %w", rootfs, err)
+	}
+
+	return nil
+}
+
+// GetRootfsPath returns a path where the rootfs of input container can be
+// accessed from the host, mounting it if needed.
+func GetRootfsPath(name string) (string, error) {
+	config, err := utils.LoadConfig(filepath.Join(GetDir(name), "config"))
+	if err != nil {
+		return "", err
+	}
+
+	if config.StorageDriver != imageutils.StorageDriverErofs {
+		return GetRootfsDir(name), nil
+	}
+
+	// the overlay of a running container cannot be mounted twice, so we go
+	// through the container's own root.
+	pid, err := GetPid(name)
+	if err == nil {
+		return filepath.Join("/proc", strconv.Itoa(pid), "root"), nil
+	}
+
+	err = MountRootfs(config)
+	if err != nil {
+		return "", err
+	}
+
+	return GetRootfsDir(name), nil
+}
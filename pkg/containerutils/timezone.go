@@ -0,0 +1,112 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// TimezoneLocal mirrors the host's timezone, this is the default.
+const TimezoneLocal = "local"
+
+// zoneinfoDir is where the host's timezone database is found.
+const zoneinfoDir = "/usr/share/zoneinfo"
+
+// ValidateTimezone returns an error if input timezone is not found on the host.
+func ValidateTimezone(timezone string) error {
+	if timezone == "" || timezone == TimezoneLocal {
+		return nil
+	}
+
+	if filepath.IsAbs(timezone) || strings.Contains(timezone, "..") {
+		return fmt.Errorf("invalid timezone %s", timezone)
+	}
+
+	if !fileutils.Exist(filepath.Join(zoneinfoDir, timezone)) {
+		return fmt.Errorf("unknown timezone %s, not found in %s", timezone, zoneinfoDir)
+	}
+
+	return nil
+}
+
+// getHostTimezone returns the name of the host's timezone, eg: Europe/Rome.
+// If it cannot be found, an empty string is returned.
+func getHostTimezone() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" && !filepath.IsAbs(tz) {
+		return tz
+	}
+
+	target, err := filepath.EvalSymlinks("/etc/localtime")
+	if err != nil {
+		return ""
+	}
+
+	// Path has a structure:
+	//    /usr/share/zoneinfo/Area/Location
+	_, zone, found := strings.Cut(target, "zoneinfo/")
+	if !found {
+		return ""
+	}
+
+	return zone
+}
+
+// setupTimezone will write the timezone requested by conf as the /etc/localtime
+// of the rootfs in path, and return the value to use for the TZ env variable.
+// The file is written instead of bind mounted, as /etc/localtime is usually an
+// absolute symlink that would resolve to the host's paths.
+func setupTimezone(path string, conf utils.Config) (string, error) {
+	timezone := conf.Timezone
+	if timezone == "" || timezone == TimezoneLocal {
+		timezone = getHostTimezone()
+	}
+
+	source := "/etc/localtime"
+	if timezone != "" {
+		source = filepath.Join(zoneinfoDir, timezone)
+	}
+
+	if !fileutils.Exist(source) {
+		logging.LogWarning("timezone file %s not found, leaving container's timezone untouched", source)
+
+		return "", nil
+	}
+
+	logging.LogDebug("setting up timezone %s from %s", timezone, source)
+
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return "", err
+	}
+
+	localtime := filepath.Join(path, "etc", "localtime")
+
+	if !fileutils.Exist(filepath.Dir(localtime)) {
+		logging.LogDebug("container has no /etc, skipping timezone setup")
+
+		return "", nil
+	}
+
+	// remove the existing symlink or file, else we would write where it points.
+	err = os.Remove(localtime)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	err = os.WriteFile(localtime, content, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	if timezone == "" {
+		return ":/etc/localtime", nil
+	}
+
+	return timezone, nil
+}
@@ -0,0 +1,398 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+)
+
+// clockTicks is the unit of the times in /proc/PID/stat, USER_HZ, that is
+// always 100 on Linux.
+const clockTicks = 100
+
+// DefaultTopDescriptors are the columns shown by top, when none is specified.
+var DefaultTopDescriptors = []string{"user", "pid", "ppid", "pcpu", "etime", "tty", "time", "args"}
+
+// Process is a process running in a container, with its IDs both as seen in
+// the container and on the host.
+type Process struct {
+	PID        int
+	HostPID    int
+	PPID       int
+	HostPPID   int
+	User       string
+	HostUser   string
+	Group      string
+	HostGroup  string
+	State      string
+	Nice       int
+	TTY        string
+	CPUTime    time.Duration
+	Elapsed    time.Duration
+	CPUPercent float64
+	VSZ        uint64
+	RSS        uint64
+	Comm       string
+	Args       string
+}
+
+// topDescriptor is a column of top: its header and how to get its value.
+type topDescriptor struct {
+	header string
+	value  func(process Process) string
+}
+
+// topDescriptors are the supported columns of top, named after the ps ones.
+var topDescriptors = map[string]topDescriptor{
+	"args":   {"COMMAND", func(p Process) string { return p.Args }},
+	"comm":   {"COMMAND", func(p Process) string { return p.Comm }},
+	"etime":  {"ELAPSED", func(p Process) string { return p.Elapsed.String() }},
+	"group":  {"GROUP", func(p Process) string { return p.Group }},
+	"hgroup": {"HGROUP", func(p Process) string { return p.HostGroup }},
+	"hpid":   {"HPID", func(p Process) string { return strconv.Itoa(p.HostPID) }},
+	"hppid":  {"HPPID", func(p Process) string { return strconv.Itoa(p.HostPPID) }},
+	"huser":  {"HUSER", func(p Process) string { return p.HostUser }},
+	"nice":   {"NI", func(p Process) string { return strconv.Itoa(p.Nice) }},
+	"pcpu":   {"%CPU", func(p Process) string { return fmt.Sprintf("%.1f", p.CPUPercent) }},
+	"pid":    {"PID", func(p Process) string { return strconv.Itoa(p.PID) }},
+	"ppid":   {"PPID", func(p Process) string { return strconv.Itoa(p.PPID) }},
+	"rss":    {"RSS", func(p Process) string { return strconv.FormatUint(p.RSS, 10) }},
+	"state":  {"STATE", func(p Process) string { return p.State }},
+	"time":   {"TIME", func(p Process) string { return p.CPUTime.String() }},
+	"tty":    {"TTY", func(p Process) string { return p.TTY }},
+	"user":   {"USER", func(p Process) string { return p.User }},
+	"vsz":    {"VSZ", func(p Process) string { return strconv.FormatUint(p.VSZ, 10) }},
+}
+
+// GetTopDescriptors returns the names of the supported columns of top.
+func GetTopDescriptors() []string {
+	result := []string{}
+
+	for name := range topDescriptors {
+		result = append(result, name)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// Top returns the header and the rows of the table of the processes running
+// in input container, with the columns in input descriptors.
+func Top(name string, descriptors []string) ([]string, [][]string, error) {
+	header := []string{}
+
+	for _, descriptor := range descriptors {
+		column, ok := topDescriptors[descriptor]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported descriptor %s, valid descriptors are: %s",
+				descriptor, strings.Join(GetTopDescriptors(), ", "))
+		}
+
+		header = append(header, column.header)
+	}
+
+	processes, err := GetProcesses(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := [][]string{}
+
+	for _, process := range processes {
+		row := []string{}
+
+		for _, descriptor := range descriptors {
+			row = append(row, topDescriptors[descriptor].value(process))
+		}
+
+		rows = append(rows, row)
+	}
+
+	return header, rows, nil
+}
+
+// GetProcesses returns the processes running in input container, ordered by pid.
+// Users and groups are mapped from the host to the container with the user
+// namespace of the processes, and resolved with the container's /etc/passwd
+// and /etc/group.
+func GetProcesses(name string) ([]Process, error) {
+	pids, err := getPids(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(pids)
+
+	uptime, err := getUptime()
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join("/proc", strconv.Itoa(pids[0]), "root")
+	users := getIDNames(filepath.Join(root, "etc", "passwd"))
+	groups := getIDNames(filepath.Join(root, "etc", "group"))
+	hostUsers := getIDNames("/etc/passwd")
+	hostGroups := getIDNames("/etc/group")
+
+	result := []Process{}
+	containerPids := map[int]int{}
+
+	for _, pid := range pids {
+		process, uid, gid, err := getProcess(pid, uptime)
+		if err != nil {
+			// the process exited in the meantime
+			continue
+		}
+
+		procDir := filepath.Join("/proc", strconv.Itoa(pid))
+
+		process.User = lookupIDName(users, mapHostID(filepath.Join(procDir, "uid_map"), uid))
+		process.Group = lookupIDName(groups, mapHostID(filepath.Join(procDir, "gid_map"), gid))
+		process.HostUser = lookupIDName(hostUsers, uid)
+		process.HostGroup = lookupIDName(hostGroups, gid)
+
+		containerPids[process.HostPID] = process.PID
+
+		result = append(result, process)
+	}
+
+	// parents outside of the container show as 0, like in the container
+	for i := range result {
+		result[i].PPID = containerPids[result[i].HostPPID]
+	}
+
+	return result, nil
+}
+
+// getProcess returns the process with input pid, with its host uid and gid,
+// computing its elapsed time and cpu usage with input uptime.
+func getProcess(pid int, uptime float64) (Process, int, int, error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return Process{}, 0, 0, err
+	}
+
+	// Line has a structure:
+	//    pid (comm) state ppid pgrp session tty_nr ...
+	// comm can contain spaces and parentheses, so we split at the last one.
+	end := bytes.LastIndexByte(stat, ')')
+	start := bytes.IndexByte(stat, '(')
+
+	if start < 0 || end < start {
+		return Process{}, 0, 0, fmt.Errorf("invalid stat for process %d", pid)
+	}
+
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 22 {
+		return Process{}, 0, 0, fmt.Errorf("invalid stat for process %d", pid)
+	}
+
+	process := Process{
+		PID:     pid,
+		HostPID: pid,
+		State:   fields[0],
+		Comm:    string(stat[start+1 : end]),
+	}
+
+	process.HostPPID, _ = strconv.Atoi(fields[1])
+	ttyNr, _ := strconv.Atoi(fields[4])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	process.Nice, _ = strconv.Atoi(fields[16])
+	starttime, _ := strconv.ParseUint(fields[19], 10, 64)
+	vsize, _ := strconv.ParseUint(fields[20], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+
+	process.TTY = getTTYName(ttyNr)
+	process.VSZ = vsize / 1024
+	process.RSS = rss * uint64(os.Getpagesize()) / 1024
+
+	cpuSeconds := float64(utime+stime) / clockTicks
+	elapsedSeconds := uptime - float64(starttime)/clockTicks
+
+	process.CPUTime = time.Duration(cpuSeconds) * time.Second
+	process.Elapsed = time.Duration(elapsedSeconds) * time.Second
+
+	if elapsedSeconds > 0 {
+		process.CPUPercent = cpuSeconds / elapsedSeconds * 100
+	}
+
+	cmdline, err := os.ReadFile(filepath.Join(procDir, "cmdline"))
+	if err == nil && len(cmdline) > 0 {
+		process.Args = strings.Join(strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), " ")
+	} else {
+		// kernel threads and zombies have no command line
+		process.Args = "[" + process.Comm + "]"
+	}
+
+	status, err := os.ReadFile(filepath.Join(procDir, "status"))
+	if err != nil {
+		return Process{}, 0, 0, err
+	}
+
+	uid, gid := 0, 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		values := strings.Fields(value)
+		if len(values) == 0 {
+			continue
+		}
+
+		switch key {
+		// the effective IDs, like ps does
+		case "Uid":
+			if len(values) > 1 {
+				uid, _ = strconv.Atoi(values[1])
+			}
+		case "Gid":
+			if len(values) > 1 {
+				gid, _ = strconv.Atoi(values[1])
+			}
+		// the pids in each of the process' pid namespaces, the last one is
+		// its own namespace
+		case "NSpid":
+			process.PID, _ = strconv.Atoi(values[len(values)-1])
+		}
+	}
+
+	return process, uid, gid, nil
+}
+
+// getUptime returns the seconds passed since the host booted.
+func getUptime() (float64, error) {
+	uptime, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(uptime))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid /proc/uptime")
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// getTTYName returns the name of the terminal with input device number, as
+// found in /proc/PID/stat.
+func getTTYName(ttyNr int) string {
+	major := (ttyNr >> 8) & 0xfff
+	minor := (ttyNr & 0xff) | ((ttyNr >> 12) & 0xfff00)
+
+	switch {
+	case ttyNr == 0:
+		return "?"
+	case major >= 136 && major <= 143:
+		return "pts/" + strconv.Itoa((major-136)*256+minor)
+	case major == 4 && minor < 64:
+		return "tty" + strconv.Itoa(minor)
+	case major == 4:
+		return "ttyS" + strconv.Itoa(minor-64)
+	default:
+		return "?"
+	}
+}
+
+// mapHostID returns the ID in the user namespace described by input uid_map
+// or gid_map of input host ID, or -1 if it is not mapped.
+func mapHostID(mapFile string, id int) int {
+	idMap, err := os.ReadFile(mapFile)
+	if err != nil {
+		return id
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(idMap))
+	for scanner.Scan() {
+		// Line has a structure:
+		//    inside outside count
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		inside, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		outside, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+
+		if id >= outside && id-outside < count {
+			return inside + id - outside
+		}
+	}
+
+	return -1
+}
+
+// getIDNames returns the names of the IDs in input /etc/passwd or /etc/group.
+func getIDNames(path string) map[int]string {
+	result := map[int]string{}
+
+	file, err := fileutils.ReadFile(path)
+	if err != nil {
+		return result
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.Trim(file, "\x00")))
+	for scanner.Scan() {
+		// Line has a structure:
+		//    name:password:id:...
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+
+		if _, ok := result[id]; !ok {
+			result[id] = fields[0]
+		}
+	}
+
+	return result
+}
+
+// lookupIDName returns the name of input ID, or the ID itself if it has none.
+// Unmapped IDs show as the overflow ID, like in the container.
+func lookupIDName(names map[int]string, id int) string {
+	if id < 0 {
+		return "nobody"
+	}
+
+	name, ok := names[id]
+	if !ok {
+		return strconv.Itoa(id)
+	}
+
+	return name
+}
@@ -0,0 +1,424 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/netns"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Drivers of user networks.
+//
+// The containers of a slirp network share the network namespace of the first
+// one started, and reach each other on localhost, so it works rootless.
+// The ones of a bridge network have an address each, on a bridge of the host,
+// so it needs root.
+const (
+	DriverBridge = "bridge"
+	DriverSlirp  = "slirp"
+)
+
+// validNetworkName matches the allowed names of user networks.
+var validNetworkName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// NetworkDir is the location of the user networks, one file each.
+var NetworkDir = filepath.Join(utils.GetLilipodHome(), "networks")
+
+// Network is a user network, that containers connect to with --network.
+type Network struct {
+	Name    string            `json:"name"`
+	ID      string            `json:"id"`
+	Driver  string            `json:"driver"`
+	Subnet  string            `json:"subnet"`
+	Gateway string            `json:"gateway"`
+	Created string            `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	// Containers are the names of the containers connected, filled by InspectNetwork.
+	Containers []string `json:"containers,omitempty"`
+}
+
+// GetBridgeName returns the name of the bridge of input network on the host.
+func (n Network) GetBridgeName() string {
+	return "lilipod" + n.ID[:8]
+}
+
+// getNetworkPath returns the path of the file of input user network.
+func getNetworkPath(name string) string {
+	return filepath.Join(NetworkDir, name+".json")
+}
+
+// IsUserNetwork returns whether input network mode is a user network.
+func IsUserNetwork(network string) bool {
+	if !validNetworkName.MatchString(network) {
+		return false
+	}
+
+	_, err := os.Stat(getNetworkPath(network))
+
+	return err == nil
+}
+
+// LoadNetwork returns the user network with input name.
+func LoadNetwork(name string) (Network, error) {
+	network := Network{}
+
+	content, err := os.ReadFile(getNetworkPath(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return network, fmt.Errorf("network %s does not exist", name)
+		}
+
+		return network, err
+	}
+
+	err = json.Unmarshal(content, &network)
+	if err != nil {
+		return network, fmt.Errorf("invalid network %s: %w", name, err)
+	}
+
+	return network, nil
+}
+
+// ListNetworks returns all the user networks, by name.
+func ListNetworks() ([]Network, error) {
+	result := []Network{}
+
+	entries, err := os.ReadDir(NetworkDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		name, ok := cutJSONSuffix(entry.Name())
+		if !ok {
+			continue
+		}
+
+		network, err := LoadNetwork(name)
+		if err != nil {
+			logging.LogWarning("%v, skipping", err)
+
+			continue
+		}
+
+		result = append(result, network)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	return result, nil
+}
+
+// cutJSONSuffix returns input file name without the .json extension, and
+// whether it had it.
+func cutJSONSuffix(name string) (string, bool) {
+	if filepath.Ext(name) != ".json" {
+		return "", false
+	}
+
+	return name[:len(name)-len(".json")], true
+}
+
+// CreateNetwork will create a user network with input name, driver and
+// subnet, allocated from 10.89.0.0/16 if empty, and labels in the key=value
+// form.
+func CreateNetwork(name, driver, subnet string, labels []string) (Network, error) {
+	if !validNetworkName.MatchString(name) {
+		return Network{}, fmt.Errorf("invalid network name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+
+	if name == constants.Private || name == constants.Host {
+		return Network{}, fmt.Errorf("network name %s is reserved", name)
+	}
+
+	if IsUserNetwork(name) {
+		return Network{}, fmt.Errorf("network %s already exists", name)
+	}
+
+	switch driver {
+	case DriverSlirp:
+	case DriverBridge:
+		if os.Getuid() != 0 {
+			return Network{}, fmt.Errorf("bridge networks can only be created by root, use the %s driver", DriverSlirp)
+		}
+	default:
+		return Network{}, fmt.Errorf("invalid driver %s, use %s or %s", driver, DriverBridge, DriverSlirp)
+	}
+
+	networks, err := ListNetworks()
+	if err != nil {
+		return Network{}, err
+	}
+
+	if subnet == "" {
+		subnet, err = allocateSubnet(networks)
+		if err != nil {
+			return Network{}, err
+		}
+	}
+
+	gateway, err := getGateway(driver, subnet)
+	if err != nil {
+		return Network{}, err
+	}
+
+	for _, network := range networks {
+		if subnetsOverlap(network.Subnet, subnet) {
+			return Network{}, fmt.Errorf("subnet %s overlaps subnet %s of network %s", subnet, network.Subnet, network.Name)
+		}
+	}
+
+	id, err := NewID()
+	if err != nil {
+		return Network{}, err
+	}
+
+	network := Network{
+		Name:    name,
+		ID:      id,
+		Driver:  driver,
+		Subnet:  subnet,
+		Gateway: gateway,
+		Created: time.Now().Format(time.RFC3339),
+		Labels:  utils.ListToMap(labels),
+	}
+
+	content, err := json.MarshalIndent(network, "", "  ")
+	if err != nil {
+		return Network{}, err
+	}
+
+	err = os.MkdirAll(NetworkDir, 0o755)
+	if err != nil {
+		return Network{}, err
+	}
+
+	return network, os.WriteFile(getNetworkPath(name), content, 0o644)
+}
+
+// subnetsOverlap returns whether input subnets have addresses in common, one
+// contains the other if so. Invalid subnets do not overlap.
+func subnetsOverlap(a, b string) bool {
+	_, netA, errA := net.ParseCIDR(a)
+	_, netB, errB := net.ParseCIDR(b)
+
+	if errA != nil || errB != nil {
+		return false
+	}
+
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+// allocateSubnet returns the first /24 of 10.89.0.0/16 not overlapping the
+// subnets of input networks.
+func allocateSubnet(networks []Network) (string, error) {
+	for i := 1; i < 255; i++ {
+		subnet := fmt.Sprintf("10.89.%d.0/24", i)
+
+		if !slices.ContainsFunc(networks, func(network Network) bool {
+			return subnetsOverlap(network.Subnet, subnet)
+		}) {
+			return subnet, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free subnet left in 10.89.0.0/16, use --subnet")
+}
+
+// getGateway returns the gateway of input subnet for input driver: the first
+// address, that the bridge has on the host, and the one of the backend for the
+// slirp driver, that uses /24 networks only.
+func getGateway(driver, subnet string) (string, error) {
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ip.To4() == nil || !ip.Equal(ipNet.IP) {
+		return "", fmt.Errorf("invalid subnet %s, use an IPv4 network like 10.89.1.0/24", subnet)
+	}
+
+	prefix, _ := ipNet.Mask.Size()
+
+	if driver == DriverSlirp {
+		if prefix != 24 {
+			return "", fmt.Errorf("invalid subnet %s, the %s driver needs a /24 network", subnet, DriverSlirp)
+		}
+
+		// the same addressing of slirp4netns, with the gateway on .2
+		gateway := ipNet.IP.To4()
+		gateway[3] = 2
+
+		return gateway.String(), nil
+	}
+
+	if prefix < 8 || prefix > 30 {
+		return "", fmt.Errorf("invalid subnet %s, use a network from /8 to /30", subnet)
+	}
+
+	gateway := ipNet.IP.To4()
+	gateway[3]++
+
+	return gateway.String(), nil
+}
+
+// InspectNetwork returns the user network with input name, with the names of
+// the containers connected to it.
+func InspectNetwork(name string) (Network, error) {
+	network, err := LoadNetwork(name)
+	if err != nil {
+		return network, err
+	}
+
+	containers, err := ListContainers(map[string]string{"network": name})
+	if err != nil {
+		return network, err
+	}
+
+	for _, container := range containers {
+		network.Containers = append(network.Containers, container.Names)
+	}
+
+	return network, nil
+}
+
+// RemoveNetwork will remove the user network with input name, and its bridge
+// on the host. It cannot be removed while containers are connected to it.
+func RemoveNetwork(name string) error {
+	network, err := InspectNetwork(name)
+	if err != nil {
+		return err
+	}
+
+	if len(network.Containers) > 0 {
+		return fmt.Errorf("network %s is used by containers %v, remove them first", name, network.Containers)
+	}
+
+	if network.Driver == DriverBridge {
+		err = netns.RemoveBridge(network.GetBridgeName(), network.Subnet)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(getNetworkPath(name))
+}
+
+// getSlirpAddress returns the address of the namespace of a slirp network,
+// the .100 one of slirp4netns.
+func (n Network) getSlirpAddress() string {
+	_, ipNet, err := net.ParseCIDR(n.Subnet)
+	if err != nil {
+		return ""
+	}
+
+	address := ipNet.IP.To4()
+	address[3] = 100
+
+	return address.String()
+}
+
+// GetNetworkOwner returns the ID of the running container of input slirp
+// network, that provides the network namespace to the others, and whether
+// there is one.
+func GetNetworkOwner(network string) (string, bool) {
+	if getNetworkDriver(network) != DriverSlirp {
+		return "", false
+	}
+
+	return getNetworkOwner(network, "")
+}
+
+// getNetworkOwner returns the ID of the running container of input slirp
+// network, that provides the network namespace to the others, but the one
+// with input ID, and whether there is one.
+func getNetworkOwner(network, exclude string) (string, bool) {
+	containers, err := ListContainers(map[string]string{"network": network})
+	if err != nil {
+		logging.LogWarning("cannot list containers of network %s: %v", network, err)
+
+		return "", false
+	}
+
+	for _, container := range containers {
+		if container.ID == exclude || !IsRunning(container.ID) {
+			continue
+		}
+
+		// only the owner has the namespace mounted, the others join it
+		_, err := os.Stat(filepath.Join(netns.GetRuntimeDir(container.ID), "netns"))
+		if err == nil {
+			return container.ID, true
+		}
+	}
+
+	return "", false
+}
+
+// AllocateAddress returns input static ip for a container of input network,
+// or the first free address of its subnet if empty, for bridge networks.
+// The others have no address of their own, so it is returned as it is.
+func AllocateAddress(network, ip string) (string, error) {
+	if !IsUserNetwork(network) {
+		return ip, nil
+	}
+
+	userNetwork, err := LoadNetwork(network)
+	if err != nil || userNetwork.Driver != DriverBridge {
+		return ip, err
+	}
+
+	_, ipNet, err := net.ParseCIDR(userNetwork.Subnet)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet %s of network %s: %w", userNetwork.Subnet, network, err)
+	}
+
+	containers, err := ListContainers(map[string]string{"network": network})
+	if err != nil {
+		return "", err
+	}
+
+	used := map[string]string{userNetwork.Gateway: "the gateway"}
+	for _, container := range containers {
+		used[container.IP] = "container " + container.Names
+	}
+
+	if ip != "" {
+		if !ipNet.Contains(net.ParseIP(ip)) {
+			return "", fmt.Errorf("address %s is not in subnet %s of network %s", ip, userNetwork.Subnet, network)
+		}
+
+		if owner, ok := used[ip]; ok {
+			return "", fmt.Errorf("address %s is already used by %s", ip, owner)
+		}
+
+		return ip, nil
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+
+	// skip the network and the broadcast addresses
+	for i := uint32(1); i < 1<<(bits-ones)-1; i++ {
+		candidate := make(net.IP, 4)
+		binary.BigEndian.PutUint32(candidate, base+i)
+
+		if _, ok := used[candidate.String()]; !ok {
+			return candidate.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no free address left in network %s", network)
+}
@@ -0,0 +1,333 @@
+// Package containerutils contains helpers and utilities for managing and creating containers
+package containerutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// validVolumeName matches the allowed names of named volumes.
+var validVolumeName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// NamedVolumeDir is the location of the named volumes, shared between containers.
+var NamedVolumeDir = filepath.Join(utils.GetLilipodHome(), "volumes", "named")
+
+// volumeMount is a custom mount or volume of a container, in the formats:
+//
+//	--mount type=bind|tmpfs|volume,source=xxx,destination=xxx,readonly,bind-propagation=xxx
+//	--volume a
+//	--volume a:b[:options]
+//
+// Volume options are comma separated, and can be: ro, rw, nocopy, uid=N, gid=N,
+// mode=OCTAL and a propagation mode like rslave.
+type volumeMount struct {
+	Type        string
+	Source      string
+	Destination string
+	Propagation string
+	Readonly    bool
+	// Managed volumes are the anonymous and named ones, they are created by us
+	// and seeded with the image content found at the destination.
+	Managed bool
+	NoCopy  bool
+	UID     int
+	GID     int
+	Mode    os.FileMode
+}
+
+// GetNamedVolumeDir returns the path of input named volume.
+func GetNamedVolumeDir(name string) string {
+	return filepath.Join(NamedVolumeDir, name)
+}
+
+// ValidateVolumes will check that input custom mounts and volumes are valid.
+func ValidateVolumes(volumes []string) error {
+	for _, volume := range volumes {
+		if volume == "" {
+			continue
+		}
+
+		_, err := parseVolume(volume, utils.Config{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isMountSpec returns if input volume uses the --mount format.
+func isMountSpec(volume string) bool {
+	return strings.HasPrefix(volume, "type=") || strings.Contains(volume, ",type=")
+}
+
+// isNamedVolume returns if input volume source is a volume name instead of a host path.
+func isNamedVolume(source string) bool {
+	return !strings.HasPrefix(source, "/") &&
+		!strings.HasPrefix(source, ".") &&
+		!strings.HasPrefix(source, "~")
+}
+
+// parseVolume will parse input custom mount or volume of config.
+func parseVolume(volume string, config utils.Config) (volumeMount, error) {
+	result := volumeMount{Type: "bind", UID: -1, GID: -1}
+
+	if isMountSpec(volume) {
+		for _, option := range strings.Split(volume, ",") {
+			key, value, _ := strings.Cut(option, "=")
+
+			switch key {
+			case "type":
+				result.Type = value
+			case "source", "src":
+				result.Source = value
+			case "destination", "dst", "target":
+				result.Destination = value
+			case "bind-propagation":
+				result.Propagation = value
+			case "volume-nocopy":
+				result.NoCopy = true
+			default:
+				err := result.setOption(option)
+				if err != nil {
+					return result, fmt.Errorf("invalid mount %s: %w", volume, err)
+				}
+			}
+		}
+
+		switch result.Type {
+		case "bind", "tmpfs":
+		case "volume":
+			result.Type = "bind"
+			result.Managed = true
+
+			if result.Source == "" {
+				result.Source = filepath.Join(utils.GetLilipodHome(), "volumes", config.ID, result.Destination)
+			} else {
+				if !validVolumeName.MatchString(result.Source) {
+					return result, fmt.Errorf("invalid volume name %s", result.Source)
+				}
+
+				result.Source = GetNamedVolumeDir(result.Source)
+			}
+		default:
+			return result, fmt.Errorf("unsupported mount %s", volume)
+		}
+
+		return result, nil
+	}
+
+	mountings := strings.Split(volume, ":")
+
+	// case of --volume a, anonymous mount
+	if len(mountings) <= 1 {
+		result.Source = filepath.Join(utils.GetLilipodHome(), "volumes", config.ID, volume)
+		result.Destination = volume
+		result.Managed = true
+
+		return result, nil
+	}
+
+	result.Source = mountings[0]
+	result.Destination = mountings[1]
+
+	if isNamedVolume(result.Source) {
+		if !validVolumeName.MatchString(result.Source) {
+			return result, fmt.Errorf("invalid volume name %s", result.Source)
+		}
+
+		result.Source = GetNamedVolumeDir(result.Source)
+		result.Managed = true
+	}
+
+	// case of --volume a:b:options
+	if len(mountings) > 2 {
+		for _, option := range strings.Split(mountings[2], ",") {
+			err := result.setOption(option)
+			if err != nil {
+				return result, fmt.Errorf("invalid volume %s: %w", volume, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// setOption will apply input volume option.
+func (v *volumeMount) setOption(option string) error {
+	key, value, _ := strings.Cut(option, "=")
+
+	switch key {
+	case "ro", "readonly":
+		v.Readonly = value == "" || value == "true" || value == "1"
+	case "rw":
+		v.Readonly = false
+	case "nocopy":
+		v.NoCopy = true
+	case "uid", "gid":
+		id, err := strconv.Atoi(value)
+		if err != nil || id < 0 {
+			return fmt.Errorf("invalid %s %s", key, value)
+		}
+
+		if key == "uid" {
+			v.UID = id
+		} else {
+			v.GID = id
+		}
+	case "mode":
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 0o7777 {
+			return fmt.Errorf("invalid mode %s", value)
+		}
+
+		v.Mode = os.FileMode(mode)
+	case "private", "rprivate", "shared", "rshared", "slave", "rslave":
+		v.Propagation = key
+	case "z", "Z", "":
+		// selinux relabeling is not supported, ignore it
+	default:
+		return fmt.Errorf("unknown option %s", option)
+	}
+
+	return nil
+}
+
+// getPropagationFlags returns the mount flags of the volume propagation mode.
+func (v *volumeMount) getPropagationFlags() uintptr {
+	switch v.Propagation {
+	case "private":
+		return syscall.MS_PRIVATE
+	case "rprivate":
+		return syscall.MS_REC | syscall.MS_PRIVATE
+	case "rshared":
+		return syscall.MS_REC | syscall.MS_SHARED
+	case "rslave":
+		return syscall.MS_REC | syscall.MS_SLAVE
+	case "shared":
+		return syscall.MS_SHARED
+	case "slave":
+		return syscall.MS_SLAVE
+	}
+
+	return 0
+}
+
+// prepare will create the managed volume if missing, and seed it with the
+// content of the rootfs at its destination, unless nocopy is set.
+// The ownership and mode options are then applied to it.
+func (v *volumeMount) prepare(rootfs string) error {
+	if !v.Managed {
+		return nil
+	}
+
+	created := !fileutils.Exist(v.Source)
+
+	err := os.MkdirAll(v.Source, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("error creating volume %s: %w", v.Source, err)
+	}
+
+	content := filepath.Join(rootfs, v.Destination)
+
+	info, err := os.Stat(content)
+	if created && err == nil && info.IsDir() {
+		// like docker, the volume also takes the ownership and mode of the original path
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok {
+			_ = os.Lchown(v.Source, int(stat.Uid), int(stat.Gid))
+		}
+
+		_ = os.Chmod(v.Source, info.Mode().Perm())
+
+		if !v.NoCopy {
+			logging.LogDebug("seeding volume %s with the content of %s", v.Source, v.Destination)
+
+			out, err := exec.Command("cp", "-a", content+"/.", v.Source).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("error seeding volume %s: %w: %s", v.Source, err, string(out))
+			}
+		}
+	}
+
+	if v.UID >= 0 || v.GID >= 0 {
+		err = os.Lchown(v.Source, v.UID, v.GID)
+		if err != nil {
+			return fmt.Errorf("error setting volume %s ownership: %w", v.Source, err)
+		}
+	}
+
+	if v.Mode != 0 {
+		err = syscall.Chmod(v.Source, uint32(v.Mode))
+		if err != nil {
+			return fmt.Errorf("error setting volume %s mode: %w", v.Source, err)
+		}
+	}
+
+	return nil
+}
+
+// mount will mount the volume in the rootfs in path.
+func (v *volumeMount) mount(path string) error {
+	dest := filepath.Join(path, v.Destination)
+
+	if v.Type == "tmpfs" {
+		err := fileutils.MountTmpfs(dest)
+		if err != nil {
+			return err
+		}
+	} else {
+		if !fileutils.Exist(v.Source) {
+			return fmt.Errorf("path %s does not exist on host", v.Source)
+		}
+
+		err := fileutils.Mount(v.Source, dest, syscall.MS_BIND|syscall.MS_REC|v.getPropagationFlags())
+		if err != nil {
+			return err
+		}
+	}
+
+	if v.Readonly {
+		// bind mounts are made read-only by remounting them
+		flags := uintptr(syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		if v.Type != "tmpfs" {
+			flags |= syscall.MS_BIND
+		}
+
+		return syscall.Mount("", dest, "", flags, "")
+	}
+
+	return nil
+}
+
+// prepareVolumes will create and seed the managed volumes of input container,
+// whose rootfs is in path.
+func prepareVolumes(path string, config utils.Config) error {
+	for _, volume := range config.Mounts {
+		if volume == "" {
+			continue
+		}
+
+		mount, err := parseVolume(volume, config)
+		if err != nil {
+			return err
+		}
+
+		err = mount.prepare(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package events contains helpers to record and read lilipod's container events.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Event describes something that happened to a container.
+type Event struct {
+	Time       string            `json:"time"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// EventsFile is where the events are recorded, one JSON object per line.
+var EventsFile = filepath.Join(utils.GetLilipodHome(), "events.log")
+
+// Emit will record a new container event with input action and attributes.
+// Failures are only logged, events should never break container operations.
+func Emit(action string, config utils.Config, attributes map[string]string) {
+	event := Event{
+		Time:       time.Now().Format(time.RFC3339Nano),
+		Type:       "container",
+		Action:     action,
+		ID:         config.ID,
+		Name:       config.Names,
+		Attributes: attributes,
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		logging.LogWarning("cannot encode event %s: %v", action, err)
+
+		return
+	}
+
+	logging.LogDebug("emitting event %s", string(line))
+
+	err = logging.AppendStringToFile(EventsFile, string(line))
+	if err != nil {
+		logging.LogWarning("cannot record event %s: %v", action, err)
+	}
+
+	notifyWebhooks(event)
+}
+
+// Read will call input function for each recorded event.
+// If follow is true, Read will keep waiting for new events, like tail -f.
+func Read(follow bool, callback func(Event) error) error {
+	file, err := os.OpenFile(EventsFile, os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	pending := []byte{}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		// keep partially written lines until they're complete
+		pending = append(pending, line...)
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if !follow {
+					return nil
+				}
+
+				// without this sleep you would hogg the CPU
+				time.Sleep(250 * time.Millisecond)
+
+				continue
+			}
+
+			return err
+		}
+
+		var event Event
+
+		err = json.Unmarshal(pending, &event)
+		pending = pending[:0]
+		if err != nil {
+			logging.LogDebug("skipping invalid event: %v", err)
+
+			continue
+		}
+
+		err = callback(event)
+		if err != nil {
+			return err
+		}
+	}
+}
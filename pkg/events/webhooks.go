@@ -0,0 +1,253 @@
+// Package events contains helpers to record and read lilipod's container events.
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Webhook is an HTTP endpoint notified of container events.
+type Webhook struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Events is the list of actions to notify, all if empty.
+	Events []string `json:"events,omitempty"`
+	// Template is a go-template rendering the request body from the Event,
+	// the event is encoded as JSON if empty.
+	Template    string            `json:"template,omitempty"`
+	ContentType string            `json:"contenttype,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Retries     int               `json:"retries"`
+}
+
+// WebhooksFile is where the configured webhooks are saved.
+var WebhooksFile = filepath.Join(utils.GetLilipodHome(), "webhooks.json")
+
+// webhookTimeout is the maximum time allowed for each delivery attempt.
+const webhookTimeout = 5 * time.Second
+
+// GetWebhooks returns all the configured webhooks.
+func GetWebhooks() ([]Webhook, error) {
+	result := []Webhook{}
+
+	file, err := fileutils.ReadFile(WebhooksFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(file, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SaveWebhooks will replace the configured webhooks with input ones.
+func SaveWebhooks(webhooks []Webhook) error {
+	data, err := json.MarshalIndent(webhooks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(WebhooksFile+".tmp", data, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(WebhooksFile+".tmp", WebhooksFile)
+}
+
+// ValidateWebhook returns an error if input webhook cannot be used.
+func ValidateWebhook(webhook Webhook) error {
+	if webhook.Name == "" || webhook.URL == "" {
+		return fmt.Errorf("webhook name and url are required")
+	}
+
+	if webhook.Template != "" {
+		_, err := template.New("webhook").Parse(webhook.Template)
+		if err != nil {
+			return fmt.Errorf("invalid webhook template: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// wants returns true if input webhook is subscribed to input action.
+func (w Webhook) wants(action string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for _, event := range w.Events {
+		if event == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// render will generate the request body for input event.
+func (w Webhook) render(event Event) ([]byte, error) {
+	if w.Template == "" {
+		return json.Marshal(event)
+	}
+
+	tmpl, err := template.New("webhook").Parse(w.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+
+	err = tmpl.Execute(&out, event)
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// Deliver will send input event to the webhook, retrying with an exponential
+// backoff in case of errors.
+func (w Webhook) Deliver(event Event) error {
+	body, err := w.render(event)
+	if err != nil {
+		return err
+	}
+
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+		if w.Template != "" {
+			contentType = "text/plain"
+		}
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		err = w.post(client, body, contentType)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= w.Retries {
+			return err
+		}
+
+		logging.LogDebug("webhook %s failed, retrying in %s: %v", w.Name, backoff, err)
+
+		time.Sleep(backoff)
+
+		backoff *= 2
+	}
+}
+
+func (w Webhook) post(client *http.Client, body []byte, contentType string) error {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set("User-Agent", "lilipod")
+
+	for key, value := range w.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.Name, response.Status)
+	}
+
+	return nil
+}
+
+// notifyWebhooks will deliver input event to all the subscribed webhooks, in
+// a detached lilipod process, so that slow or unreachable webhooks never delay
+// the operations on containers.
+func notifyWebhooks(event Event) {
+	webhooks, err := GetWebhooks()
+	if err != nil {
+		logging.LogWarning("cannot load webhooks: %v", err)
+
+		return
+	}
+
+	if !slices.ContainsFunc(webhooks, func(webhook Webhook) bool { return webhook.wants(event.Action) }) {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		logging.LogWarning("cannot encode event %s: %v", event.Action, err)
+
+		return
+	}
+
+	deliverCmd := exec.Command(os.Args[0], "--log-level", logging.GetLogLevel(), "webhook", "deliver", string(line))
+	deliverCmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = deliverCmd.Start()
+	if err != nil {
+		logging.LogWarning("cannot notify webhooks of %s: %v", event.Action, err)
+
+		return
+	}
+
+	// reap the process once done, without waiting for it
+	go func() { _ = deliverCmd.Wait() }()
+}
+
+// DeliverWebhooks will deliver input event to all the subscribed webhooks.
+// Failures are only logged, as for the events themselves.
+func DeliverWebhooks(event Event) {
+	webhooks, err := GetWebhooks()
+	if err != nil {
+		logging.LogWarning("cannot load webhooks: %v", err)
+
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.wants(event.Action) {
+			continue
+		}
+
+		logging.LogDebug("notifying webhook %s of %s", webhook.Name, event.Action)
+
+		err := webhook.Deliver(event)
+		if err != nil {
+			logging.LogWarning("cannot notify webhook %s: %v", webhook.Name, err)
+		}
+	}
+}
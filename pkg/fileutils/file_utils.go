@@ -16,6 +16,7 @@ import (
 	"github.com/89luca89/lilipod/pkg/constants"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/klauspost/compress/zstd"
 )
 
 // ReadFile will return the content of input file or error.
@@ -130,68 +131,70 @@ func Exist(path string) bool {
 	return err == nil
 }
 
-// CopyFileContainer will copy file from src to dest.
-func CopyFileContainer(src string, dest string) error {
-	input, err := ReadFile(src)
-	if err != nil {
-		logging.LogError("%v", err)
-
-		return err
-	}
-
-	fileinfo, err := os.Stat(src)
-	if err != nil {
-		logging.LogError("%v", err)
-
-		return err
-	}
+// maxSymlinks is the maximum number of symlinks followed to resolve a path,
+// like the kernel's limit.
+const maxSymlinks = 40
+
+// SecurePath returns the path on the host of input path inside rootfs.
+// Symlinks are resolved as if rootfs was the root, so that they cannot point
+// outside of it, as absolute ones usually do.
+func SecurePath(rootfs string, path string) (string, error) {
+	parts := strings.Split(filepath.Clean("/"+path), "/")
+	current := "/"
+	links := 0
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+
+			continue
+		}
 
-	destinfo, err := os.Stat(dest)
-	if err != nil {
-		logging.LogDebug("%v", err)
+		next := filepath.Join(current, part)
 
-		_, err = os.Create(dest)
+		link, err := os.Readlink(filepath.Join(rootfs, next))
 		if err != nil {
-			logging.LogError("%v", err)
+			// not a symlink, or not existing yet
+			current = next
 
-			return err
+			continue
 		}
 
-		destinfo, err = os.Stat(dest)
-		if err != nil {
-			logging.LogError("%v", err)
-
-			return err
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", path)
 		}
-	}
 
-	if destinfo.IsDir() {
-		dest = filepath.Join(dest, filepath.Base(src))
-	}
-
-	err = WriteFile(dest, input, uint32(fileinfo.Mode()))
-	if err != nil {
-		logging.LogError("%v", err)
+		if filepath.IsAbs(link) {
+			current = "/"
+		}
 
-		return err
+		parts = append(strings.Split(link, "/"), parts...)
 	}
 
-	return nil
+	return filepath.Join(rootfs, current), nil
 }
 
-// DiscUsageMegaBytes returns disk usage for input path in MB (rounded).
-func DiscUsageMegaBytes(path string) (string, error) {
+// DiscUsage returns disk usage for input path in bytes.
+func DiscUsage(path string) (int64, error) {
 	var discUsage int64
 
 	readSize := func(_ string, file os.FileInfo, err error) error {
-		if !file.IsDir() {
-			discUsage += file.Size()
-		}
-
+		// file is nil on errors
 		if err != nil {
 			return err
 		}
 
+		if !file.IsDir() {
+			discUsage += file.Size()
+		}
+
 		return nil
 	}
 
@@ -199,6 +202,16 @@ func DiscUsageMegaBytes(path string) (string, error) {
 	if err != nil {
 		logging.LogError("%v", err)
 
+		return 0, err
+	}
+
+	return discUsage, nil
+}
+
+// DiscUsageMegaBytes returns disk usage for input path in MB (rounded).
+func DiscUsageMegaBytes(path string) (string, error) {
+	discUsage, err := DiscUsage(path)
+	if err != nil {
 		return "", err
 	}
 
@@ -210,7 +223,7 @@ func DiscUsageMegaBytes(path string) (string, error) {
 // Umount will force umount a destination path.
 func Umount(dest string) error {
 	for {
-		if !ismountpoint(dest) {
+		if !IsMountpoint(dest) {
 			logging.LogDebug("%s not a mountpoint", dest)
 
 			break
@@ -229,9 +242,9 @@ func Umount(dest string) error {
 	return nil
 }
 
-// ismountpoint will return whether the input path is a mounpoint or not.
+// IsMountpoint will return whether the input path is a mounpoint or not.
 // This function will parse the /proc/mounts file and search for input path.
-func ismountpoint(path string) bool {
+func IsMountpoint(path string) bool {
 	mounts, err := ReadFile("/proc/mounts")
 	if err != nil {
 		return false
@@ -265,7 +278,8 @@ func Mount(src, dest string, mode uintptr) error {
 	if info.IsDir() {
 		_ = os.MkdirAll(dest, 0o755)
 	} else {
-		file, _ := os.Create(dest)
+		// the mount point is not truncated, it's still used when not mounted
+		file, _ := os.OpenFile(dest, os.O_CREATE|os.O_RDONLY, 0o644)
 
 		defer func() { _ = file.Close() }()
 	}
@@ -392,7 +406,78 @@ func MountBindRO(src, dest string) error {
 			syscall.MS_NOEXEC|syscall.MS_NODEV|syscall.MS_PRIVATE)
 }
 
+// zstdMagic are the first bytes of a zstd compressed file.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// IsZstd returns whether input file is zstd compressed, as tar cannot always
+// detect it on its own.
+func IsZstd(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer func() { _ = file.Close() }()
+
+	magic := make([]byte, len(zstdMagic))
+
+	_, err = io.ReadFull(file, magic)
+	if err != nil {
+		return false
+	}
+
+	// skippable frames, as used by zstd:chunked, are 0x184D2A5? in little endian
+	if magic[0]&0xf0 == 0x50 && magic[1] == 0x2a && magic[2] == 0x4d && magic[3] == 0x18 {
+		return true
+	}
+
+	return string(magic) == string(zstdMagic)
+}
+
+// openArchive returns the archive to pass to tar for input file: the file
+// itself for uncompressed and gzip ones, else "-" and the decompressed stream
+// to use as stdin. zstd:chunked files are zstd files too, their table of
+// contents is in skippable frames that the decoder ignores.
+func openArchive(path string) (string, io.ReadCloser, error) {
+	if !IsZstd(path) {
+		return path, nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	decoder, err := zstd.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+
+		return "", nil, err
+	}
+
+	logging.LogDebug("decompressing zstd archive %s", path)
+
+	return "-", zstdReadCloser{decoder: decoder, file: file}, nil
+}
+
+// zstdReadCloser closes both the decoder and the underlying file.
+type zstdReadCloser struct {
+	decoder *zstd.Decoder
+	file    *os.File
+}
+
+func (z zstdReadCloser) Read(data []byte) (int, error) {
+	return z.decoder.Read(data)
+}
+
+func (z zstdReadCloser) Close() error {
+	z.decoder.Close()
+
+	return z.file.Close()
+}
+
 // UntarFile will untar target file to target directory.
+// Archives can be uncompressed, gzip or zstd compressed.
 // If userns is specified and it is keep-id, it will perform the
 // untarring in a new user namespace with user id maps set, in order to prevent
 // permission errors.
@@ -405,10 +490,35 @@ func UntarFile(path string, target string, userns string) error {
 		return err
 	}
 
+	archive, stdin, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+
+	if stdin != nil {
+		defer func() { _ = stdin.Close() }()
+	}
+
+	return untar(archive, stdin, target, userns)
+}
+
+// UntarStream will untar input tar stream, uncompressed or gzip compressed,
+// to target directory, as UntarFile.
+func UntarStream(stream io.Reader, target string, userns string) error {
+	return untar("-", stream, target, userns)
+}
+
+// untar will untar input archive, read from stdin if not nil, to target
+// directory, see UntarFile.
+func untar(archive string, stdin io.Reader, target string, userns string) error {
 	if userns != constants.KeepID {
-		cmd := exec.Command("tar", "--exclude=dev/*", "-xf", path, "-C", target)
+		cmd := exec.Command("tar", "--exclude=dev/*", "-xf", archive, "-C", target)
 		logging.LogDebug("no keep-id specified, simply perform %v", cmd.Args)
 
+		if stdin != nil {
+			cmd.Stdin = stdin
+		}
+
 		out, err := cmd.CombinedOutput()
 		if err != nil {
 			return fmt.Errorf("%w: %s", err, string(out))
@@ -422,11 +532,15 @@ func UntarFile(path string, target string, userns string) error {
 		"-c",
 		"mkdir -p " + target + " &&" +
 			"chown -R root:root " + target + " &&" +
-			"tar --exclude=dev/* -xf " + path + " -C " + target,
+			"tar --exclude=dev/* -xf " + archive + " -C " + target,
 	}
 
 	cmd := exec.Command(command, args...)
 
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+
 	// we need to unpack using keep-id in order to keep consistency
 	cloneFlags := syscall.CLONE_NEWUTS | syscall.CLONE_NEWNS
 	cloneFlags |= syscall.CLONE_NEWUSER
@@ -443,7 +557,7 @@ func UntarFile(path string, target string, userns string) error {
 
 	logging.LogDebug("setting up keep-id %s, %s", uid, gid)
 
-	err = procutils.SetProcessKeepIDMaps(cmd, uid, gid)
+	err := procutils.SetProcessKeepIDMaps(cmd, uid, gid)
 	if err != nil {
 		logging.LogError("%v", err)
 
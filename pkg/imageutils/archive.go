@@ -0,0 +1,323 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Supported formats for Save.
+const (
+	FormatDockerArchive = "docker-archive"
+	FormatOCIArchive    = "oci-archive"
+	FormatOCIDir        = "oci-dir"
+)
+
+// ociRefAnnotations are the annotations used in OCI indexes to store the
+// name of an image, the standard one first.
+var ociRefAnnotations = []string{
+	"org.opencontainers.image.ref.name",
+	"io.containerd.image.name",
+}
+
+// archivedImage is an image found in an archive, with the name to save it as.
+type archivedImage struct {
+	name  string
+	image v1.Image
+}
+
+// Save will write input stored images to output, in input format:
+// a docker-archive tarball, an oci-archive tarball or an oci-dir layout.
+func Save(images []string, output string, format string) error {
+	switch format {
+	case FormatDockerArchive:
+		refs := map[name.Reference]v1.Image{}
+
+		for _, image := range images {
+			img, err := loadImage(image)
+			if err != nil {
+				return err
+			}
+
+			ref, err := name.ParseReference(GetName(image))
+			if err != nil {
+				return err
+			}
+
+			refs[ref] = img
+		}
+
+		logging.LogDebug("writing docker-archive %s", output)
+
+		return tarball.MultiRefWriteToFile(output, refs)
+	case FormatOCIDir:
+		if fileutils.Exist(output) {
+			return fmt.Errorf("%s already exists", output)
+		}
+
+		return writeLayout(images, output)
+	case FormatOCIArchive:
+		tmpdir := output + ".tmp"
+
+		// always cleanup before and after
+		_ = os.RemoveAll(tmpdir)
+
+		defer func() { _ = os.RemoveAll(tmpdir) }()
+
+		err := writeLayout(images, tmpdir)
+		if err != nil {
+			return err
+		}
+
+		logging.LogDebug("writing oci-archive %s", output)
+
+		out, err := exec.Command("tar", "-cf", output, "-C", tmpdir, ".").CombinedOutput()
+		if err != nil {
+			_ = os.Remove(output)
+
+			return fmt.Errorf("failed to create archive: %w: %s", err, string(out))
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %s, use one of %s, %s, %s",
+			format, FormatDockerArchive, FormatOCIArchive, FormatOCIDir)
+	}
+}
+
+// writeLayout will write input stored images in an OCI layout in path,
+// annotated with their names.
+func writeLayout(images []string, path string) error {
+	logging.LogDebug("writing oci layout %s", path)
+
+	ociLayout, err := layout.Write(path, empty.Index)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		img, err := loadImage(image)
+		if err != nil {
+			return err
+		}
+
+		err = ociLayout.AppendImage(img, layout.WithAnnotations(map[string]string{
+			ociRefAnnotations[0]: GetName(image),
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load will save to ImageDir all the images in input, which can be a
+// docker-archive tarball, an oci-archive tarball or an oci-dir layout.
+// If imageName is specified, it is used as the name of the image, else the
+// names saved in the archive are used. Returns the IDs of the loaded images.
+// If quiet is specified, no output nor progress will be shown.
+func Load(input string, imageName string, quiet bool) ([]string, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []archivedImage
+
+	switch {
+	case info.IsDir():
+		images, err = getLayoutImages(input)
+	case hasArchiveEntry(input, "index.json"):
+		// oci-archives are extracted, as the layout needs random access to the blobs
+		err = os.MkdirAll(ImageDir, os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpdir, err := os.MkdirTemp(ImageDir, ".load-")
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() { _ = os.RemoveAll(tmpdir) }()
+
+		out, err := exec.Command("tar", "-xf", input, "-C", tmpdir).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %w: %s", input, err, string(out))
+		}
+
+		images, err = getLayoutImages(tmpdir)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		images, err = getTarballImages(input)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images found in %s", input)
+	}
+
+	if imageName != "" {
+		if len(images) > 1 {
+			return nil, fmt.Errorf("%s contains %d images, a single name cannot be used", input, len(images))
+		}
+
+		images[0].name = imageName
+	}
+
+	result := []string{}
+
+	for _, image := range images {
+		if image.name == "" {
+			return nil, fmt.Errorf("an image in %s has no name, specify one", input)
+		}
+
+		ref, err := name.ParseReference(image.name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image name %s: %w", image.name, err)
+		}
+
+		if !quiet {
+			fmt.Printf("loading image %s\n", ref.Name())
+		}
+
+		id, err := store(ref.Name(), image.image, nil, quiet)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, id)
+	}
+
+	return result, SyncLayout()
+}
+
+// hasArchiveEntry returns whether the tarball in path contains input file.
+func hasArchiveEntry(path string, file string) bool {
+	archive, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer func() { _ = archive.Close() }()
+
+	reader := tar.NewReader(archive)
+
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			return false
+		}
+
+		if filepath.Clean(header.Name) == file {
+			return true
+		}
+	}
+}
+
+// getLayoutImages returns the images of the OCI layout in path.
+func getLayoutImages(path string) ([]archivedImage, error) {
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []archivedImage{}
+
+	for _, descriptor := range indexManifest.Manifests {
+		if !descriptor.MediaType.IsImage() {
+			logging.LogWarning("skipping %s of type %s", descriptor.Digest, descriptor.MediaType)
+
+			continue
+		}
+
+		img, err := index.Image(descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		image := archivedImage{image: img}
+
+		for _, annotation := range ociRefAnnotations {
+			if descriptor.Annotations[annotation] != "" {
+				image.name = descriptor.Annotations[annotation]
+
+				break
+			}
+		}
+
+		result = append(result, image)
+	}
+
+	return result, nil
+}
+
+// getTarballImages returns the images of the docker-archive in path, once
+// per tag.
+func getTarballImages(path string) ([]archivedImage, error) {
+	opener := func() (io.ReadCloser, error) { return os.Open(path) }
+
+	manifest, err := tarball.LoadManifest(opener)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a docker-archive or oci-archive: %w", path, err)
+	}
+
+	result := []archivedImage{}
+
+	for _, descriptor := range manifest {
+		if len(descriptor.RepoTags) == 0 {
+			if len(manifest) > 1 {
+				return nil, fmt.Errorf("untagged images in %s can only be loaded alone", path)
+			}
+
+			img, err := tarball.Image(opener, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, archivedImage{image: img})
+
+			continue
+		}
+
+		for _, repoTag := range descriptor.RepoTags {
+			tag, err := name.NewTag(repoTag)
+			if err != nil {
+				return nil, err
+			}
+
+			img, err := tarball.Image(opener, &tag)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, archivedImage{name: repoTag, image: img})
+		}
+	}
+
+	return result, nil
+}
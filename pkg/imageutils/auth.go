@@ -0,0 +1,324 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Keychain resolves the registry credentials from the podman and docker auth
+// files, in this order:
+//   - $REGISTRY_AUTH_FILE
+//   - $XDG_RUNTIME_DIR/containers/auth.json
+//   - $HOME/.config/containers/auth.json
+//   - $DOCKER_CONFIG/config.json
+//   - $HOME/.docker/config.json
+//
+// The first file with credentials for the registry wins. Credentials are looked
+// up in the credential helpers (credHelpers or credsStore) when configured, eg:
+// docker-credential-pass, docker-credential-secretservice, so that passwords
+// do not need to be stored in plain text.
+var Keychain authn.Keychain = keychain{}
+
+type keychain struct{}
+
+// getAuthFiles returns the existing auth files, by priority.
+func getAuthFiles() []string {
+	candidates := []string{os.Getenv("REGISTRY_AUTH_FILE")}
+
+	if os.Getenv("XDG_RUNTIME_DIR") != "" {
+		candidates = append(candidates,
+			filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "containers", "auth.json"))
+	}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		candidates = append(candidates, filepath.Join(home, ".config", "containers", "auth.json"))
+	}
+
+	if os.Getenv("DOCKER_CONFIG") != "" {
+		candidates = append(candidates, filepath.Join(os.Getenv("DOCKER_CONFIG"), "config.json"))
+	}
+
+	if home != "" {
+		candidates = append(candidates, filepath.Join(home, ".docker", "config.json"))
+	}
+
+	result := []string{}
+
+	for _, candidate := range candidates {
+		if candidate != "" && fileutils.Exist(candidate) {
+			result = append(result, candidate)
+		}
+	}
+
+	return result
+}
+
+// loadAuthFile parses input auth file, podman's auth.json share the format
+// of docker's config.json.
+func loadAuthFile(path string) (*configfile.ConfigFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = file.Close() }()
+
+	authFile, err := config.LoadFromReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	authFile.Filename = path
+
+	return authFile, nil
+}
+
+// getAuthKeys returns the keys a registry can be saved under in the auth files.
+func getAuthKeys(registry string) []string {
+	if registry == name.DefaultRegistry || registry == "docker.io" {
+		return []string{authn.DefaultAuthKey, name.DefaultRegistry, "docker.io"}
+	}
+
+	return []string{registry}
+}
+
+// getCredentialHelper returns the docker-credential-* program that stores the
+// credentials for input key in authFile, or an empty string if they are saved
+// in the file itself. Helpers for a single registry, eg: ecr-login for an ECR
+// registry, take precedence over the default credsStore.
+func getCredentialHelper(authFile *configfile.ConfigFile, key string) string {
+	helper, found := authFile.CredentialHelpers[key]
+	if !found {
+		helper = authFile.CredentialsStore
+	}
+
+	if helper == "" {
+		return ""
+	}
+
+	return "docker-credential-" + helper
+}
+
+// Resolve implements authn.Keychain.
+func (keychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	for _, path := range getAuthFiles() {
+		authFile, err := loadAuthFile(path)
+		if err != nil {
+			logging.LogWarning("cannot read auth file %s: %v", path, err)
+
+			continue
+		}
+
+		for _, key := range getAuthKeys(target.RegistryStr()) {
+			helper := getCredentialHelper(authFile, key)
+			if helper != "" {
+				_, err = exec.LookPath(helper)
+				if err != nil {
+					logging.LogWarning("credential helper %s configured for %s in %s is not installed", helper, key, path)
+
+					continue
+				}
+
+				logging.LogDebug("getting credentials for %s from %s", key, helper)
+			}
+
+			// this runs the docker-credential-* helper, if configured
+			auth, err := authFile.GetAuthConfig(key)
+			if err != nil {
+				logging.LogWarning("cannot get credentials for %s from %s: %v", key, path, err)
+
+				continue
+			}
+
+			if auth.Username == "" && auth.Password == "" &&
+				auth.IdentityToken == "" && auth.RegistryToken == "" && auth.Auth == "" {
+				continue
+			}
+
+			logging.LogDebug("using credentials for %s from %s", key, path)
+
+			return authn.FromConfig(authn.AuthConfig{
+				Username:      auth.Username,
+				Password:      auth.Password,
+				Auth:          auth.Auth,
+				IdentityToken: auth.IdentityToken,
+				RegistryToken: auth.RegistryToken,
+			}), nil
+		}
+	}
+
+	return authn.Anonymous, nil
+}
+
+// GetAuthFile returns the auth file where login saves the credentials:
+// $REGISTRY_AUTH_FILE if set, else podman's auth.json.
+func GetAuthFile() string {
+	if os.Getenv("REGISTRY_AUTH_FILE") != "" {
+		return os.Getenv("REGISTRY_AUTH_FILE")
+	}
+
+	if os.Getenv("XDG_RUNTIME_DIR") != "" {
+		return filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "containers", "auth.json")
+	}
+
+	home, _ := os.UserHomeDir()
+
+	return filepath.Join(home, ".config", "containers", "auth.json")
+}
+
+// openAuthFile returns the content of input auth file, or an empty one
+// if it does not exist yet.
+func openAuthFile(path string) (*configfile.ConfigFile, error) {
+	if !fileutils.Exist(path) {
+		return configfile.New(path), nil
+	}
+
+	return loadAuthFile(path)
+}
+
+// getAuthKey returns the key to save the credentials of input registry under,
+// docker hub is saved as docker.io like podman does.
+func getAuthKey(registry string) (string, error) {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return "", err
+	}
+
+	if reg.RegistryStr() == name.DefaultRegistry {
+		return "docker.io", nil
+	}
+
+	return reg.RegistryStr(), nil
+}
+
+// Login will check input credentials against registry, and save them in
+// authFile. If a credential helper is configured in authFile, it is used to
+// store them.
+func Login(registry string, username string, password string, authFile string) error {
+	key, err := getAuthKey(registry)
+	if err != nil {
+		return err
+	}
+
+	reg, err := name.NewRegistry(key)
+	if err != nil {
+		return err
+	}
+
+	if isInsecure(reg) {
+		reg, err = name.NewInsecureRegistry(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	base, err := getTransport(reg)
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("checking credentials of %s for %s", username, reg.RegistryStr())
+
+	// the token exchange fails for bad credentials, the API check covers
+	// registries using basic auth.
+	roundTripper, err := transport.NewWithContext(context.Background(), reg,
+		authn.FromConfig(authn.AuthConfig{Username: username, Password: password}),
+		base, []string{reg.Scope(transport.PullScope)})
+	if err != nil {
+		return fmt.Errorf("login to %s failed: %w", key, err)
+	}
+
+	client := &http.Client{Transport: roundTripper}
+
+	response, err := client.Get(fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr()))
+	if err != nil {
+		return fmt.Errorf("login to %s failed: %w", key, err)
+	}
+
+	_ = response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("login to %s failed: %s", key, response.Status)
+	}
+
+	file, err := openAuthFile(authFile)
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("saving credentials for %s in %s", key, authFile)
+
+	return file.GetCredentialsStore(key).Store(types.AuthConfig{
+		ServerAddress: key,
+		Username:      username,
+		Password:      password,
+	})
+}
+
+// Logout will remove the credentials of input registry from authFile.
+// If all is specified, the credentials of all the registries are removed.
+func Logout(registry string, authFile string, all bool) error {
+	file, err := openAuthFile(authFile)
+	if err != nil {
+		return err
+	}
+
+	if all {
+		for key := range file.GetAuthConfigs() {
+			err = file.GetCredentialsStore(key).Erase(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	key, err := getAuthKey(registry)
+	if err != nil {
+		return err
+	}
+
+	// docker saves the docker hub credentials under its legacy key
+	keys := []string{key}
+	if key == "docker.io" {
+		keys = getAuthKeys(name.DefaultRegistry)
+	}
+
+	found := false
+
+	for _, authKey := range keys {
+		if _, exists := file.GetAuthConfigs()[authKey]; !exists {
+			continue
+		}
+
+		found = true
+
+		err = file.GetCredentialsStore(authKey).Erase(authKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("not logged into %s", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// BlobDir is the location of the layers, stored once by digest and shared by
+// all the images referencing them in their manifest, eg: blobs/sha256/abc...
+// The manifests and configs of the images are there too, as it is the blob
+// directory of the OCI layout in the LayoutDir.
+var BlobDir = filepath.Join(utils.GetLilipodHome(), "blobs")
+
+// getBlobPath returns the path of the layer with input digest in the BlobDir.
+func getBlobPath(digest v1.Hash) string {
+	return filepath.Join(BlobDir, digest.Algorithm, digest.Hex)
+}
+
+// GetLayerPath returns the path of the layer with input digest of input image
+// name or id. Images stored before the BlobDir existed keep their layers in
+// their own directory, those are used if they are still there.
+func GetLayerPath(image string, digest v1.Hash) string {
+	return getLayerPath(GetPath(image), digest)
+}
+
+// getLayerPath returns the path of the layer with input digest of the image
+// in input directory, see GetLayerPath.
+func getLayerPath(dir string, digest v1.Hash) string {
+	legacyPath := filepath.Join(dir, digest.Hex+".tar.gz")
+	if !fileutils.Exist(getBlobPath(digest)) && fileutils.Exist(legacyPath) {
+		return legacyPath
+	}
+
+	return getBlobPath(digest)
+}
+
+// StoreLayer will move the layer in path to the BlobDir, with input digest.
+// If the layer is already stored, path is just removed.
+func StoreLayer(path string, digest v1.Hash) error {
+	target := getBlobPath(digest)
+
+	if fileutils.Exist(target) {
+		return os.Remove(path)
+	}
+
+	err := os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(path, target)
+}
+
+// MigrateLayers will hardlink in the BlobDir the layers of input image that
+// was stored before it existed, so that other images can share them.
+func MigrateLayers(image string) error {
+	layers, err := getManifestLayers(GetPath(image))
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
+		path := GetLayerPath(image, layer.Digest)
+		if path == getBlobPath(layer.Digest) {
+			continue
+		}
+
+		logging.LogDebug("moving layer %s of %s to %s", layer.Digest, image, BlobDir)
+
+		err = os.MkdirAll(filepath.Dir(getBlobPath(layer.Digest)), 0o755)
+		if err != nil {
+			return err
+		}
+
+		err = os.Link(path, getBlobPath(layer.Digest))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DiscUsageMegaBytes returns the disk usage of input image name or id in MB
+// (rounded), with its layers in the BlobDir, shared or not.
+func DiscUsageMegaBytes(image string) (string, error) {
+	var discUsage int64
+
+	files, err := os.ReadDir(GetPath(image))
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err == nil && !info.IsDir() {
+			discUsage += info.Size()
+		}
+	}
+
+	layers, err := getManifestLayers(GetPath(image))
+	if err != nil {
+		return "", err
+	}
+
+	for _, layer := range layers {
+		path := GetLayerPath(image, layer.Digest)
+
+		// legacy layers are already counted with the image directory
+		if filepath.Dir(path) == GetPath(image) {
+			continue
+		}
+
+		// only the table of contents of the layers pulled lazily is stored
+		info, err := os.Stat(path)
+		if err != nil {
+			info, err = os.Stat(getLazyPath(layer.Digest))
+		}
+
+		if err == nil {
+			discUsage += info.Size()
+		}
+	}
+
+	size := math.Round(float64(discUsage) / 1024.0 / 1024.0)
+
+	return fmt.Sprintf("%.2f MB", size), nil
+}
+
+// getManifestLayers returns the layers of the manifest of input image directory.
+func getManifestLayers(dir string) ([]v1.Descriptor, error) {
+	manifestFile, err := fileutils.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest v1.Manifest
+
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest.Layers, nil
+}
+
+// getManifestBlobs returns the digests of the blobs of the manifest of input
+// image directory: its layers, its config and the manifest itself.
+func getManifestBlobs(dir string) ([]v1.Hash, error) {
+	manifestFile, err := fileutils.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest v1.Manifest
+
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, _, err := v1.SHA256(bytes.NewReader(manifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []v1.Hash{digest, manifest.Config.Digest}
+
+	for _, layer := range manifest.Layers {
+		result = append(result, layer.Digest)
+	}
+
+	return result, nil
+}
+
+// getBlobUsage returns the names of the stored images using each blob, by digest.
+func getBlobUsage() (map[v1.Hash][]string, error) {
+	result := map[v1.Hash][]string{}
+
+	images, err := os.ReadDir(ImageDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+
+		return nil, err
+	}
+
+	for _, image := range images {
+		// hidden dirs are temporary ones of ongoing operations, eg: load
+		if !image.IsDir() || strings.HasPrefix(image.Name(), ".") {
+			continue
+		}
+
+		blobs, err := getManifestBlobs(filepath.Join(ImageDir, image.Name()))
+		if err != nil {
+			continue
+		}
+
+		name := GetName(image.Name())
+
+		for _, blob := range blobs {
+			result[blob] = append(result[blob], name)
+		}
+	}
+
+	return result, nil
+}
+
+// RemoveUnusedLayers will remove the layers in the BlobDir and the LazyDir
+// that no stored image references anymore.
+func RemoveUnusedLayers() error {
+	// images added to the OCI layout by other tools are stored first,
+	// else their blobs would be removed.
+	err := SyncLayout()
+	if err != nil {
+		return err
+	}
+
+	usage, err := getBlobUsage()
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{BlobDir, LazyDir} {
+		err = removeUnusedBlobs(dir, usage)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeUnusedBlobs will remove the blobs in input directory, stored by
+// digest, that are not in usage.
+func removeUnusedBlobs(dir string, usage map[v1.Hash][]string) error {
+	algorithms, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, algorithm := range algorithms {
+		blobs, err := os.ReadDir(filepath.Join(dir, algorithm.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, blob := range blobs {
+			digest := v1.Hash{Algorithm: algorithm.Name(), Hex: blob.Name()}
+			if len(usage[digest]) > 0 {
+				continue
+			}
+
+			logging.LogDebug("removing unused layer %s", digest)
+
+			err = os.Remove(filepath.Join(dir, algorithm.Name(), blob.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
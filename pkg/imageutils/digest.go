@@ -0,0 +1,49 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// saveRepoDigest will save in targetDIR the digest the image reference
+// resolved to when pulled.
+func saveRepoDigest(targetDIR string, digest v1.Hash) error {
+	return fileutils.WriteFile(filepath.Join(targetDIR, "digest"), []byte(digest.String()), 0o644)
+}
+
+// GetRepoDigest returns the digest input image name or id was pulled by,
+// eg: the one of the manifest list of a multi-arch image, to pin it with
+// name@digest. Images that were not pulled, eg: loaded or built ones, only
+// have the digest of their manifest.
+func GetRepoDigest(image string) (string, error) {
+	digest, err := fileutils.ReadFile(filepath.Join(GetPath(image), "digest"))
+	if err == nil {
+		return strings.TrimSpace(string(digest)), nil
+	}
+
+	return GetDigest(image)
+}
+
+// SplitName returns the repository and the tag or digest of input image
+// name, eg: localhost:5000/alpine:latest -> localhost:5000/alpine, latest, "".
+func SplitName(image string) (string, string, string) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return image, "", ""
+	}
+
+	switch ref := ref.(type) {
+	case name.Tag:
+		return ref.Context().Name(), ref.TagStr(), ""
+	case name.Digest:
+		return ref.Context().Name(), "", ref.DigestStr()
+	default:
+		return ref.Context().Name(), "", ""
+	}
+}
@@ -0,0 +1,150 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+)
+
+// Storage drivers for the containers' rootfs.
+// With the files driver each container gets its own copy of the image, with the
+// experimental erofs driver the image is converted once in a read-only EROFS
+// filesystem, shared by all its containers with their changes on top.
+const (
+	StorageDriverFiles = "files"
+	StorageDriverErofs = "erofs"
+)
+
+// ValidateStorageDriver returns an error if input storage driver is not
+// supported, or if its tools are not available on the host.
+func ValidateStorageDriver(driver string) error {
+	switch driver {
+	case "", StorageDriverFiles:
+		return nil
+	case StorageDriverErofs:
+		_, err := exec.LookPath("mkfs.erofs")
+		if err != nil {
+			return fmt.Errorf("storage driver %s needs mkfs.erofs (erofs-utils) installed", driver)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unsupported storage driver %s", driver)
+	}
+}
+
+// GetErofsPath returns the path of the EROFS filesystem of input image.
+func GetErofsPath(image string) string {
+	return filepath.Join(GetPath(image), "image.erofs")
+}
+
+// getErofsDigestPath returns where the fs-verity digest of the EROFS
+// filesystem of input image is saved.
+func getErofsDigestPath(image string) string {
+	return GetErofsPath(image) + ".digest"
+}
+
+// BuildErofs will convert the layers of input image in an EROFS filesystem,
+// if not already done, and return its path.
+// If the host filesystem supports it, fs-verity is enabled on the result and its
+// digest is recorded, so that corruptions or tampering are detected at mount time.
+func BuildErofs(image string) (string, error) {
+	erofsPath := GetErofsPath(image)
+	if fileutils.Exist(erofsPath) {
+		return erofsPath, nil
+	}
+
+	logging.LogDebug("converting image %s to erofs", image)
+
+	unpackDir := erofsPath + ".rootfs"
+
+	defer func() { _ = os.RemoveAll(unpackDir) }()
+
+	err := os.MkdirAll(unpackDir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	err = Unpack(image, unpackDir, "")
+	if err != nil {
+		return "", err
+	}
+
+	tmpPath := erofsPath + ".tmp"
+
+	out, err := exec.Command("mkfs.erofs", "--quiet", tmpPath, unpackDir).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(tmpPath)
+
+		return "", fmt.Errorf("failed to create erofs image: %w: %s", err, string(out))
+	}
+
+	digest, err := enableVerity(tmpPath)
+	if err != nil {
+		logging.LogWarning("fs-verity not available, %s will not be verified: %v", erofsPath, err)
+	} else {
+		err = os.WriteFile(getErofsDigestPath(image), []byte(digest), 0o644)
+		if err != nil {
+			_ = os.Remove(tmpPath)
+
+			return "", err
+		}
+	}
+
+	return erofsPath, os.Rename(tmpPath, erofsPath)
+}
+
+// VerifyErofs will check the EROFS filesystem of input image against the
+// fs-verity digest recorded when it was built, if any.
+func VerifyErofs(image string) error {
+	expected, err := fileutils.ReadFile(getErofsDigestPath(image))
+	if err != nil {
+		logging.LogDebug("no fs-verity digest for %s, skipping verification", image)
+
+		return nil
+	}
+
+	digest, err := measureVerity(GetErofsPath(image))
+	if err != nil {
+		return fmt.Errorf("cannot verify erofs image of %s: %w", image, err)
+	}
+
+	if digest != strings.TrimSpace(string(expected)) {
+		return fmt.Errorf("erofs image of %s does not match its fs-verity digest", image)
+	}
+
+	return nil
+}
+
+// enableVerity will enable fs-verity on input file and return its digest.
+func enableVerity(path string) (string, error) {
+	out, err := exec.Command("fsverity", "enable", path).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, string(out))
+	}
+
+	return measureVerity(path)
+}
+
+// measureVerity returns the fs-verity digest of input file, eg: sha256:abc...
+func measureVerity(path string) (string, error) {
+	out, err := exec.Command("fsverity", "measure", path).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, string(out))
+	}
+
+	// output is in the form of "sha256:abc... path"
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty fs-verity digest for %s", path)
+	}
+
+	return fields[0], nil
+}
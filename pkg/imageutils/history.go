@@ -0,0 +1,91 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// HistoryEntry is a step of the build of an image, as saved in its config.
+type HistoryEntry struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"createdBy"`
+	Author    string    `json:"author,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	// Layer is the digest of the layer created by the step, empty if the
+	// step only changed the config, eg: ENV.
+	Layer string `json:"layer,omitempty"`
+	// Size is the size of the compressed layer on disk.
+	Size uint64 `json:"size"`
+}
+
+// GetHistory returns the history of input image, newest step first.
+// Steps creating a layer are paired with the layers of the manifest, in order.
+func GetHistory(image string) ([]HistoryEntry, error) {
+	manifestFile, err := fileutils.ReadFile(filepath.Join(GetPath(image), "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest v1.Manifest
+
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	configFile, err := fileutils.ReadFile(filepath.Join(GetPath(image), "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var config v1.ConfigFile
+
+	err = json.Unmarshal(configFile, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	history := config.History
+
+	// some images have no history at all, keep showing their layers
+	if len(history) == 0 {
+		for range manifest.Layers {
+			history = append(history, v1.History{})
+		}
+	}
+
+	result := []HistoryEntry{}
+	layer := 0
+
+	for _, step := range history {
+		entry := HistoryEntry{
+			Created:   step.Created.Time,
+			CreatedBy: step.CreatedBy,
+			Author:    step.Author,
+			Comment:   step.Comment,
+		}
+
+		if !step.EmptyLayer && layer < len(manifest.Layers) {
+			digest := manifest.Layers[layer].Digest
+			entry.Layer = digest.String()
+
+			info, err := os.Stat(GetLayerPath(image, digest))
+			if err == nil {
+				entry.Size = uint64(info.Size())
+			}
+
+			layer++
+		}
+
+		result = append([]HistoryEntry{entry}, result...)
+	}
+
+	return result, nil
+}
@@ -3,24 +3,24 @@
 package imageutils
 
 import (
-	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
-	"text/template"
 
 	"github.com/89luca89/lilipod/pkg/fileutils"
 	"github.com/89luca89/lilipod/pkg/logging"
 	"github.com/89luca89/lilipod/pkg/utils"
-	"github.com/google/go-containerregistry/pkg/crane"
-	"github.com/google/go-containerregistry/pkg/legacy"
+	"github.com/containerd/stargz-snapshotter/estargz"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/schollz/progressbar/v3"
 )
 
@@ -35,6 +35,29 @@ func GetID(image string) string {
 		return image
 	}
 
+	// images from local sources are stored with a name of their own
+	if _, _, _, found := splitTransport(image); found {
+		image = getTransportName(image)
+	}
+
+	// short names refer to the first of their resolutions that is stored
+	candidates, err := ResolveShortName(image)
+	if err != nil || len(candidates) == 0 {
+		candidates = []string{image}
+	}
+
+	for _, candidate := range candidates {
+		id := getNameID(candidate)
+		if fileutils.Exist(filepath.Join(ImageDir, id)) {
+			return id
+		}
+	}
+
+	return getNameID(candidates[0])
+}
+
+// getNameID returns the md5sum based ID of input image name.
+func getNameID(image string) string {
 	// Normalize the name with full length registry
 	ref, err := name.ParseReference(image)
 	if err == nil {
@@ -56,31 +79,181 @@ func GetPath(name string) string {
 	return filepath.Join(ImageDir, GetID(name))
 }
 
+// GetDigest returns the manifest digest of input image name or id, eg: sha256:abc...
+func GetDigest(image string) (string, error) {
+	manifest, err := fileutils.ReadFile(filepath.Join(GetPath(image), "manifest.json"))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)), nil
+}
+
 // Pull will pull a given image and save it to ImageDir.
-// This function uses github.com/google/go-containerregistry/pkg/crane to pull
+// This function uses github.com/google/go-containerregistry/pkg/v1/remote to pull
 // the image's manifest, and performs the downloading of each layer separately.
-// Each layer is deduplicated between images in order to save space, using hardlinks.
+// Layers are stored once in the BlobDir, and shared between images in order to save space.
+// For multi-arch images, the one matching input platform is pulled, eg: linux/arm64,
+// or the one of the host if empty.
+// Short names are resolved, and mirrors are used, as configured in registries.conf.
+// Images can be copied from local sources too, eg: an OCI layout with
+// oci:/srv/alpine:3.19, or a skopeo directory with dir:/srv/alpine.
+// The pulled image is then added to the index.json of the LayoutDir.
 // If quiet is specified, no output nor progress will be shown.
-func Pull(image string, quiet bool) (string, error) {
+func Pull(image string, platform string, quiet bool) (string, error) {
+	if _, _, _, found := splitTransport(image); found {
+		id, err := pullTransport(image, platform, quiet)
+		if err != nil {
+			return "", err
+		}
+
+		return id, SyncLayout()
+	}
+
+	candidates, err := ResolveShortName(image)
+	if err != nil {
+		return "", err
+	}
+
+	errs := []error{}
+
+	for _, candidate := range candidates {
+		id, err := pullImage(candidate, platform, quiet)
+		if err == nil {
+			return id, SyncLayout()
+		}
+
+		errs = append(errs, err)
+	}
+
+	return "", errors.Join(errs...)
+}
+
+// pullImage will pull input fully qualified image from its mirrors or
+// registry, and save it to ImageDir.
+func pullImage(image string, platform string, quiet bool) (string, error) {
 	// First we try to get the fully qualified uri of the image
 	// eg alpine:latest -> index.docker.io/library/alpine:latest
-	ref, err := name.ParseReference(image)
-	if err == nil {
-		image = ref.Name()
+	ref, err := parseReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	image = ref.Name()
+
+	sources, err := getPullSources(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if platform == "" {
+		platform = GetHostPlatform()
+	}
+
+	wantPlatform, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return "", fmt.Errorf("invalid platform %s: %w", platform, err)
 	}
 
 	if !quiet {
-		fmt.Printf("pulling image manifest: %s\n", image)
+		fmt.Printf("pulling image manifest: %s (%s)\n", image, wantPlatform)
 	}
-	// Pull will just get us the v1.Image struct, from
-	// which we get all the information we need
-	imageManifest, err := crane.Pull(image)
+
+	var (
+		descriptor    *remote.Descriptor
+		imageManifest v1.Image
+		source        name.Reference
+	)
+
+	for _, location := range sources {
+		source, err = parseReference(location)
+		if err != nil {
+			return "", err
+		}
+
+		var options []remote.Option
+
+		options, err = getRemoteOptions(source.Context().Registry)
+		if err != nil {
+			return "", err
+		}
+
+		if location != image {
+			logging.LogDebug("pulling %s from %s", image, location)
+		}
+
+		// Get will just get us the manifest, and Image the v1.Image struct,
+		// from which we get all the information we need.
+		// Manifest lists and OCI indexes are resolved to the image of the platform.
+		// Images pulled by digest are verified against it.
+		err = withRetry("pulling manifest of "+location, func() error {
+			descriptor, err = remote.Get(source, append(options, remote.WithPlatform(*wantPlatform))...)
+			if err != nil {
+				return err
+			}
+
+			imageManifest, err = descriptor.Image()
+
+			return err
+		})
+		if err == nil {
+			break
+		}
+
+		if len(sources) > 1 {
+			logging.LogWarning("cannot pull %s: %v", location, err)
+		}
+	}
+
+	if err != nil {
+		logging.LogError("%+v", err)
+
+		return "", err
+	}
+
+	// single-arch images are returned whatever their platform is
+	imageConfig, err := imageManifest.ConfigFile()
 	if err != nil {
 		logging.LogError("%+v", err)
 
 		return "", err
 	}
 
+	if imageConfig.Platform() != nil && !imageConfig.Platform().Satisfies(*wantPlatform) {
+		logging.LogWarning("image %s is for platform %s, not %s",
+			image, imageConfig.Platform(), wantPlatform)
+	}
+
+	// images are admitted in the store only if trusted
+	err = VerifyImage(ref, descriptor.Digest, imageManifest)
+	if err != nil {
+		logging.LogError("%+v", err)
+
+		return "", err
+	}
+
+	repository := source.Context()
+
+	id, err := store(image, imageManifest, &repository, quiet)
+	if err != nil {
+		return "", err
+	}
+
+	// the digest the reference resolved to, eg: the one of the manifest list,
+	// is the one to pin the image to.
+	if !quiet {
+		fmt.Printf("digest: %s\n", descriptor.Digest)
+	}
+
+	return id, saveRepoDigest(GetPath(image), descriptor.Digest)
+}
+
+// store will save input image with the given name to ImageDir, layer by layer.
+// Layers are saved once in the BlobDir, and shared by all the images using them.
+// Interrupted layer downloads are resumed from the source repository, if not
+// nil, and eStargz layers are pulled lazily from it if enabled, see SetLazyPull.
+// If quiet is specified, no output nor progress will be shown.
+func store(image string, imageManifest v1.Image, source *name.Repository, quiet bool) (string, error) {
 	// We get the layers
 	layers, err := imageManifest.Layers()
 	if err != nil {
@@ -89,6 +262,18 @@ func Pull(image string, quiet bool) (string, error) {
 		return "", err
 	}
 
+	manifest, err := imageManifest.Manifest()
+	if err != nil {
+		logging.LogError("%+v", err)
+
+		return "", err
+	}
+
+	var fetcher blobFetcher
+	if source != nil {
+		fetcher = newBlobFetcher(*source)
+	}
+
 	// Prepare the image path
 	targetDIR := GetPath(image)
 	if !fileutils.Exist(targetDIR) {
@@ -100,20 +285,42 @@ func Pull(image string, quiet bool) (string, error) {
 		}
 	}
 
-	keepFiles := []string{}
 	// Now we download the layers
-	for _, layer := range layers {
-		fileName, err := downloadLayer(targetDIR, quiet, layer)
+	for i, layer := range layers {
+		// only the table of contents of eStargz layers is needed to pull them lazily
+		if lazyPull && source != nil && i < len(manifest.Layers) &&
+			manifest.Layers[i].Annotations[estargz.TOCJSONDigestAnnotation] != "" {
+			err := withRetry("pulling table of contents", func() error {
+				return pullLazyLayer(source.Name(), manifest.Layers[i], fetcher, quiet)
+			})
+			if err != nil {
+				logging.LogError("%+v", err)
+
+				return "", err
+			}
+
+			continue
+		}
+
+		err := withRetry("pulling layer", func() error {
+			return downloadLayer(targetDIR, quiet, layer, fetcher)
+		})
 		if err != nil {
 			logging.LogError("%+v", err)
 
 			return "", err
 		}
-
-		keepFiles = append(keepFiles, fileName)
 	}
 
 	logging.LogDebug("%d layers successfully saved", len(layers))
+
+	// partial downloads of layers the image does not use anymore are useless
+	err = os.RemoveAll(filepath.Join(targetDIR, ".partial"))
+	if err != nil {
+		logging.LogError("%+v", err)
+
+		return "", err
+	}
 	logging.LogDebug("cleaning up unwanded files")
 
 	fileList, err := os.ReadDir(targetDIR)
@@ -123,19 +330,16 @@ func Pull(image string, quiet bool) (string, error) {
 		return "", err
 	}
 
+	// the layers are in the BlobDir, the files of a previous pull are
+	// rewritten, and the layers of images stored before it are moved there.
 	for _, file := range fileList {
-		if !strings.Contains(
-			strings.Join(keepFiles, ":"),
-			filepath.Base(file.Name()),
-		) {
-			logging.LogDebug("found unwanted file %s, removing", file.Name())
+		logging.LogDebug("found unwanted file %s, removing", file.Name())
 
-			err = os.Remove(filepath.Join(targetDIR, file.Name()))
-			if err != nil {
-				logging.LogError("%+v", err)
+		err = os.RemoveAll(filepath.Join(targetDIR, file.Name()))
+		if err != nil {
+			logging.LogError("%+v", err)
 
-				return "", err
-			}
+			return "", err
 		}
 	}
 
@@ -190,6 +394,14 @@ func Pull(image string, quiet bool) (string, error) {
 		return "", err
 	}
 
+	// and the platform, as only one per name is saved
+	err = savePlatform(targetDIR, imageManifest)
+	if err != nil {
+		logging.LogError("%+v", err)
+
+		return "", err
+	}
+
 	if !quiet {
 		fmt.Println("done")
 	}
@@ -197,135 +409,160 @@ func Pull(image string, quiet bool) (string, error) {
 	return GetID(image), nil
 }
 
-// Inspect will return a JSON or a formatted string describing the input images.
-func Inspect(images []string, format string) (string, error) {
-	result := ""
+// Unpack will extract all the layers of input image in target, in order.
+func Unpack(image string, target string, userns string) error {
+	imageDir := GetPath(image)
 
-	for _, image := range images {
-		image := GetPath(image)
+	manifestFile, err := fileutils.ReadFile(filepath.Join(imageDir, "manifest.json"))
+	if err != nil {
+		return err
+	}
 
-		configFile, err := fileutils.ReadFile(filepath.Join(image, "config.json"))
-		if err != nil {
-			return "", err
-		}
+	var manifest v1.Manifest
 
-		var config legacy.LayerConfigFile
+	err = json.Unmarshal(manifestFile, &manifest)
+	if err != nil {
+		return err
+	}
 
-		err = json.Unmarshal(configFile, &config)
-		if err != nil {
-			return "", err
-		}
+	logging.LogDebug("extracting image's layers")
 
-		// Go-template string
-		if format != "" {
-			tmpl, err := template.New("format").Parse(format)
-			if err != nil {
-				return "", err
-			}
+	for _, layer := range manifest.Layers {
+		logging.LogDebug("extracting layer %s in %s", layer.Digest, target)
 
-			var out bytes.Buffer
+		path := GetLayerPath(image, layer.Digest)
 
-			err = tmpl.Execute(&out, config)
+		// the files of the layers pulled lazily are fetched now
+		if !fileutils.Exist(path) && fileutils.Exist(getLazyPath(layer.Digest)) {
+			err = unpackLazyLayer(layer.Digest, target, userns)
 			if err != nil {
-				return "", err
+				return err
 			}
 
-			result += out.String()
-
 			continue
 		}
-		// else we do json dump
 
-		out, err := json.MarshalIndent(config, " ", " ")
+		err = fileutils.UntarFile(path, target, userns)
 		if err != nil {
-			return "", err
+			return err
 		}
+	}
 
-		result += string(out) + "\n"
+	return nil
+}
+
+// Extract returns the filesystem of input image as a single tar stream, as
+// if its layers were unpacked in order, with the files they delete removed.
+func Extract(image string) (io.ReadCloser, error) {
+	img, err := loadImage(image)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	return mutate.Extract(img), nil
 }
 
 // ----------------------------------------------------------------------------
 
-// downloadLayer will download input layer into targetDIR.
-// downloadLayer will first searc hexisting images inside the ImageDir in order
-// to find matching layers, and hardlink them in order to save disk space.
+// downloadLayer will download input layer into the BlobDir, using targetDIR
+// for the partial download. Layers already in the BlobDir are skipped, and
+// the ones of images stored before it existed are hardlinked there.
 //
 // Each layer download is verified in order to ensure no corrupted downloads occur.
-func downloadLayer(targetDIR string, quiet bool, layer v1.Layer) (string, error) {
+// Interrupted downloads are kept, and resumed using fetcher, if not nil.
+func downloadLayer(targetDIR string, quiet bool, layer v1.Layer, fetcher blobFetcher) error {
 	// we use this as a path to download layers, in order to
-	// verify them and ensure we do not leave broken files
-	tmpdir := filepath.Join(targetDIR, ".temp")
+	// verify them and ensure we do not leave broken files.
+	// It is kept between pulls, to resume interrupted downloads.
+	partialDIR := filepath.Join(targetDIR, ".partial")
 
-	// always cleanup before
-	_ = os.RemoveAll(tmpdir)
-
-	err := os.MkdirAll(tmpdir, 0o750)
+	err := os.MkdirAll(partialDIR, 0o750)
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
-		return "", err
+		return err
 	}
 
-	// and after
-	defer func() { _ = os.RemoveAll(tmpdir) }()
+	layerDigest, err := layer.Digest()
+	if err != nil {
+		logging.LogDebug("error: %+v", err)
 
-	layerDigest, _ := layer.Digest()
+		return err
+	}
 
-	layerFileName := strings.Split(layerDigest.String(), ":")[1] + ".tar.gz"
+	blobPath := getBlobPath(layerDigest)
+	partialPath := filepath.Join(partialDIR, layerDigest.Hex+".partial")
 
 	if !quiet {
-		logging.Log("pulling layer %s", layerFileName)
+		logging.Log("pulling layer %s", layerDigest.Hex)
 	}
 
 	// If a layer already exists, exit
-	if fileutils.Exist(filepath.Join(targetDIR, layerFileName)) &&
-		fileutils.CheckFileDigest(filepath.Join(targetDIR, layerFileName), layerDigest.String()) {
+	if fileutils.Exist(blobPath) && fileutils.CheckFileDigest(blobPath, layerDigest.String()) {
 		if !quiet {
-			logging.Log("layer %s already exists, skipping", layerFileName)
+			logging.Log("layer %s already exists, skipping", layerDigest.Hex)
 		}
 
-		return layerFileName, nil
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(blobPath), 0o755)
+	if err != nil {
+		logging.LogDebug("error: %+v", err)
+
+		return err
 	}
 
-	// But if a layer with the same name/digest exists in another directory
-	// let's deduplicate the disk usage by using hardlinks
-	matchingLayers := findExistingLayer(ImageDir, layerFileName)
+	// But if the layer of an image stored before the BlobDir existed matches,
+	// let's move it there, using a hardlink as that image uses it still.
+	matchingLayers := findExistingLayer(ImageDir, layerDigest.Hex+".tar.gz")
 	if len(matchingLayers) > 0 &&
 		fileutils.CheckFileDigest(matchingLayers[0], layerDigest.String()) {
 		if !quiet {
-			logging.Log("layer %s already exists, linking", layerFileName)
+			logging.Log("layer %s already exists, linking", layerDigest.Hex)
 		}
 
-		return layerFileName, os.Link(matchingLayers[0], filepath.Join(targetDIR, layerFileName))
+		_ = os.Remove(blobPath)
+
+		return os.Link(matchingLayers[0], blobPath)
+	}
+
+	// the download could have been interrupted right before being saved
+	if fileutils.Exist(partialPath) && fileutils.CheckFileDigest(partialPath, layerDigest.String()) {
+		return os.Rename(partialPath, blobPath)
 	}
 
-	// Else we proceed with the download of the layer
-	savedLayer, err := os.Create(filepath.Join(tmpdir, layerFileName))
+	layerSize, err := layer.Size()
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
-		return "", err
+		return err
 	}
 
-	defer func() { _ = savedLayer.Close() }()
-
-	tarLayer, err := layer.Compressed()
+	// Else we proceed with the download of the layer, or of its missing part
+	tarLayer, offset, err := openLayer(layer, partialPath, layerSize, fetcher)
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
-		return "", err
+		return err
 	}
 
-	layerSize, err := layer.Size()
+	defer func() { _ = tarLayer.Close() }()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+
+	savedLayer, err := os.OpenFile(partialPath, flags, 0o644)
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
-		return "", err
+		return err
 	}
 
+	defer func() { _ = savedLayer.Close() }()
+
 	bar := progressbar.NewOptions64(layerSize,
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionShowBytes(true),
@@ -338,29 +575,33 @@ func downloadLayer(targetDIR string, quiet bool, layer v1.Layer) (string, error)
 		}),
 	)
 
+	if offset > 0 {
+		_ = bar.Set64(offset)
+	}
+
 	_, err = io.Copy(io.MultiWriter(savedLayer, bar), tarLayer)
 	if err != nil {
 		logging.LogDebug("error: %+v", err)
 
-		return "", err
+		return err
 	}
 
 	// always verify if the download was correctly done by
 	// checking the digest of the file
-	if fileutils.CheckFileDigest(filepath.Join(tmpdir, layerFileName), layerDigest.String()) {
-		err = os.Rename(filepath.Join(tmpdir, layerFileName),
-			filepath.Join(targetDIR, layerFileName))
+	if fileutils.CheckFileDigest(partialPath, layerDigest.String()) {
+		logging.LogDebug("successfully checked layer: %s", layerDigest.Hex)
 
-		logging.LogDebug("successfully checked layer: %s", layerFileName)
-
-		return layerFileName, err
+		return os.Rename(partialPath, blobPath)
 	}
 
-	return "", fmt.Errorf("error getting layer")
+	// a corrupted download cannot be resumed
+	_ = os.Remove(partialPath)
+
+	return errCorruptedLayer
 }
 
-// findExistingLayer is useful to find layers with matching name/digest in order to
-// deduplicate disk usage by using hardlinks later.
+// findExistingLayer is useful to find the layers of images stored before the
+// BlobDir existed with matching name/digest, in order to move them there.
 func findExistingLayer(targetDIR, filename string) []string {
 	var matchingFiles []string
 
@@ -0,0 +1,148 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ImageInspect describes a stored image, as shown by Inspect.
+type ImageInspect struct {
+	ID           string `json:"Id"`
+	Digest       string
+	RepoTags     []string
+	RepoDigests  []string
+	Created      time.Time
+	Author       string
+	Architecture string
+	Os           string
+	Variant      string `json:",omitempty"`
+	// Size is the size of the layers in bytes, compressed as stored.
+	Size    int64
+	Config  v1.Config
+	RootFS  ImageRootFS
+	Layers  []string
+	Labels  map[string]string
+	History []v1.History
+}
+
+// ImageRootFS are the uncompressed layers of an image, by diffID.
+type ImageRootFS struct {
+	Type   string
+	Layers []v1.Hash
+}
+
+// getImageInspect returns the description of input image name or id.
+func getImageInspect(image string) (ImageInspect, error) {
+	var result ImageInspect
+
+	configFile, err := fileutils.ReadFile(filepath.Join(GetPath(image), "config.json"))
+	if err != nil {
+		return result, err
+	}
+
+	var config v1.ConfigFile
+
+	err = json.Unmarshal(configFile, &config)
+	if err != nil {
+		return result, err
+	}
+
+	layers, err := getManifestLayers(GetPath(image))
+	if err != nil {
+		return result, err
+	}
+
+	digest, err := GetDigest(image)
+	if err != nil {
+		return result, err
+	}
+
+	result = ImageInspect{
+		ID:           GetID(image),
+		Digest:       digest,
+		RepoTags:     []string{},
+		RepoDigests:  []string{},
+		Created:      config.Created.Time,
+		Author:       config.Author,
+		Architecture: config.Architecture,
+		Os:           config.OS,
+		Variant:      config.Variant,
+		Config:       config.Config,
+		RootFS:       ImageRootFS{Type: config.RootFS.Type, Layers: config.RootFS.DiffIDs},
+		Layers:       []string{},
+		Labels:       config.Config.Labels,
+		History:      config.History,
+	}
+
+	for _, layer := range layers {
+		result.Size += layer.Size
+		result.Layers = append(result.Layers, layer.Digest.String())
+	}
+
+	repository, tag, _ := SplitName(GetName(image))
+	if tag != "" {
+		result.RepoTags = append(result.RepoTags, repository+":"+tag)
+	}
+
+	repoDigest, err := GetRepoDigest(image)
+	if err == nil {
+		result.RepoDigests = append(result.RepoDigests, repository+"@"+repoDigest)
+	}
+
+	return result, nil
+}
+
+// Inspect will return a JSON or a formatted string describing the input images:
+// their config, eg: env, entrypoint, exposed ports, their layers, size and platform.
+func Inspect(images []string, format string) (string, error) {
+	result := ""
+
+	for _, image := range images {
+		if !fileutils.Exist(GetPath(image)) {
+			return "", fmt.Errorf("image %s not found", image)
+		}
+
+		inspect, err := getImageInspect(image)
+		if err != nil {
+			return "", err
+		}
+
+		// Go-template string
+		if format != "" {
+			tmpl, err := template.New("format").Parse(format)
+			if err != nil {
+				return "", err
+			}
+
+			var out bytes.Buffer
+
+			err = tmpl.Execute(&out, inspect)
+			if err != nil {
+				return "", err
+			}
+
+			result += out.String()
+
+			continue
+		}
+		// else we do json dump
+
+		out, err := json.MarshalIndent(inspect, " ", " ")
+		if err != nil {
+			return "", err
+		}
+
+		result += string(out) + "\n"
+	}
+
+	return result, nil
+}
@@ -0,0 +1,279 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// LayoutDir is the root of the OCI image layout of the stored images: all
+// their blobs are in the BlobDir, and index.json lists them by name, so that
+// other tools can use it, eg: skopeo copy oci:LayoutDir:index.docker.io/library/alpine:latest ...
+var LayoutDir = utils.GetLilipodHome()
+
+// layoutIDAnnotation marks the images of the index.json stored by lilipod,
+// with their ID. Images added by other tools do not have it.
+const layoutIDAnnotation = "io.github.89luca89.lilipod.image.id"
+
+// ociLayoutFile is the content of the oci-layout file of the LayoutDir.
+const ociLayoutFile = `{"imageLayoutVersion": "1.0.0"}`
+
+// storeBlob will save input data in the BlobDir, if not already there, and
+// return its digest.
+func storeBlob(data []byte) (v1.Hash, error) {
+	digest, _, err := v1.SHA256(bytes.NewReader(data))
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	path := getBlobPath(digest)
+	if fileutils.Exist(path) {
+		return digest, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	// blobs must always be complete, as other tools can read them
+	err = fileutils.WriteFile(path+".tmp", data, 0o644)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+
+	return digest, os.Rename(path+".tmp", path)
+}
+
+// getLayoutDescriptor returns the descriptor of the stored image with input
+// ID in the index.json, saving its manifest and config in the BlobDir too.
+func getLayoutDescriptor(id string) (v1.Descriptor, error) {
+	dir := filepath.Join(ImageDir, id)
+
+	rawManifest, err := fileutils.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	rawConfig, err := fileutils.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	var manifest v1.Manifest
+
+	err = json.Unmarshal(rawManifest, &manifest)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	digest, err := storeBlob(rawManifest)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	configDigest, err := storeBlob(rawConfig)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	if configDigest != manifest.Config.Digest {
+		return v1.Descriptor{}, fmt.Errorf("config %s does not match the manifest", configDigest)
+	}
+
+	// the layers must be in the BlobDir too
+	err = MigrateLayers(id)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+
+	// old manifests have no media type, the layers tell their format
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = types.OCIManifestSchema1
+
+		if len(manifest.Layers) > 0 && manifest.Layers[0].MediaType == types.DockerLayer {
+			mediaType = types.DockerManifestSchema2
+		}
+	}
+
+	descriptor := v1.Descriptor{
+		MediaType: mediaType,
+		Size:      int64(len(rawManifest)),
+		Digest:    digest,
+		Annotations: map[string]string{
+			ociRefAnnotations[0]: GetName(id),
+			layoutIDAnnotation:   id,
+		},
+	}
+
+	platform, err := v1.ParsePlatform(GetPlatform(id))
+	if err == nil && platform.OS != "" {
+		descriptor.Platform = platform
+	}
+
+	return descriptor, nil
+}
+
+// importLayoutImages will store the images added to the index.json by other
+// tools, eg: skopeo copy docker://alpine oci:LayoutDir:alpine, with the name
+// of their ref.name annotation.
+func importLayoutImages() error {
+	rawIndex, err := fileutils.ReadFile(filepath.Join(LayoutDir, "index.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	var indexManifest v1.IndexManifest
+
+	err = json.Unmarshal(rawIndex, &indexManifest)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", filepath.Join(LayoutDir, "index.json"), err)
+	}
+
+	var index v1.ImageIndex
+
+	platform, err := v1.ParsePlatform(GetHostPlatform())
+	if err != nil {
+		return err
+	}
+
+	for _, descriptor := range indexManifest.Manifests {
+		if descriptor.Annotations[layoutIDAnnotation] != "" {
+			continue
+		}
+
+		imageName := ""
+
+		for _, annotation := range ociRefAnnotations {
+			if descriptor.Annotations[annotation] != "" {
+				imageName = descriptor.Annotations[annotation]
+
+				break
+			}
+		}
+
+		ref, err := name.ParseReference(imageName)
+		if err != nil {
+			logging.LogWarning("dropping image %s of the OCI layout, %q is not a valid name",
+				descriptor.Digest, imageName)
+
+			continue
+		}
+
+		if index == nil {
+			index, err = layout.ImageIndexFromPath(LayoutDir)
+			if err != nil {
+				return err
+			}
+		}
+
+		img, err := resolveLayoutImage(index, descriptor, platform)
+		if err != nil {
+			logging.LogWarning("dropping image %s of the OCI layout: %v", imageName, err)
+
+			continue
+		}
+
+		logging.LogDebug("importing image %s from the OCI layout", ref.Name())
+
+		_, err = store(ref.Name(), img, nil, true)
+		if err != nil {
+			return err
+		}
+
+		err = saveRepoDigest(GetPath(ref.Name()), descriptor.Digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SyncLayout will update the index.json of the LayoutDir with the stored
+// images, after storing the ones other tools added to it.
+func SyncLayout() error {
+	err := importLayoutImages()
+	if err != nil {
+		return err
+	}
+
+	images, err := os.ReadDir(ImageDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	manifests := []v1.Descriptor{}
+
+	for _, image := range images {
+		// hidden dirs are temporary ones of ongoing operations, eg: load
+		if !image.IsDir() || strings.HasPrefix(image.Name(), ".") {
+			continue
+		}
+
+		descriptor, err := getLayoutDescriptor(image.Name())
+		if err != nil {
+			logging.LogWarning("skipping image %s in the OCI layout: %v", image.Name(), err)
+
+			continue
+		}
+
+		manifests = append(manifests, descriptor)
+	}
+
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return manifests[i].Annotations[ociRefAnnotations[0]] < manifests[j].Annotations[ociRefAnnotations[0]]
+	})
+
+	rawIndex, err := json.MarshalIndent(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     manifests,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if !fileutils.Exist(filepath.Join(LayoutDir, "oci-layout")) {
+		err = fileutils.WriteFile(filepath.Join(LayoutDir, "oci-layout"), []byte(ociLayoutFile), 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	indexPath := filepath.Join(LayoutDir, "index.json")
+
+	current, err := fileutils.ReadFile(indexPath)
+	if err == nil && bytes.Equal(current, rawIndex) {
+		return nil
+	}
+
+	logging.LogDebug("updating %s with %d images", indexPath, len(manifests))
+
+	err = fileutils.WriteFile(indexPath+".tmp", rawIndex, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(indexPath+".tmp", indexPath)
+}
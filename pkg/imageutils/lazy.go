@@ -0,0 +1,462 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/containerd/stargz-snapshotter/estargz"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+)
+
+// LazyDir is the location of the layers pulled lazily, stored once by digest
+// as the BlobDir: only their table of contents is saved, see SetLazyPull.
+var LazyDir = filepath.Join(utils.GetLilipodHome(), "lazy")
+
+// lazyReadAhead is how much of a lazy layer is fetched at once: its files are
+// read in order when unpacking it, so the next ones are fetched with the first.
+const lazyReadAhead = 8 << 20
+
+// lazyPull is whether the eStargz layers of the pulled images are pulled lazily.
+var lazyPull = false
+
+// SetLazyPull will set whether the eStargz layers of the pulled images are
+// pulled lazily: only their table of contents is downloaded, and their files
+// are fetched from the registry when the rootfs of a container is created.
+// The other layers are always pulled.
+func SetLazyPull(lazy bool) {
+	lazyPull = lazy
+}
+
+// lazyLayer is an eStargz layer of which only the table of contents is
+// stored, the rest is fetched from the repository it was pulled from.
+type lazyLayer struct {
+	Repository string  `json:"repository"`
+	Digest     v1.Hash `json:"digest"`
+	Size       int64   `json:"size"`
+	TOCDigest  string  `json:"tocDigest"`
+	// TOC is the end of the layer, from its table of contents to its footer.
+	TOC []byte `json:"toc"`
+
+	fetcher blobFetcher
+	mutex   sync.Mutex
+	window  []byte
+	offset  int64
+}
+
+// getLazyPath returns the path of the lazy layer with input digest in the LazyDir.
+func getLazyPath(digest v1.Hash) string {
+	return filepath.Join(LazyDir, digest.Algorithm, digest.Hex)
+}
+
+// HasLayer returns whether the layer with input digest of input image name
+// or id is stored, fully or lazily.
+func HasLayer(image string, digest v1.Hash) bool {
+	return fileutils.Exist(GetLayerPath(image, digest)) || fileutils.Exist(getLazyPath(digest))
+}
+
+// ReadAt reads the content of the layer at offset: its table of contents is
+// read from the saved one, the rest is fetched from the registry.
+func (l *lazyLayer) ReadAt(data []byte, offset int64) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	tocOffset := l.Size - int64(len(l.TOC))
+	read := 0
+
+	if offset < tocOffset {
+		end := min(offset+int64(len(data)), tocOffset)
+
+		if offset < l.offset || end > l.offset+int64(len(l.window)) {
+			err := l.fetch(offset, min(max(end-offset, lazyReadAhead), tocOffset-offset))
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		read = copy(data[:end-offset], l.window[offset-l.offset:])
+	}
+
+	if read < len(data) {
+		start := offset + int64(read) - tocOffset
+		if start >= int64(len(l.TOC)) {
+			return read, io.EOF
+		}
+
+		read += copy(data[read:], l.TOC[start:])
+	}
+
+	if read < len(data) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// fetch will download size bytes of the layer from offset, to be read.
+func (l *lazyLayer) fetch(offset int64, size int64) error {
+	logging.LogDebug("fetching %d bytes of layer %s at %d", size, l.Digest, offset)
+
+	return withRetry("fetching layer "+l.Digest.String(), func() error {
+		reader, err := l.fetcher(l.Digest, offset, size)
+		if err != nil {
+			return err
+		}
+
+		defer func() { _ = reader.Close() }()
+
+		window := make([]byte, size)
+
+		_, err = io.ReadFull(reader, window)
+		if err != nil {
+			return err
+		}
+
+		l.window = window
+		l.offset = offset
+
+		return nil
+	})
+}
+
+// open returns the eStargz reader of the layer, with the verifier of its
+// chunks, after checking its table of contents against its digest.
+func (l *lazyLayer) open() (*estargz.Reader, estargz.TOCEntryVerifier, error) {
+	reader, err := estargz.Open(io.NewSectionReader(l, 0, l.Size))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	verifier, err := reader.VerifyTOC(digest.Digest(l.TOCDigest))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errCorruptedLayer, err)
+	}
+
+	return reader, verifier, nil
+}
+
+// getEntries returns the entries of the table of contents of the layer, in
+// the order of the archive.
+func (l *lazyLayer) getEntries() ([]*estargz.TOCEntry, error) {
+	_, footerSize, err := estargz.OpenFooter(io.NewSectionReader(l, 0, l.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	var decompressor estargz.Decompressor = &estargz.GzipDecompressor{}
+	if footerSize != decompressor.FooterSize() {
+		decompressor = &estargz.LegacyGzipDecompressor{}
+	}
+
+	toc, _, err := decompressor.ParseTOC(bytes.NewReader(l.TOC[:int64(len(l.TOC))-footerSize]))
+	if err != nil {
+		return nil, err
+	}
+
+	return toc.Entries, nil
+}
+
+// loadLazyLayer returns the lazy layer with input digest, to be fetched from
+// the repository it was pulled from.
+func loadLazyLayer(digest v1.Hash) (*lazyLayer, error) {
+	file, err := fileutils.ReadFile(getLazyPath(digest))
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &lazyLayer{}
+
+	err = json.Unmarshal(file, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := parseReference(layer.Repository + "@" + layer.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	layer.fetcher = newBlobFetcher(ref.Context())
+
+	return layer, nil
+}
+
+// pullLazyLayer will save the table of contents of input eStargz layer of
+// repository in the LazyDir, fetched using fetcher, after verifying it.
+// Layers already stored, fully or lazily, are skipped.
+func pullLazyLayer(repository string, descriptor v1.Descriptor, fetcher blobFetcher, quiet bool) error {
+	if !quiet {
+		logging.Log("pulling table of contents of layer %s", descriptor.Digest.Hex)
+	}
+
+	if fileutils.Exist(getBlobPath(descriptor.Digest)) || fileutils.Exist(getLazyPath(descriptor.Digest)) {
+		if !quiet {
+			logging.Log("layer %s already exists, skipping", descriptor.Digest.Hex)
+		}
+
+		return nil
+	}
+
+	layer := &lazyLayer{
+		Repository: repository,
+		Digest:     descriptor.Digest,
+		Size:       descriptor.Size,
+		TOCDigest:  descriptor.Annotations[estargz.TOCJSONDigestAnnotation],
+		fetcher:    fetcher,
+	}
+
+	tocOffset, _, err := estargz.OpenFooter(io.NewSectionReader(layer, 0, layer.Size))
+	if err != nil {
+		return err
+	}
+
+	if tocOffset < 0 || tocOffset >= layer.Size {
+		return fmt.Errorf("invalid table of contents offset %d of layer %s", tocOffset, layer.Digest)
+	}
+
+	toc := make([]byte, layer.Size-tocOffset)
+
+	_, err = io.ReadFull(io.NewSectionReader(layer, tocOffset, layer.Size-tocOffset), toc)
+	if err != nil {
+		return err
+	}
+
+	layer.TOC = toc
+
+	_, _, err = layer.open()
+	if err != nil {
+		return err
+	}
+
+	file, err := json.Marshal(layer)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(getLazyPath(layer.Digest)), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return fileutils.WriteFile(getLazyPath(layer.Digest), file, 0o644)
+}
+
+// unpackLazyLayer will extract the lazy layer with input digest in target,
+// fetching its files from the registry, see fileutils.UntarFile.
+func unpackLazyLayer(digest v1.Hash, target string, userns string) error {
+	layer, err := loadLazyLayer(digest)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	errs := make(chan error, 1)
+
+	go func() {
+		err := layer.writeTar(writer)
+		_ = writer.CloseWithError(err)
+		errs <- err
+	}()
+
+	err = fileutils.UntarStream(reader, target, userns)
+
+	_ = reader.Close()
+
+	// a failed fetch stops tar, its error is the one to report
+	writeErr := <-errs
+	if writeErr != nil {
+		return writeErr
+	}
+
+	return err
+}
+
+// writeTar will write the layer as a tar archive to output, its files fetched
+// from the registry and verified chunk by chunk.
+func (l *lazyLayer) writeTar(output io.Writer) error {
+	reader, verifier, err := l.open()
+	if err != nil {
+		return err
+	}
+
+	entries, err := l.getEntries()
+	if err != nil {
+		return err
+	}
+
+	writer := tar.NewWriter(output)
+
+	for _, entry := range entries {
+		header, err := getLazyHeader(entry)
+		if err != nil {
+			return err
+		}
+
+		if header == nil {
+			continue
+		}
+
+		err = writer.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if entry.Type != "reg" {
+			continue
+		}
+
+		err = writeLazyFile(writer, reader, verifier, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// getLazyHeader returns the tar header of input entry of a table of contents,
+// or nil for the entries that are not files, eg: the chunks of large files.
+func getLazyHeader(entry *estargz.TOCEntry) (*tar.Header, error) {
+	types := map[string]byte{
+		"dir":      tar.TypeDir,
+		"reg":      tar.TypeReg,
+		"symlink":  tar.TypeSymlink,
+		"hardlink": tar.TypeLink,
+		"char":     tar.TypeChar,
+		"block":    tar.TypeBlock,
+		"fifo":     tar.TypeFifo,
+	}
+
+	typeflag, found := types[entry.Type]
+	if !found || filepath.Base(entry.Name) == estargz.PrefetchLandmark ||
+		filepath.Base(entry.Name) == estargz.NoPrefetchLandmark {
+		return nil, nil
+	}
+
+	header := &tar.Header{
+		Typeflag: typeflag,
+		Name:     entry.Name,
+		Linkname: entry.LinkName,
+		Mode:     entry.Mode,
+		Uid:      entry.UID,
+		Gid:      entry.GID,
+		Uname:    entry.Uname,
+		Gname:    entry.Gname,
+		Devmajor: int64(entry.DevMajor),
+		Devminor: int64(entry.DevMinor),
+		Format:   tar.FormatPAX,
+	}
+
+	if typeflag == tar.TypeReg {
+		header.Size = entry.Size
+	}
+
+	if entry.ModTime3339 != "" {
+		modTime, err := time.Parse(time.RFC3339, entry.ModTime3339)
+		if err != nil {
+			return nil, err
+		}
+
+		header.ModTime = modTime
+	}
+
+	for key, value := range entry.Xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = map[string]string{}
+		}
+
+		header.PAXRecords["SCHILY.xattr."+key] = string(value)
+	}
+
+	return header, nil
+}
+
+// writeLazyFile will write the content of input regular file entry to
+// writer, fetched using reader, each chunk verified before being written.
+func writeLazyFile(
+	writer io.Writer,
+	reader *estargz.Reader,
+	verifier estargz.TOCEntryVerifier,
+	entry *estargz.TOCEntry,
+) error {
+	file, err := reader.OpenFile(entry.Name)
+	if err != nil {
+		return err
+	}
+
+	for offset := int64(0); offset < entry.Size; {
+		chunk, found := reader.ChunkEntryForOffset(entry.Name, offset)
+		if !found {
+			return fmt.Errorf("chunk of %s at %d not found", entry.Name, offset)
+		}
+
+		data := make([]byte, chunk.ChunkSize)
+
+		read, err := file.ReadAt(data, chunk.ChunkOffset)
+		if err != nil && (!errors.Is(err, io.EOF) || read < len(data)) {
+			return err
+		}
+
+		chunkVerifier, err := verifier.Verifier(chunk)
+		if err != nil {
+			return err
+		}
+
+		_, _ = chunkVerifier.Write(data)
+
+		if !chunkVerifier.Verified() {
+			return fmt.Errorf("%w: %s", errCorruptedLayer, entry.Name)
+		}
+
+		_, err = writer.Write(data)
+		if err != nil {
+			return err
+		}
+
+		offset = chunk.ChunkOffset + chunk.ChunkSize
+	}
+
+	return nil
+}
+
+// openLazyLayer returns the compressed content of the lazy layer with input
+// digest, downloaded from the registry and verified against its digest.
+func openLazyLayer(digest v1.Hash) (io.ReadCloser, error) {
+	layer, err := loadLazyLayer(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := parseReference(layer.Repository + "@" + digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := getRemoteOptions(ref.Context().Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.LogDebug("layer %s was pulled lazily, downloading it from %s", digest, layer.Repository)
+
+	remoteLayer, err := remote.Layer(ref.Context().Digest(digest.String()), options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteLayer.Compressed()
+}
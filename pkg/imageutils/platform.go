@@ -0,0 +1,76 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// GetHostPlatform returns the platform of the host, eg: linux/amd64.
+func GetHostPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// savePlatform will save the platform of input image in targetDIR.
+func savePlatform(targetDIR string, image v1.Image) error {
+	config, err := image.ConfigFile()
+	if err != nil {
+		return err
+	}
+
+	platform := ""
+	if config.Platform() != nil {
+		platform = config.Platform().String()
+	}
+
+	return fileutils.WriteFile(filepath.Join(targetDIR, "platform"), []byte(platform), 0o644)
+}
+
+// GetPlatform returns the platform of input image name or id, eg: linux/arm64.
+// For images pulled before the platform was saved, it is read from the config.
+func GetPlatform(image string) string {
+	platform, err := fileutils.ReadFile(filepath.Join(GetPath(image), "platform"))
+	if err == nil {
+		return strings.TrimSpace(string(platform))
+	}
+
+	configFile, err := fileutils.ReadFile(filepath.Join(GetPath(image), "config.json"))
+	if err != nil {
+		return ""
+	}
+
+	var config v1.ConfigFile
+
+	err = json.Unmarshal(configFile, &config)
+	if err != nil || config.Platform() == nil {
+		return ""
+	}
+
+	return config.Platform().String()
+}
+
+// HasPlatform returns whether input image exists for input platform,
+// eg: linux/arm64 is satisfied by linux/arm64/v8.
+func HasPlatform(image string, platform string) bool {
+	if !fileutils.Exist(GetPath(image)) {
+		return false
+	}
+
+	want, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return false
+	}
+
+	have, err := v1.ParsePlatform(GetPlatform(image))
+	if err != nil {
+		return false
+	}
+
+	return have.Satisfies(*want)
+}
@@ -0,0 +1,301 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Requirement types of the containers trust policy, see containers-policy.json(5).
+const (
+	PolicyInsecureAcceptAnything = "insecureAcceptAnything"
+	PolicyReject                 = "reject"
+	PolicySignedBy               = "signedBy"
+	PolicySigstoreSigned         = "sigstoreSigned"
+)
+
+// TrustPolicy is the content of a containers policy.json.
+type TrustPolicy struct {
+	Default []PolicyRequirement `json:"default"`
+	// Transports are the requirements by transport, then by scope. Only the
+	// docker transport is used to pull images.
+	Transports map[string]map[string][]PolicyRequirement `json:"transports"`
+}
+
+// PolicyRequirement is a requirement an image must satisfy to be pulled.
+type PolicyRequirement struct {
+	Type           string          `json:"type"`
+	KeyPath        string          `json:"keyPath,omitempty"`
+	KeyPaths       []string        `json:"keyPaths,omitempty"`
+	KeyData        string          `json:"keyData,omitempty"`
+	Fulcio         json.RawMessage `json:"fulcio,omitempty"`
+	SignedIdentity *SignedIdentity `json:"signedIdentity,omitempty"`
+}
+
+// SignedIdentity describes which image references a signature is valid for.
+type SignedIdentity struct {
+	Type             string `json:"type"`
+	DockerReference  string `json:"dockerReference,omitempty"`
+	DockerRepository string `json:"dockerRepository,omitempty"`
+}
+
+// getTrustPolicyFiles returns the paths of the trust policy, by priority.
+func getTrustPolicyFiles() []string {
+	result := []string{}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		result = append(result, filepath.Join(home, ".config", "containers", "policy.json"))
+	}
+
+	return append(result, "/etc/containers/policy.json")
+}
+
+// loadTrustPolicy returns the first trust policy found, or nil if none is.
+func loadTrustPolicy() (*TrustPolicy, string, error) {
+	for _, path := range getTrustPolicyFiles() {
+		file, err := fileutils.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, path, err
+		}
+
+		var policy TrustPolicy
+
+		err = json.Unmarshal(file, &policy)
+		if err != nil {
+			return nil, path, fmt.Errorf("invalid %s: %w", path, err)
+		}
+
+		return &policy, path, nil
+	}
+
+	return nil, "", nil
+}
+
+// getPolicyScopes returns the scopes of the docker transport that can match
+// input reference, most specific first, eg: docker.io/library/alpine:latest,
+// docker.io/library/alpine, docker.io/library, docker.io.
+func getPolicyScopes(ref name.Reference) []string {
+	registry := ref.Context().RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+
+	repository := registry + "/" + ref.Context().RepositoryStr()
+
+	result := []string{repository + strings.TrimPrefix(ref.Name(), ref.Context().Name())}
+
+	for scope := repository; strings.Contains(scope, "/"); scope = scope[:strings.LastIndex(scope, "/")] {
+		result = append(result, scope)
+	}
+
+	result = append(result, registry)
+
+	// wildcards only match subdomains, eg: *.example.com matches a.example.com
+	host := strings.Split(registry, ":")[0]
+	for strings.Contains(host, ".") {
+		host = host[strings.Index(host, ".")+1:]
+		result = append(result, "*."+host)
+	}
+
+	return result
+}
+
+// getRequirements returns the requirements of policy for input reference.
+func (p *TrustPolicy) getRequirements(ref name.Reference) ([]PolicyRequirement, string) {
+	scopes := p.Transports["docker"]
+
+	for _, scope := range getPolicyScopes(ref) {
+		requirements, found := scopes[scope]
+		if found {
+			return requirements, scope
+		}
+	}
+
+	requirements, found := scopes[""]
+	if found {
+		return requirements, "docker"
+	}
+
+	return p.Default, "default"
+}
+
+// normalizeReference returns input image reference fully qualified, eg:
+// alpine -> index.docker.io/library/alpine:latest.
+func normalizeReference(reference string) string {
+	ref, err := name.ParseReference(reference)
+	if err != nil {
+		return reference
+	}
+
+	return ref.Name()
+}
+
+// normalizeRepository returns the fully qualified repository of input image
+// reference, eg: alpine:latest -> index.docker.io/library/alpine.
+func normalizeRepository(reference string) string {
+	ref, err := name.ParseReference(reference)
+	if err != nil {
+		return reference
+	}
+
+	return ref.Context().Name()
+}
+
+// getIdentityMatcher returns a function accepting the image references in
+// the signatures that are valid for ref, as described by identity.
+func getIdentityMatcher(ref name.Reference, identity *SignedIdentity) (func(reference string) bool, error) {
+	identityType := "matchRepoDigestOrExact"
+	if identity != nil {
+		identityType = identity.Type
+	}
+
+	_, isDigest := ref.(name.Digest)
+
+	switch identityType {
+	case "matchExact":
+		return func(reference string) bool {
+			return normalizeReference(reference) == ref.Name()
+		}, nil
+	case "matchRepoDigestOrExact":
+		return func(reference string) bool {
+			if isDigest {
+				return normalizeRepository(reference) == ref.Context().Name()
+			}
+
+			return normalizeReference(reference) == ref.Name()
+		}, nil
+	case "matchRepository":
+		return func(reference string) bool {
+			return normalizeRepository(reference) == ref.Context().Name()
+		}, nil
+	case "exactReference":
+		return func(reference string) bool {
+			return normalizeReference(reference) == normalizeReference(identity.DockerReference)
+		}, nil
+	case "exactRepository":
+		return func(reference string) bool {
+			return normalizeRepository(reference) == normalizeRepository(identity.DockerRepository)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signedIdentity type %s", identityType)
+	}
+}
+
+// getRequirementKeys returns the public keys of a sigstoreSigned requirement.
+func getRequirementKeys(requirement PolicyRequirement) ([]crypto.PublicKey, error) {
+	result := []crypto.PublicKey{}
+
+	paths := requirement.KeyPaths
+	if requirement.KeyPath != "" {
+		paths = append(paths, requirement.KeyPath)
+	}
+
+	for _, path := range paths {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, key)
+	}
+
+	if requirement.KeyData != "" {
+		data, err := base64.StdEncoding.DecodeString(requirement.KeyData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyData: %w", err)
+		}
+
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid keyData: %w", err)
+		}
+
+		result = append(result, key)
+	}
+
+	return result, nil
+}
+
+// checkRequirement returns nil if input image satisfies input requirement.
+func checkRequirement(ref name.Reference, resolved v1.Hash, image v1.Image, requirement PolicyRequirement) error {
+	switch requirement.Type {
+	case PolicyInsecureAcceptAnything:
+		return nil
+	case PolicyReject:
+		return fmt.Errorf("image %s is rejected by the trust policy", ref.Name())
+	case PolicySignedBy:
+		return fmt.Errorf("image %s requires GPG signatures, which are not supported", ref.Name())
+	case PolicySigstoreSigned:
+		// keyless signatures are verified with the certificate chain of
+		// Fulcio and the inclusion proof of Rekor, which need the sigstore
+		// libraries, so the images requiring them are rejected
+		if len(requirement.Fulcio) > 0 {
+			return fmt.Errorf("image %s requires keyless signatures, which are not supported", ref.Name())
+		}
+
+		keys, err := getRequirementKeys(requirement)
+		if err != nil {
+			return err
+		}
+
+		if len(keys) == 0 {
+			return fmt.Errorf("no keys in the sigstoreSigned requirement for %s", ref.Name())
+		}
+
+		identity, err := getIdentityMatcher(ref, requirement.SignedIdentity)
+		if err != nil {
+			return err
+		}
+
+		return verifyImageSignatures(ref, resolved, image, keys, identity)
+	default:
+		return fmt.Errorf("unsupported requirement type %s in the trust policy", requirement.Type)
+	}
+}
+
+// checkTrustPolicy returns nil if input image is allowed by the containers
+// trust policy, eg: /etc/containers/policy.json. All the requirements of the
+// most specific scope matching the image must be satisfied.
+// Without a trust policy, all images are allowed.
+func checkTrustPolicy(ref name.Reference, resolved v1.Hash, image v1.Image) error {
+	policy, path, err := loadTrustPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	requirements, scope := policy.getRequirements(ref)
+
+	logging.LogDebug("checking %s against scope %s of %s", ref.Name(), scope, path)
+
+	// an empty list of requirements is invalid, and treated as reject
+	if len(requirements) == 0 {
+		return fmt.Errorf("image %s is rejected by the trust policy, no requirements for %s in %s",
+			ref.Name(), scope, path)
+	}
+
+	for _, requirement := range requirements {
+		err = checkRequirement(ref, resolved, image, requirement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// RegistryProxy is the proxy to use for a registry.
+type RegistryProxy struct {
+	// Registry is the host of the registry, eg: ghcr.io, or *.example.com.
+	Registry string `json:"registry"`
+	// Proxy is the URL of the proxy, eg: http://proxy.local:3128, or empty
+	// to connect directly.
+	Proxy string `json:"proxy"`
+}
+
+// ProxyConfigFile is where the proxies of the registries are configured.
+var ProxyConfigFile = filepath.Join(utils.GetLilipodHome(), "proxies.json")
+
+// getRegistryProxy returns the configured proxy for input registry, or nil
+// if there is none, and the environment is used.
+func getRegistryProxy(registry name.Registry) (*RegistryProxy, error) {
+	file, err := fileutils.ReadFile(ProxyConfigFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	proxies := []RegistryProxy{}
+
+	err = json.Unmarshal(file, &proxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ProxyConfigFile, err)
+	}
+
+	host := registry.RegistryStr()
+	if host == name.DefaultRegistry {
+		host = "docker.io"
+	}
+
+	var result *RegistryProxy
+
+	for i, proxy := range proxies {
+		if proxy.Registry == host {
+			return &proxies[i], nil
+		}
+
+		// wildcards are less specific than the exact host
+		if result == nil && strings.HasPrefix(proxy.Registry, "*.") && matchesPrefix(host, proxy.Registry) {
+			result = &proxies[i]
+		}
+	}
+
+	return result, nil
+}
+
+// getProxy returns the proxy function to access input registry: the one
+// configured in the ProxyConfigFile, else the one of HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY.
+func getProxy(registry name.Registry) (func(*http.Request) (*url.URL, error), error) {
+	proxy, err := getRegistryProxy(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy == nil {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	if proxy.Proxy == "" {
+		logging.LogDebug("connecting to %s without proxy", registry.RegistryStr())
+
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(proxy.Proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %s for %s in %s", proxy.Proxy, proxy.Registry, ProxyConfigFile)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy %s for %s, valid schemes are: http, https, socks5",
+			proxy.Proxy, proxy.Registry)
+	}
+
+	logging.LogDebug("connecting to %s with proxy %s", registry.RegistryStr(), proxyURL.Redacted())
+
+	return http.ProxyURL(proxyURL), nil
+}
@@ -0,0 +1,118 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"fmt"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Pull policies, to decide when an image is pulled before using it.
+const (
+	PullAlways  = "always"
+	PullMissing = "missing"
+	PullNever   = "never"
+	PullNewer   = "newer"
+)
+
+// HasNewer returns whether the registry, or the local source, has a different
+// image than the stored one, comparing the manifest digest for input platform,
+// eg: linux/arm64, or the one of the host if empty.
+func HasNewer(image string, platform string) (bool, error) {
+	stored, err := GetDigest(image)
+	if err != nil {
+		return false, err
+	}
+
+	if platform == "" {
+		platform = GetHostPlatform()
+	}
+
+	wantPlatform, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return false, fmt.Errorf("invalid platform %s: %w", platform, err)
+	}
+
+	// local sources are compared with the digest they were copied by
+	if _, _, _, found := splitTransport(image); found {
+		_, digest, err := getTransportImage(image, wantPlatform)
+		if err != nil {
+			return false, err
+		}
+
+		stored, err = GetRepoDigest(image)
+
+		return digest.String() != stored, err
+	}
+
+	ref, err := parseReference(GetName(image))
+	if err != nil {
+		return false, err
+	}
+
+	options, err := getCraneOptions(ref.Context().Registry)
+	if err != nil {
+		return false, err
+	}
+
+	// manifest lists are resolved to the digest of the platform's manifest,
+	// the one that is stored.
+	remote, err := crane.Digest(ref.Name(), append(options, crane.WithPlatform(wantPlatform))...)
+	if err != nil {
+		return false, err
+	}
+
+	logging.LogDebug("stored digest of %s is %s, remote is %s", ref.Name(), stored, remote)
+
+	return remote != stored, nil
+}
+
+// ShouldPull returns whether input image has to be pulled before using it,
+// according to input pull policy:
+//   - always: it is always pulled
+//   - missing: it is pulled if not stored, or not for input platform
+//   - never: it is never pulled, and an error is returned if not stored
+//   - newer: as missing, and if the registry has a different one. The stored
+//     image is used if the registry cannot be reached.
+func ShouldPull(image string, policy string, platform string) (bool, error) {
+	// images added to the OCI layout by other tools can be used too
+	err := SyncLayout()
+	if err != nil {
+		return false, err
+	}
+
+	missing := !fileutils.Exist(GetPath(image)) || (platform != "" && !HasPlatform(image, platform))
+
+	switch policy {
+	case PullAlways:
+		return true, nil
+	case PullMissing, "":
+		return missing, nil
+	case PullNever:
+		if missing {
+			return false, fmt.Errorf("image %s not found, and the pull policy is %s", image, PullNever)
+		}
+
+		return false, nil
+	case PullNewer:
+		if missing {
+			return true, nil
+		}
+
+		newer, err := HasNewer(image, platform)
+		if err != nil {
+			logging.LogWarning("cannot check for a newer %s, using the stored one: %v", image, err)
+
+			return false, nil
+		}
+
+		return newer, nil
+	default:
+		return false, fmt.Errorf("unsupported pull policy %s, valid policies are: %s, %s, %s, %s",
+			policy, PullAlways, PullMissing, PullNever, PullNewer)
+	}
+}
@@ -0,0 +1,213 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/schollz/progressbar/v3"
+)
+
+// storedImage is an image saved in the ImageDir, as done by Pull.
+type storedImage struct {
+	dir      string
+	manifest []byte
+	config   []byte
+	layers   map[v1.Hash]v1.Descriptor
+}
+
+// storedLayer is a compressed layer of a storedImage.
+type storedLayer struct {
+	path       string
+	descriptor v1.Descriptor
+}
+
+// RawConfigFile returns the saved config.json of the image.
+func (s *storedImage) RawConfigFile() ([]byte, error) {
+	return s.config, nil
+}
+
+// RawManifest returns the saved manifest.json of the image.
+func (s *storedImage) RawManifest() ([]byte, error) {
+	return s.manifest, nil
+}
+
+// MediaType returns the media type of the saved manifest.
+func (s *storedImage) MediaType() (types.MediaType, error) {
+	var manifest v1.Manifest
+
+	err := json.Unmarshal(s.manifest, &manifest)
+	if err != nil {
+		return "", err
+	}
+
+	if manifest.MediaType == "" {
+		return types.DockerManifestSchema2, nil
+	}
+
+	return manifest.MediaType, nil
+}
+
+// LayerByDigest returns the saved layer with input digest.
+func (s *storedImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	descriptor, found := s.layers[digest]
+	if !found {
+		return nil, fmt.Errorf("layer %s not found in %s", digest, s.dir)
+	}
+
+	return &storedLayer{
+		path:       getLayerPath(s.dir, digest),
+		descriptor: descriptor,
+	}, nil
+}
+
+// Digest returns the digest of the compressed layer.
+func (s *storedLayer) Digest() (v1.Hash, error) {
+	return s.descriptor.Digest, nil
+}
+
+// Compressed returns the content of the compressed layer, downloaded from
+// its registry if it was pulled lazily.
+func (s *storedLayer) Compressed() (io.ReadCloser, error) {
+	if !fileutils.Exist(s.path) && fileutils.Exist(getLazyPath(s.descriptor.Digest)) {
+		return openLazyLayer(s.descriptor.Digest)
+	}
+
+	return os.Open(s.path)
+}
+
+// Size returns the size of the compressed layer.
+func (s *storedLayer) Size() (int64, error) {
+	return s.descriptor.Size, nil
+}
+
+// MediaType returns the media type of the compressed layer.
+func (s *storedLayer) MediaType() (types.MediaType, error) {
+	return s.descriptor.MediaType, nil
+}
+
+// loadImage returns the stored image with input name or id.
+func loadImage(image string) (v1.Image, error) {
+	stored := &storedImage{
+		dir:    GetPath(image),
+		layers: map[v1.Hash]v1.Descriptor{},
+	}
+
+	var err error
+
+	stored.manifest, err = fileutils.ReadFile(filepath.Join(stored.dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	stored.config, err = fileutils.ReadFile(filepath.Join(stored.dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest v1.Manifest
+
+	err = json.Unmarshal(stored.manifest, &manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range manifest.Layers {
+		stored.layers[layer.Digest] = layer
+	}
+
+	return partial.CompressedToImage(stored)
+}
+
+// Push will upload input stored image to destination, or to the registry
+// it was pulled from if empty.
+// Layers already present in the destination registry are not uploaded again.
+// If quiet is specified, no output nor progress will be shown.
+func Push(image string, destination string, quiet bool) error {
+	if destination == "" {
+		destination = GetName(image)
+	}
+
+	ref, err := parseReference(destination)
+	if err != nil {
+		return err
+	}
+
+	img, err := loadImage(image)
+	if err != nil {
+		return err
+	}
+
+	options, err := getRemoteOptions(ref.Context().Registry)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+
+	if quiet {
+		close(done)
+	} else {
+		fmt.Printf("pushing image %s\n", ref.Name())
+
+		// the channel is closed when the upload is done
+		updates := make(chan v1.Update, 16)
+		options = append(options, remote.WithProgress(updates))
+
+		go showPushProgress(updates, done)
+	}
+
+	err = remote.Write(ref, img, options...)
+
+	<-done
+
+	if err != nil {
+		logging.LogError("%+v", err)
+
+		return err
+	}
+
+	if !quiet {
+		fmt.Println("done")
+	}
+
+	return nil
+}
+
+// showPushProgress will print the progress of an upload until input updates
+// channel is closed, then close done.
+func showPushProgress(updates chan v1.Update, done chan struct{}) {
+	defer close(done)
+
+	var bar *progressbar.ProgressBar
+
+	for update := range updates {
+		if update.Error != nil || update.Total == 0 {
+			continue
+		}
+
+		if bar == nil {
+			bar = progressbar.NewOptions64(update.Total,
+				progressbar.OptionEnableColorCodes(true),
+				progressbar.OptionShowBytes(true),
+				progressbar.OptionSetWidth(30),
+				progressbar.OptionSetDescription("Copying blobs"),
+				progressbar.OptionOnCompletion(func() {
+					println("")
+				}),
+			)
+		}
+
+		_ = bar.Set64(update.Complete)
+	}
+}
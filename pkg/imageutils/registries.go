@@ -0,0 +1,523 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Values of pull-from-mirror, see containers-registries.conf(5).
+const (
+	MirrorPullAll        = "all"
+	MirrorPullDigestOnly = "digest-only"
+	MirrorPullTagOnly    = "tag-only"
+)
+
+// RegistryMirror is a location an image can be pulled from, instead of its registry.
+type RegistryMirror struct {
+	Location       string
+	Insecure       bool
+	PullFromMirror string
+}
+
+// RegistryConfig is the configuration of the images matching Prefix.
+type RegistryConfig struct {
+	Prefix             string
+	Location           string
+	Insecure           bool
+	Blocked            bool
+	MirrorByDigestOnly bool
+	Mirrors            []RegistryMirror
+}
+
+// RegistriesConfig is the content of the registries.conf files.
+type RegistriesConfig struct {
+	// UnqualifiedSearchRegistries are tried in order to pull short names, eg: alpine.
+	UnqualifiedSearchRegistries []string
+	Registries                  []RegistryConfig
+	// Aliases are the fully qualified images of short names.
+	Aliases map[string]string
+}
+
+// registriesConfig is the loaded configuration, see getRegistriesConfig.
+var registriesConfig *RegistriesConfig
+
+// getRegistriesConfFiles returns the registries.conf file to use, and its
+// drop-in files in the order they are applied.
+func getRegistriesConfFiles() []string {
+	home, _ := os.UserHomeDir()
+
+	if os.Getenv("CONTAINERS_REGISTRIES_CONF") != "" {
+		return []string{os.Getenv("CONTAINERS_REGISTRIES_CONF")}
+	}
+
+	result := []string{"/etc/containers/registries.conf"}
+
+	userConfig := filepath.Join(home, ".config", "containers", "registries.conf")
+	if home != "" && fileutils.Exist(userConfig) {
+		result = []string{userConfig}
+	}
+
+	dirs := []string{"/etc/containers/registries.conf.d"}
+	if home != "" {
+		dirs = append(dirs, filepath.Join(home, ".config", "containers", "registries.conf.d"))
+	}
+
+	for _, dir := range dirs {
+		dropins, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+		if err != nil {
+			continue
+		}
+
+		sort.Strings(dropins)
+
+		result = append(result, dropins...)
+	}
+
+	return result
+}
+
+// getRegistriesConfig returns the merged content of the registries.conf
+// files, an empty configuration is returned if there are none.
+func getRegistriesConfig() (*RegistriesConfig, error) {
+	if registriesConfig != nil {
+		return registriesConfig, nil
+	}
+
+	result := &RegistriesConfig{Aliases: map[string]string{}}
+
+	for _, path := range getRegistriesConfFiles() {
+		content, err := fileutils.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		logging.LogDebug("loading %s", path)
+
+		config, err := parseRegistriesConf(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+
+		result.merge(config)
+	}
+
+	registriesConfig = result
+
+	return result, nil
+}
+
+// merge will apply input drop-in configuration on top of c: the search
+// registries are replaced if set, registries with the same prefix are
+// replaced, and empty aliases are removed.
+func (c *RegistriesConfig) merge(dropin *RegistriesConfig) {
+	if dropin.UnqualifiedSearchRegistries != nil {
+		c.UnqualifiedSearchRegistries = dropin.UnqualifiedSearchRegistries
+	}
+
+	for _, registry := range dropin.Registries {
+		replaced := false
+
+		for i := range c.Registries {
+			if c.Registries[i].Prefix == registry.Prefix {
+				c.Registries[i] = registry
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			c.Registries = append(c.Registries, registry)
+		}
+	}
+
+	for key, value := range dropin.Aliases {
+		if value == "" {
+			delete(c.Aliases, key)
+		} else {
+			c.Aliases[key] = value
+		}
+	}
+}
+
+// parseRegistriesConf will parse input registries.conf, in the subset of
+// TOML it uses: tables, arrays of tables, and string, boolean and string
+// array values.
+func parseRegistriesConf(content string) (*RegistriesConfig, error) {
+	result := &RegistriesConfig{Aliases: map[string]string{}}
+	section := ""
+
+	lines := strings.Split(content, "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripComment(lines[i]))
+
+		// arrays can span multiple lines
+		for strings.Count(line, "[")-strings.Count(line, "]") > 0 && i+1 < len(lines) &&
+			strings.Contains(line, "=") {
+			i++
+			line += " " + strings.TrimSpace(stripComment(lines[i]))
+		}
+
+		switch {
+		case line == "":
+			continue
+		case line == "[[registry]]":
+			result.Registries = append(result.Registries, RegistryConfig{})
+			section = "registry"
+		case line == "[[registry.mirror]]":
+			if len(result.Registries) == 0 {
+				return nil, fmt.Errorf("line %d: mirror outside of a registry", i+1)
+			}
+
+			registry := &result.Registries[len(result.Registries)-1]
+			registry.Mirrors = append(registry.Mirrors, RegistryMirror{})
+			section = "registry.mirror"
+		case strings.HasPrefix(line, "["):
+			section = strings.Trim(line, "[] ")
+			if strings.HasPrefix(section, "registries.") {
+				return nil, fmt.Errorf("line %d: the version 1 format is not supported", i+1)
+			}
+		default:
+			key, value, found := strings.Cut(line, "=")
+			if !found {
+				return nil, fmt.Errorf("line %d: invalid line %s", i+1, line)
+			}
+
+			err := result.set(section, unquoteKey(strings.TrimSpace(key)), strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+		}
+	}
+
+	for i := range result.Registries {
+		registry := &result.Registries[i]
+
+		if registry.Prefix == "" {
+			registry.Prefix = registry.Location
+		}
+
+		if registry.Prefix == "" {
+			return nil, fmt.Errorf("registry %d has no prefix nor location", i+1)
+		}
+	}
+
+	return result, nil
+}
+
+// set will assign input value to the key of section.
+func (c *RegistriesConfig) set(section string, key string, value string) error {
+	var (
+		registry *RegistryConfig
+		mirror   *RegistryMirror
+	)
+
+	if len(c.Registries) > 0 {
+		registry = &c.Registries[len(c.Registries)-1]
+
+		if len(registry.Mirrors) > 0 {
+			mirror = &registry.Mirrors[len(registry.Mirrors)-1]
+		}
+	}
+
+	var err error
+
+	switch section + "." + key {
+	case ".unqualified-search-registries":
+		c.UnqualifiedSearchRegistries, err = parseStringArray(value)
+	case ".short-name-mode", ".credential-helpers":
+		logging.LogDebug("ignoring %s", key)
+	case "registry.prefix":
+		registry.Prefix, err = parseString(value)
+	case "registry.location":
+		registry.Location, err = parseString(value)
+	case "registry.insecure":
+		registry.Insecure, err = strconv.ParseBool(value)
+	case "registry.blocked":
+		registry.Blocked, err = strconv.ParseBool(value)
+	case "registry.mirror-by-digest-only":
+		registry.MirrorByDigestOnly, err = strconv.ParseBool(value)
+	case "registry.mirror.location":
+		mirror.Location, err = parseString(value)
+	case "registry.mirror.insecure":
+		mirror.Insecure, err = strconv.ParseBool(value)
+	case "registry.mirror.pull-from-mirror":
+		mirror.PullFromMirror, err = parseString(value)
+	default:
+		if section != "aliases" {
+			logging.LogWarning("ignoring unknown key %s in registries.conf", strings.TrimPrefix(section+"."+key, "."))
+
+			return nil
+		}
+
+		c.Aliases[key], err = parseString(value)
+	}
+
+	return err
+}
+
+// stripComment returns input line without its comment, if any.
+func stripComment(line string) string {
+	var quote rune
+
+	for i, char := range line {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\''):
+			quote = char
+		case quote == 0 && char == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// unquoteKey returns input key without quotes, eg: "alpine" -> alpine.
+func unquoteKey(key string) string {
+	value, err := parseString(key)
+	if err != nil {
+		return key
+	}
+
+	return value
+}
+
+// parseString returns the content of input basic or literal TOML string.
+func parseString(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], nil
+	case len(value) >= 2 && value[0] == '"':
+		return strconv.Unquote(value)
+	default:
+		return "", fmt.Errorf("invalid string %s", value)
+	}
+}
+
+// parseStringArray returns the content of input TOML array of strings.
+func parseStringArray(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("invalid array %s", value)
+	}
+
+	result := []string{}
+
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parsed, err := parseString(item)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, parsed)
+	}
+
+	return result, nil
+}
+
+// isShortName returns whether input image has no registry, eg: alpine or
+// library/alpine:latest.
+func isShortName(image string) bool {
+	first, _, found := strings.Cut(image, "/")
+	if !found {
+		return true
+	}
+
+	return !strings.ContainsAny(first, ".:") && first != "localhost"
+}
+
+// ResolveShortName returns the images input one can refer to, in the order
+// they should be tried: the alias of a short name, or the short name in each
+// of the unqualified-search-registries. Other images are returned as they are,
+// as are short names without configuration, that default to docker.io.
+func ResolveShortName(image string) ([]string, error) {
+	if !isShortName(image) {
+		return []string{image}, nil
+	}
+
+	config, err := getRegistriesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// the tag and digest are kept, eg: alpine:3.19 -> docker.io/library/alpine:3.19
+	repository, suffix := image, ""
+
+	index := strings.IndexAny(image, ":@")
+	if index >= 0 {
+		repository, suffix = image[:index], image[index:]
+	}
+
+	alias, found := config.Aliases[repository]
+	if found {
+		if suffix != "" {
+			alias = strings.SplitN(alias, "@", 2)[0]
+			if strings.LastIndex(alias, ":") > strings.LastIndex(alias, "/") {
+				alias = alias[:strings.LastIndex(alias, ":")]
+			}
+		}
+
+		logging.LogDebug("resolved %s to alias %s", image, alias+suffix)
+
+		return []string{alias + suffix}, nil
+	}
+
+	if len(config.UnqualifiedSearchRegistries) == 0 {
+		return []string{image}, nil
+	}
+
+	result := []string{}
+	for _, registry := range config.UnqualifiedSearchRegistries {
+		result = append(result, registry+"/"+image)
+	}
+
+	return result, nil
+}
+
+// getConfigName returns input reference as written in the containers config
+// files, eg: index.docker.io/library/alpine:latest -> docker.io/library/alpine:latest.
+func getConfigName(ref name.Reference) string {
+	registry := ref.Context().RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+
+	return registry + strings.TrimPrefix(ref.Name(), ref.Context().RegistryStr())
+}
+
+// matchesPrefix returns whether input reference is matched by input prefix
+// of registries.conf, eg: docker.io/library, or *.example.com.
+func matchesPrefix(reference string, prefix string) bool {
+	if strings.HasPrefix(prefix, "*.") {
+		host := strings.SplitN(reference, "/", 2)[0]
+
+		return strings.HasSuffix(host, prefix[1:])
+	}
+
+	if !strings.HasPrefix(reference, prefix) {
+		return false
+	}
+
+	rest := reference[len(prefix):]
+
+	return rest == "" || strings.ContainsAny(rest[:1], "/:@")
+}
+
+// getRegistryConfig returns the configuration with the longest prefix
+// matching input reference, or nil if none does.
+func (c *RegistriesConfig) getRegistryConfig(reference string) *RegistryConfig {
+	var result *RegistryConfig
+
+	for i, registry := range c.Registries {
+		if matchesPrefix(reference, registry.Prefix) &&
+			(result == nil || len(registry.Prefix) > len(result.Prefix)) {
+			result = &c.Registries[i]
+		}
+	}
+
+	return result
+}
+
+// getPullSources returns the references input image should be pulled from,
+// in order: its mirrors, then its location, as configured in registries.conf.
+func getPullSources(ref name.Reference) ([]string, error) {
+	config, err := getRegistriesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	reference := getConfigName(ref)
+
+	registry := config.getRegistryConfig(reference)
+	if registry == nil {
+		return []string{ref.Name()}, nil
+	}
+
+	if registry.Blocked {
+		return nil, fmt.Errorf("image %s is blocked by registries.conf", ref.Name())
+	}
+
+	// wildcard prefixes can only be blocked or insecure, they have no location
+	if strings.HasPrefix(registry.Prefix, "*.") {
+		return []string{ref.Name()}, nil
+	}
+
+	rest := reference[len(registry.Prefix):]
+
+	_, isDigest := ref.(name.Digest)
+
+	result := []string{}
+
+	for _, mirror := range registry.Mirrors {
+		pullFromMirror := mirror.PullFromMirror
+		if pullFromMirror == "" {
+			pullFromMirror = MirrorPullAll
+		}
+
+		if (registry.MirrorByDigestOnly || pullFromMirror == MirrorPullDigestOnly) && !isDigest ||
+			pullFromMirror == MirrorPullTagOnly && isDigest {
+			continue
+		}
+
+		result = append(result, mirror.Location+rest)
+	}
+
+	if registry.Location == "" {
+		return append(result, ref.Name()), nil
+	}
+
+	return append(result, registry.Location+rest), nil
+}
+
+// isConfigInsecure returns whether input registry is a location or a mirror
+// marked as insecure in registries.conf.
+func isConfigInsecure(registry name.Registry) bool {
+	config, err := getRegistriesConfig()
+	if err != nil {
+		return false
+	}
+
+	host := registry.RegistryStr()
+	if host == name.DefaultRegistry {
+		host = "docker.io"
+	}
+
+	for _, entry := range config.Registries {
+		location := entry.Location
+		if location == "" {
+			location = entry.Prefix
+		}
+
+		if entry.Insecure && matchesPrefix(host, strings.SplitN(location, "/", 2)[0]) {
+			return true
+		}
+
+		for _, mirror := range entry.Mirrors {
+			if mirror.Insecure && strings.SplitN(mirror.Location, "/", 2)[0] == host {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// blobFetcher returns the content of the blob with input digest, starting
+// from offset, size bytes of it or up to its end if size is 0.
+type blobFetcher func(digest v1.Hash, offset int64, size int64) (io.ReadCloser, error)
+
+// newBlobFetcher returns a blobFetcher for the blobs of input repository,
+// using HTTP range requests.
+func newBlobFetcher(repo name.Repository) blobFetcher {
+	var client *http.Client
+
+	return func(digest v1.Hash, offset int64, size int64) (io.ReadCloser, error) {
+		// the token is only requested when resuming the first time
+		if client == nil {
+			auth, err := Keychain.Resolve(repo)
+			if err != nil {
+				return nil, err
+			}
+
+			base, err := getTransport(repo.Registry)
+			if err != nil {
+				return nil, err
+			}
+
+			roundTripper, err := transport.NewWithContext(context.Background(), repo.Registry, auth,
+				base, []string{repo.Scope(transport.PullScope)})
+			if err != nil {
+				return nil, err
+			}
+
+			client = &http.Client{Transport: roundTripper}
+		}
+
+		url := fmt.Sprintf("%s://%s/v2/%s/blobs/%s",
+			repo.Registry.Scheme(), repo.RegistryStr(), repo.RepositoryStr(), digest)
+
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+		if size > 0 {
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+		}
+
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != http.StatusPartialContent {
+			_ = response.Body.Close()
+
+			return nil, fmt.Errorf("range request not supported: %s", response.Status)
+		}
+
+		return response.Body, nil
+	}
+}
+
+// openLayer returns the compressed content of input layer to save in path,
+// and the offset to write it at. If path already contains a part of the
+// layer, only the missing part is fetched, else the whole layer.
+func openLayer(layer v1.Layer, path string, size int64, fetcher blobFetcher) (io.ReadCloser, int64, error) {
+	info, err := os.Stat(path)
+	if err == nil && fetcher != nil && info.Size() > 0 && info.Size() < size {
+		digest, err := layer.Digest()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		reader, err := fetcher(digest, info.Size(), 0)
+		if err == nil {
+			logging.LogDebug("resuming layer %s from %d bytes", digest, info.Size())
+
+			return reader, info.Size(), nil
+		}
+
+		logging.LogDebug("cannot resume layer %s, downloading it again: %v", digest, err)
+	}
+
+	reader, err := layer.Compressed()
+
+	return reader, 0, err
+}
@@ -0,0 +1,93 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// maxRetryDelay is the longest we wait between two attempts.
+const maxRetryDelay = 5 * time.Minute
+
+// errCorruptedLayer is returned when a downloaded layer does not match its digest.
+var errCorruptedLayer = errors.New("error getting layer, digest mismatch")
+
+// retryAttempts is how many times a failed fetch is retried.
+var retryAttempts = 3
+
+// retryDelay is the delay before the first retry, doubled for each next one.
+var retryDelay = 2 * time.Second
+
+// SetRetryPolicy will set how many times failed manifest and layer fetches
+// are retried, and the delay before the first retry, doubled each time.
+func SetRetryPolicy(attempts int, delay time.Duration) error {
+	if attempts < 0 {
+		return fmt.Errorf("invalid retry attempts %d, it must not be negative", attempts)
+	}
+
+	if delay <= 0 {
+		return fmt.Errorf("invalid retry delay %s, it must be positive", delay)
+	}
+
+	retryAttempts = attempts
+	retryDelay = delay
+
+	return nil
+}
+
+// isRetryable returns whether input error can be solved by trying again:
+// server errors, rate limits, timeouts, dropped connections and corrupted
+// downloads. Client errors, eg: unauthorized or not found, are not.
+func isRetryable(err error) bool {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		return transportErr.StatusCode >= http.StatusInternalServerError ||
+			transportErr.StatusCode == http.StatusTooManyRequests ||
+			transportErr.StatusCode == http.StatusRequestTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// failed dials, reads and writes, but not TLS errors
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, errCorruptedLayer)
+}
+
+// withRetry will call fetch until it succeeds, it fails with an error that
+// is not retryable, or the retry attempts are over, waiting longer each time.
+func withRetry(what string, fetch func() error) error {
+	delay := retryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fetch()
+		if err == nil || attempt > retryAttempts || !isRetryable(err) {
+			return err
+		}
+
+		logging.LogWarning("%s failed, retrying in %s (%d/%d): %v", what, delay, attempt, retryAttempts, err)
+
+		time.Sleep(delay)
+
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
@@ -0,0 +1,242 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// dockerHubSearchURL is the search API of Docker Hub, the only registry with one.
+const dockerHubSearchURL = "https://index.docker.io/v1/search"
+
+// maxSearchPage is the maximum number of results Docker Hub returns at once.
+const maxSearchPage = 100
+
+// SearchResult is an image found by Search.
+type SearchResult struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Stars       int    `json:"stars"`
+	Official    bool   `json:"official"`
+}
+
+// dockerHubResults is the response of the Docker Hub search API.
+type dockerHubResults struct {
+	Results []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		StarCount   int    `json:"star_count"`
+		IsOfficial  bool   `json:"is_official"`
+	} `json:"results"`
+}
+
+// matchesSearchFilters returns whether input result satisfies all filters:
+// is-official=true|false and stars=N, the minimum number of stars.
+func matchesSearchFilters(result SearchResult, filters map[string]string) (bool, error) {
+	for key, value := range filters {
+		switch key {
+		case "is-official":
+			official, err := strconv.ParseBool(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid filter is-official=%s", value)
+			}
+
+			if result.Official != official {
+				return false, nil
+			}
+		case "stars":
+			stars, err := strconv.Atoi(value)
+			if err != nil {
+				return false, fmt.Errorf("invalid filter stars=%s", value)
+			}
+
+			if result.Stars < stars {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported filter %s, valid filters are: is-official, stars", key)
+		}
+	}
+
+	return true, nil
+}
+
+// searchDockerHub returns the images of Docker Hub matching term, as listed
+// by its search API.
+func searchDockerHub(term string, limit int) ([]SearchResult, error) {
+	reg, err := name.NewRegistry(name.DefaultRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := getTransport(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Transport: transport}
+
+	query := url.Values{"q": {term}, "n": {strconv.Itoa(limit)}}
+
+	response, err := client.Get(dockerHubSearchURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search on docker.io failed: %s", response.Status)
+	}
+
+	var results dockerHubResults
+
+	err = json.NewDecoder(response.Body).Decode(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []SearchResult{}
+
+	for _, entry := range results.Results {
+		// official images are in the library namespace
+		repository := entry.Name
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+
+		result = append(result, SearchResult{
+			Name:        "docker.io/" + repository,
+			Description: entry.Description,
+			Stars:       entry.StarCount,
+			Official:    entry.IsOfficial,
+		})
+	}
+
+	return result, nil
+}
+
+// searchCatalog returns the images of input registry whose name contains
+// term, as listed by its catalog.
+func searchCatalog(registry string, term string) ([]SearchResult, error) {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	if isInsecure(reg) {
+		reg, err = name.NewInsecureRegistry(registry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	options, err := getRemoteOptions(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	repositories, err := remote.Catalog(context.Background(), reg, options...)
+	if err != nil {
+		return nil, fmt.Errorf("search on %s failed: %w", registry, err)
+	}
+
+	result := []SearchResult{}
+
+	for _, repository := range repositories {
+		if strings.Contains(strings.ToLower(repository), strings.ToLower(term)) {
+			result = append(result, SearchResult{Name: registry + "/" + repository})
+		}
+	}
+
+	return result, nil
+}
+
+// Search returns up to limit images matching term, that satisfy all filters.
+// Term can start with the registry to search, eg: quay.io/fedora, else the
+// unqualified-search-registries of registries.conf are searched, or docker.io.
+// Docker Hub is searched with its search API, the other registries by listing
+// their catalog, that has neither descriptions nor stars.
+func Search(term string, limit int, filters map[string]string) ([]SearchResult, error) {
+	// validate the filters even if nothing is found
+	_, err := matchesSearchFilters(SearchResult{}, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	registries := []string{"docker.io"}
+
+	if !isShortName(term) {
+		registries = []string{strings.SplitN(term, "/", 2)[0]}
+		term = strings.SplitN(term, "/", 2)[1]
+	} else {
+		config, err := getRegistriesConfig()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(config.UnqualifiedSearchRegistries) > 0 {
+			registries = config.UnqualifiedSearchRegistries
+		}
+	}
+
+	// filtered results are fetched in full pages, not to miss any
+	page := limit
+	if len(filters) > 0 || page > maxSearchPage {
+		page = maxSearchPage
+	}
+
+	result := []SearchResult{}
+
+	for _, registry := range registries {
+		var (
+			found []SearchResult
+			err   error
+		)
+
+		if registry == "docker.io" || registry == name.DefaultRegistry {
+			found, err = searchDockerHub(term, page)
+		} else {
+			found, err = searchCatalog(registry, term)
+		}
+
+		if err != nil {
+			if len(registries) == 1 {
+				return nil, err
+			}
+
+			logging.LogWarning("%v", err)
+
+			continue
+		}
+
+		for _, entry := range found {
+			matches, err := matchesSearchFilters(entry, filters)
+			if err != nil {
+				return nil, err
+			}
+
+			if !matches {
+				continue
+			}
+
+			result = append(result, entry)
+
+			if len(result) == limit {
+				return result, nil
+			}
+		}
+	}
+
+	return result, nil
+}
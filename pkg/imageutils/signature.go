@@ -0,0 +1,287 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// SignaturePolicy requires the images in Scope to be signed with cosign,
+// by one of Keys.
+type SignaturePolicy struct {
+	// Scope is a fully qualified registry or repository, eg: ghcr.io/org or
+	// index.docker.io/library/alpine.
+	Scope string `json:"scope"`
+	// Keys are the paths of the PEM encoded public keys, eg: cosign.pub.
+	Keys []string `json:"keys"`
+}
+
+// SignaturePolicyFile is where the signature policies are configured.
+var SignaturePolicyFile = filepath.Join(utils.GetLilipodHome(), "signatures.json")
+
+// cosignSignatureAnnotation is where cosign saves the signature of the payload.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// maxPayloadSize is the maximum size of a signature payload we read.
+const maxPayloadSize = 1024 * 1024
+
+// cosignPayload is the simple signing payload signed by cosign.
+type cosignPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// getSignaturePolicy returns the most specific policy for input repository,
+// or nil if it does not need to be signed.
+func getSignaturePolicy(repository string) (*SignaturePolicy, error) {
+	file, err := fileutils.ReadFile(SignaturePolicyFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	policies := []SignaturePolicy{}
+
+	err = json.Unmarshal(file, &policies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", SignaturePolicyFile, err)
+	}
+
+	var result *SignaturePolicy
+
+	matched := -1
+
+	for i, policy := range policies {
+		scope := strings.TrimSuffix(policy.Scope, "/")
+		if repository != scope && !strings.HasPrefix(repository, scope+"/") {
+			continue
+		}
+
+		if len(scope) > matched {
+			result = &policies[i]
+			matched = len(scope)
+		}
+	}
+
+	return result, nil
+}
+
+// loadPublicKey returns the PEM encoded public key in input path.
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	file, err := fileutils.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePublicKey(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// parsePublicKey returns the PEM encoded public key in input data.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verifyPayload returns whether signature is a valid signature of payload,
+// made by input key.
+func verifyPayload(key crypto.PublicKey, payload []byte, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	default:
+		return false
+	}
+}
+
+// verifySignatures returns nil if the cosign signatures of input digest, in
+// the repository of ref, contain one made by any of input keys.
+// If identity is not nil, it must accept the image reference in the signature too.
+func verifySignatures(ref name.Reference, digest v1.Hash, keys []crypto.PublicKey,
+	identity func(reference string) bool,
+) error {
+	// cosign saves the signatures as an image tagged after the signed digest
+	tag := ref.Context().Tag(fmt.Sprintf("%s-%s.sig", digest.Algorithm, digest.Hex))
+
+	options, err := getRemoteOptions(ref.Context().Registry)
+	if err != nil {
+		return err
+	}
+
+	signatures, err := remote.Image(tag, options...)
+	if err != nil {
+		return fmt.Errorf("no signatures found for %s: %w", digest, err)
+	}
+
+	manifest, err := signatures.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+
+		blob, err := signatures.LayerByDigest(layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		reader, err := blob.Compressed()
+		if err != nil {
+			return err
+		}
+
+		payload, err := io.ReadAll(io.LimitReader(reader, maxPayloadSize))
+
+		_ = reader.Close()
+
+		if err != nil {
+			return err
+		}
+
+		var content cosignPayload
+
+		// the payload must be about this very image, not just validly signed
+		err = json.Unmarshal(payload, &content)
+		if err != nil || content.Critical.Image.DockerManifestDigest != digest.String() {
+			continue
+		}
+
+		if identity != nil && !identity(content.Critical.Identity.DockerReference) {
+			logging.LogDebug("signature of %s is for %s", digest, content.Critical.Identity.DockerReference)
+
+			continue
+		}
+
+		for _, key := range keys {
+			if verifyPayload(key, payload, signature) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no valid signature found for %s", digest)
+}
+
+// getSignedDigests returns the digests a signature of input image can be
+// made for: the one input reference resolved to when pulled, eg: the one of a
+// manifest list, and the one of the manifest of the image.
+func getSignedDigests(resolved v1.Hash, image v1.Image) ([]v1.Hash, error) {
+	digest, err := image.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	if resolved == digest {
+		return []v1.Hash{digest}, nil
+	}
+
+	return []v1.Hash{resolved, digest}, nil
+}
+
+// verifyImageSignatures returns nil if any of the manifests of input image,
+// pulled from the one with the resolved digest, is signed by one of input
+// keys, see verifySignatures.
+func verifyImageSignatures(ref name.Reference, resolved v1.Hash, image v1.Image, keys []crypto.PublicKey,
+	identity func(reference string) bool,
+) error {
+	digests, err := getSignedDigests(resolved, image)
+	if err != nil {
+		return err
+	}
+
+	for _, digest := range digests {
+		err = verifySignatures(ref, digest, keys, identity)
+		if err == nil {
+			logging.LogDebug("verified signature of %s (%s)", ref.Name(), digest)
+
+			return nil
+		}
+
+		logging.LogDebug("%v", err)
+	}
+
+	return fmt.Errorf("image %s is not signed by a trusted key: %w", ref.Name(), err)
+}
+
+// VerifyImage will check that input image is allowed by the containers trust
+// policy, and that it has valid cosign signatures if the signature policies
+// of lilipod require it. Resolved is the digest of the manifest input reference
+// resolved to when the image was pulled, so that the signatures are checked
+// for the content pulled, wherever it was pulled from.
+func VerifyImage(ref name.Reference, resolved v1.Hash, image v1.Image) error {
+	err := checkTrustPolicy(ref, resolved, image)
+	if err != nil {
+		return err
+	}
+
+	policy, err := getSignaturePolicy(ref.Context().Name())
+	if err != nil || policy == nil {
+		return err
+	}
+
+	keys := []crypto.PublicKey{}
+
+	for _, path := range policy.Keys {
+		key, err := loadPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("cannot load key for %s: %w", policy.Scope, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys configured for %s in %s", policy.Scope, SignaturePolicyFile)
+	}
+
+	return verifyImageSignatures(ref, resolved, image, keys, nil)
+}
@@ -0,0 +1,55 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+)
+
+// ExportSquashfs will flatten the layers of input image in a squashfs
+// filesystem saved in output, using input compression algorithm.
+// The result can be loop-mounted read-only, eg. as an overlay lowerdir.
+func ExportSquashfs(image string, output string, compression string) error {
+	_, err := exec.LookPath("mksquashfs")
+	if err != nil {
+		return fmt.Errorf("exporting squashfs images needs mksquashfs (squashfs-tools) installed")
+	}
+
+	unpackDir := filepath.Join(GetPath(image), ".squashfs-rootfs")
+
+	// always cleanup before and after
+	_ = os.RemoveAll(unpackDir)
+
+	defer func() { _ = os.RemoveAll(unpackDir) }()
+
+	err = os.MkdirAll(unpackDir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	logging.LogDebug("unpacking image %s in %s", image, unpackDir)
+
+	err = Unpack(image, unpackDir, "")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := output + ".tmp"
+
+	logging.LogDebug("creating squashfs %s with %s compression", output, compression)
+
+	out, err := exec.Command("mksquashfs", unpackDir, tmpPath,
+		"-noappend", "-no-progress", "-comp", compression).CombinedOutput()
+	if err != nil {
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("failed to create squashfs image: %w: %s", err, string(out))
+	}
+
+	return os.Rename(tmpPath, output)
+}
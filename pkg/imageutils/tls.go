@@ -0,0 +1,218 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// registryTLS is the TLS configuration of the connections to a registry.
+type registryTLS struct {
+	// insecure disables the verification of the certificate, and allows
+	// falling back to plain HTTP.
+	insecure bool
+	// certDir replaces the certs.d directories, if not empty.
+	certDir string
+}
+
+// registriesTLS is the TLS configuration by registry, eg: localhost:5000.
+// Registries not in it are verified using the system CAs and certs.d.
+var registriesTLS = map[string]registryTLS{}
+
+// SetRegistryTLS configures the connections to input registry, eg: localhost:5000.
+// If verify is false, its certificate is not verified and plain HTTP is allowed.
+// If certDir is not empty, the certificates in it are used instead of the
+// ones in the certs.d directories.
+func SetRegistryTLS(registry string, verify bool, certDir string) error {
+	reg, err := name.NewRegistry(registry)
+	if err != nil {
+		return err
+	}
+
+	registriesTLS[reg.RegistryStr()] = registryTLS{insecure: !verify, certDir: certDir}
+
+	return nil
+}
+
+// GetRegistry returns the registry of input image reference, eg:
+// localhost:5000/alpine:latest -> localhost:5000.
+func GetRegistry(image string) (string, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return "", err
+	}
+
+	return ref.Context().RegistryStr(), nil
+}
+
+// isInsecure returns whether input registry is not verified, as configured
+// with SetRegistryTLS or else in registries.conf.
+func isInsecure(registry name.Registry) bool {
+	config, found := registriesTLS[registry.RegistryStr()]
+	if found {
+		return config.insecure
+	}
+
+	return isConfigInsecure(registry)
+}
+
+// parseReference parses input image reference, allowing plain HTTP if its
+// registry is not verified.
+func parseReference(image string) (name.Reference, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil || !isInsecure(ref.Context().Registry) {
+		return ref, err
+	}
+
+	return name.ParseReference(image, name.Insecure)
+}
+
+// getCertDirs returns the directories with the certificates of input
+// registry, see containers-certs.d(5).
+func getCertDirs(registry name.Registry) []string {
+	config := registriesTLS[registry.RegistryStr()]
+	if config.certDir != "" {
+		return []string{config.certDir}
+	}
+
+	host := registry.RegistryStr()
+	if host == name.DefaultRegistry {
+		host = "docker.io"
+	}
+
+	result := []string{}
+
+	home, err := os.UserHomeDir()
+	if err == nil {
+		result = append(result, filepath.Join(home, ".config", "containers", "certs.d", host))
+	}
+
+	return append(result,
+		filepath.Join("/etc/containers/certs.d", host),
+		filepath.Join("/etc/docker/certs.d", host))
+}
+
+// loadCertDir will add the CAs (*.crt) in dir to pool, and return the client
+// certificates (*.cert, with the matching *.key) in it.
+func loadCertDir(dir string, pool *x509.CertPool) ([]tls.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	result := []tls.Certificate{}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		switch filepath.Ext(entry.Name()) {
+		case ".crt":
+			logging.LogDebug("using CA %s", path)
+
+			data, err := fileutils.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in %s", path)
+			}
+		case ".cert":
+			key := strings.TrimSuffix(path, ".cert") + ".key"
+
+			logging.LogDebug("using client certificate %s", path)
+
+			cert, err := tls.LoadX509KeyPair(path, key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client certificate %s: %w", path, err)
+			}
+
+			result = append(result, cert)
+		}
+	}
+
+	return result, nil
+}
+
+// getTransport returns the transport to connect to input registry, trusting
+// the system CAs and the ones in its certs.d directories, through its proxy.
+func getTransport(registry name.Registry) (http.RoundTripper, error) {
+	proxy, err := getProxy(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := remote.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	transport.Proxy = proxy
+
+	if isInsecure(registry) {
+		logging.LogWarning("skipping TLS verification for %s", registry.RegistryStr())
+
+		transport.TLSClientConfig.InsecureSkipVerify = true
+
+		return transport, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, dir := range getCertDirs(registry) {
+		certs, err := loadCertDir(dir, pool)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig.Certificates = append(transport.TLSClientConfig.Certificates, certs...)
+	}
+
+	transport.TLSClientConfig.RootCAs = pool
+
+	return transport, nil
+}
+
+// getRemoteOptions returns the options to access input registry, with the
+// credentials and the transport to use.
+func getRemoteOptions(registry name.Registry) ([]remote.Option, error) {
+	transport, err := getTransport(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	return []remote.Option{remote.WithAuthFromKeychain(Keychain), remote.WithTransport(transport)}, nil
+}
+
+// getCraneOptions returns the options to pull from input registry, with the
+// credentials and the transport to use.
+func getCraneOptions(registry name.Registry) ([]crane.Option, error) {
+	transport, err := getTransport(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []crane.Option{crane.WithAuthFromKeychain(Keychain), crane.WithTransport(transport)}
+	if isInsecure(registry) {
+		options = append(options, crane.Insecure)
+	}
+
+	return options, nil
+}
@@ -0,0 +1,323 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Transports of the local image sources, eg: oci:/srv/alpine:3.19.
+const (
+	// TransportDir is a directory written by skopeo copy dir:PATH, with the
+	// manifest and the blobs of a single image.
+	TransportDir = "dir:"
+	// TransportOCI is an OCI layout directory, optionally followed by the
+	// reference of the image in it.
+	TransportOCI = "oci:"
+)
+
+// splitTransport returns the transport, path and reference of input image
+// from a local source, eg: oci:/srv/alpine:3.19 -> oci:, /srv/alpine, 3.19.
+// The last value is false if input image is not from a local source.
+func splitTransport(image string) (string, string, string, bool) {
+	for _, transport := range []string{TransportDir, TransportOCI} {
+		source, found := strings.CutPrefix(image, transport)
+		if !found {
+			continue
+		}
+
+		if transport == TransportDir {
+			return transport, source, "", true
+		}
+
+		path, reference, _ := strings.Cut(source, ":")
+
+		return transport, path, reference, true
+	}
+
+	return "", "", "", false
+}
+
+// getTransportName returns the name input image from a local source is
+// stored as: its reference if it is a full image name, else the name of its
+// directory tagged with the reference, or latest,
+// eg: oci:/srv/alpine:3.19 -> alpine:3.19.
+func getTransportName(image string) string {
+	_, path, reference, _ := splitTransport(image)
+
+	if strings.Contains(reference, "/") {
+		ref, err := name.ParseReference(reference)
+		if err == nil {
+			return ref.Name()
+		}
+	}
+
+	if reference == "" {
+		reference = "latest"
+	}
+
+	tagged := strings.ToLower(filepath.Base(filepath.Clean(path))) + ":" + reference
+
+	ref, err := name.ParseReference(tagged)
+	if err != nil {
+		return tagged
+	}
+
+	return ref.Name()
+}
+
+// pullTransport will save input image from a local source to ImageDir, with
+// the name returned by getTransportName. For multi-arch images, the one
+// matching input platform is saved, or the one of the host if empty.
+func pullTransport(image string, platform string, quiet bool) (string, error) {
+	if platform == "" {
+		platform = GetHostPlatform()
+	}
+
+	wantPlatform, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return "", fmt.Errorf("invalid platform %s: %w", platform, err)
+	}
+
+	img, digest, err := getTransportImage(image, wantPlatform)
+	if err != nil {
+		return "", err
+	}
+
+	imageConfig, err := img.ConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	if imageConfig.Platform() != nil && !imageConfig.Platform().Satisfies(*wantPlatform) {
+		logging.LogWarning("image %s is for platform %s, not %s", image, imageConfig.Platform(), wantPlatform)
+	}
+
+	imageName := getTransportName(image)
+
+	if !quiet {
+		fmt.Printf("copying image %s as %s\n", image, imageName)
+	}
+
+	id, err := store(imageName, img, nil, quiet)
+	if err != nil {
+		return "", err
+	}
+
+	return id, saveRepoDigest(GetPath(imageName), digest)
+}
+
+// getTransportImage returns input image from a local source, and the digest
+// of its manifest, or manifest list.
+func getTransportImage(image string, platform *v1.Platform) (v1.Image, v1.Hash, error) {
+	transport, path, reference, _ := splitTransport(image)
+
+	var (
+		img    v1.Image
+		digest v1.Hash
+		err    error
+	)
+
+	switch transport {
+	case TransportDir:
+		img, err = partial.CompressedToImage(&dirImage{path: path})
+		if err == nil {
+			digest, err = img.Digest()
+		}
+	default:
+		img, digest, err = getLayoutImage(path, reference, platform)
+	}
+
+	if err != nil {
+		return nil, v1.Hash{}, fmt.Errorf("cannot read %s: %w", image, err)
+	}
+
+	return img, digest, nil
+}
+
+// getLayoutImage returns the image of the OCI layout in path with input
+// reference, or the only one if empty, and the digest of its descriptor.
+// Image indexes are resolved to the image of input platform.
+func getLayoutImage(path string, reference string, platform *v1.Platform) (v1.Image, v1.Hash, error) {
+	index, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+
+	var descriptor *v1.Descriptor
+
+	references := []string{}
+
+	for i, manifest := range indexManifest.Manifests {
+		for _, annotation := range ociRefAnnotations {
+			if manifest.Annotations[annotation] == "" {
+				continue
+			}
+
+			references = append(references, manifest.Annotations[annotation])
+
+			if manifest.Annotations[annotation] == reference {
+				descriptor = &indexManifest.Manifests[i]
+			}
+
+			break
+		}
+	}
+
+	if reference == "" && len(indexManifest.Manifests) == 1 {
+		descriptor = &indexManifest.Manifests[0]
+	}
+
+	if descriptor == nil {
+		if reference == "" {
+			return nil, v1.Hash{}, fmt.Errorf("%d images found, specify one of: %s",
+				len(indexManifest.Manifests), strings.Join(references, ", "))
+		}
+
+		return nil, v1.Hash{}, fmt.Errorf("image %s not found", reference)
+	}
+
+	img, err := resolveLayoutImage(index, *descriptor, platform)
+
+	return img, descriptor.Digest, err
+}
+
+// resolveLayoutImage returns the image of input descriptor of an OCI layout
+// index, or the one of input platform if it is an image index.
+func resolveLayoutImage(index v1.ImageIndex, descriptor v1.Descriptor, platform *v1.Platform) (v1.Image, error) {
+	if descriptor.MediaType.IsImage() {
+		return index.Image(descriptor.Digest)
+	}
+
+	if !descriptor.MediaType.IsIndex() {
+		return nil, fmt.Errorf("unsupported media type %s", descriptor.MediaType)
+	}
+
+	child, err := index.ImageIndex(descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	childManifest, err := child.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, manifest := range childManifest.Manifests {
+		if manifest.Platform != nil && manifest.Platform.Satisfies(*platform) {
+			return child.Image(manifest.Digest)
+		}
+	}
+
+	return nil, fmt.Errorf("no image found for platform %s", platform)
+}
+
+// dirImage is an image in a directory written by skopeo copy dir:PATH, where
+// the blobs are named by the hex of their digest.
+type dirImage struct {
+	path string
+}
+
+// dirLayer is a layer of a dirImage.
+type dirLayer struct {
+	path       string
+	descriptor v1.Descriptor
+}
+
+// RawManifest implements partial.CompressedImageCore.
+func (d *dirImage) RawManifest() ([]byte, error) {
+	return fileutils.ReadFile(filepath.Join(d.path, "manifest.json"))
+}
+
+// MediaType implements partial.CompressedImageCore.
+func (d *dirImage) MediaType() (types.MediaType, error) {
+	raw, err := d.RawManifest()
+	if err != nil {
+		return "", err
+	}
+
+	var manifest struct {
+		MediaType types.MediaType `json:"mediaType"`
+	}
+
+	err = json.Unmarshal(raw, &manifest)
+	if err != nil {
+		return "", err
+	}
+
+	// OCI manifests can omit it
+	if manifest.MediaType == "" {
+		return types.OCIManifestSchema1, nil
+	}
+
+	if manifest.MediaType.IsIndex() {
+		return "", errors.New("manifest lists are not supported, copy a single image")
+	}
+
+	return manifest.MediaType, nil
+}
+
+// RawConfigFile implements partial.CompressedImageCore.
+func (d *dirImage) RawConfigFile() ([]byte, error) {
+	manifest, err := partial.Manifest(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileutils.ReadFile(filepath.Join(d.path, manifest.Config.Digest.Hex))
+}
+
+// LayerByDigest implements partial.CompressedImageCore.
+func (d *dirImage) LayerByDigest(digest v1.Hash) (partial.CompressedLayer, error) {
+	manifest, err := partial.Manifest(d)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.Digest == digest {
+			return &dirLayer{path: filepath.Join(d.path, digest.Hex), descriptor: layer}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("layer %s not found in %s", digest, d.path)
+}
+
+// Digest implements partial.CompressedLayer.
+func (l *dirLayer) Digest() (v1.Hash, error) {
+	return l.descriptor.Digest, nil
+}
+
+// Compressed implements partial.CompressedLayer.
+func (l *dirLayer) Compressed() (io.ReadCloser, error) {
+	return os.Open(l.path)
+}
+
+// Size implements partial.CompressedLayer.
+func (l *dirLayer) Size() (int64, error) {
+	return l.descriptor.Size, nil
+}
+
+// MediaType implements partial.CompressedLayer.
+func (l *dirLayer) MediaType() (types.MediaType, error) {
+	return l.descriptor.MediaType, nil
+}
@@ -0,0 +1,72 @@
+// Package imageutils contains helpers and utilities for managing and pulling
+// images.
+package imageutils
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/89luca89/lilipod/pkg/fileutils"
+)
+
+// Layer is a layer of a stored image.
+type Layer struct {
+	Digest string
+	// Size is the size of the compressed layer on disk.
+	Size uint64
+	// SharedWith are the names of the other stored images using this layer.
+	SharedWith []string
+}
+
+// GetName returns the fully qualified name of input stored image name or id.
+func GetName(image string) string {
+	name, err := fileutils.ReadFile(filepath.Join(GetPath(image), "image_name"))
+	if err != nil {
+		return image
+	}
+
+	return string(name)
+}
+
+// GetLayers returns the layers of input image in order, with the other
+// stored images sharing each one of them.
+// Layers are stored once in the BlobDir, so shared layers use disk space only once.
+func GetLayers(image string) ([]Layer, error) {
+	layers, err := getManifestLayers(GetPath(image))
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := getBlobUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	name := GetName(image)
+	result := []Layer{}
+
+	for _, layer := range layers {
+		entry := Layer{
+			Digest:     layer.Digest.String(),
+			SharedWith: []string{},
+		}
+
+		info, err := os.Stat(GetLayerPath(image, layer.Digest))
+		if err == nil {
+			entry.Size = uint64(info.Size())
+		}
+
+		for _, other := range usage[layer.Digest] {
+			if other != name {
+				entry.SharedWith = append(entry.SharedWith, other)
+			}
+		}
+
+		sort.Strings(entry.SharedWith)
+
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
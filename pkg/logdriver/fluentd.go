@@ -0,0 +1,190 @@
+// Package logdriver provides drivers to forward container output to remote
+// logging endpoints, like syslog or fluentd.
+package logdriver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// defaultFluentdAddress is the default address of a fluentd/fluent-bit forward input.
+const defaultFluentdAddress = "localhost:24224"
+
+type fluentdDriver struct {
+	network string
+	address string
+	tag     string
+	record  map[string]string
+	conn    net.Conn
+}
+
+// newFluentdDriver will connect to the fluentd forward endpoint specified in the
+// fluentd-address option, eg: host:24224, tcp://host:24224 or unix:///run/fluentd.sock.
+func newFluentdDriver(config utils.Config, tag string) (*fluentdDriver, error) {
+	network := "tcp"
+	address := config.LogOpts["fluentd-address"]
+
+	switch {
+	case address == "":
+		address = defaultFluentdAddress
+	case strings.Contains(address, "://"):
+		addressURL, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fluentd-address: %w", err)
+		}
+
+		switch addressURL.Scheme {
+		case "tcp":
+			address = addressURL.Host
+		case "unix":
+			network = "unix"
+			address = addressURL.Path
+		default:
+			return nil, fmt.Errorf("unsupported fluentd-address scheme %s", addressURL.Scheme)
+		}
+	}
+
+	driver := &fluentdDriver{
+		network: network,
+		address: address,
+		tag:     tag,
+		record: map[string]string{
+			"container_id":   config.ID,
+			"container_name": config.Names,
+		},
+	}
+
+	err := driver.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return driver, nil
+}
+
+func (f *fluentdDriver) connect() error {
+	logging.LogDebug("connecting to fluentd %s %s with tag %s", f.network, f.address, f.tag)
+
+	conn, err := net.DialTimeout(f.network, f.address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	f.conn = conn
+
+	return nil
+}
+
+// Log will send input line to fluentd using the forward protocol's message mode:
+//
+//	[tag, time, {"log": line, "source": stream, ...}]
+//
+// If the connection was lost, a single reconnection is attempted.
+func (f *fluentdDriver) Log(stream string, timestamp time.Time, line string) error {
+	record := map[string]string{
+		"log":    line,
+		"source": "stdout",
+	}
+
+	if stream == logging.StreamStderr {
+		record["source"] = "stderr"
+	}
+
+	for k, v := range f.record {
+		record[k] = v
+	}
+
+	message := encodeMessage(f.tag, timestamp, record)
+
+	if f.conn != nil {
+		_, err := f.conn.Write(message)
+		if err == nil {
+			return nil
+		}
+
+		logging.LogDebug("fluentd write failed, reconnecting: %v", err)
+
+		_ = f.conn.Close()
+		f.conn = nil
+	}
+
+	err := f.connect()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.conn.Write(message)
+
+	return err
+}
+
+// Close will close the connection to fluentd.
+func (f *fluentdDriver) Close() error {
+	if f.conn == nil {
+		return nil
+	}
+
+	return f.conn.Close()
+}
+
+// encodeMessage will encode a forward protocol message in msgpack.
+// Only the few msgpack types needed by the message are implemented.
+func encodeMessage(tag string, timestamp time.Time, record map[string]string) []byte {
+	var buf bytes.Buffer
+
+	// fixarray of 3 elements
+	buf.WriteByte(0x93)
+	encodeString(&buf, tag)
+
+	// uint32 unix timestamp
+	buf.WriteByte(0xce)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(timestamp.Unix()))
+
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	// map16, the record is never big enough for a map32
+	buf.WriteByte(0xde)
+	_ = binary.Write(&buf, binary.BigEndian, uint16(len(keys)))
+
+	for _, k := range keys {
+		encodeString(&buf, k)
+		encodeString(&buf, record[k])
+	}
+
+	return buf.Bytes()
+}
+
+// encodeString will encode input string in msgpack, using the smallest str type.
+func encodeString(buf *bytes.Buffer, input string) {
+	length := len(input)
+
+	switch {
+	case length < 32:
+		buf.WriteByte(0xa0 | byte(length))
+	case length <= 0xff:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(length))
+	case length <= 0xffff:
+		buf.WriteByte(0xda)
+		_ = binary.Write(buf, binary.BigEndian, uint16(length))
+	default:
+		buf.WriteByte(0xdb)
+		_ = binary.Write(buf, binary.BigEndian, uint32(length))
+	}
+
+	buf.WriteString(input)
+}
@@ -0,0 +1,88 @@
+// Package logdriver provides drivers to forward container output to remote
+// logging endpoints, like syslog or fluentd.
+package logdriver
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/utils"
+)
+
+// Supported log drivers.
+const (
+	File    = "file"
+	Syslog  = "syslog"
+	Fluentd = "fluentd"
+)
+
+// defaultTag is used when no tag option is specified.
+const defaultTag = "{{.Names}}"
+
+// Driver forwards container output lines to a logging endpoint.
+type Driver interface {
+	// Log will send a single line of output of input stream (out or err).
+	Log(stream string, timestamp time.Time, line string) error
+	// Close will release the resources held by the driver.
+	Close() error
+}
+
+// Validate returns an error if input driver name is not supported.
+func Validate(driver string) error {
+	switch driver {
+	case "", File, Syslog, Fluentd:
+		return nil
+	default:
+		return fmt.Errorf("unsupported log driver %s, supported drivers are: %s, %s, %s",
+			driver, File, Syslog, Fluentd)
+	}
+}
+
+// New returns the driver configured for input container.
+// The file driver has nothing to forward, so a nil Driver is returned for it.
+func New(config utils.Config) (Driver, error) {
+	err := Validate(config.LogDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := getTag(config)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.LogDriver {
+	case Syslog:
+		return newSyslogDriver(config.LogOpts, tag)
+	case Fluentd:
+		return newFluentdDriver(config, tag)
+	default:
+		//nolint: nilnil
+		return nil, nil
+	}
+}
+
+// getTag will render the tag option of input config. The tag can be a
+// go-template using the container's config, eg: "lilipod.{{.Names}}".
+func getTag(config utils.Config) (string, error) {
+	tag := config.LogOpts["tag"]
+	if tag == "" {
+		tag = defaultTag
+	}
+
+	tmpl, err := template.New("tag").Parse(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid log tag %s: %w", tag, err)
+	}
+
+	var out bytes.Buffer
+
+	err = tmpl.Execute(&out, config)
+	if err != nil {
+		return "", fmt.Errorf("invalid log tag %s: %w", tag, err)
+	}
+
+	return out.String(), nil
+}
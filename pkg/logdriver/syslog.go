@@ -0,0 +1,104 @@
+// Package logdriver provides drivers to forward container output to remote
+// logging endpoints, like syslog or fluentd.
+package logdriver
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+	"time"
+
+	"github.com/89luca89/lilipod/pkg/logging"
+)
+
+// syslogFacilities maps the facility names accepted in the syslog-facility option.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+type syslogDriver struct {
+	writer *syslog.Writer
+}
+
+// newSyslogDriver will connect to the syslog endpoint specified in the
+// syslog-address option, eg: udp://host:514, tcp://host:514 or unix:///dev/log.
+// If no address is specified, the local syslog daemon is used.
+func newSyslogDriver(options map[string]string, tag string) (*syslogDriver, error) {
+	network := ""
+	address := ""
+
+	if options["syslog-address"] != "" {
+		addressURL, err := url.Parse(options["syslog-address"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog-address: %w", err)
+		}
+
+		switch addressURL.Scheme {
+		case "udp", "tcp":
+			network = addressURL.Scheme
+			address = addressURL.Host
+
+			if addressURL.Port() == "" {
+				address += ":514"
+			}
+		case "unix", "unixgram":
+			network = addressURL.Scheme
+			address = addressURL.Path
+		default:
+			return nil, fmt.Errorf("unsupported syslog-address scheme %s", addressURL.Scheme)
+		}
+	}
+
+	facility := syslog.LOG_DAEMON
+
+	if options["syslog-facility"] != "" {
+		value, ok := syslogFacilities[options["syslog-facility"]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported syslog-facility %s", options["syslog-facility"])
+		}
+
+		facility = value
+	}
+
+	logging.LogDebug("connecting to syslog %s %s with tag %s", network, address, tag)
+
+	writer, err := syslog.Dial(network, address, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syslogDriver{writer: writer}, nil
+}
+
+// Log will send input line to syslog, stderr lines are sent with error priority.
+func (s *syslogDriver) Log(stream string, _ time.Time, line string) error {
+	if stream == logging.StreamStderr {
+		return s.writer.Err(line)
+	}
+
+	return s.writer.Info(line)
+}
+
+// Close will close the connection to syslog.
+func (s *syslogDriver) Close() error {
+	return s.writer.Close()
+}
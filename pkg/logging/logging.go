@@ -43,6 +43,12 @@ const (
 	yellow = "\033[1;33m"
 )
 
+// Streams of a log line, as saved in the log file.
+const (
+	StreamStdout = "out"
+	StreamStderr = "err"
+)
+
 var levels = map[int]string{
 	0: "mute",
 	1: "error",
@@ -89,9 +95,11 @@ func GetLogLevel() string {
 // File will be continuously read if follow is true. (like tail -f)
 // Timestamps for each line will be shown if timestamps is true.
 // Lines will be printed to stderr or stdout based on where they were meant to be.
+// If stream is specified, only lines of that stream (StreamStdout or StreamStderr)
+// will be printed.
 //
 //	Line structure: timestamp:stdout:line
-func ReadLog(file io.Reader, since, until int64, follow, timestamps bool) error {
+func ReadLog(file io.Reader, since, until int64, follow, timestamps bool, stream string) error {
 	reader := bufio.NewReader(file)
 
 	if until <= 0 {
@@ -140,11 +148,16 @@ func ReadLog(file io.Reader, since, until int64, follow, timestamps bool) error
 			content = time.Unix(timestamp, 0).Format(time.RFC3339Nano) + " " + content
 		}
 
+		// Skip lines not belonging to the requested stream, if any.
+		if stream != "" && where != stream {
+			continue
+		}
+
 		// Ensure we're printing only if the timestamp is between since and until.
 		// If none is specified, since is 0 and until is MAX_INT, so we'll always print.
 		if timestamp >= since && timestamp <= until {
 			// print to stderr if needd
-			if where == "err" {
+			if where == StreamStderr {
 				fmt.Fprintf(os.Stderr, "%s", content)
 
 				continue
@@ -0,0 +1,150 @@
+// Package netns provides network namespace management functionality for lilipod
+package netns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/constants"
+)
+
+// BackendBridge connects the namespace to a bridge on the host, with a veth
+// pair. It needs to be root on the host.
+const BackendBridge = "bridge"
+
+// hostCommand returns the command to run input command in the network
+// namespace of the host, as ours is the container's one once set up.
+func hostCommand(command ...string) *exec.Cmd {
+	return exec.Command("nsenter", append([]string{"-t", "1", "-n"}, command...)...)
+}
+
+// EnsureBridge will create the bridge with input name on the host, with the
+// gateway address of input subnet, and masquerade the subnet's traffic, if
+// not already done.
+func EnsureBridge(bridge string, gateway string, subnet string) error {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet %s: %w", subnet, err)
+	}
+
+	prefix, _ := ipNet.Mask.Size()
+
+	if hostCommand("ip", "link", "show", bridge).Run() != nil {
+		commands := [][]string{
+			{"ip", "link", "add", bridge, "type", "bridge"},
+			{"ip", "addr", "add", fmt.Sprintf("%s/%d", gateway, prefix), "dev", bridge},
+			{"ip", "link", "set", bridge, "up"},
+		}
+
+		for _, command := range commands {
+			out, err := hostCommand(command...).CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("failed to create bridge %s, %v: %w: %s", bridge, command, err, string(out))
+			}
+		}
+	}
+
+	// /proc/sys/net is the one of the network namespace of the writer
+	out, err := hostCommand("sh", "-c", "echo 1 > /proc/sys/net/ipv4/ip_forward").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to enable ip forwarding: %w: %s", err, string(out))
+	}
+
+	rule := []string{"POSTROUTING", "-s", ipNet.String(), "!", "-o", bridge, "-j", "MASQUERADE"}
+
+	if hostCommand(append([]string{"iptables", "-t", "nat", "-C"}, rule...)...).Run() != nil {
+		out, err := hostCommand(append([]string{"iptables", "-t", "nat", "-A"}, rule...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to masquerade subnet %s: %w: %s", subnet, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+// RemoveBridge will delete the bridge with input name from the host, and
+// the masquerading of input subnet.
+func RemoveBridge(bridge string, subnet string) error {
+	rule := []string{"POSTROUTING", "-s", subnet, "!", "-o", bridge, "-j", "MASQUERADE"}
+
+	if hostCommand(append([]string{"iptables", "-t", "nat", "-C"}, rule...)...).Run() == nil {
+		out, err := hostCommand(append([]string{"iptables", "-t", "nat", "-D"}, rule...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to remove masquerading of subnet %s: %w: %s", subnet, err, string(out))
+		}
+	}
+
+	if hostCommand("ip", "link", "show", bridge).Run() != nil {
+		return nil
+	}
+
+	out, err := hostCommand("ip", "link", "del", bridge).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove bridge %s: %w: %s", bridge, err, string(out))
+	}
+
+	return nil
+}
+
+// startBridge connects the namespace of the given target PID to its bridge,
+// with a veth pair named after the container, and its address in the
+// bridge's subnet.
+func (n *NetworkNamespace) startBridge(targetPid int) error {
+	if os.Getenv("ROOTFUL") != constants.TrueString || n.Bridge == nil {
+		return fmt.Errorf("bridge networks can only be used by root")
+	}
+
+	err := EnsureBridge(n.Bridge.Name, n.Bridge.Gateway, n.Bridge.Subnet)
+	if err != nil {
+		return err
+	}
+
+	_, ipNet, err := net.ParseCIDR(n.Bridge.Subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet %s: %w", n.Bridge.Subnet, err)
+	}
+
+	prefix, _ := ipNet.Mask.Size()
+
+	// interface names are at most 15 characters
+	veth := "veth" + n.ContainerID[:min(len(n.ContainerID), 11)]
+
+	hostCommands := [][]string{
+		{"ip", "link", "add", veth, "type", "veth", "peer", "name", "eth0", "netns", fmt.Sprint(targetPid)},
+		{"ip", "link", "set", veth, "master", n.Bridge.Name},
+		{"ip", "link", "set", veth, "up"},
+	}
+
+	for _, command := range hostCommands {
+		out, err := hostCommand(command...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to connect to bridge %s, %v: %w: %s", n.Bridge.Name, command, err, string(out))
+		}
+	}
+
+	commands := [][]string{
+		{"ip", "link", "set", "lo", "up"},
+		{"ip", "addr", "add", fmt.Sprintf("%s/%d", n.IP, prefix), "dev", "eth0"},
+		{"ip", "link", "set", "eth0", "up"},
+		{"ip", "route", "add", "default", "via", n.Bridge.Gateway, "dev", "eth0"},
+	}
+
+	if n.MacAddress != "" {
+		commands = append([][]string{{"ip", "link", "set", "eth0", "address", n.MacAddress}}, commands...)
+	}
+
+	for _, command := range commands {
+		args := append([]string{"-n", "-t", fmt.Sprint(targetPid)}, command...)
+
+		out, err := exec.Command("nsenter", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to configure bridge network, %v: %w: %s",
+				command, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
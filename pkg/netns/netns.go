@@ -2,42 +2,72 @@
 package netns
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"unsafe"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/89luca89/lilipod/pkg/utils"
 	"golang.org/x/sys/unix"
 )
 
-const (
-	// Linux syscall numbers from /usr/include/asm/unistd_64.h
-	SYS_UNSHARE = 272
-	SYS_SETNS   = 308
-	SYS_UMOUNT2 = 166
-
-	// Clone flags from /usr/include/linux/sched.h
-	CLONE_NEWNET = 0x40000000
-
-	// Mount flags from /usr/include/linux/mount.h
-	MS_BIND = 4096
-)
-
 // NetworkNamespace represents a network namespace configuration
 type NetworkNamespace struct {
 	ContainerID    string
 	RuntimeDir     string
 	NetNSMountPath string
 	SlirpAPISocket string
-	slirpProcess   *os.Process
+	// IP is the optional static IPv4 address of the container, in a /24 network.
+	IP string
+	// MacAddress is the optional static MAC address of the container.
+	MacAddress string
+	// Ports are the ports forwarded from the host once the backend is ready.
+	Ports []PortForward
+	// Backend is the program providing the connectivity, see GetBackend.
+	Backend string
+	// Bridge is the bridge of the host to connect to, with BackendBridge.
+	Bridge  *Bridge
+	process *os.Process
+}
+
+// Backends providing the connectivity of private networks.
+const (
+	BackendPasta       = "pasta"
+	BackendSlirp4netns = "slirp4netns"
+)
+
+// Bridge is a bridge of the host, with its gateway address in its subnet.
+type Bridge struct {
+	Name    string
+	Gateway string
+	Subnet  string
+}
+
+// PortForward is a port forwarded by the backend from the host to the namespace.
+type PortForward struct {
+	ID        int    `json:"id"`
+	Proto     string `json:"proto"`
+	HostAddr  string `json:"host_addr"`
+	HostPort  int    `json:"host_port"`
+	GuestAddr string `json:"guest_addr"`
+	GuestPort int    `json:"guest_port"`
+}
+
+// GetRuntimeDir returns the directory holding the namespace and backend
+// state of input container.
+func GetRuntimeDir(containerID string) string {
+	return filepath.Join("/run/user", fmt.Sprint(os.Getuid()), "lilipod", containerID)
 }
 
 // New creates a new NetworkNamespace instance
 func New(containerID string) (*NetworkNamespace, error) {
 	// Create runtime directory for this container
-	runtimeDir := filepath.Join("/run/user", fmt.Sprint(os.Getuid()), "lilipod", containerID)
+	runtimeDir := GetRuntimeDir(containerID)
 	if err := os.MkdirAll(runtimeDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create runtime directory: %w", err)
 	}
@@ -53,10 +83,8 @@ func New(containerID string) (*NetworkNamespace, error) {
 // Setup creates and configures the network namespace
 func (n *NetworkNamespace) Setup() error {
 	// Create new network namespace
-	// Use raw syscall for unshare since it's not directly exposed in unix package
-	_, _, errno := unix.Syscall(SYS_UNSHARE, uintptr(CLONE_NEWNET), 0, 0)
-	if errno != 0 {
-		return fmt.Errorf("failed to unshare network namespace: %w", errno)
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to unshare network namespace: %w", err)
 	}
 
 	// Get the current process's network namespace path
@@ -67,24 +95,46 @@ func (n *NetworkNamespace) Setup() error {
 		return fmt.Errorf("failed to create netns mount point: %w", err)
 	}
 
-	// Use raw syscall for mount since the unix.Mount signature doesn't match what we need
-	mountFlags := MS_BIND
-	_, _, errno = unix.Syscall6(unix.SYS_MOUNT,
-		uintptr(unsafe.Pointer(&[]byte(netnsProcPath)[0])),
-		uintptr(unsafe.Pointer(&[]byte(n.NetNSMountPath)[0])),
-		uintptr(unsafe.Pointer(&[]byte("none")[0])),
-		uintptr(mountFlags),
-		0, 0)
-	if errno != 0 {
+	// Bind mount the namespace to keep it around
+	if err := unix.Mount(netnsProcPath, n.NetNSMountPath, "none", unix.MS_BIND, ""); err != nil {
 		os.Remove(n.NetNSMountPath)
-		return fmt.Errorf("failed to bind mount network namespace: %w", errno)
+		return fmt.Errorf("failed to bind mount network namespace: %w", err)
 	}
 
 	return nil
 }
 
-// StartSlirp starts the slirp4netns process for the given target PID
-func (n *NetworkNamespace) StartSlirp(targetPid int) error {
+// GetBackend returns the backend to use for input one: pasta or slirp4netns
+// as they are, or pasta if it is installed, and the embedded slirp4netns
+// otherwise, if empty.
+func GetBackend(backend string) string {
+	if backend != "" {
+		return backend
+	}
+
+	if _, err := exec.LookPath(BackendPasta); err == nil {
+		return BackendPasta
+	}
+
+	return BackendSlirp4netns
+}
+
+// Start starts the backend of the namespace for the given target PID, and
+// forwards the ports once it is ready.
+func (n *NetworkNamespace) Start(targetPid int) error {
+	if n.Backend == BackendBridge {
+		return n.startBridge(targetPid)
+	}
+
+	if GetBackend(n.Backend) == BackendPasta {
+		return n.startPasta(targetPid)
+	}
+
+	return n.startSlirp(targetPid)
+}
+
+// startSlirp starts the slirp4netns process for the given target PID
+func (n *NetworkNamespace) startSlirp(targetPid int) error {
 	// Construct the path to the slirp4netns binary managed by EnsureUNIXDependencies
 	slirpPath := filepath.Join(utils.LilipodBinPath, "slirp4netns")
 
@@ -93,51 +143,350 @@ func (n *NetworkNamespace) StartSlirp(targetPid int) error {
 		return fmt.Errorf("slirp4netns binary not found at %s, ensure dependencies are set up: %w", slirpPath, err)
 	}
 
+	args := []string{"--mtu=65520", "-a", n.SlirpAPISocket}
+
+	var ipNet *net.IPNet
+
+	// with a static IP we configure the interface ourselves, slirp4netns
+	// would always assign the .100 address of the network.
+	if n.IP != "" {
+		var err error
+
+		ipNet, err = GetStaticNetwork(n.IP)
+		if err != nil {
+			return err
+		}
+
+		args = append(args, "--cidr", ipNet.String())
+	} else {
+		args = append(args, "--configure")
+	}
+
+	if n.MacAddress != "" {
+		args = append(args, "--macaddress", n.MacAddress)
+	}
+
+	// slirp4netns will write to this fd once the tap device is ready
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create slirp4netns ready pipe: %w", err)
+	}
+
+	defer func() { _ = readyR.Close() }()
+
+	args = append(args, "--ready-fd=3", fmt.Sprint(targetPid), "tap0")
+
 	// Prepare slirp4netns command
-	cmd := exec.Command(slirpPath,
-		"--configure",
-		"--mtu=65520",
-		"-r", "/etc/resolv.conf",
-		"-a", n.SlirpAPISocket,
-		fmt.Sprint(targetPid),
-		"tap0",
-	)
+	cmd := exec.Command(slirpPath, args...)
+	cmd.ExtraFiles = []*os.File{readyW}
 
 	// Start the slirp4netns process
 	if err := cmd.Start(); err != nil {
+		_ = readyW.Close()
+
 		return fmt.Errorf("failed to start slirp4netns: %w", err)
 	}
 
+	_ = readyW.Close()
+
 	// Store the process for later cleanup
-	n.slirpProcess = cmd.Process
+	n.process = cmd.Process
+
+	ready := make([]byte, 1)
+
+	_, err = readyR.Read(ready)
+	if err != nil {
+		return fmt.Errorf("slirp4netns did not become ready: %w", err)
+	}
+
+	if ipNet != nil {
+		err = configureStaticIP(targetPid, n.IP, ipNet)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, forward := range n.Ports {
+		err = n.addPortForward(forward)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// ParsePortMapping returns the port forward of input mapping, in the
+// [hostIP:][hostPort:]containerPort[/proto] form, eg: 127.0.0.1:8080:80/tcp.
+// Without a host port, the container port is used on the host too.
+func ParsePortMapping(mapping string) (PortForward, error) {
+	spec, proto, found := strings.Cut(mapping, "/")
+	if !found {
+		proto = "tcp"
+	}
+
+	forward := PortForward{Proto: strings.ToLower(proto), HostAddr: "0.0.0.0"}
+
+	if forward.Proto != "tcp" && forward.Proto != "udp" {
+		return forward, fmt.Errorf("invalid protocol in port mapping %s, use tcp or udp", mapping)
+	}
+
+	parts := []string{spec}
+
+	// the host IP can be an IPv6 address, with colons
+	if index := strings.LastIndex(spec, ":"); index >= 0 {
+		parts = []string{spec[:index], spec[index+1:]}
+
+		if index := strings.LastIndex(parts[0], ":"); index >= 0 {
+			parts = []string{parts[0][:index], parts[0][index+1:], parts[1]}
+		}
+	}
+
+	var err error
+
+	forward.GuestPort, err = parsePort(parts[len(parts)-1])
+	if err != nil {
+		return forward, fmt.Errorf("invalid port mapping %s: %w", mapping, err)
+	}
+
+	forward.HostPort = forward.GuestPort
+
+	if len(parts) > 1 && parts[len(parts)-2] != "" {
+		forward.HostPort, err = parsePort(parts[len(parts)-2])
+		if err != nil {
+			return forward, fmt.Errorf("invalid port mapping %s: %w", mapping, err)
+		}
+	}
+
+	if len(parts) > 2 && parts[0] != "" {
+		address := net.ParseIP(strings.Trim(parts[0], "[]"))
+		if address == nil {
+			return forward, fmt.Errorf("invalid host address in port mapping %s", mapping)
+		}
+
+		forward.HostAddr = address.String()
+	}
+
+	return forward, nil
+}
+
+// parsePort returns the port number in input string.
+func parsePort(port string) (int, error) {
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return 0, fmt.Errorf("invalid port %s", port)
+	}
+
+	return number, nil
+}
+
+// addPortForward will forward input port from the host to the namespace,
+// with the slirp4netns API.
+func (n *NetworkNamespace) addPortForward(forward PortForward) error {
+	var response struct {
+		Error *struct {
+			Desc string `json:"desc"`
+		} `json:"error"`
+	}
+
+	arguments := map[string]any{
+		"proto":      forward.Proto,
+		"host_addr":  forward.HostAddr,
+		"host_port":  forward.HostPort,
+		"guest_port": forward.GuestPort,
+	}
+
+	// slirp4netns forwards to the .100 address it configures by default
+	if n.IP != "" {
+		arguments["guest_addr"] = n.IP
+	}
+
+	request := map[string]any{"execute": "add_hostfwd", "arguments": arguments}
+
+	err := slirpRequest(n.SlirpAPISocket, request, &response)
+	if err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("failed to publish port %s:%d: %s",
+			forward.HostAddr, forward.HostPort, response.Error.Desc)
+	}
+
+	return nil
+}
+
+// AddPortForwards will forward input ports from the host to input address,
+// with the backend of input container, for the containers that joined its
+// namespace. The ports already forwarded, eg: on restarts, are skipped.
+func AddPortForwards(containerID string, ip string, ports []PortForward) error {
+	if len(ports) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(getPastaPortsPath(containerID)); err == nil {
+		return fmt.Errorf("cannot publish ports with the pasta backend of container %s, it only forwards its own", containerID)
+	}
+
+	existing, err := ListPortForwards(containerID)
+	if err != nil {
+		return err
+	}
+
+	n := &NetworkNamespace{
+		ContainerID:    containerID,
+		SlirpAPISocket: filepath.Join(GetRuntimeDir(containerID), "slirp.sock"),
+		IP:             ip,
+	}
+
+	for _, forward := range ports {
+		if slices.ContainsFunc(existing, func(current PortForward) bool {
+			return current.Proto == forward.Proto && current.HostPort == forward.HostPort &&
+				current.HostAddr == forward.HostAddr
+		}) {
+			continue
+		}
+
+		err := n.addPortForward(forward)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetStaticNetwork returns the /24 network of input static IPv4 address.
+// The .2 and .3 addresses are reserved for the slirp4netns gateway and DNS.
+func GetStaticNetwork(ip string) (*net.IPNet, error) {
+	address := net.ParseIP(ip).To4()
+	if address == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %s", ip)
+	}
+
+	switch address[3] {
+	case 0, 2, 3, 255:
+		return nil, fmt.Errorf("address %s is reserved in its network", ip)
+	}
+
+	mask := net.CIDRMask(24, 32)
+
+	return &net.IPNet{IP: address.Mask(mask), Mask: mask}, nil
+}
+
+// configureStaticIP will set up the tap device of the namespace of targetPid
+// with input address, and route the traffic to the slirp4netns gateway.
+func configureStaticIP(targetPid int, ip string, ipNet *net.IPNet) error {
+	gateway := make(net.IP, len(ipNet.IP))
+	copy(gateway, ipNet.IP)
+	gateway[3] = 2
+
+	prefix, _ := ipNet.Mask.Size()
+
+	nsenterArgs := []string{"--preserve-credentials", "-n", "-t", fmt.Sprint(targetPid)}
+
+	// if the target is in a different user namespace, we need to join it too
+	// to have the capabilities to configure its network.
+	selfNS, _ := os.Readlink("/proc/self/ns/user")
+	targetNS, _ := os.Readlink(fmt.Sprintf("/proc/%d/ns/user", targetPid))
+
+	if selfNS != targetNS {
+		nsenterArgs = append(nsenterArgs, "-U")
+	}
+
+	commands := [][]string{
+		{"ip", "link", "set", "lo", "up"},
+		{"ip", "link", "set", "tap0", "mtu", "65520"},
+		{"ip", "addr", "add", fmt.Sprintf("%s/%d", ip, prefix), "dev", "tap0"},
+		{"ip", "link", "set", "tap0", "up"},
+		{"ip", "route", "add", "default", "via", gateway.String(), "dev", "tap0"},
+	}
+
+	for _, command := range commands {
+		out, err := exec.Command("nsenter", append(nsenterArgs, command...)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to configure static ip, %v: %w: %s", command, err, string(out))
+		}
+	}
+
+	return nil
+}
+
+// ListPortForwards returns the ports currently forwarded by the backend of
+// input container.
+func ListPortForwards(containerID string) ([]PortForward, error) {
+	// pasta has no API, the ports it forwards are saved at start
+	if _, err := os.Stat(getPastaPortsPath(containerID)); err == nil {
+		return loadPastaPorts(containerID)
+	}
+
+	var response struct {
+		Return struct {
+			Entries []PortForward `json:"entries"`
+		} `json:"return"`
+		Error *struct {
+			Desc string `json:"desc"`
+		} `json:"error"`
+	}
+
+	socket := filepath.Join(GetRuntimeDir(containerID), "slirp.sock")
+
+	err := slirpRequest(socket, map[string]string{"execute": "list_hostfwd"}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("slirp4netns: %s", response.Error.Desc)
+	}
+
+	return response.Return.Entries, nil
+}
+
+// slirpRequest will send input request to the slirp4netns API socket, and
+// decode its reply in response.
+func slirpRequest(socket string, request any, response any) error {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to slirp4netns: %w", err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return err
+	}
+
+	// slirp4netns replies once the request is complete
+	if unixConn, ok := conn.(*net.UnixConn); ok {
+		_ = unixConn.CloseWrite()
+	}
+
+	return json.NewDecoder(conn).Decode(response)
+}
+
 // Cleanup performs cleanup of the network namespace and associated resources
 func (n *NetworkNamespace) Cleanup() error {
 	var errors []error
 
-	// Terminate slirp4netns process if it exists
-	if n.slirpProcess != nil {
+	// Terminate the backend process if it exists
+	if n.process != nil {
 		// Try SIGTERM first
-		if err := n.slirpProcess.Signal(unix.SIGTERM); err != nil {
-			errors = append(errors, fmt.Errorf("failed to send SIGTERM to slirp4netns: %w", err))
+		if err := n.process.Signal(unix.SIGTERM); err != nil {
+			errors = append(errors, fmt.Errorf("failed to send SIGTERM to %s: %w", GetBackend(n.Backend), err))
 			// Force kill if SIGTERM fails
-			if err := n.slirpProcess.Kill(); err != nil {
-				errors = append(errors, fmt.Errorf("failed to kill slirp4netns: %w", err))
+			if err := n.process.Kill(); err != nil {
+				errors = append(errors, fmt.Errorf("failed to kill %s: %w", GetBackend(n.Backend), err))
 			}
 		}
 		// Wait for the process to exit
-		_, _ = n.slirpProcess.Wait()
+		_, _ = n.process.Wait()
 	}
 
-	// Unmount the network namespace using raw syscall
-	_, _, errno := unix.Syscall(SYS_UMOUNT2,
-		uintptr(unsafe.Pointer(&[]byte(n.NetNSMountPath)[0])),
-		0, 0)
-	if errno != 0 {
-		errors = append(errors, fmt.Errorf("failed to unmount network namespace: %w", errno))
+	// Unmount the network namespace
+	if err := unix.Unmount(n.NetNSMountPath, 0); err != nil {
+		errors = append(errors, fmt.Errorf("failed to unmount network namespace: %w", err))
 	}
 
 	// Remove the netns mount file
@@ -170,10 +519,9 @@ func SetupChildNetworking(netnsPath string) error {
 	}
 	defer unix.Close(netnsFd)
 
-	// Use raw syscall for setns since it's not directly exposed in unix package
-	_, _, errno := unix.Syscall(SYS_SETNS, uintptr(netnsFd), uintptr(CLONE_NEWNET), 0)
-	if errno != 0 {
-		return fmt.Errorf("failed to enter network namespace: %w", errno)
+	// Enter the network namespace
+	if err := unix.Setns(netnsFd, unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("failed to enter network namespace: %w", err)
 	}
 
 	// Configure loopback interface
@@ -0,0 +1,155 @@
+// Package netns provides network namespace management functionality for lilipod
+package netns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// getPastaPortsPath returns the path of the ports forwarded by the pasta
+// instance of input container, as pasta has no API to list them.
+func getPastaPortsPath(containerID string) string {
+	return filepath.Join(GetRuntimeDir(containerID), "ports.json")
+}
+
+// loadPastaPorts returns the ports forwarded by the pasta instance of input
+// container.
+func loadPastaPorts(containerID string) ([]PortForward, error) {
+	content, err := os.ReadFile(getPastaPortsPath(containerID))
+	if err != nil {
+		return nil, err
+	}
+
+	forwards := []PortForward{}
+
+	err = json.Unmarshal(content, &forwards)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ports of container %s: %w", containerID, err)
+	}
+
+	return forwards, nil
+}
+
+// startPasta starts pasta for the given target PID. pasta configures the
+// namespace itself, and forwards the ports natively, without the userspace
+// TCP/IP stack of slirp4netns.
+func (n *NetworkNamespace) startPasta(targetPid int) error {
+	pidFile := filepath.Join(n.RuntimeDir, "pasta.pid")
+	logFile := filepath.Join(n.RuntimeDir, "pasta.log")
+
+	args := []string{"--config-net", "--quiet", "--pid", pidFile, "--ns-ifname", "tap0"}
+
+	// the same addressing of slirp4netns, with the gateway on .2
+	if n.IP != "" {
+		ipNet, err := GetStaticNetwork(n.IP)
+		if err != nil {
+			return err
+		}
+
+		gateway := make(net.IP, len(ipNet.IP))
+		copy(gateway, ipNet.IP)
+		gateway[3] = 2
+
+		args = append(args, "--address", n.IP, "--netmask", "24", "--gateway", gateway.String())
+	}
+
+	if n.MacAddress != "" {
+		args = append(args, "--ns-mac-addr", n.MacAddress)
+	}
+
+	// pasta only forwards the published ports, not all the ones bound in the namespace
+	args = append(args, getPastaPortArgs(n.Ports)...)
+	args = append(args, strconv.Itoa(targetPid))
+
+	log, err := os.Create(logFile)
+	if err != nil {
+		return fmt.Errorf("failed to create pasta log: %w", err)
+	}
+
+	defer func() { _ = log.Close() }()
+
+	// pasta goes in background once the namespace is ready
+	cmd := exec.Command(BackendPasta, args...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+
+	err = cmd.Run()
+	if err != nil {
+		out, _ := os.ReadFile(logFile)
+
+		return fmt.Errorf("failed to start pasta: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	content, err := os.ReadFile(pidFile)
+	if err != nil {
+		return fmt.Errorf("failed to read pasta pid: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return fmt.Errorf("invalid pasta pid %s", string(content))
+	}
+
+	// Store the process for later cleanup
+	n.process, err = os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	return n.savePastaPorts()
+}
+
+// getPastaPortArgs returns the pasta options to forward input ports from
+// the host.
+func getPastaPortArgs(ports []PortForward) []string {
+	tcp := []string{}
+	udp := []string{}
+
+	for _, forward := range ports {
+		spec := fmt.Sprintf("%d:%d", forward.HostPort, forward.GuestPort)
+		if forward.HostAddr != "" && forward.HostAddr != "0.0.0.0" {
+			spec = forward.HostAddr + "/" + spec
+		}
+
+		if forward.Proto == "udp" {
+			udp = append(udp, "--udp-ports", spec)
+		} else {
+			tcp = append(tcp, "--tcp-ports", spec)
+		}
+	}
+
+	if len(tcp) == 0 {
+		tcp = []string{"--tcp-ports", "none"}
+	}
+
+	if len(udp) == 0 {
+		udp = []string{"--udp-ports", "none"}
+	}
+
+	return append(tcp, udp...)
+}
+
+// savePastaPorts will save the ports forwarded by pasta, for ListPortForwards.
+func (n *NetworkNamespace) savePastaPorts() error {
+	forwards := []PortForward{}
+
+	for i, forward := range n.Ports {
+		forward.ID = i + 1
+		forward.GuestAddr = n.IP
+
+		forwards = append(forwards, forward)
+	}
+
+	content, err := json.Marshal(forwards)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(getPastaPortsPath(n.ContainerID), content, 0o600)
+}
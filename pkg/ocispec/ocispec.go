@@ -0,0 +1,92 @@
+// Package ocispec contains the subset of the OCI runtime-spec types that
+// lilipod is able to describe its containers with.
+// Types are compatible with the config.json of runtime-spec v1.1.0.
+package ocispec
+
+// Version is the version of the runtime-spec that is generated.
+const Version = "1.1.0"
+
+// Spec is the base configuration for the container.
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Domainname  string            `json:"domainname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// Process contains information to start a specific application inside the container.
+type Process struct {
+	Terminal     bool          `json:"terminal,omitempty"`
+	User         User          `json:"user"`
+	Args         []string      `json:"args,omitempty"`
+	Env          []string      `json:"env,omitempty"`
+	Cwd          string        `json:"cwd"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// User specifies the user the process runs as.
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// Capabilities are the sets of capabilities of the process.
+type Capabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// Root contains information about the container's root filesystem on the host.
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount specifies a mount for a container.
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Linux contains platform-specific configuration for Linux based containers.
+type Linux struct {
+	UIDMappings   []LinuxIDMapping `json:"uidMappings,omitempty"`
+	GIDMappings   []LinuxIDMapping `json:"gidMappings,omitempty"`
+	Namespaces    []LinuxNamespace `json:"namespaces,omitempty"`
+	MaskedPaths   []string         `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string         `json:"readonlyPaths,omitempty"`
+}
+
+// LinuxIDMapping specifies UID/GID mappings.
+type LinuxIDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// LinuxNamespace is the configuration for a Linux namespace.
+type LinuxNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// Linux namespace types.
+const (
+	PIDNamespace     = "pid"
+	NetworkNamespace = "network"
+	MountNamespace   = "mount"
+	IPCNamespace     = "ipc"
+	UTSNamespace     = "uts"
+	UserNamespace    = "user"
+	CgroupNamespace  = "cgroup"
+)
@@ -3,6 +3,7 @@ package procutils
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/89luca89/lilipod/pkg/constants"
 	"github.com/89luca89/lilipod/pkg/logging"
+	"golang.org/x/sys/unix"
 )
 
 // EnsureFakeRoot will ensure process is executed with rootless-helper.
@@ -323,6 +325,31 @@ func IsPidRunning(pid int) bool {
 	return len(out) > 0
 }
 
+// ParseSignal returns the signal with input name or number, the name with or
+// without the SIG prefix, in any case, eg: SIGHUP, hup or 1.
+func ParseSignal(signal string) (syscall.Signal, error) {
+	number, err := strconv.Atoi(signal)
+	if err == nil {
+		if number < 1 || number > 64 {
+			return 0, fmt.Errorf("invalid signal %s", signal)
+		}
+
+		return syscall.Signal(number), nil
+	}
+
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	result := unix.SignalNum(name)
+	if result == 0 {
+		return 0, fmt.Errorf("invalid signal %s", signal)
+	}
+
+	return result, nil
+}
+
 // RunWithTTY will run input cmd using main process' stdin/out/err.
 func RunWithTTY(cmd *exec.Cmd) error {
 	logging.LogDebug("tty specified, just use cmd.Run")
@@ -334,14 +361,68 @@ func RunWithTTY(cmd *exec.Cmd) error {
 	return cmd.Run()
 }
 
+// Streams selects the standard streams attached to a process.
+type Streams struct {
+	Stdin  bool
+	Stdout bool
+	Stderr bool
+}
+
+// AllStreams attaches stdin, stdout and stderr.
+var AllStreams = Streams{Stdin: true, Stdout: true, Stderr: true}
+
+// Attached returns true if at least one stream is selected.
+func (s Streams) Attached() bool {
+	return s.Stdin || s.Stdout || s.Stderr
+}
+
+// ParseStreams returns the streams selected by input names (stdin, stdout, stderr).
+func ParseStreams(names []string) (Streams, error) {
+	streams := Streams{}
+
+	for _, name := range names {
+		switch strings.ToLower(name) {
+		case "stdin":
+			streams.Stdin = true
+		case "stdout":
+			streams.Stdout = true
+		case "stderr":
+			streams.Stderr = true
+		default:
+			return streams, fmt.Errorf("invalid stream %s, use stdin, stdout or stderr", name)
+		}
+	}
+
+	return streams, nil
+}
+
 // RunInteractive will run input cmd using main process' stdin, but
 // pipe stdout/err to main.
 //
 // This usually is used in combination with the ptyAgent inside a container.
 func RunInteractive(cmd *exec.Cmd) error {
-	logging.LogDebug("interactive but no tty, setting up pipes")
+	return RunAttached(cmd, AllStreams)
+}
 
-	cmd.Stdin = os.Stdin
+// RunAttached will run input cmd with the selected streams attached to the main
+// process' ones. Stdin can be a file or a pipe, the process will see its EOF.
+// Output of the streams that are not selected is discarded.
+func RunAttached(cmd *exec.Cmd, streams Streams) error {
+	logging.LogDebug("interactive but no tty, setting up pipes for %+v", streams)
+
+	if streams.Stdin {
+		cmd.Stdin = os.Stdin
+	}
+
+	stdoutTarget := io.Discard
+	if streams.Stdout {
+		stdoutTarget = os.Stdout
+	}
+
+	stderrTarget := io.Discard
+	if streams.Stderr {
+		stderrTarget = os.Stderr
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -358,48 +439,85 @@ func RunInteractive(cmd *exec.Cmd) error {
 		return err
 	}
 
-	// Non-blockingly echo command output to terminal
-	go func() { _, _ = io.Copy(os.Stdout, stdout) }()
-	go func() { _, _ = io.Copy(os.Stderr, stderr) }()
+	// Non-blockingly echo command output to terminal, keeping the two
+	// streams separated.
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+
+		_, _ = io.Copy(stdoutTarget, stdout)
+	}()
+	go func() {
+		defer wg.Done()
+
+		_, _ = io.Copy(stderrTarget, stderr)
+	}()
+
+	// all output must be read before calling Wait, else we'd lose the tail
+	// of the output.
+	wg.Wait()
 
 	return cmd.Wait()
 }
 
 // RunDetached will run input cmd and redurect all outputs to logfile.
-// No stdin is set up.
-func RunDetached(cmd *exec.Cmd, logfile string) error {
+// If forward is not nil, each output line will also be passed to it, this is
+// used to ship logs to remote log drivers.
+// If cmd.Stdout or cmd.Stderr are already set, the output is copied there too,
+// eg: to the clients attached to the container.
+// No stdin is set up, unless cmd.Stdin is already set.
+func RunDetached(cmd *exec.Cmd, logfile string, forward func(stream string, line string)) error {
 	logging.LogDebug("no interactive and no tty, setting up process log file")
 
 	// non interactive mode, save stdout and stderr to file and disown
 	cmd.SysProcAttr.Foreground = false
 	cmd.SysProcAttr.Setsid = true
 
-	// ensure we either create the file, or truncate the existing one
-	// so that we always start fresh
-	_, err := os.Create(logfile)
+	// ensure the file exists, output is always appended so that multiple
+	// processes can share the same log file. Rotation is up to the caller.
+	logFile, err := os.OpenFile(logfile, os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		logging.LogDebug("%v", err)
+	} else {
+		_ = logFile.Close()
 	}
 
 	logging.LogDebug("no interactive and no tty, setting up process pipes")
 
 	outR, outW := io.Pipe()
 	errR, errW := io.Pipe()
-	cmd.Stdout = io.Writer(outW)
-	cmd.Stderr = io.Writer(errW)
+
+	if cmd.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(outW, cmd.Stdout)
+	} else {
+		cmd.Stdout = io.Writer(outW)
+	}
+
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(errW, cmd.Stderr)
+	} else {
+		cmd.Stderr = io.Writer(errW)
+	}
 
 	stdinLines := make(chan string)
 	stderrLines := make(chan string)
 
 	var wg sync.WaitGroup
 
-	wg.Add(5)
+	wg.Add(4)
 
 	go func() {
 		defer wg.Done()
 
 		for line := range stdinLines {
-			line := fmt.Sprintf("%d:out:%s", time.Now().Unix(), line)
+			if forward != nil {
+				forward(logging.StreamStdout, line)
+			}
+
+			line := fmt.Sprintf("%d:%s:%s", time.Now().Unix(), logging.StreamStdout, line)
 
 			err := logging.AppendStringToFile(logfile, line)
 			if err != nil {
@@ -425,7 +543,11 @@ func RunDetached(cmd *exec.Cmd, logfile string) error {
 		defer wg.Done()
 
 		for line := range stderrLines {
-			line := fmt.Sprintf("%d:err:%s", time.Now().Unix(), line)
+			if forward != nil {
+				forward(logging.StreamStderr, line)
+			}
+
+			line := fmt.Sprintf("%d:%s:%s", time.Now().Unix(), logging.StreamStderr, line)
 
 			err := logging.AppendStringToFile(logfile, line)
 			if err != nil {
@@ -447,18 +569,9 @@ func RunDetached(cmd *exec.Cmd, logfile string) error {
 		}
 	}()
 
-	// keep an eye on the child process, and exit if dead
-	go func() {
-		defer wg.Done()
-
-		for {
-			time.Sleep(time.Second * 5)
-
-			if !IsPidRunning(cmd.Process.Pid) {
-				os.Exit(0)
-			}
-		}
-	}()
+	// processes spawned by the container may keep the output pipes open
+	// after the main process exits, don't wait for them forever.
+	cmd.WaitDelay = time.Second * 5
 
 	logging.LogDebug("no interactive and no tty, start process in background")
 
@@ -467,7 +580,16 @@ func RunDetached(cmd *exec.Cmd, logfile string) error {
 		return err
 	}
 
+	err = cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+
+	// process is dead, close the pipes so that the loggers can finish
+	_ = outW.Close()
+	_ = errW.Close()
+
 	wg.Wait()
 
-	return cmd.Wait()
+	return err
 }
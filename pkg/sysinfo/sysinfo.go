@@ -0,0 +1,296 @@
+// Package sysinfo gathers information about the host, useful to triage issues.
+package sysinfo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/89luca89/lilipod/pkg/cgrouputils"
+	"github.com/89luca89/lilipod/pkg/constants"
+	"github.com/89luca89/lilipod/pkg/containerutils"
+	"github.com/89luca89/lilipod/pkg/fileutils"
+	"github.com/89luca89/lilipod/pkg/imageutils"
+	"github.com/89luca89/lilipod/pkg/logging"
+	"github.com/89luca89/lilipod/pkg/netns"
+	"github.com/89luca89/lilipod/pkg/procutils"
+	"github.com/89luca89/lilipod/pkg/utils"
+	"golang.org/x/sys/unix"
+)
+
+// Info describes the host and lilipod's configuration.
+type Info struct {
+	Version string      `json:"version"`
+	Host    HostInfo    `json:"host"`
+	Store   StoreInfo   `json:"store"`
+	Cgroup  CgroupInfo  `json:"cgroup"`
+	Network NetworkInfo `json:"network"`
+	Kernel  KernelInfo  `json:"kernel"`
+	// Helpers are the external programs used by lilipod, with their version.
+	Helpers map[string]string `json:"helpers"`
+}
+
+// HostInfo describes the host system and the user running lilipod.
+type HostInfo struct {
+	Arch    string `json:"arch"`
+	Kernel  string `json:"kernel"`
+	OS      string `json:"os"`
+	Rootful bool   `json:"rootful"`
+	UID     int    `json:"uid"`
+	// UIDMap and GIDMap are the subordinate id ranges of the user,
+	// in the form of hostid:start:size.
+	UIDMap string `json:"uidmap"`
+	GIDMap string `json:"gidmap"`
+}
+
+// StoreInfo describes the storage used by lilipod.
+type StoreInfo struct {
+	Home           string   `json:"home"`
+	ImageDir       string   `json:"image_dir"`
+	BlobDir        string   `json:"blob_dir"`
+	ContainerDir   string   `json:"container_dir"`
+	VolumeDir      string   `json:"volume_dir"`
+	StorageDrivers []string `json:"storage_drivers"`
+	Images         int      `json:"images"`
+	Containers     int      `json:"containers"`
+}
+
+// CgroupInfo describes the cgroup of the current process.
+type CgroupInfo struct {
+	Version     string   `json:"version"`
+	Path        string   `json:"path"`
+	Delegated   bool     `json:"delegated"`
+	Controllers []string `json:"controllers"`
+}
+
+// NetworkInfo describes the network backend of private networks.
+type NetworkInfo struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+}
+
+// KernelInfo lists the kernel features used by lilipod.
+type KernelInfo struct {
+	Overlay        bool `json:"overlay"`
+	Erofs          bool `json:"erofs"`
+	IDMappedMounts bool `json:"idmapped_mounts"`
+	UserNamespaces bool `json:"user_namespaces"`
+}
+
+// helperVersionArgs are the helpers whose version is reported, with the
+// arguments to get it.
+var helperVersionArgs = map[string][]string{
+	"crun":       {"--version"},
+	"erofsfuse":  {"--version"},
+	"fsverity":   {"--version"},
+	"getsubids":  {"--version"},
+	"mkfs.erofs": {"-V"},
+	"mksquashfs": {"-version"},
+	"newuidmap":  {"--version"},
+	"nsenter":    {"--version"},
+	"pasta":      {"--version"},
+	"runc":       {"--version"},
+	"tar":        {"--version"},
+}
+
+// Get will gather the information about the host.
+func Get() Info {
+	info := Info{
+		Version: constants.Version,
+		Helpers: map[string]string{},
+	}
+
+	info.Host = getHostInfo()
+	info.Store = getStoreInfo()
+	info.Cgroup = getCgroupInfo()
+	info.Network = getNetworkInfo()
+	info.Kernel = getKernelInfo()
+
+	for helper, args := range helperVersionArgs {
+		info.Helpers[helper] = getHelperVersion(helper, args)
+	}
+
+	info.Helpers["slirp4netns"] = getHelperVersion(filepath.Join(utils.LilipodBinPath, "slirp4netns"), []string{"--version"})
+
+	return info
+}
+
+// getNetworkInfo returns the backend used by private networks without an
+// explicit one, pasta if installed, or the embedded slirp4netns.
+func getNetworkInfo() NetworkInfo {
+	network := NetworkInfo{
+		Backend: netns.GetBackend(""),
+		Path:    filepath.Join(utils.LilipodBinPath, "slirp4netns"),
+	}
+
+	if network.Backend == netns.BackendPasta {
+		network.Path, _ = exec.LookPath(netns.BackendPasta)
+	}
+
+	return network
+}
+
+// getHostInfo returns the host system info, and the id ranges of the user.
+func getHostInfo() HostInfo {
+	host := HostInfo{
+		Arch:    runtime.GOARCH,
+		OS:      runtime.GOOS,
+		Rootful: os.Getenv("ROOTFUL") == constants.TrueString,
+		UID:     os.Getuid(),
+	}
+
+	var uname unix.Utsname
+
+	err := unix.Uname(&uname)
+	if err == nil {
+		host.Kernel = unix.ByteSliceToString(uname.Release[:])
+	}
+
+	if !host.Rootful {
+		uids, gids, err := procutils.GetSubIDRanges()
+		if err != nil {
+			logging.LogDebug("cannot get subordinate id ranges: %v", err)
+		} else {
+			host.UIDMap = strings.Join(uids, ":")
+			host.GIDMap = strings.Join(gids, ":")
+		}
+	}
+
+	return host
+}
+
+// getStoreInfo returns the storage paths and drivers, and the number of
+// images and containers.
+func getStoreInfo() StoreInfo {
+	store := StoreInfo{
+		Home:           utils.GetLilipodHome(),
+		ImageDir:       imageutils.ImageDir,
+		BlobDir:        imageutils.BlobDir,
+		ContainerDir:   containerutils.ContainerDir,
+		VolumeDir:      filepath.Join(utils.GetLilipodHome(), "volumes"),
+		StorageDrivers: []string{imageutils.StorageDriverFiles},
+	}
+
+	if imageutils.ValidateStorageDriver(imageutils.StorageDriverErofs) == nil {
+		store.StorageDrivers = append(store.StorageDrivers, imageutils.StorageDriverErofs)
+	}
+
+	images, err := os.ReadDir(imageutils.ImageDir)
+	if err == nil {
+		store.Images = len(images)
+	}
+
+	containers, err := os.ReadDir(containerutils.ContainerDir)
+	if err == nil {
+		store.Containers = len(containers)
+	}
+
+	return store
+}
+
+// getCgroupInfo returns the cgroup of the current process, and whether it can
+// be managed by the user.
+func getCgroupInfo() CgroupInfo {
+	cgroup := CgroupInfo{
+		Version:     cgrouputils.GetVersion(),
+		Controllers: []string{},
+	}
+
+	if cgroup.Version != cgrouputils.Version2 {
+		hierarchies, err := cgrouputils.GetHierarchies(os.Getpid())
+		if err != nil {
+			logging.LogDebug("cannot get cgroup hierarchies: %v", err)
+
+			return cgroup
+		}
+
+		for _, hierarchy := range hierarchies {
+			cgroup.Controllers = append(cgroup.Controllers, hierarchy.Controllers...)
+		}
+
+		return cgroup
+	}
+
+	path, err := cgrouputils.GetCgroupPath(os.Getpid())
+	if err != nil {
+		logging.LogDebug("cannot get cgroup: %v", err)
+
+		return cgroup
+	}
+
+	cgroup.Path = path
+	cgroup.Delegated = cgrouputils.IsDelegated(path)
+	cgroup.Controllers = cgrouputils.GetControllers(path)
+
+	return cgroup
+}
+
+// getKernelInfo will detect the kernel features used by lilipod.
+func getKernelInfo() KernelInfo {
+	kernel := KernelInfo{}
+
+	filesystems, err := fileutils.ReadFile("/proc/filesystems")
+	if err == nil {
+		for _, line := range strings.Split(string(filesystems), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[len(fields)-1] {
+			case "overlay":
+				kernel.Overlay = true
+			case "erofs":
+				kernel.Erofs = true
+			}
+		}
+	}
+
+	// modules are loaded on first use, so they could be missing from the list
+	if !kernel.Overlay {
+		kernel.Overlay = fileutils.Exist("/sys/module/overlay")
+	}
+
+	if !kernel.Erofs {
+		kernel.Erofs = fileutils.Exist("/sys/module/erofs")
+	}
+
+	// the mount_setattr syscall, needed for idmapped mounts, reports EINVAL
+	// when available and invoked with no flags, and ENOSYS otherwise.
+	err = unix.MountSetattr(-1, "", 0, &unix.MountAttr{})
+	kernel.IDMappedMounts = err != unix.ENOSYS
+
+	maxUserNamespaces, err := fileutils.ReadFile("/proc/sys/user/max_user_namespaces")
+	kernel.UserNamespaces = err == nil && strings.TrimSpace(string(maxUserNamespaces)) != "0"
+
+	// some distributions restrict them to privileged users
+	unprivileged, err := fileutils.ReadFile("/proc/sys/kernel/unprivileged_userns_clone")
+	if err == nil && strings.TrimSpace(string(unprivileged)) == "0" {
+		kernel.UserNamespaces = false
+	}
+
+	return kernel
+}
+
+// getHelperVersion returns the first line of the version of input helper, or
+// "not found" if it is not installed.
+func getHelperVersion(helper string, args []string) string {
+	path, err := exec.LookPath(helper)
+	if err != nil {
+		return "not found"
+	}
+
+	out, err := exec.Command(path, args...).CombinedOutput()
+	if err != nil {
+		logging.LogDebug("cannot get %s version: %v", helper, err)
+	}
+
+	version, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if version == "" {
+		return path
+	}
+
+	return version
+}
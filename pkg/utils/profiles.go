@@ -0,0 +1,48 @@
+// Package utils contains generic helpers, utilities and structs.
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GetProfilesPath returns the path of the file with the creation profiles.
+// It is searched in:
+//
+// XDG_CONFIG_HOME/lilipod/profiles.json
+// HOME/.config/lilipod/profiles.json
+//
+// These variable are searched in this order.
+func GetProfilesPath() string {
+	if os.Getenv("XDG_CONFIG_HOME") != "" {
+		return filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "lilipod", "profiles.json")
+	}
+
+	return filepath.Join(os.Getenv("HOME"), ".config", "lilipod", "profiles.json")
+}
+
+// LoadProfile returns the creation profile with input name, as the values of
+// the options of create and run by their flag name. The image and command
+// keys hold the image and command to use when none are specified.
+func LoadProfile(name string) (map[string]any, error) {
+	content, err := os.ReadFile(GetProfilesPath())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read profiles: %w", err)
+	}
+
+	profiles := map[string]map[string]any{}
+
+	err = json.Unmarshal(content, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profiles %s: %w", GetProfilesPath(), err)
+	}
+
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in %s", name, GetProfilesPath())
+	}
+
+	return profile, nil
+}
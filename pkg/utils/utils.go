@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/89luca89/lilipod/pkg/constants"
 	"github.com/89luca89/lilipod/pkg/fileutils"
@@ -26,6 +27,10 @@ type Config struct {
 	Env        []string          `json:"env"`
 	Cgroup     string            `json:"cgroup"`
 	Created    string            `json:"created"`
+	Started    string            `json:"started,omitempty"`
+	Finished   string            `json:"finished,omitempty"`
+	ExitCode   int               `json:"exitcode,omitempty"`
+	Domainname string            `json:"domainname,omitempty"`
 	Gidmap     string            `json:"gidmap"`
 	Hostname   string            `json:"hostname"`
 	ID         string            `json:"id"`
@@ -33,20 +38,98 @@ type Config struct {
 	Ipc        string            `json:"ipc"`
 	Names      string            `json:"names"`
 	Network    string            `json:"network"`
+	IP         string            `json:"ip,omitempty"`
+	MacAddress string            `json:"macaddress,omitempty"`
+	Ports      []string          `json:"ports,omitempty"`
 	Pid        string            `json:"pid"`
+	PidFile    string            `json:"pidfile,omitempty"`
 	Privileged bool              `json:"privileged"`
 	Size       string            `json:"size"`
 	Status     string            `json:"status"`
 	Time       string            `json:"time"`
 	Uidmap     string            `json:"uidmap"`
 	User       string            `json:"user"`
+	GroupAdd   []string          `json:"groupadd,omitempty"`
 	Userns     string            `json:"userns"`
 	Workdir    string            `json:"workdir"`
 	Stopsignal string            `json:"stopsignal"`
 	Mounts     []string          `json:"mounts"`
 	Labels     map[string]string `json:"labels"`
+	Timezone   string            `json:"timezone,omitempty"`
+	LocaleGen  bool              `json:"localegen,omitempty"`
+	Init       bool              `json:"init,omitempty"`
+	Tty        bool              `json:"tty,omitempty"`
+	// dependencies related, the IDs of the containers to start before this one
+	Requires []string `json:"requires,omitempty"`
 	// entry point related
 	Entrypoint []string `json:"entrypoint"`
+	// image related
+	ImageDigest  string `json:"imagedigest,omitempty"`
+	ImageRemoved bool   `json:"imageremoved,omitempty"`
+	// logging related
+	LogDriver string            `json:"logdriver,omitempty"`
+	LogOpts   map[string]string `json:"logopts,omitempty"`
+	// storage related
+	StorageDriver string `json:"storagedriver,omitempty"`
+	// runtime related
+	Runtime string `json:"runtime,omitempty"`
+	// network related, the backend of private networks, detected at start if empty,
+	// and the names the container is resolved with in user networks, besides its own
+	NetworkBackend string   `json:"networkbackend,omitempty"`
+	NetworkAliases []string `json:"networkaliases,omitempty"`
+	// stats related
+	StatsInterval string `json:"statsinterval,omitempty"`
+	// resources related
+	Memory    string `json:"memory,omitempty"`
+	CPUs      string `json:"cpus,omitempty"`
+	PidsLimit string `json:"pidslimit,omitempty"`
+	// restart related, auto-removed containers are removed once they exit instead
+	Restart      string `json:"restart,omitempty"`
+	RestartCount int    `json:"restartcount,omitempty"`
+	AutoRemove   bool   `json:"autoremove,omitempty"`
+	// stop related, the seconds to wait for the container to stop before killing it
+	StopTimeout int `json:"stoptimeout,omitempty"`
+	// idle related, how long the container runs without exec or attach sessions before being stopped
+	IdleTimeout string `json:"idletimeout,omitempty"`
+	// health related
+	Healthcheck *HealthConfig `json:"healthcheck,omitempty"`
+	Health      *HealthState  `json:"health,omitempty"`
+	// hooks related
+	Hooks *HooksConfig `json:"hooks,omitempty"`
+}
+
+// HooksConfig holds the commands run on the host around the lifecycle of a
+// container, each one with sh -c.
+type HooksConfig struct {
+	PreStart  []string `json:"prestart,omitempty"`
+	PostStart []string `json:"poststart,omitempty"`
+	PostStop  []string `json:"poststop,omitempty"`
+}
+
+// HealthConfig holds the healthcheck configuration of a container.
+// Test follows the image config format, eg: ["CMD-SHELL", "curl localhost"]
+// or ["CMD", "curl", "localhost"].
+type HealthConfig struct {
+	Test     []string `json:"test"`
+	Interval string   `json:"interval"`
+	Timeout  string   `json:"timeout"`
+	Retries  int      `json:"retries"`
+}
+
+// HealthState holds the current health status of a container, with the
+// results of the latest probes.
+type HealthState struct {
+	Status        string           `json:"status"`
+	FailingStreak int              `json:"failingstreak"`
+	Log           []HealthLogEntry `json:"log"`
+}
+
+// HealthLogEntry is the result of a single healthcheck probe.
+type HealthLogEntry struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	ExitCode int    `json:"exitcode"`
+	Output   string `json:"output"`
 }
 
 // GetDefaultTable returns the default table style we use to print out tables.
@@ -103,13 +186,48 @@ func SaveConfig(config Config, path string) error {
 		return err
 	}
 
-	logging.LogDebug("save config: cleaning up %s", path)
-	// ensure we start from a clean file
-	_ = os.Remove(path)
+	logging.LogDebug("save config: writing %s.tmp", path)
+
+	// write to a temporary file first, and atomically replace the config,
+	// so that a crash never leaves a partially written config behind.
+	tmpFile, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		logging.LogDebug("error: %+v", err)
+
+		return err
+	}
+
+	_, err = tmpFile.Write(file)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		logging.LogDebug("error: %+v", err)
+
+		_ = os.Remove(path + ".tmp")
+
+		return err
+	}
+
+	// keep the previous valid config around, it can be used to repair
+	// the container if needed.
+	_, err = LoadConfig(path)
+	if err == nil {
+		logging.LogDebug("save config: backing up %s", path)
 
-	logging.LogDebug("save config: writing %s", path)
+		_ = os.Remove(path + ".bak")
+		_ = os.Link(path, path+".bak")
+	}
 
-	return fileutils.WriteFile(path, file, 0o644)
+	logging.LogDebug("save config: replacing %s", path)
+
+	return os.Rename(path+".tmp", path)
 }
 
 // LoadConfig loads a config from file to config struct.
@@ -335,3 +453,138 @@ func ListToMap(input []string) map[string]string {
 
 	return result
 }
+
+// MergeEnv returns input env list with the variables of overrides, in the
+// key=value format, replacing the ones with the same name.
+// Variables without a value are taken from the host's environment, if set.
+func MergeEnv(env []string, overrides ...string) []string {
+	result := append([]string{}, env...)
+
+	for _, variable := range overrides {
+		key, _, found := strings.Cut(variable, "=")
+		if !found {
+			value, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+
+			variable = key + "=" + value
+		}
+
+		replaced := false
+
+		for i, current := range result {
+			if strings.HasPrefix(current, key+"=") {
+				result[i] = variable
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			result = append(result, variable)
+		}
+	}
+
+	return result
+}
+
+// ReadListFile returns the lines of input file, in the key=value format used
+// by ListToMap. Empty lines and lines starting with # are skipped.
+func ReadListFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		result = append(result, line)
+	}
+
+	return result, nil
+}
+
+// HumanSize returns a human readable representation of input bytes, using
+// binary (1024 based) units, eg. 12.3MiB.
+func HumanSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value := float64(size)
+	unit := 0
+
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", size, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
+
+// LegacyTimeFormat is the local time format used by older lilipod versions
+// for the containers' creation time.
+const LegacyTimeFormat = "2006.01.02 15:04:05"
+
+// ParseTime parses an RFC3339 timestamp, or a legacy local time one.
+func ParseTime(input string) (time.Time, error) {
+	result, err := time.Parse(time.RFC3339Nano, input)
+	if err == nil {
+		return result, nil
+	}
+
+	return time.ParseInLocation(LegacyTimeFormat, input, time.Local)
+}
+
+// HumanDuration returns a human readable approximation of input duration,
+// eg: "About a minute", "2 hours", "3 days".
+func HumanDuration(duration time.Duration) string {
+	const (
+		day   = 24 * time.Hour
+		week  = 7 * day
+		month = 30 * day
+		year  = 365 * day
+	)
+
+	switch seconds := int(duration.Seconds()); {
+	case seconds < 1:
+		return "Less than a second"
+	case seconds == 1:
+		return "1 second"
+	case seconds < 60:
+		return fmt.Sprintf("%d seconds", seconds)
+	}
+
+	switch minutes := int(duration.Minutes()); {
+	case minutes == 1:
+		return "About a minute"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+
+	switch hours := int(duration.Round(time.Hour).Hours()); {
+	case hours == 1:
+		return "About an hour"
+	case hours < 48:
+		return fmt.Sprintf("%d hours", hours)
+	}
+
+	switch {
+	case duration < 2*week:
+		return fmt.Sprintf("%d days", duration/day)
+	case duration < 3*month:
+		return fmt.Sprintf("%d weeks", duration/week)
+	case duration < 2*year:
+		return fmt.Sprintf("%d months", duration/month)
+	default:
+		return fmt.Sprintf("%d years", duration/year)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+// initFlag makes the agent run input process as the init of the container.
+const initFlag = "--init"
+
+// runInit will run input process, forwarding it all the signals we receive,
+// and reap all the processes orphaned in the container, like a real init
+// would. Returns the exit code of the process.
+func runInit(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no command specified")
+
+		return 127
+	}
+
+	// orphans are reparented to us even when we're not pid 1, eg: in the
+	// host's pid namespace
+	err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to become subreaper: %v\n", err)
+	}
+
+	// catch everything before starting the process, else we could lose its
+	// SIGCHLD, as pid 1 the ones we don't handle would be ignored anyway.
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+
+		return 127
+	}
+
+	// we never use cmd.Wait, the process is reaped with the others
+	pid := cmd.Process.Pid
+
+	for sig := range signals {
+		switch sig {
+		case unix.SIGCHLD:
+			exitCode, exited := reap(pid)
+			if exited {
+				return exitCode
+			}
+		case unix.SIGURG:
+			// used by the go runtime for preemption, not meant for the process
+		default:
+			_ = unix.Kill(pid, sig.(unix.Signal))
+		}
+	}
+
+	return 0
+}
+
+// reap will wait all the exited processes, returning the exit code of input
+// pid, and whether it exited.
+func reap(pid int) (int, bool) {
+	exitCode, exited := 0, false
+
+	for {
+		var status unix.WaitStatus
+
+		reaped, err := unix.Wait4(-1, &status, unix.WNOHANG, nil)
+		if err != nil || reaped <= 0 {
+			return exitCode, exited
+		}
+
+		if reaped != pid {
+			continue
+		}
+
+		exited = true
+
+		switch {
+		case status.Exited():
+			exitCode = status.ExitStatus()
+		case status.Signaled():
+			exitCode = 128 + int(status.Signal())
+		}
+	}
+}
@@ -1,5 +1,5 @@
 // Package main of ptyagent. This program is used to run input process instantiating
-// a working PTY.
+// a working PTY, or as the init of the container with --init.
 package main
 
 import (
@@ -21,6 +21,10 @@ func main() {
 		return
 	}
 
+	if os.Args[1] == initFlag {
+		os.Exit(runInit(os.Args[2:]))
+	}
+
 	pty, err := createPty()
 	if err != nil {
 		log.Fatal(err)
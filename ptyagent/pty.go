@@ -54,7 +54,14 @@ func (p *pty) Start() error {
 		p.wg.Done()
 	}()
 
-	return p.inheritWindowSize()
+	err = p.inheritWindowSize()
+	// Stdout is not a terminal when started in background, eg: to attach
+	// to it later, use the usual default size then
+	if errors.Is(err, unix.ENOTTY) {
+		return unix.IoctlSetWinsize(int(p.master.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: 24, Col: 80})
+	}
+
+	return err
 }
 
 func (p *pty) Terminate() {
@@ -105,15 +112,11 @@ func (p *pty) makeStdinRaw() error {
 	err := termios.Tcgetattr(os.Stdin.Fd(), &stdinTermios)
 	// We might get ENOTTY if stdin is redirected
 	if err != nil {
-		var unixerr *unix.Errno
-
-		if errors.As(err, &unixerr) {
-			if *unixerr == unix.ENOTTY {
-				return nil
-			}
-
-			return err
+		if errors.Is(err, unix.ENOTTY) {
+			return nil
 		}
+
+		return err
 	}
 
 	p.previousStdinTermios = stdinTermios